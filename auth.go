@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// basicAuthHeader returns the Authorization header value for "user:password".
+func basicAuthHeader(userPass string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(userPass))
+}
+
+// digestChallenge holds the parameters of a WWW-Authenticate: Digest header.
+type digestChallenge struct {
+	realm     string
+	nonce     string
+	opaque    string
+	algorithm string
+	qop       string // "auth" if offered, otherwise empty
+}
+
+// parseDigestChallenges returns every Digest challenge in the response's
+// WWW-Authenticate headers.
+func parseDigestChallenges(h http.Header) []digestChallenge {
+	var out []digestChallenge
+	for _, v := range h.Values("WWW-Authenticate") {
+		scheme, rest, _ := strings.Cut(strings.TrimSpace(v), " ")
+		if !strings.EqualFold(scheme, "Digest") {
+			continue
+		}
+		params := parseAuthParams(rest)
+		c := digestChallenge{
+			realm:     params["realm"],
+			nonce:     params["nonce"],
+			opaque:    params["opaque"],
+			algorithm: strings.ToUpper(params["algorithm"]),
+		}
+		if c.algorithm == "" {
+			c.algorithm = "MD5"
+		}
+		for _, q := range strings.Split(params["qop"], ",") {
+			if strings.TrimSpace(q) == "auth" {
+				c.qop = "auth"
+			}
+		}
+		if c.nonce != "" {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// parseAuthParams parses a comma-separated list of key=value or
+// key="quoted value" pairs, as used in WWW-Authenticate.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for len(s) > 0 {
+		s = strings.TrimLeft(s, " ,")
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " ")
+		var val string
+		if strings.HasPrefix(s, `"`) {
+			var sb strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				sb.WriteByte(s[i])
+			}
+			val = sb.String()
+			if i < len(s) {
+				i++
+			}
+			s = s[i:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			val = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		params[key] = val
+	}
+	return params
+}
+
+// digestHash returns the hash constructor for an RFC 7616 algorithm name,
+// or nil if unsupported.
+func digestHash(algorithm string) func() hash.Hash {
+	switch strings.TrimSuffix(algorithm, "-SESS") {
+	case "MD5":
+		return md5.New
+	case "SHA-256":
+		return sha256.New
+	}
+	return nil
+}
+
+// pickDigestChallenge prefers SHA-256 over MD5 among supported challenges.
+func pickDigestChallenge(challenges []digestChallenge) (digestChallenge, bool) {
+	var best digestChallenge
+	found := false
+	for _, c := range challenges {
+		if digestHash(c.algorithm) == nil {
+			continue
+		}
+		if !found || strings.HasPrefix(c.algorithm, "SHA-256") {
+			best = c
+			found = true
+		}
+	}
+	return best, found
+}
+
+// digestAuthHeader computes the RFC 7616 Authorization header value for a
+// request to u with the given credentials ("user:password").
+func digestAuthHeader(c digestChallenge, userPass, method string, u *url.URL) (string, error) {
+	newHash := digestHash(c.algorithm)
+	if newHash == nil {
+		return "", fmt.Errorf("unsupported digest algorithm %q", c.algorithm)
+	}
+	h := func(s string) string {
+		hh := newHash()
+		hh.Write([]byte(s))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+
+	user, pass, _ := strings.Cut(userPass, ":")
+	uri := u.RequestURI()
+
+	cnonceBytes := make([]byte, 16)
+	if _, err := rand.Read(cnonceBytes); err != nil {
+		return "", err
+	}
+	cnonce := hex.EncodeToString(cnonceBytes)
+	const nc = "00000001"
+
+	ha1 := h(user + ":" + c.realm + ":" + pass)
+	if strings.HasSuffix(c.algorithm, "-SESS") {
+		ha1 = h(ha1 + ":" + c.nonce + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+
+	var response string
+	if c.qop == "auth" {
+		response = h(ha1 + ":" + c.nonce + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+	} else {
+		response = h(ha1 + ":" + c.nonce + ":" + ha2)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `Digest username=%q, realm=%q, nonce=%q, uri=%q, algorithm=%s, response=%q`,
+		user, c.realm, c.nonce, uri, c.algorithm, response)
+	if c.qop == "auth" {
+		fmt.Fprintf(&sb, `, qop=auth, nc=%s, cnonce=%q`, nc, cnonce)
+	}
+	if c.opaque != "" {
+		fmt.Fprintf(&sb, `, opaque=%q`, c.opaque)
+	}
+	return sb.String(), nil
+}
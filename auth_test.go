@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestBasicAuthHeader(t *testing.T) {
+	// The example from RFC 7617.
+	if got, want := basicAuthHeader("Aladdin:open sesame"), "Basic QWxhZGRpbjpvcGVuIHNlc2FtZQ=="; got != want {
+		t.Errorf("basicAuthHeader = %q, want %q", got, want)
+	}
+}
+
+func TestParseDigestChallenges(t *testing.T) {
+	h := http.Header{}
+	h.Add("WWW-Authenticate", `Basic realm="site"`)
+	h.Add("WWW-Authenticate", `Digest realm="a@example.com", nonce="abc", qop="auth-int, auth", opaque="xyz"`)
+	h.Add("WWW-Authenticate", `digest realm="b", nonce="def", algorithm=sha-256`)
+	h.Add("WWW-Authenticate", `Digest realm="no nonce"`)
+
+	got := parseDigestChallenges(h)
+	if len(got) != 2 {
+		t.Fatalf("parseDigestChallenges found %d challenges, want 2: %+v", len(got), got)
+	}
+	if want := (digestChallenge{realm: "a@example.com", nonce: "abc", opaque: "xyz", algorithm: "MD5", qop: "auth"}); got[0] != want {
+		t.Errorf("first challenge = %+v, want %+v", got[0], want)
+	}
+	if want := (digestChallenge{realm: "b", nonce: "def", algorithm: "SHA-256"}); got[1] != want {
+		t.Errorf("second challenge = %+v, want %+v", got[1], want)
+	}
+
+	c, ok := pickDigestChallenge(append(got, digestChallenge{nonce: "n", algorithm: "SHA-512-256"}))
+	if !ok || c.algorithm != "SHA-256" {
+		t.Errorf("pickDigestChallenge = %+v, %v; want the SHA-256 challenge", c, ok)
+	}
+	if _, ok := pickDigestChallenge([]digestChallenge{{nonce: "n", algorithm: "SHA-512-256"}}); ok {
+		t.Errorf("pickDigestChallenge picked an unsupported algorithm")
+	}
+}
+
+// digestParams parses the name=value pairs of a Digest Authorization
+// header.
+func digestParams(header string) map[string]string {
+	return parseAuthParams(strings.TrimPrefix(header, "Digest "))
+}
+
+func TestDigestAuthHeaderWithoutQop(t *testing.T) {
+	// RFC 2069 style, with the credentials of the RFC 2617 example.
+	c := digestChallenge{realm: "testrealm@host.com", nonce: "dcd98b7102dd2f0e8b11d0f600bfb0c093", opaque: "5ccc069c403ebaf9f0171e9517f40e41", algorithm: "MD5"}
+	u, _ := url.Parse("http://www.example.com/dir/index.html")
+	header, err := digestAuthHeader(c, "Mufasa:Circle Of Life", "GET", u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := digestParams(header)
+	if p["username"] != "Mufasa" || p["uri"] != "/dir/index.html" || p["opaque"] != c.opaque {
+		t.Errorf("header %q has the wrong parameters", header)
+	}
+	if _, ok := p["qop"]; ok {
+		t.Errorf("header %q has a qop the challenge didn't offer", header)
+	}
+	if want := "670fd8c2df070c60b045671b8b24ff02"; p["response"] != want {
+		t.Errorf("response = %q, want %q", p["response"], want)
+	}
+}
+
+func TestDigestAuthHeaderQop(t *testing.T) {
+	hexOf := func(newHash func() hash.Hash, s string) string {
+		h := newHash()
+		h.Write([]byte(s))
+		return hex.EncodeToString(h.Sum(nil))
+	}
+	u, _ := url.Parse("https://example.org/dir/index.html?page=2")
+
+	// The cnonce is random, so the expected response is computed from the
+	// one the header carries.
+	for _, c := range []digestChallenge{
+		{realm: "http-auth@example.org", nonce: "7ypf/xlj9XXwfDPEoM4URrv/xwf94BcCAzFZH4GiTo0v", algorithm: "SHA-256", qop: "auth"},
+		{realm: "r", nonce: "n", algorithm: "MD5-SESS", qop: "auth"},
+	} {
+		newHash := digestHash(c.algorithm)
+		header, err := digestAuthHeader(c, "Mufasa:Circle:of Life", "GET", u)
+		if err != nil {
+			t.Fatalf("%s: %v", c.algorithm, err)
+		}
+		p := digestParams(header)
+		if p["qop"] != "auth" || p["nc"] != "00000001" || p["cnonce"] == "" || p["uri"] != "/dir/index.html?page=2" {
+			t.Errorf("%s: header %q lacks qop=auth, nc, cnonce or the query", c.algorithm, header)
+			continue
+		}
+		ha1 := hexOf(newHash, "Mufasa:"+c.realm+":Circle:of Life")
+		if c.algorithm == "MD5-SESS" {
+			ha1 = hexOf(md5.New, ha1+":"+c.nonce+":"+p["cnonce"])
+		}
+		ha2 := hexOf(newHash, "GET:/dir/index.html?page=2")
+		if want := hexOf(newHash, ha1+":"+c.nonce+":00000001:"+p["cnonce"]+":auth:"+ha2); p["response"] != want {
+			t.Errorf("%s: response = %q, want %q", c.algorithm, p["response"], want)
+		}
+	}
+
+	if _, err := digestAuthHeader(digestChallenge{nonce: "n", algorithm: "SHA-512-256"}, "u:p", "GET", u); err == nil {
+		t.Errorf("digestAuthHeader accepted an unsupported algorithm")
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// errBodyTooLarge is reported once a body exceeds --max-body-size.
+var errBodyTooLarge = errors.New("body exceeds --max-body-size")
+
+// bodyLimitKey carries the body size limit in a request's context, so
+// every read path (buffered, streamed, downloaded) enforces it without
+// threading it through each call.
+type bodyLimitKey struct{}
+
+// withBodyLimit returns ctx carrying limit; zero means unlimited.
+func withBodyLimit(ctx context.Context, limit int64) context.Context {
+	if limit <= 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, bodyLimitKey{}, limit)
+}
+
+// bodyLimit returns the body size limit of resp's request, or zero.
+func bodyLimit(resp *http.Response) int64 {
+	if resp.Request == nil {
+		return 0
+	}
+	limit, _ := resp.Request.Context().Value(bodyLimitKey{}).(int64)
+	return limit
+}
+
+// capReader yields at most limit bytes of r and then fails with
+// errBodyTooLarge if r has more. A zero limit returns r unchanged.
+func capReader(r io.Reader, limit int64) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+	return &cappedReader{r: r, left: limit}
+}
+
+type cappedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (c *cappedReader) Read(p []byte) (int, error) {
+	if c.left <= 0 {
+		// Probe for one more byte to tell "exactly at the limit" from
+		// "over it".
+		var b [1]byte
+		n, err := c.r.Read(b[:])
+		if n > 0 {
+			return 0, errBodyTooLarge
+		}
+		return 0, err
+	}
+	if int64(len(p)) > c.left {
+		p = p[:c.left]
+	}
+	n, err := c.r.Read(p)
+	c.left -= int64(n)
+	return n, err
+}
+
+// parseByteSize parses sizes like "10MB", "512k", "1GiB" or "4096".
+// Units are binary: K/KB/KiB = 1024 bytes, M = 1024K, and so on.
+func parseByteSize(s string) (int64, error) {
+	t := strings.ToUpper(strings.TrimSpace(s))
+	t = strings.TrimSuffix(strings.TrimSuffix(t, "IB"), "B")
+	mult := int64(1)
+	if n := len(t); n > 0 {
+		switch t[n-1] {
+		case 'K':
+			mult = 1 << 10
+		case 'M':
+			mult = 1 << 20
+		case 'G':
+			mult = 1 << 30
+		case 'T':
+			mult = 1 << 40
+		}
+		if mult > 1 {
+			t = t[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid size %q (want e.g. 10MB, 512KB, 4096)", s)
+	}
+	return int64(f * float64(mult)), nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int64
+		wantErr bool
+	}{
+		{in: "4096", want: 4096},
+		{in: "0", want: 0},
+		{in: "512k", want: 512 << 10},
+		{in: "512KB", want: 512 << 10},
+		{in: "10MB", want: 10 << 20},
+		{in: "10M", want: 10 << 20},
+		{in: "1GiB", want: 1 << 30},
+		{in: "2T", want: 2 << 40},
+		{in: "1.5K", want: 1536},
+		{in: " 2 mb ", want: 2 << 20},
+		{in: "", wantErr: true},
+		{in: "MB", wantErr: true},
+		{in: "-1MB", wantErr: true},
+		{in: "ten", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseByteSize(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseByteSize(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("parseByteSize(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestFetchOptionsBodyLimit(t *testing.T) {
+	tests := []struct {
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{size: "", want: 0},
+		{size: "5MB", want: 5 << 20},
+		{size: "lots", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := fetchOptions{maxBodySize: tt.size}.bodyLimit()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("bodyLimit(%q) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("bodyLimit(%q) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
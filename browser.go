@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// browserSolveTimeout caps how long the browser fallback waits for a page
+// to get past its challenge.
+const browserSolveTimeout = 30 * time.Second
+
+// browserPollInterval is how often the browser fallback checks the page.
+const browserPollInterval = 500 * time.Millisecond
+
+// browserChallengeMarkers are strings of challenge pages that go away once
+// the browser is through: Cloudflare, Akamai, Incapsula, DDoS-Guard and
+// captcha interstitials.
+var browserChallengeMarkers = []string{
+	"Just a moment",
+	"_cf_chl",
+	"cf-challenge",
+	"jschl_vc",
+	cfChallengePath,
+	"challenges.cloudflare.com",
+	"turnstile",
+	"h-captcha",
+	"g-recaptcha",
+	"/_sec/cp_challenge",
+	"bm-verify",
+	"sec-if-cpt",
+	"_Incapsula_Resource",
+	"Incapsula incident ID",
+	"check.ddos-guard.net",
+	"/.well-known/ddos-guard/",
+}
+
+// challengeMarkers returns the markers of the challenge page body to wait
+// out in the browser: the browserChallengeMarkers it contains, or else
+// its <title> element, so a page that stays put never counts as passed.
+func challengeMarkers(body []byte) []string {
+	var markers []string
+	for _, m := range browserChallengeMarkers {
+		if bytes.Contains(body, []byte(m)) {
+			markers = append(markers, m)
+		}
+	}
+	if len(markers) == 0 {
+		if t := titleRe.Find(body); t != nil {
+			markers = append(markers, string(t))
+		}
+	}
+	return markers
+}
+
+// browserClearance loads pageURL in a locally installed headless Chrome,
+// driven over the DevTools protocol, and waits until the page has a new
+// clearance cookie or none of the challenge page body's markers are left
+// in its DOM, for up to browserSolveTimeout. It returns the
+// browser's cookies for pageURL, for the lightweight transport to carry
+// on with. Chrome announces the chrome profile's User-Agent, so the
+// cookies fit that profile's fingerprint.
+func browserClearance(ctx context.Context, pageURL string, cookies []*http.Cookie, body []byte, verbose bool) ([]*http.Cookie, error) {
+	markers := challengeMarkers(body)
+	ctx, cancel := context.WithTimeout(ctx, browserSolveTimeout)
+	defer cancel()
+
+	var ua string
+	for _, h := range chromeProfile().Headers {
+		if h[0] == "User-Agent" {
+			ua = h[1]
+		}
+	}
+	allocCtx, cancelAlloc := chromedp.NewExecAllocator(ctx, append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.UserAgent(ua),
+		chromedp.Flag("disable-blink-features", "AutomationControlled"),
+	)...)
+	defer cancelAlloc()
+	bctx, cancelBrowser := chromedp.NewContext(allocCtx)
+	defer cancelBrowser()
+
+	// The session's cookies go in first, so the browser continues it.
+	var params []*network.CookieParam
+	for _, c := range cookies {
+		params = append(params, &network.CookieParam{Name: c.Name, Value: c.Value, URL: pageURL})
+	}
+	before := map[string]string{}
+	for _, c := range cookies {
+		before[c.Name] = c.Value
+	}
+
+	if verbose {
+		fmt.Fprintf(os.Stderr, "[*] Opening %s in Chrome\n", pageURL)
+	}
+	if err := chromedp.Run(bctx,
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			if len(params) == 0 {
+				return nil
+			}
+			return network.SetCookies(params).Do(ctx)
+		}),
+		chromedp.Navigate(pageURL),
+	); err != nil {
+		return nil, fmt.Errorf("browser: %w", err)
+	}
+
+	for {
+		var html string
+		var browserCookies []*network.Cookie
+		if err := chromedp.Run(bctx,
+			chromedp.Evaluate(`document.documentElement.outerHTML`, &html),
+			chromedp.ActionFunc(func(ctx context.Context) error {
+				var err error
+				browserCookies, err = network.GetCookies().WithURLs([]string{pageURL}).Do(ctx)
+				return err
+			}),
+		); err != nil {
+			return nil, fmt.Errorf("browser: %w", err)
+		}
+
+		earned := false
+		for _, c := range browserCookies {
+			if clearanceCookieNames[c.Name] && before[c.Name] != c.Value {
+				earned = true
+			}
+		}
+		// The DOM has no status or headers to judge by: the challenge is
+		// over when the markers of the page it replaced are gone.
+		passed := len(markers) > 0 && !slices.ContainsFunc(markers, func(m string) bool {
+			return strings.Contains(html, m)
+		})
+		if earned || passed {
+			if verbose {
+				fmt.Fprintf(os.Stderr, "[*] Browser passed the challenge with %d cookies\n", len(browserCookies))
+			}
+			return fromBrowserCookies(browserCookies), nil
+		}
+
+		select {
+		case <-time.After(browserPollInterval):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("browser: page still challenged: %w", ctx.Err())
+		}
+	}
+}
+
+// fromBrowserCookies converts DevTools cookies for storage in the jar.
+func fromBrowserCookies(cookies []*network.Cookie) []*http.Cookie {
+	var out []*http.Cookie
+	for _, c := range cookies {
+		hc := &http.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			Secure:   c.Secure,
+			HttpOnly: c.HTTPOnly,
+		}
+		if !c.Session && c.Expires > 0 {
+			hc.Expires = time.Unix(int64(c.Expires), 0)
+		}
+		out = append(out, hc)
+	}
+	return out
+}
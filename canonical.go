@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// canonicalEntry records where an alias URL really lives and how we learned it.
+type canonicalEntry struct {
+	Canonical string    `json:"canonical"`
+	Source    string    `json:"source"` // "redirect" or "link"
+	Updated   time.Time `json:"updated"`
+}
+
+// canonicalMap is an on-disk map of original→final URLs built from redirect
+// chains and <link rel="canonical"> tags. Batch fetches consult it so that
+// known aliases are fetched once, at their canonical address.
+type canonicalMap struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]canonicalEntry
+}
+
+// defaultCanonicalMapPath returns the default path for the canonical map:
+// ~/.ghostfetch/canonical.json
+func defaultCanonicalMapPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return filepath.Join(home, ".ghostfetch", "canonical.json")
+}
+
+func newCanonicalMap(path string) *canonicalMap {
+	return &canonicalMap{path: path, entries: make(map[string]canonicalEntry)}
+}
+
+// Load reads the map from disk. If the file does not exist, Load returns nil.
+func (m *canonicalMap) Load() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, err := os.ReadFile(m.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &m.entries)
+}
+
+// Save writes the map to disk.
+func (m *canonicalMap) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(m.path), 0700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(m.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.path, data, 0600)
+}
+
+// Record stores original→canonical. Self-mappings are ignored.
+func (m *canonicalMap) Record(original, canonical, source string) {
+	original = normalizeURL(original)
+	canonical = normalizeURL(canonical)
+	if original == "" || canonical == "" || original == canonical {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[original] = canonicalEntry{
+		Canonical: canonical,
+		Source:    source,
+		Updated:   time.Now(),
+	}
+}
+
+// Lookup follows the alias chain for rawURL and returns the final canonical
+// URL. The boolean is false if rawURL is not a known alias.
+func (m *canonicalMap) Lookup(rawURL string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cur := normalizeURL(rawURL)
+	found := false
+	// Bounded walk in case the file contains a cycle.
+	for i := 0; i < 10; i++ {
+		e, ok := m.entries[cur]
+		if !ok {
+			break
+		}
+		cur = e.Canonical
+		found = true
+	}
+	return cur, found
+}
+
+// normalizeURL prepends https:// when no scheme is given and drops the
+// fragment, so that equivalent URLs map to the same key.
+func normalizeURL(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.Fragment = ""
+	return u.String()
+}
+
+// canonicalLinkHref returns the resolved href of the page's
+// <link rel="canonical">, or empty string if there is none.
+func canonicalLinkHref(body []byte, baseURL string) string {
+	doc, err := html.Parse(strings.NewReader(string(body)))
+	if err != nil {
+		return ""
+	}
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return ""
+	}
+
+	var href string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if href != "" {
+			return
+		}
+		if n.Type == html.ElementNode && n.Data == "link" && strings.EqualFold(getAttr(n, "rel"), "canonical") {
+			if ref, err := url.Parse(getAttr(n, "href")); err == nil {
+				resolved := base.ResolveReference(ref)
+				if resolved.Scheme == "http" || resolved.Scheme == "https" {
+					href = resolved.String()
+				}
+			}
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return href
+}
+
+// recordResult learns aliases from a completed fetch: the redirect chain
+// (requested URL → final URL) and, for successful responses, the page's
+// canonical link.
+func (m *canonicalMap) recordResult(r *fetchResult) {
+	if r == nil || r.Error != nil || r.resp == nil {
+		return
+	}
+	final := r.URL
+	if r.resp.Request != nil && r.resp.Request.URL != nil {
+		final = r.resp.Request.URL.String()
+		m.Record(r.URL, final, "redirect")
+	}
+	if r.StatusCode == 200 {
+		if href := canonicalLinkHref(r.Body, final); href != "" {
+			m.Record(final, href, "link")
+		}
+	}
+}
+
+// openCanonicalMap loads the canonical map when --canonical-map is set.
+// It returns nil when the feature is disabled.
+func openCanonicalMap() (*canonicalMap, error) {
+	if !flagCanonicalMap {
+		return nil, nil
+	}
+	m := newCanonicalMap(defaultCanonicalMapPath())
+	if err := m.Load(); err != nil {
+		return nil, fmt.Errorf("failed to load canonical map: %w", err)
+	}
+	return m, nil
+}
+
+// runCanonical prints the known canonical URL for rawURL.
+func runCanonical(rawURL string) error {
+	m := newCanonicalMap(defaultCanonicalMapPath())
+	if err := m.Load(); err != nil {
+		return fmt.Errorf("failed to load canonical map: %w", err)
+	}
+	canonical, known := m.Lookup(rawURL)
+
+	if flagJSONOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			URL       string `json:"url"`
+			Canonical string `json:"canonical"`
+			Known     bool   `json:"known"`
+		}{normalizeURL(rawURL), canonical, known})
+	}
+
+	if !known {
+		return fmt.Errorf("no canonical mapping known for %s", normalizeURL(rawURL))
+	}
+	fmt.Println(canonical)
+	return nil
+}
@@ -3,12 +3,17 @@ package main
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -40,21 +45,96 @@ func extractSitekey(body []byte) (sitekey string, captchaType string) {
 }
 
 // CaptchaSolver dispatches captcha-solving requests to an external service
-// such as 2captcha or anticaptcha, then polls for the result.
+// such as 2captcha, anticaptcha or NopeCHA, then polls for the result.
 type CaptchaSolver struct {
 	service string
 	apiKey  string
 	baseURL string
 	client  *http.Client
+	opts    captchaOptions
+}
+
+// captchaConfig holds captcha solving settings as given in the config
+// file's "captcha" object or the --captcha-* flags.
+type captchaConfig struct {
+	// PollInterval is how often a submitted task is polled (e.g. "5s").
+	PollInterval string `json:"poll_interval,omitempty"`
+	// MaxWait is how long a task may take before the next provider is
+	// tried (e.g. "3m").
+	MaxWait string `json:"max_wait,omitempty"`
+	// SoftID, Invisible, Enterprise and Pingback are passed to 2captcha
+	// as soft_id, invisible=1, enterprise=1 and pingback.
+	SoftID     string `json:"soft_id,omitempty"`
+	Invisible  bool   `json:"invisible,omitempty"`
+	Enterprise bool   `json:"enterprise,omitempty"`
+	Pingback   string `json:"pingback,omitempty"`
+}
+
+// captchaOptions are the parsed captcha solving settings.
+type captchaOptions struct {
+	pollInterval time.Duration
+	maxWait      time.Duration
+	softID       string
+	invisible    bool
+	enterprise   bool
+	pingback     string
+}
+
+// defaultCaptchaOptions poll every 2 seconds for up to 2 minutes.
+var defaultCaptchaOptions = captchaOptions{pollInterval: 2 * time.Second, maxWait: 2 * time.Minute}
+
+// resolveCaptchaConfig returns the captcha settings from the flags, with
+// the config file filling in those left unset.
+func resolveCaptchaConfig() captchaConfig {
+	c := appConfig.Captcha
+	if flagCaptchaPollInterval != "" {
+		c.PollInterval = flagCaptchaPollInterval
+	}
+	if flagCaptchaMaxWait != "" {
+		c.MaxWait = flagCaptchaMaxWait
+	}
+	if flagCaptchaSoftID != "" {
+		c.SoftID = flagCaptchaSoftID
+	}
+	if flagCaptchaPingback != "" {
+		c.Pingback = flagCaptchaPingback
+	}
+	c.Invisible = c.Invisible || flagCaptchaInvisible
+	c.Enterprise = c.Enterprise || flagCaptchaEnterprise
+	return c
+}
+
+// options parses c, using the defaults for unset durations.
+func (c captchaConfig) options() (captchaOptions, error) {
+	o := defaultCaptchaOptions
+	var err error
+	if c.PollInterval != "" {
+		if o.pollInterval, err = time.ParseDuration(c.PollInterval); err != nil || o.pollInterval <= 0 {
+			return o, fmt.Errorf("invalid captcha poll interval %q", c.PollInterval)
+		}
+	}
+	if c.MaxWait != "" {
+		if o.maxWait, err = time.ParseDuration(c.MaxWait); err != nil || o.maxWait <= 0 {
+			return o, fmt.Errorf("invalid captcha max wait %q", c.MaxWait)
+		}
+	}
+	if c.Pingback != "" {
+		if u, err := url.Parse(c.Pingback); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return o, fmt.Errorf("invalid captcha pingback URL %q", c.Pingback)
+		}
+	}
+	o.softID, o.invisible, o.enterprise, o.pingback = c.SoftID, c.Invisible, c.Enterprise, c.Pingback
+	return o, nil
 }
 
 // newCaptchaSolver creates a CaptchaSolver for the given service name.
-// Supported services are "2captcha" and "anticaptcha".
+// Supported services are "2captcha", "anticaptcha" and "nopecha".
 func newCaptchaSolver(service, apiKey string) (*CaptchaSolver, error) {
 	s := &CaptchaSolver{
 		service: service,
 		apiKey:  apiKey,
 		client:  &http.Client{Timeout: 30 * time.Second},
+		opts:    defaultCaptchaOptions,
 	}
 
 	switch service {
@@ -62,13 +142,79 @@ func newCaptchaSolver(service, apiKey string) (*CaptchaSolver, error) {
 		s.baseURL = "https://2captcha.com"
 	case "anticaptcha":
 		s.baseURL = "https://api.anti-captcha.com"
+	case "nopecha":
+		s.baseURL = "https://api.nopecha.com"
 	default:
-		return nil, fmt.Errorf("unsupported captcha service: %q (supported: 2captcha, anticaptcha)", service)
+		return nil, fmt.Errorf("unsupported captcha service: %q (supported: 2captcha, anticaptcha, nopecha)", service)
 	}
 
 	return s, nil
 }
 
+// Failures another provider may not share, after which the next one in
+// a --captcha-service chain is tried.
+var (
+	errCaptchaNoBalance = errors.New("no balance left")
+	errCaptchaTimeout   = errors.New("no answer in time")
+)
+
+// captchaCredentials fills in the captcha service list and keys from
+// GHOSTFETCH_CAPTCHA_SERVICE and GHOSTFETCH_CAPTCHA_KEY when the flags
+// leave them empty.
+func captchaCredentials(service, key string) (string, string) {
+	if service == "" {
+		service = os.Getenv("GHOSTFETCH_CAPTCHA_SERVICE")
+	}
+	if key == "" {
+		key = os.Getenv("GHOSTFETCH_CAPTCHA_KEY")
+	}
+	return service, key
+}
+
+// newCaptchaSolvers returns a solver for each service in the comma-separated
+// list services, in failover order. keys lists their API keys the same way,
+// one per service.
+func newCaptchaSolvers(services, keys string, opts captchaOptions) ([]*CaptchaSolver, error) {
+	svcList := strings.Split(services, ",")
+	keyList := strings.Split(keys, ",")
+	if len(keyList) != len(svcList) {
+		return nil, fmt.Errorf("%d captcha services but %d keys (give one key per service, comma-separated)", len(svcList), len(keyList))
+	}
+	var solvers []*CaptchaSolver
+	for i, svc := range svcList {
+		s, err := newCaptchaSolver(strings.TrimSpace(svc), strings.TrimSpace(keyList[i]))
+		if err != nil {
+			return nil, err
+		}
+		s.opts = opts
+		solvers = append(solvers, s)
+	}
+	return solvers, nil
+}
+
+// pollWait waits out one poll interval of a task submitted at start. It
+// fails with errCaptchaTimeout once the next poll would come after the
+// max wait.
+func (s *CaptchaSolver) pollWait(ctx context.Context, start time.Time) error {
+	if time.Since(start)+s.opts.pollInterval > s.opts.maxWait {
+		return fmt.Errorf("timed out after %s: %w", s.opts.maxWait, errCaptchaTimeout)
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(s.opts.pollInterval):
+		return nil
+	}
+}
+
+// captchaFailover reports whether err is worth retrying with the next
+// provider: one out of balance or timing out.
+func captchaFailover(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, errCaptchaNoBalance) || errors.Is(err, errCaptchaTimeout) ||
+		(errors.As(err, &netErr) && netErr.Timeout())
+}
+
 // Solve submits a captcha challenge to the configured service and polls
 // until the solution is available or the context is cancelled. It returns
 // the solved token string.
@@ -78,24 +224,205 @@ func (s *CaptchaSolver) Solve(ctx context.Context, sitekey, pageURL, captchaType
 		return s.solve2Captcha(ctx, sitekey, pageURL, captchaType)
 	case "anticaptcha":
 		return s.solveAntiCaptcha(ctx, sitekey, pageURL, captchaType)
+	case "nopecha":
+		return s.solveNopeCHA(ctx, sitekey, pageURL, captchaType)
 	default:
 		return "", fmt.Errorf("unsupported captcha service: %q", s.service)
 	}
 }
 
-// solve2Captcha implements the 2captcha submit-then-poll flow.
-// Submit: POST to /in.php with method, key, sitekey, pageurl, json=1
-// Poll:   GET /res.php?action=get&id=<id>&key=<key>&json=1
-func (s *CaptchaSolver) solve2Captcha(ctx context.Context, sitekey, pageURL, captchaType string) (string, error) {
-	method := twoCaptchaMethod(captchaType)
+// Balance returns the credit left on the account, in the service's unit:
+// US dollars for 2captcha and anticaptcha, solves for NopeCHA.
+func (s *CaptchaSolver) Balance(ctx context.Context) (float64, error) {
+	var req *http.Request
+	var err error
+	switch s.service {
+	case "2captcha":
+		req, err = http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/res.php?key=%s&action=getbalance&json=1",
+			s.baseURL, url.QueryEscape(s.apiKey)), nil)
+	case "anticaptcha":
+		payload, _ := json.Marshal(map[string]string{"clientKey": s.apiKey})
+		req, err = http.NewRequestWithContext(ctx, "POST", s.baseURL+"/getBalance", bytes.NewReader(payload))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	case "nopecha":
+		req, err = http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/status?key=%s",
+			s.baseURL, url.QueryEscape(s.apiKey)), nil)
+	default:
+		return 0, fmt.Errorf("unsupported captcha service: %q", s.service)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%s: build balance request: %w", s.service, err)
+	}
 
-	// Submit the captcha task.
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("%s: balance request: %w", s.service, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("%s: read balance response: %w", s.service, err)
+	}
+
+	var result struct {
+		// 2captcha
+		Status  int    `json:"status"`
+		Request string `json:"request"`
+		// anticaptcha
+		ErrorID          int     `json:"errorId"`
+		ErrorCode        string  `json:"errorCode"`
+		ErrorDescription string  `json:"errorDescription"`
+		Balance          float64 `json:"balance"`
+		// NopeCHA
+		Credit  float64 `json:"credit"`
+		Error   int     `json:"error"`
+		Message string  `json:"message"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return 0, fmt.Errorf("%s: parse balance response: %w", s.service, err)
+	}
+
+	switch s.service {
+	case "2captcha":
+		if result.Status != 1 {
+			return 0, fmt.Errorf("2captcha: balance failed: %s", result.Request)
+		}
+		return strconv.ParseFloat(result.Request, 64)
+	case "anticaptcha":
+		if result.ErrorID != 0 {
+			return 0, fmt.Errorf("anticaptcha: balance failed: %s (%s)", result.ErrorCode, result.ErrorDescription)
+		}
+		return result.Balance, nil
+	default:
+		if result.Error != 0 {
+			return 0, fmt.Errorf("nopecha: balance failed: %s (error %d)", result.Message, result.Error)
+		}
+		return result.Credit, nil
+	}
+}
+
+// captchaBalance is one service's line of `captcha balance` output.
+type captchaBalance struct {
+	Service string  `json:"service"`
+	Balance float64 `json:"balance"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// runCaptchaBalance prints the credit left with each configured captcha
+// service, as text or JSON. It fails if any balance could not be read.
+func runCaptchaBalance() error {
+	svc, key := captchaCredentials(flagCaptchaService, flagCaptchaKey)
+	if svc == "" || key == "" {
+		return fmt.Errorf("no captcha service configured (use --captcha-service and --captcha-key, or GHOSTFETCH_CAPTCHA_SERVICE and GHOSTFETCH_CAPTCHA_KEY)")
+	}
+	solvers, err := newCaptchaSolvers(svc, key, defaultCaptchaOptions)
+	if err != nil {
+		return err
+	}
+
+	timeout, err := time.ParseDuration(flagTimeout)
+	if err != nil {
+		return fmt.Errorf("invalid timeout %q: %w", flagTimeout, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var balances []captchaBalance
+	failed := 0
+	for _, s := range solvers {
+		b := captchaBalance{Service: s.service}
+		if b.Balance, err = s.Balance(ctx); err != nil {
+			b.Error = err.Error()
+			failed++
+		}
+		balances = append(balances, b)
+	}
+
+	if flagJSONOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(balances)
+	} else {
+		for _, b := range balances {
+			if b.Error != "" {
+				fmt.Printf("%s\tERR %s\n", b.Service, b.Error)
+			} else {
+				fmt.Printf("%s\t%g\n", b.Service, b.Balance)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("could not read %d of %d balances", failed, len(balances))
+	}
+	return nil
+}
+
+// SolveImage sends a captcha image to the configured service's image
+// recognition task and returns the text read from it.
+func (s *CaptchaSolver) SolveImage(ctx context.Context, image []byte) (string, error) {
+	encoded := base64.StdEncoding.EncodeToString(image)
+	switch s.service {
+	case "2captcha":
+		return s.run2Captcha(ctx, url.Values{
+			"method": {"base64"},
+			"body":   {encoded},
+		})
+	case "anticaptcha":
+		return s.runAntiCaptcha(ctx, map[string]interface{}{
+			"type": "ImageToTextTask",
+			"body": encoded,
+		})
+	case "nopecha":
+		data, err := s.runNopeCHA(ctx, "/", map[string]interface{}{
+			"type":       "textcaptcha",
+			"image_data": []string{encoded},
+		})
+		if err != nil {
+			return "", err
+		}
+		// Recognition answers one text per image.
+		var texts []string
+		if err := json.Unmarshal(data, &texts); err != nil || len(texts) == 0 {
+			return "", fmt.Errorf("nopecha: unexpected recognition result: %s", data)
+		}
+		return texts[0], nil
+	default:
+		return "", fmt.Errorf("unsupported captcha service: %q", s.service)
+	}
+}
+
+// solve2Captcha solves a sitekey captcha with 2captcha.
+func (s *CaptchaSolver) solve2Captcha(ctx context.Context, sitekey, pageURL, captchaType string) (string, error) {
 	form := url.Values{
-		"key":     {s.apiKey},
-		"method":  {method},
+		"method":  {twoCaptchaMethod(captchaType)},
 		"sitekey": {sitekey},
 		"pageurl": {pageURL},
-		"json":    {"1"},
+	}
+	if s.opts.invisible {
+		form.Set("invisible", "1")
+	}
+	if s.opts.enterprise {
+		form.Set("enterprise", "1")
+	}
+	return s.run2Captcha(ctx, form)
+}
+
+// run2Captcha implements the 2captcha submit-then-poll flow for the task
+// described by form.
+// Submit: POST to /in.php with the task fields, key, json=1
+// Poll:   GET /res.php?action=get&id=<id>&key=<key>&json=1
+func (s *CaptchaSolver) run2Captcha(ctx context.Context, form url.Values) (string, error) {
+	// Submit the captcha task.
+	form.Set("key", s.apiKey)
+	form.Set("json", "1")
+	if s.opts.softID != "" {
+		form.Set("soft_id", s.opts.softID)
+	}
+	if s.opts.pingback != "" {
+		form.Set("pingback", s.opts.pingback)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+"/in.php", strings.NewReader(form.Encode()))
@@ -123,6 +450,9 @@ func (s *CaptchaSolver) solve2Captcha(ctx context.Context, sitekey, pageURL, cap
 		return "", fmt.Errorf("2captcha: parse submit response: %w", err)
 	}
 	if submitResp.Status != 1 {
+		if submitResp.Request == "ERROR_ZERO_BALANCE" {
+			return "", fmt.Errorf("2captcha: submit failed: %s: %w", submitResp.Request, errCaptchaNoBalance)
+		}
 		return "", fmt.Errorf("2captcha: submit failed: %s", submitResp.Request)
 	}
 
@@ -132,14 +462,10 @@ func (s *CaptchaSolver) solve2Captcha(ctx context.Context, sitekey, pageURL, cap
 	pollURL := fmt.Sprintf("%s/res.php?key=%s&action=get&id=%s&json=1",
 		s.baseURL, url.QueryEscape(s.apiKey), url.QueryEscape(taskID))
 
-	const maxPolls = 60
-	const pollInterval = 2 * time.Second
-
-	for i := 0; i < maxPolls; i++ {
-		select {
-		case <-ctx.Done():
-			return "", ctx.Err()
-		case <-time.After(pollInterval):
+	start := time.Now()
+	for {
+		if err := s.pollWait(ctx, start); err != nil {
+			return "", fmt.Errorf("2captcha: %w", err)
 		}
 
 		pollReq, err := http.NewRequestWithContext(ctx, "GET", pollURL, nil)
@@ -175,21 +501,24 @@ func (s *CaptchaSolver) solve2Captcha(ctx context.Context, sitekey, pageURL, cap
 		}
 	}
 
-	return "", fmt.Errorf("2captcha: timed out after %d polls", maxPolls)
 }
 
-// solveAntiCaptcha implements the anti-captcha createTask/getTaskResult flow.
+// solveAntiCaptcha solves a sitekey captcha with anti-captcha.
 func (s *CaptchaSolver) solveAntiCaptcha(ctx context.Context, sitekey, pageURL, captchaType string) (string, error) {
-	taskType := antiCaptchaTaskType(captchaType)
+	return s.runAntiCaptcha(ctx, map[string]interface{}{
+		"type":       antiCaptchaTaskType(captchaType),
+		"websiteURL": pageURL,
+		"websiteKey": sitekey,
+	})
+}
 
+// runAntiCaptcha implements the anti-captcha createTask/getTaskResult flow
+// for task.
+func (s *CaptchaSolver) runAntiCaptcha(ctx context.Context, task map[string]interface{}) (string, error) {
 	// Submit the captcha task.
 	createPayload := map[string]interface{}{
 		"clientKey": s.apiKey,
-		"task": map[string]interface{}{
-			"type":       taskType,
-			"websiteURL": pageURL,
-			"websiteKey": sitekey,
-		},
+		"task":      task,
 	}
 
 	payloadBytes, err := json.Marshal(createPayload)
@@ -224,18 +553,17 @@ func (s *CaptchaSolver) solveAntiCaptcha(ctx context.Context, sitekey, pageURL,
 		return "", fmt.Errorf("anticaptcha: parse create response: %w", err)
 	}
 	if createResp.ErrorID != 0 {
+		if createResp.ErrorCode == "ERROR_ZERO_BALANCE" {
+			return "", fmt.Errorf("anticaptcha: create failed: %s (%s): %w", createResp.ErrorCode, createResp.ErrorDescription, errCaptchaNoBalance)
+		}
 		return "", fmt.Errorf("anticaptcha: create failed: %s (%s)", createResp.ErrorCode, createResp.ErrorDescription)
 	}
 
 	// Poll for the result.
-	const maxPolls = 60
-	const pollInterval = 2 * time.Second
-
-	for i := 0; i < maxPolls; i++ {
-		select {
-		case <-ctx.Done():
-			return "", ctx.Err()
-		case <-time.After(pollInterval):
+	start := time.Now()
+	for {
+		if err := s.pollWait(ctx, start); err != nil {
+			return "", fmt.Errorf("anticaptcha: %w", err)
 		}
 
 		pollPayload, err := json.Marshal(map[string]interface{}{
@@ -269,6 +597,7 @@ func (s *CaptchaSolver) solveAntiCaptcha(ctx context.Context, sitekey, pageURL,
 			Solution struct {
 				Token          string `json:"token"`
 				GRecaptchaResp string `json:"gRecaptchaResponse"`
+				Text           string `json:"text"`
 			} `json:"solution"`
 			ErrorCode        string `json:"errorCode"`
 			ErrorDescription string `json:"errorDescription"`
@@ -282,17 +611,134 @@ func (s *CaptchaSolver) solveAntiCaptcha(ctx context.Context, sitekey, pageURL,
 		}
 
 		if result.Status == "ready" {
-			token := result.Solution.Token
-			if token == "" {
-				token = result.Solution.GRecaptchaResp
+			switch {
+			case result.Solution.Token != "":
+				return result.Solution.Token, nil
+			case result.Solution.GRecaptchaResp != "":
+				return result.Solution.GRecaptchaResp, nil
 			}
-			return token, nil
+			return result.Solution.Text, nil
 		}
 
 		// status == "processing", keep polling
 	}
 
-	return "", fmt.Errorf("anticaptcha: timed out after %d polls", maxPolls)
+}
+
+// NopeCHA error codes: a job still being solved, and a key with no
+// credit left.
+const (
+	nopechaIncompleteJob = 14
+	nopechaOutOfCredit   = 16
+)
+
+// solveNopeCHA solves a sitekey captcha with NopeCHA's token API.
+func (s *CaptchaSolver) solveNopeCHA(ctx context.Context, sitekey, pageURL, captchaType string) (string, error) {
+	data, err := s.runNopeCHA(ctx, "/token/", map[string]interface{}{
+		"type":    nopechaType(captchaType),
+		"sitekey": sitekey,
+		"url":     pageURL,
+	})
+	if err != nil {
+		return "", err
+	}
+	var token string
+	if err := json.Unmarshal(data, &token); err != nil {
+		return "", fmt.Errorf("nopecha: unexpected token result: %s", data)
+	}
+	return token, nil
+}
+
+// runNopeCHA implements NopeCHA's job flow on one of its APIs (path "/"
+// for recognition, "/token/" for tokens): a JSON job is posted, then
+// polled with the job id in the query string. It returns the job's data.
+// Submit: POST <path> with the job fields plus key
+// Poll:   GET <path>?key=<key>&id=<id>
+func (s *CaptchaSolver) runNopeCHA(ctx context.Context, path string, job map[string]interface{}) (json.RawMessage, error) {
+	// Submit the captcha job.
+	job["key"] = s.apiKey
+	payloadBytes, err := json.Marshal(job)
+	if err != nil {
+		return nil, fmt.Errorf("nopecha: marshal submit request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.baseURL+path, bytes.NewReader(payloadBytes))
+	if err != nil {
+		return nil, fmt.Errorf("nopecha: build submit request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("nopecha: submit request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("nopecha: read submit response: %w", err)
+	}
+
+	// Both calls answer {"data": ...} on success and {"error": code,
+	// "message": ...} otherwise.
+	type nopechaResponse struct {
+		Data    json.RawMessage `json:"data"`
+		Error   int             `json:"error"`
+		Message string          `json:"message"`
+	}
+	var submitResp nopechaResponse
+	if err := json.Unmarshal(body, &submitResp); err != nil {
+		return nil, fmt.Errorf("nopecha: parse submit response: %w", err)
+	}
+	var jobID string
+	if submitResp.Error == nopechaOutOfCredit {
+		return nil, fmt.Errorf("nopecha: submit failed: %s (error %d): %w", submitResp.Message, submitResp.Error, errCaptchaNoBalance)
+	}
+	if submitResp.Error != 0 || json.Unmarshal(submitResp.Data, &jobID) != nil || jobID == "" {
+		return nil, fmt.Errorf("nopecha: submit failed: %s (error %d)", submitResp.Message, submitResp.Error)
+	}
+
+	// Poll for the result.
+	pollURL := fmt.Sprintf("%s%s?key=%s&id=%s",
+		s.baseURL, path, url.QueryEscape(s.apiKey), url.QueryEscape(jobID))
+
+	start := time.Now()
+	for {
+		if err := s.pollWait(ctx, start); err != nil {
+			return nil, fmt.Errorf("nopecha: %w", err)
+		}
+
+		pollReq, err := http.NewRequestWithContext(ctx, "GET", pollURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("nopecha: build poll request: %w", err)
+		}
+
+		pollResp, err := s.client.Do(pollReq)
+		if err != nil {
+			return nil, fmt.Errorf("nopecha: poll request: %w", err)
+		}
+
+		pollBody, err := io.ReadAll(pollResp.Body)
+		pollResp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("nopecha: read poll response: %w", err)
+		}
+
+		var result nopechaResponse
+		if err := json.Unmarshal(pollBody, &result); err != nil {
+			return nil, fmt.Errorf("nopecha: parse poll response: %w", err)
+		}
+
+		switch {
+		case result.Error == nopechaIncompleteJob:
+			// still solving, keep polling
+		case result.Error != 0:
+			return nil, fmt.Errorf("nopecha: solve failed: %s (error %d)", result.Message, result.Error)
+		case len(result.Data) > 0:
+			return result.Data, nil
+		}
+	}
+
 }
 
 // twoCaptchaMethod maps captcha types to 2captcha method parameters.
@@ -322,3 +768,17 @@ func antiCaptchaTaskType(captchaType string) string {
 		return "RecaptchaV2TaskProxyless"
 	}
 }
+
+// nopechaType maps captcha types to NopeCHA token job types.
+func nopechaType(captchaType string) string {
+	switch captchaType {
+	case "turnstile":
+		return "turnstile"
+	case "hcaptcha":
+		return "hcaptcha"
+	case "recaptcha":
+		return "recaptcha2"
+	default:
+		return "recaptcha2"
+	}
+}
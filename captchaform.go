@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// pageForm is a form as a browser would submit it: where to, how, and
+// the values of its successful controls.
+type pageForm struct {
+	action string // form action, as written in the page
+	method string // "GET" or "POST"
+	values url.Values
+}
+
+// newPageForm reads form's action, method and the values its inputs,
+// selects and textareas would submit.
+func newPageForm(form *html.Node) *pageForm {
+	values := url.Values{}
+	for _, n := range formFields(form) {
+		name := getAttr(n, "name")
+		if name == "" || hasAttr(n, "disabled") {
+			continue
+		}
+		switch n.Data {
+		case "textarea":
+			values.Add(name, textContent(n))
+		case "select":
+			var first, selected *html.Node
+			for _, o := range filterNodes(n, func(o *html.Node) bool { return o.Data == "option" }) {
+				if first == nil {
+					first = o
+				}
+				if selected == nil && hasAttr(o, "selected") {
+					selected = o
+				}
+			}
+			if selected == nil {
+				selected = first
+			}
+			if selected != nil {
+				v := getAttr(selected, "value")
+				if !hasAttr(selected, "value") {
+					v = strings.TrimSpace(textContent(selected))
+				}
+				values.Add(name, v)
+			}
+		default:
+			switch strings.ToLower(getAttr(n, "type")) {
+			case "checkbox", "radio":
+				if hasAttr(n, "checked") {
+					values.Add(name, getAttr(n, "value"))
+				}
+			case "submit", "button", "reset", "image", "file":
+			default:
+				values.Add(name, getAttr(n, "value"))
+			}
+		}
+	}
+	method := "GET"
+	if strings.EqualFold(getAttr(form, "method"), "post") {
+		method = "POST"
+	}
+	return &pageForm{action: getAttr(form, "action"), method: method, values: values}
+}
+
+// submit sends the form from pageURL, as a person pressing its submit
+// button would, and returns the cookies the response set. It is only
+// submitted to the page's own origin: a form pointing elsewhere would
+// carry the session's cookies off-site.
+func (f *pageForm) submit(ctx context.Context, tr http.RoundTripper, profile BrowserProfile, pageURL string, cookies []*http.Cookie, headers [][2]string, verbose bool) ([]*http.Cookie, error) {
+	page, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	ref, err := url.Parse(f.action)
+	if err != nil {
+		return nil, fmt.Errorf("bad form action %q: %w", f.action, err)
+	}
+	target := page.ResolveReference(ref)
+	if target.Scheme != page.Scheme || target.Host != page.Host {
+		return nil, fmt.Errorf("refusing to submit form to %s: not the page's origin", target.Host)
+	}
+
+	formHeaders := append(append([][2]string{}, headers...),
+		[2]string{"Referer", pageURL},
+		[2]string{"Sec-Fetch-Site", "same-origin"},
+	)
+	var resp *http.Response
+	if f.method == "POST" {
+		formHeaders = append(formHeaders,
+			[2]string{"Content-Type", "application/x-www-form-urlencoded"},
+			[2]string{"Origin", page.Scheme + "://" + page.Host},
+		)
+		if verbose {
+			fmt.Fprintf(os.Stderr, "[*] Posting form to %s\n", target)
+		}
+		resp, _, err = doFetchWithBody(ctx, tr, profile, "POST", target.String(), formHeaders, cookies, f.values.Encode())
+	} else {
+		target.RawQuery = f.values.Encode()
+		if verbose {
+			fmt.Fprintf(os.Stderr, "[*] Submitting form to %s\n", target)
+		}
+		resp, _, err = doFetch(ctx, tr, profile, "GET", target.String(), formHeaders, cookies)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("submit form: %w", err)
+	}
+	return redirectCookies(resp), nil
+}
+
+// captchaResponseFields returns the form fields a solved widget's token
+// goes in. hCaptcha fills g-recaptcha-response too, for sites written
+// against reCAPTCHA.
+func captchaResponseFields(widget *html.Node, captchaType string) []string {
+	switch captchaType {
+	case "turnstile":
+		if name := getAttr(widget, "data-response-field-name"); name != "" {
+			return []string{name}
+		}
+		return []string{"cf-turnstile-response"}
+	case "hcaptcha":
+		return []string{"h-captcha-response", "g-recaptcha-response"}
+	default:
+		return []string{"g-recaptcha-response"}
+	}
+}
+
+// captchaTokenForm finds the form the captcha widget with sitekey sits
+// in, or the page's only form if the widget is outside one, and fills in
+// token as the widget's script would.
+func captchaTokenForm(body []byte, sitekey, captchaType, token string) (*pageForm, error) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	widgets := filterNodes(doc, func(n *html.Node) bool {
+		return getAttr(n, "data-sitekey") == sitekey
+	})
+	if len(widgets) == 0 {
+		return nil, fmt.Errorf("no captcha widget with sitekey %s", sitekey)
+	}
+	widget := widgets[0]
+	var form *html.Node
+	for p := widget.Parent; p != nil; p = p.Parent {
+		if p.Type == html.ElementNode && p.Data == "form" {
+			form = p
+			break
+		}
+	}
+	if form == nil {
+		forms := filterNodes(doc, func(n *html.Node) bool { return n.Data == "form" })
+		if len(forms) != 1 {
+			return nil, fmt.Errorf("captcha widget is outside a form and the page has %d forms", len(forms))
+		}
+		form = forms[0]
+	}
+	// Submitting a form people fill in (a login, comment or contact form)
+	// would post whatever its defaults are: only a form that is nothing
+	// but the captcha is sent.
+	responseFields := captchaResponseFields(widget, captchaType)
+	for _, n := range formFields(form) {
+		if userFacingField(n, responseFields) {
+			return nil, fmt.Errorf("captcha form has a field to fill in (%s %q); not submitting it", n.Data, getAttr(n, "name"))
+		}
+	}
+	f := newPageForm(form)
+	for _, field := range responseFields {
+		f.values.Set(field, token)
+	}
+	return f, nil
+}
+
+// userFacingField reports whether a form control is one a person fills
+// in, rather than hidden state, a button, or one of the captcha's own
+// response fields.
+func userFacingField(n *html.Node, responseFields []string) bool {
+	if slices.Contains(responseFields, getAttr(n, "name")) {
+		return false
+	}
+	if n.Data != "input" {
+		return true // textarea, select
+	}
+	switch strings.ToLower(getAttr(n, "type")) {
+	case "hidden", "submit", "button", "image", "reset":
+		return false
+	}
+	return true
+}
+
+// submitCaptchaToken submits a solved widget's token with the page's form
+// and returns the cookies the submission set.
+func submitCaptchaToken(ctx context.Context, tr http.RoundTripper, profile BrowserProfile, pageURL string, body []byte, sitekey, captchaType, token string, cookies []*http.Cookie, headers [][2]string, verbose bool) ([]*http.Cookie, error) {
+	form, err := captchaTokenForm(body, sitekey, captchaType, token)
+	if err != nil {
+		return nil, err
+	}
+	return form.submit(ctx, tr, profile, pageURL, cookies, headers, verbose)
+}
@@ -9,9 +9,19 @@ import (
 type ChallengeType int
 
 const (
-	ChallengeNone    ChallengeType = iota
+	ChallengeNone ChallengeType = iota
 	ChallengeJS
 	ChallengeCaptcha
+	// ChallengeAkamai is an Akamai Bot Manager block. It can't be solved
+	// here (it needs a browser-generated sensor_data payload) and is only
+	// detected so callers know why they got a 403.
+	ChallengeAkamai
+	// ChallengeIncapsula is an Imperva Incapsula interstitial, solved by
+	// running its scripts for the session cookies.
+	ChallengeIncapsula
+	// ChallengeDDoSGuard is DDoS-Guard's JS check, solved by running its
+	// inline script for the __ddg cookies.
+	ChallengeDDoSGuard
 )
 
 func (c ChallengeType) String() string {
@@ -22,6 +32,12 @@ func (c ChallengeType) String() string {
 		return "js"
 	case ChallengeCaptcha:
 		return "captcha"
+	case ChallengeAkamai:
+		return "akamai"
+	case ChallengeIncapsula:
+		return "incapsula"
+	case ChallengeDDoSGuard:
+		return "ddos-guard"
 	default:
 		return "unknown"
 	}
@@ -31,18 +47,41 @@ func detectChallenge(resp *http.Response, body []byte) ChallengeType {
 	server := strings.ToLower(resp.Header.Get("Server"))
 	isCloudflare := strings.Contains(server, "cloudflare")
 
-	// Check for captcha challenges first (higher priority)
-	if containsAny(body, [][]byte{
+	// Check for captcha challenges first (higher priority). A widget or a
+	// plain image captcha is only a challenge on an interstitial: on a
+	// regular page it guards one of its forms, and the page is the content.
+	if captchaInterstitial(resp, body) && (containsAny(body, [][]byte{
 		[]byte("turnstile"),
 		[]byte("challenges.cloudflare.com"),
 		[]byte("h-captcha"),
 		[]byte("data-sitekey"),
 		[]byte("g-recaptcha"),
 		[]byte("www.google.com/recaptcha"),
-	}) {
+	}) || hasImageCaptcha(body)) {
 		return ChallengeCaptcha
 	}
 
+	// Akamai Bot Manager blocks with a 403/429 or an interstitial page.
+	if isAkamai(resp, body) && (resp.StatusCode == 403 || resp.StatusCode == 429 ||
+		containsAny(body, [][]byte{
+			[]byte("/_sec/cp_challenge"),
+			[]byte("bm-verify"),
+			[]byte("sec-if-cpt"),
+		})) {
+		return ChallengeAkamai
+	}
+
+	if isIncapsulaInterstitial(resp, body) {
+		return ChallengeIncapsula
+	}
+
+	// DDoS-Guard serves its check page with a 403 (or a small 200 page
+	// that reloads itself once the cookies are set).
+	if isDDoSGuard(resp, body) && (resp.StatusCode == 403 || resp.StatusCode == 503 ||
+		(len(body) < 10000 && containsAny(body, [][]byte{[]byte("document.cookie")}))) {
+		return ChallengeDDoSGuard
+	}
+
 	// Check for Cloudflare JS challenge
 	if isCloudflare && (resp.StatusCode == 503 || resp.StatusCode == 403) {
 		if containsAny(body, [][]byte{
@@ -67,6 +106,50 @@ func detectChallenge(resp *http.Response, body []byte) ChallengeType {
 	return ChallengeNone
 }
 
+// isCloudflareManagedChallenge reports whether a captcha page is
+// Cloudflare's own interstitial (a managed challenge) rather than a site's
+// form with a Turnstile widget. Only there does the solved token go in
+// as the cf_clearance cookie; a widget's token is submitted with its form.
+func isCloudflareManagedChallenge(resp *http.Response, body []byte) bool {
+	return strings.Contains(strings.ToLower(resp.Header.Get("Server")), "cloudflare") &&
+		containsAny(body, [][]byte{
+			[]byte("_cf_chl"),
+			[]byte("cf-challenge"),
+			[]byte(cfChallengePath),
+		})
+}
+
+// captchaInterstitial reports whether a page with a captcha widget is a
+// challenge standing in for the page, worth a paid solve: an error
+// response, Cloudflare's managed challenge, or a page with little else on
+// it. A full page merely embedding a widget (in a contact, comment or
+// login form) is the content itself.
+func captchaInterstitial(resp *http.Response, body []byte) bool {
+	return resp.StatusCode >= 400 || len(body) < imageCaptchaMaxPage || isCloudflareManagedChallenge(resp, body)
+}
+
+// akamaiCookies are set by Akamai Bot Manager on protected sites.
+var akamaiCookies = map[string]bool{"_abck": true, "bm_sz": true, "ak_bmsc": true, "bm_sv": true}
+
+// isAkamai reports whether a response comes from an Akamai Bot Manager
+// protected site: Akamai's edge server, its bot cookies, or its sensor
+// script and error page references.
+func isAkamai(resp *http.Response, body []byte) bool {
+	if strings.Contains(strings.ToLower(resp.Header.Get("Server")), "akamaighost") {
+		return true
+	}
+	for _, c := range resp.Cookies() {
+		if akamaiCookies[c.Name] {
+			return true
+		}
+	}
+	return containsAny(body, [][]byte{
+		[]byte("sensor_data"),
+		[]byte("errors.edgesuite.net"),
+		[]byte("/_sec/cp_challenge"),
+	})
+}
+
 func containsAny(body []byte, patterns [][]byte) bool {
 	for _, p := range patterns {
 		if bytes.Contains(body, p) {
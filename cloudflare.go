@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// cfChallengePath is where Cloudflare serves its challenge scripts and
+// answer endpoints on every protected site.
+const cfChallengePath = "/cdn-cgi/challenge-platform/"
+
+// cfMaxSubmitDelay caps how long the challenge script may make us wait
+// before the answer is posted.
+const cfMaxSubmitDelay = 10 * time.Second
+
+var (
+	cfFormRe  = regexp.MustCompile(`(?is)<form\b([^>]*\bid\s*=\s*["']challenge-form["'][^>]*)>(.*?)</form>`)
+	cfInputRe = regexp.MustCompile(`(?i)<input\b([^>]*)>`)
+	cfAttrRe  = regexp.MustCompile(`([a-zA-Z][\w-]*)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+	cfSrcRe   = regexp.MustCompile(`(?i)<script[^>]+src\s*=\s*["']([^"']*/cdn-cgi/challenge-platform/[^"']*)["']`)
+)
+
+// cfInput is a field of the challenge form, in page order.
+type cfInput struct {
+	id, name, value string
+}
+
+// cfChallengeForm is the answer form of a Cloudflare challenge page.
+type cfChallengeForm struct {
+	action string
+	inputs []cfInput
+}
+
+// htmlAttrs parses the attributes of an HTML tag.
+func htmlAttrs(tag string) map[string]string {
+	attrs := map[string]string{}
+	for _, m := range cfAttrRe.FindAllStringSubmatch(tag, -1) {
+		attrs[strings.ToLower(m[1])] = strings.ReplaceAll(m[2]+m[3], "&amp;", "&")
+	}
+	return attrs
+}
+
+// extractCFChallengeForm returns the page's #challenge-form, if it has one.
+func extractCFChallengeForm(body []byte) (*cfChallengeForm, bool) {
+	m := cfFormRe.FindSubmatch(body)
+	if m == nil {
+		return nil, false
+	}
+	form := &cfChallengeForm{action: htmlAttrs(string(m[1]))["action"]}
+	for _, in := range cfInputRe.FindAllSubmatch(m[2], -1) {
+		attrs := htmlAttrs(string(in[1]))
+		if attrs["name"] == "" {
+			continue
+		}
+		form.inputs = append(form.inputs, cfInput{id: attrs["id"], name: attrs["name"], value: attrs["value"]})
+	}
+	return form, true
+}
+
+// cfChallengeURL resolves ref against the page and reports whether it is a
+// Cloudflare challenge endpoint on the page's own origin. Only those are
+// fetched or posted to, so a page can't steer the solver elsewhere.
+func cfChallengeURL(page *url.URL, ref string) (string, bool) {
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", false
+	}
+	u := page.ResolveReference(r)
+	if u.Scheme != page.Scheme || u.Host != page.Host {
+		return "", false
+	}
+	if strings.HasPrefix(u.Path, cfChallengePath) {
+		return u.String(), true
+	}
+	for k := range u.Query() {
+		if strings.HasPrefix(k, "__cf_chl_") {
+			return u.String(), true
+		}
+	}
+	return "", false
+}
+
+func init() {
+	registerSolver(cloudflareSolver{})
+}
+
+// cloudflareSolver solves JS challenges: Cloudflare pages with an answer
+// form go through the full challenge flow, other pages only need the
+// cookie their script sets.
+type cloudflareSolver struct{}
+
+func (cloudflareSolver) Name() string { return ChallengeJS.String() }
+func (cloudflareSolver) runsScripts() {}
+
+func (cloudflareSolver) Detect(resp *http.Response, body []byte) bool {
+	return detectChallenge(resp, body) == ChallengeJS
+}
+
+// Solve runs the challenge flow when the page has an answer form, and
+// falls back to the page script's cookie without one or when it fails.
+func (cloudflareSolver) Solve(ctx context.Context, f *Fetcher, resp *http.Response, body []byte) (*Solution, error) {
+	pageURL := resp.Request.URL.String()
+	if _, ok := extractCFChallengeForm(body); ok {
+		solved, err := solveCloudflareChallenge(ctx, f, pageURL, body)
+		if err == nil {
+			return &Solution{Cookies: solved}, nil
+		}
+		if f.verbose {
+			fmt.Fprintf(os.Stderr, "[*] Cloudflare challenge flow failed: %v\n", err)
+		}
+	}
+	script := extractScriptContent(body)
+	if script == "" {
+		return nil, fmt.Errorf("no inline script on the challenge page")
+	}
+	result, err := newJSSolver(pageURL, body).Solve(ctx, script)
+	if err != nil {
+		return nil, err
+	}
+	if result.CookieName == "" {
+		return nil, fmt.Errorf("challenge script set no cookie")
+	}
+	return &Solution{Cookies: []*http.Cookie{{Name: result.CookieName, Value: result.CookieValue}}}, nil
+}
+
+// solveCloudflareChallenge runs Cloudflare's challenge flow for a page
+// with an answer form: it loads the challenge-platform scripts the page
+// includes or injects, runs them with the page script in the JS sandbox,
+// waits as long as the script would, posts the form to its challenge
+// endpoint and returns the cookies the answer earned (cf_clearance).
+func solveCloudflareChallenge(ctx context.Context, f *Fetcher, pageURL string, body []byte) ([]*http.Cookie, error) {
+	form, ok := extractCFChallengeForm(body)
+	if !ok {
+		return nil, fmt.Errorf("no challenge form")
+	}
+	page, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Scripts the page includes, then those its scripts inject, as a
+	// browser would load them; two rounds cover the orchestrator.
+	inline := extractScriptContent(body)
+	var srcs []string
+	for _, m := range cfSrcRe.FindAllSubmatch(body, -1) {
+		srcs = append(srcs, string(m[1]))
+	}
+	loaded := map[string]bool{}
+	var external []string
+	var result *SolveResult
+	for round := 0; round < 2; round++ {
+		for _, src := range srcs {
+			u, ok := cfChallengeURL(page, src)
+			if !ok || loaded[u] {
+				continue
+			}
+			loaded[u] = true
+			_, sbody, err := f.Fetch(ctx, "GET", u, [][2]string{
+				{"Accept", "*/*"},
+				{"Referer", pageURL},
+				{"Sec-Fetch-Dest", "script"},
+				{"Sec-Fetch-Mode", "no-cors"},
+				{"Sec-Fetch-Site", "same-origin"},
+			}, "")
+			if err != nil {
+				return nil, fmt.Errorf("fetch challenge script: %w", err)
+			}
+			external = append(external, string(sbody))
+		}
+
+		solver := newJSSolver(pageURL, body)
+		solver.cookies = f.Cookies()
+		result, err = solver.Solve(ctx, strings.Join(append([]string{inline}, external...), "\n;\n"))
+		if err != nil {
+			return nil, err
+		}
+		if result.Submitted || len(result.Scripts) == 0 {
+			break
+		}
+		srcs = result.Scripts
+	}
+	// Requests the scripts made with fetch/XHR go out before the form,
+	// as in a browser; only same-origin challenge endpoints are replayed.
+	earned := result.Cookies
+	f.cookies = mergeCookies(f.cookies, earned)
+	for _, r := range result.Requests {
+		target, ok := cfChallengeURL(page, r.URL)
+		if !ok {
+			if f.verbose {
+				fmt.Fprintf(os.Stderr, "[*] Not replaying script request to %s\n", r.URL)
+			}
+			continue
+		}
+		rh := append([][2]string{{"Referer", pageURL}, {"Sec-Fetch-Site", "same-origin"}, {"Sec-Fetch-Mode", "cors"}, {"Sec-Fetch-Dest", "empty"}}, r.Headers...)
+		resp, _, err := f.Fetch(ctx, r.Method, target, rh, r.Body)
+		if err != nil {
+			return nil, fmt.Errorf("replay challenge request: %w", err)
+		}
+		earned = mergeCookies(earned, redirectCookies(resp))
+	}
+	if !result.Submitted {
+		if findCookie(earned, "cf_clearance") != nil {
+			return earned, nil
+		}
+		return nil, fmt.Errorf("challenge script did not submit the form")
+	}
+
+	action := form.action
+	if result.FormAction != "" {
+		action = result.FormAction
+	}
+	target, ok := cfChallengeURL(page, action)
+	if !ok {
+		return nil, fmt.Errorf("refusing to post challenge answer to %q: not a Cloudflare challenge endpoint of %s", action, page.Host)
+	}
+	// The page's fields in order, with the values the script left in
+	// them, then any fields the script added.
+	values := url.Values{}
+	for _, in := range form.inputs {
+		v := in.value
+		if fv, ok := result.FormData[in.name]; ok {
+			v = fv
+		}
+		values.Add(in.name, v)
+	}
+	for name, v := range result.FormData {
+		if !values.Has(name) {
+			values.Set(name, v)
+		}
+	}
+
+	// The solver already waited up to jsMaxTimerDelay of the delay.
+	if delay := min(result.Delay, cfMaxSubmitDelay) - min(result.Delay, jsMaxTimerDelay); delay > 0 {
+		if f.verbose {
+			fmt.Fprintf(os.Stderr, "[*] Waiting %s before answering the challenge\n", delay)
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	postHeaders := [][2]string{
+		{"Content-Type", "application/x-www-form-urlencoded"},
+		{"Origin", page.Scheme + "://" + page.Host},
+		{"Referer", pageURL},
+		{"Sec-Fetch-Site", "same-origin"},
+		{"Cache-Control", "max-age=0"},
+	}
+	resp, _, err := f.Fetch(ctx, "POST", target, postHeaders, values.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("post challenge answer: %w", err)
+	}
+
+	earned = mergeCookies(earned, redirectCookies(resp))
+	if findCookie(earned, "cf_clearance") != nil {
+		return earned, nil
+	}
+	return nil, fmt.Errorf("challenge answer earned no cf_clearance (HTTP %d)", resp.StatusCode)
+}
+
+// redirectCookies returns the cookies set by resp and by the redirect
+// responses that led to it, oldest first.
+func redirectCookies(resp *http.Response) []*http.Cookie {
+	var chain []*http.Response
+	for r := resp; r != nil; {
+		chain = append(chain, r)
+		if r.Request == nil {
+			break
+		}
+		r = r.Request.Response
+	}
+	var cookies []*http.Cookie
+	for i := len(chain) - 1; i >= 0; i-- {
+		cookies = mergeCookies(cookies, chain[i].Cookies())
+	}
+	return cookies
+}
+
+// findCookie returns the cookie called name, or nil.
+func findCookie(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, c := range cookies {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
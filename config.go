@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Config holds user defaults loaded from the config file. Command-line
+// flags always take precedence over values set here.
+type Config struct {
+	// Process is the default output pipeline, e.g. ["readability", "truncate:4000"].
+	Process []string `json:"process,omitempty"`
+	// Accept overrides the Accept header per output mode for fetches.
+	// Keys are "json", "markdown" and "raw".
+	Accept map[string]string `json:"accept,omitempty"`
+	// Presets adds or overrides --preset bundles: preset name to flag
+	// name (without dashes) to value, e.g. {"agent": {"timeout": "60s"}}.
+	Presets map[string]map[string]string `json:"presets,omitempty"`
+	// Crawl holds crawl settings such as per-pattern depth limits.
+	Crawl crawlConfig `json:"crawl,omitempty"`
+	// SolverPlugins lists challenge solver plugin executables, loaded
+	// before any given with --solver-plugin.
+	SolverPlugins []string `json:"solver_plugins,omitempty"`
+	// Captcha holds captcha polling and 2captcha settings, overridden by
+	// the --captcha-* flags.
+	Captcha captchaConfig `json:"captcha,omitempty"`
+	// Markdown holds HTML to markdown conversion options, overridden by
+	// the --md-* flags.
+	Markdown markdownConfig `json:"markdown,omitempty"`
+}
+
+// appConfig is the configuration loaded before any subcommand runs.
+// It is never nil after loadAppConfig succeeds.
+var appConfig = &Config{}
+
+// defaultConfigPath returns the default path for the config file:
+// ~/.ghostfetch/config.json
+func defaultConfigPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return filepath.Join(home, ".ghostfetch", "config.json")
+}
+
+// loadConfig reads a JSON config file. If the file does not exist,
+// loadConfig returns an empty Config (no error).
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	if cfg.Crawl.Depth, err = compileDepthRules(cfg.Crawl.Depth); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// loadAppConfig loads the config file named by --config (or the default
+// path) into appConfig.
+func loadAppConfig() error {
+	path := flagConfig
+	if path == "" {
+		path = defaultConfigPath()
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	appConfig = cfg
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// cookieEntry is how the cookies subcommand shows a stored cookie. Values
+// are left out, so listings can be shared without handing out sessions.
+type cookieEntry struct {
+	Name    string    `json:"name"`
+	Domain  string    `json:"domain"`
+	Path    string    `json:"path"`
+	Expires time.Time `json:"expires,omitzero"`
+	Secure  bool      `json:"secure,omitempty"`
+	Profile string    `json:"profile,omitempty"`
+}
+
+// matchesDomain reports whether the cookie belongs to domain or one of its
+// subdomains. An empty domain matches every cookie.
+func (sc savedCookie) matchesDomain(domain string) bool {
+	if domain == "" {
+		return true
+	}
+	h := sc.host()
+	return h == domain || strings.HasSuffix(h, "."+domain)
+}
+
+// Stored returns a copy of the cookies the jar would save.
+func (p *PersistentJar) Stored() []savedCookie {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return slices.Clone(p.tracked)
+}
+
+// normalizeCookieDomain turns a --domain value into a bare lowercase host.
+func normalizeCookieDomain(domain string) string {
+	host, _, _ := strings.Cut(normalizeSite(domain), "/")
+	return strings.TrimPrefix(host, ".")
+}
+
+// openCookieJar loads the persistent cookie jar for the cookies
+// subcommand.
+func openCookieJar() (*PersistentJar, error) {
+	jar := newPersistentJar(defaultCookieJarPath())
+	if err := jar.Load(); err != nil {
+		return nil, fmt.Errorf("failed to load cookies: %w", err)
+	}
+	return jar, nil
+}
+
+// runCookiesList prints the stored cookies of domain (all if empty),
+// sorted by domain and name, as a table or JSON.
+func runCookiesList(domain string) error {
+	jar, err := openCookieJar()
+	if err != nil {
+		return err
+	}
+	domain = normalizeCookieDomain(domain)
+	var entries []cookieEntry
+	for _, sc := range jar.Stored() {
+		if sc.matchesDomain(domain) {
+			entries = append(entries, cookieEntry{
+				Name:    sc.Name,
+				Domain:  sc.host(),
+				Path:    cmp.Or(sc.Path, "/"),
+				Expires: sc.Expires,
+				Secure:  sc.Secure,
+				Profile: sc.Profile,
+			})
+		}
+	}
+	slices.SortFunc(entries, func(a, b cookieEntry) int {
+		return cmp.Or(cmp.Compare(a.Domain, b.Domain), cmp.Compare(a.Name, b.Name), cmp.Compare(a.Path, b.Path))
+	})
+
+	if flagJSONOutput {
+		if entries == nil {
+			entries = []cookieEntry{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tDOMAIN\tPATH\tEXPIRES")
+	for _, e := range entries {
+		expires := "session"
+		if !e.Expires.IsZero() {
+			expires = e.Expires.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", e.Name, e.Domain, e.Path, expires)
+	}
+	return tw.Flush()
+}
+
+// runCookiesDelete removes the cookies of domain (all domains if empty)
+// whose name is one of names, or all of them if names is empty, and
+// saves the jar.
+func runCookiesDelete(names []string, domain string) error {
+	jar, err := openCookieJar()
+	if err != nil {
+		return err
+	}
+	domain = normalizeCookieDomain(domain)
+	removed := 0
+	for _, sc := range jar.Stored() {
+		if sc.matchesDomain(domain) && (len(names) == 0 || slices.Contains(names, sc.Name)) {
+			jar.Forget(sc)
+			removed++
+		}
+	}
+	if removed == 0 {
+		fmt.Fprintln(os.Stderr, "[*] No matching cookies")
+		return nil
+	}
+	if err := jar.Save(); err != nil {
+		return fmt.Errorf("failed to save cookies: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "[*] Deleted %d cookie(s)\n", removed)
+	return nil
+}
+
+// runCookiesGC removes expired cookies and stale clearances from the jar,
+// and with olderThan (a date or an age such as "30d") those not set again
+// since then, and saves it.
+func runCookiesGC(olderThan string) error {
+	var cutoff time.Time
+	if olderThan != "" {
+		var err error
+		if cutoff, err = parseSearchTime(olderThan, time.Now()); err != nil {
+			return fmt.Errorf("invalid --older-than %q: %w", olderThan, err)
+		}
+	}
+	jar, err := openCookieJar()
+	if err != nil {
+		return err
+	}
+	removed := jar.expired + jar.GC(cutoff)
+	if err := jar.Save(); err != nil {
+		return fmt.Errorf("failed to save cookies: %w", err)
+	}
+	fmt.Fprintf(os.Stderr, "[*] Removed %d cookie(s), %d left\n", removed, len(jar.Stored()))
+	return nil
+}
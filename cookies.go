@@ -1,12 +1,17 @@
 package main
 
 import (
+	"cmp"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -22,16 +27,73 @@ type PersistentJar struct {
 	path    string
 	mu      sync.Mutex
 	tracked []savedCookie
+	// expired counts the saved cookies Load skipped as expired.
+	expired int
 }
 
 type savedCookie struct {
-	Name    string    `json:"name"`
-	Value   string    `json:"value"`
-	Domain  string    `json:"domain"`
-	Path    string    `json:"path"`
-	Expires time.Time `json:"expires"`
-	Secure  bool      `json:"secure"`
-	URL     string    `json:"url"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	// Domain is the cookie's domain; with HostOnly (no Domain attribute)
+	// it is the host that set it, and the cookie is sent to that host
+	// only, not its subdomains.
+	Domain   string `json:"domain"`
+	HostOnly bool   `json:"host_only,omitempty"`
+	// Path is the cookie's effective path: its Path attribute, or the
+	// default path of the URL that set it.
+	Path     string    `json:"path"`
+	Expires  time.Time `json:"expires"`
+	Secure   bool      `json:"secure"`
+	HttpOnly bool      `json:"http_only,omitempty"`
+	SameSite string    `json:"same_site,omitempty"`
+	URL      string    `json:"url"`
+	// Profile is the browser profile that obtained a clearance cookie.
+	// Anti-bot vendors bind clearance to the TLS/HTTP fingerprint, so
+	// replaying it under another profile silently invalidates it.
+	Profile string `json:"profile,omitempty"`
+	// SolvedAt is when a clearance cookie was obtained.
+	SolvedAt time.Time `json:"solved_at,omitzero"`
+	// SetAt is when a site last set the cookie; zero for cookies saved
+	// before it was recorded.
+	SetAt time.Time `json:"set_at,omitzero"`
+}
+
+// stale reports whether the cookie is no use anymore at now: expired, a
+// clearance past its validity, or not set again since cutoff. A zero
+// cutoff keeps cookies however long they've been idle.
+func (sc savedCookie) stale(now, cutoff time.Time) bool {
+	switch {
+	case !sc.Expires.IsZero() && sc.Expires.Before(now):
+		return true
+	case !sc.SolvedAt.IsZero() && sc.validUntil().Before(now):
+		return true
+	default:
+		return !sc.SetAt.IsZero() && sc.SetAt.Before(cutoff)
+	}
+}
+
+// clearanceSessionTTL is how long a clearance cookie without an expiry
+// is trusted after it was obtained.
+const clearanceSessionTTL = 30 * time.Minute
+
+// validUntil returns when a clearance cookie stops being trusted.
+func (sc savedCookie) validUntil() time.Time {
+	if !sc.Expires.IsZero() {
+		return sc.Expires
+	}
+	return sc.SolvedAt.Add(clearanceSessionTTL)
+}
+
+// clearanceCookieNames are anti-bot clearance cookies that are bound to
+// the fingerprint of the client that earned them. Only cookies issued
+// once a challenge is passed count: bot-management cookies set on every
+// response (__cf_bm, _abck, ak_bmsc, datadome, incap_ses_*) would make
+// any page look cleared. Cookies a solver earns are pinned regardless,
+// through SetClearanceCookies.
+var clearanceCookieNames = map[string]bool{
+	"cf_clearance": true,
+	"__ddg2_":      true,
+	"reese84":      true,
 }
 
 func newPersistentJar(path string) *PersistentJar {
@@ -42,50 +104,239 @@ func newPersistentJar(path string) *PersistentJar {
 }
 
 func (p *PersistentJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	p.setCookies(u, cookies, "")
+}
+
+// SetClearanceCookies stores cookies like SetCookies and pins them to the
+// browser profile that obtained them.
+func (p *PersistentJar) SetClearanceCookies(u *url.URL, cookies []*http.Cookie, profile string) {
+	p.setCookies(u, cookies, profile)
+}
+
+// SetResponseCookies stores cookies from a response fetched with profile,
+// pinning the well-known clearance cookies among them.
+func (p *PersistentJar) SetResponseCookies(u *url.URL, cookies []*http.Cookie, profile string) {
+	var plain, clearance []*http.Cookie
+	for _, c := range cookies {
+		if clearanceCookieNames[c.Name] {
+			clearance = append(clearance, c)
+		} else {
+			plain = append(plain, c)
+		}
+	}
+	p.setCookies(u, plain, "")
+	p.setCookies(u, clearance, profile)
+}
+
+func (p *PersistentJar) setCookies(u *url.URL, cookies []*http.Cookie, profile string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.jar.SetCookies(u, cookies)
+	now := time.Now()
 	for _, c := range cookies {
-		urlKey := u.Scheme + "://" + u.Host
-		// Remove existing entry for same name+url to avoid duplicates
-		for i, tc := range p.tracked {
-			if tc.Name == c.Name && tc.URL == urlKey {
-				p.tracked = append(p.tracked[:i], p.tracked[i+1:]...)
-				break
+		sc := savedCookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   strings.ToLower(strings.TrimPrefix(c.Domain, ".")),
+			Path:     c.Path,
+			Expires:  c.Expires,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+			SameSite: sameSiteName(c.SameSite),
+			URL:      u.Scheme + "://" + u.Host,
+			Profile:  profile,
+			SetAt:    now,
+		}
+		if sc.Domain == "" {
+			sc.Domain, sc.HostOnly = strings.ToLower(u.Hostname()), true
+		}
+		if !strings.HasPrefix(sc.Path, "/") {
+			sc.Path = defaultCookiePath(u.Path)
+		}
+		// Max-Age wins over Expires (RFC 6265 5.3).
+		if c.MaxAge > 0 {
+			sc.Expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		}
+		p.untrack(sc)
+		// A cookie set with Max-Age<=0 or a past expiry deletes it.
+		if c.MaxAge < 0 || (!sc.Expires.IsZero() && sc.Expires.Before(now)) {
+			continue
+		}
+		if profile != "" {
+			sc.SolvedAt = now
+		}
+		p.tracked = append(p.tracked, sc)
+	}
+}
+
+// key identifies a stored cookie the way the jar does: by name, domain
+// and path.
+func (sc savedCookie) key() string {
+	return sc.Name + ";" + sc.host() + ";" + cmp.Or(sc.Path, "/")
+}
+
+// untrack drops the tracked cookie with sc's key, if any.
+func (p *PersistentJar) untrack(sc savedCookie) {
+	p.tracked = slices.DeleteFunc(p.tracked, func(tc savedCookie) bool { return tc.key() == sc.key() })
+}
+
+// host returns the host a stored cookie belongs to: its domain without
+// the leading dot, or, for cookies saved before domains were always
+// recorded, the host it was set by.
+func (sc savedCookie) host() string {
+	if sc.Domain != "" {
+		return strings.ToLower(strings.TrimPrefix(sc.Domain, "."))
+	}
+	if u, err := url.Parse(sc.URL); err == nil {
+		return strings.ToLower(u.Hostname())
+	}
+	return ""
+}
+
+// httpCookie returns the cookie to replay into the jar for sc's URL. A
+// host-only cookie (or one saved before that was recorded, without a
+// domain) gets no Domain attribute, so it stays host-only.
+func (sc savedCookie) httpCookie() *http.Cookie {
+	c := &http.Cookie{
+		Name:     sc.Name,
+		Value:    sc.Value,
+		Path:     sc.Path,
+		Expires:  sc.Expires,
+		Secure:   sc.Secure,
+		HttpOnly: sc.HttpOnly,
+		SameSite: parseSameSite(sc.SameSite),
+	}
+	if !sc.HostOnly {
+		c.Domain = sc.Domain
+	}
+	return c
+}
+
+// defaultCookiePath returns the default path of a cookie set by a request
+// for path (RFC 6265 5.1.4): the path up to its last "/", or "/".
+func defaultCookiePath(path string) string {
+	i := strings.LastIndex(path, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+// sameSiteName and parseSameSite convert a SameSite attribute to and from
+// how it is saved: "lax", "strict", "none", or "" when unset.
+func sameSiteName(s http.SameSite) string {
+	switch s {
+	case http.SameSiteLaxMode:
+		return "lax"
+	case http.SameSiteStrictMode:
+		return "strict"
+	case http.SameSiteNoneMode:
+		return "none"
+	}
+	return ""
+}
+
+func parseSameSite(s string) http.SameSite {
+	switch s {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteDefaultMode
+}
+
+// Clearance returns the clearance cookie that would be sent to u if it
+// was obtained with profile and is still valid.
+func (p *PersistentJar) Clearance(u *url.URL, profile string) (savedCookie, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for _, c := range p.jar.Cookies(u) {
+		for _, tc := range p.tracked {
+			if tc.Profile == profile && profile != "" && tc.Name == c.Name && tc.Value == c.Value &&
+				!tc.SolvedAt.IsZero() && now.Before(tc.validUntil()) {
+				return tc, true
 			}
 		}
-		p.tracked = append(p.tracked, savedCookie{
-			Name:    c.Name,
-			Value:   c.Value,
-			Domain:  c.Domain,
-			Path:    c.Path,
-			Expires: c.Expires,
-			Secure:  c.Secure,
-			URL:     urlKey,
-		})
 	}
+	return savedCookie{}, false
+}
+
+// Forget removes the cookie sc from the jar, e.g. a clearance the site no
+// longer accepts.
+func (p *PersistentJar) Forget(sc savedCookie) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.forget(sc)
+}
+
+// forget is Forget with p.mu held.
+func (p *PersistentJar) forget(sc savedCookie) {
+	if u, err := url.Parse(sc.URL); err == nil {
+		c := sc.httpCookie()
+		c.Value, c.Expires, c.MaxAge = "", time.Time{}, -1
+		p.jar.SetCookies(u, []*http.Cookie{c})
+	}
+	p.untrack(sc)
+}
+
+// PinnedProfile returns the profile a clearance cookie that would be sent
+// to u was obtained with, and that cookie's name. It returns empty
+// strings if no pinned cookie applies.
+func (p *PersistentJar) PinnedProfile(u *url.URL) (profile, cookie string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range p.jar.Cookies(u) {
+		for _, tc := range p.tracked {
+			if tc.Profile != "" && tc.Name == c.Name && tc.Value == c.Value {
+				return tc.Profile, tc.Name
+			}
+		}
+	}
+	return "", ""
 }
 
 func (p *PersistentJar) Cookies(u *url.URL) []*http.Cookie {
 	return p.jar.Cookies(u)
 }
 
-// Save writes all non-expired tracked cookies to the JSON file on disk.
+// GC removes the stale cookies (see savedCookie.stale), counting those
+// not set again since cutoff as stale unless cutoff is zero, and returns
+// how many it removed.
+func (p *PersistentJar) GC(cutoff time.Time) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.gc(cutoff)
+}
+
+// gc is GC with p.mu held.
+func (p *PersistentJar) gc(cutoff time.Time) int {
+	now := time.Now()
+	removed := 0
+	for _, sc := range slices.Clone(p.tracked) {
+		if sc.stale(now, cutoff) {
+			p.forget(sc)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Save writes the tracked cookies to the JSON file on disk, first
+// dropping expired ones and clearances past their validity. Idle cookies
+// are only dropped by an explicit GC, since a long-lived session cookie
+// stays valid whether or not the site sets it again.
 func (p *PersistentJar) Save() error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	if err := os.MkdirAll(filepath.Dir(p.path), 0700); err != nil {
 		return err
 	}
-	now := time.Now()
-	var active []savedCookie
-	for _, sc := range p.tracked {
-		if !sc.Expires.IsZero() && sc.Expires.Before(now) {
-			continue
-		}
-		active = append(active, sc)
-	}
-	data, err := json.MarshalIndent(active, "", "  ")
+	p.gc(time.Time{})
+	data, err := json.MarshalIndent(p.tracked, "", "  ")
 	if err != nil {
 		return err
 	}
@@ -111,16 +362,91 @@ func (p *PersistentJar) Load() error {
 	now := time.Now()
 	for _, sc := range saved {
 		if !sc.Expires.IsZero() && sc.Expires.Before(now) {
+			p.expired++
 			continue
 		}
 		u, err := url.Parse(sc.URL)
 		if err != nil {
 			continue
 		}
-		p.jar.SetCookies(u, []*http.Cookie{
-			{Name: sc.Name, Value: sc.Value, Domain: sc.Domain, Path: sc.Path, Expires: sc.Expires, Secure: sc.Secure},
-		})
+		// Entries saved before host-only cookies were marked have no
+		// domain when host-only.
+		if sc.Domain == "" {
+			sc.Domain, sc.HostOnly = strings.ToLower(u.Hostname()), true
+		}
+		p.jar.SetCookies(u, []*http.Cookie{sc.httpCookie()})
 		p.tracked = append(p.tracked, sc)
 	}
 	return nil
 }
+
+// inlineCookies parses --cookie values into the cookies to send to
+// targetURL. Each value is curl-style: "name=value; other=2", or @file
+// holding the same or a Netscape cookies.txt, whose cookies are only sent
+// where their domain, path and secure flag allow.
+func inlineCookies(values []string, targetURL string) ([]*http.Cookie, error) {
+	var cookies []*http.Cookie
+	for _, v := range values {
+		if file, ok := strings.CutPrefix(v, "@"); ok {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("cookie file: %w", err)
+			}
+			if strings.Contains(string(data), "\t") {
+				found, err := netscapeCookies(string(data), targetURL)
+				if err != nil {
+					return nil, fmt.Errorf("cookie file %s: %w", file, err)
+				}
+				cookies = mergeCookies(cookies, found)
+				continue
+			}
+			v = strings.ReplaceAll(strings.TrimSpace(string(data)), "\n", ";")
+		}
+		for _, pair := range strings.Split(v, ";") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			name, value, ok := strings.Cut(pair, "=")
+			if name = strings.TrimSpace(name); !ok || name == "" {
+				return nil, fmt.Errorf("invalid cookie %q: want name=value", pair)
+			}
+			cookies = mergeCookies(cookies, []*http.Cookie{{Name: name, Value: strings.TrimSpace(value)}})
+		}
+	}
+	return cookies, nil
+}
+
+// netscapeCookies returns the cookies of a Netscape cookies.txt (as
+// written by curl and browser extensions) that apply to targetURL.
+func netscapeCookies(data string, targetURL string) ([]*http.Cookie, error) {
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return nil, err
+	}
+	host := strings.ToLower(u.Hostname())
+	path := cmp.Or(u.Path, "/")
+	now := time.Now()
+	var cookies []*http.Cookie
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(strings.TrimPrefix(line, "#HttpOnly_"))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		f := strings.Split(line, "\t")
+		if len(f) < 7 {
+			return nil, fmt.Errorf("line %d: want 7 tab-separated fields", i+1)
+		}
+		domain, subdomains, cookiePath, secure := strings.ToLower(strings.TrimPrefix(f[0], ".")), f[1] == "TRUE", f[2], f[3] == "TRUE"
+		if host != domain && !(subdomains && strings.HasSuffix(host, "."+domain)) {
+			continue
+		}
+		if !strings.HasPrefix(path, cookiePath) || (secure && u.Scheme != "https") {
+			continue
+		}
+		if exp, err := strconv.ParseInt(f[4], 10, 64); err == nil && exp != 0 && time.Unix(exp, 0).Before(now) {
+			continue
+		}
+		cookies = mergeCookies(cookies, []*http.Cookie{{Name: f[5], Value: f[6]}})
+	}
+	return cookies, nil
+}
@@ -0,0 +1,364 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// crawlLink is a page waiting in the crawl frontier.
+type crawlLink struct {
+	URL     string `json:"url"`
+	Depth   int    `json:"depth"`
+	Referer string `json:"referer,omitempty"`
+}
+
+// crawlParams holds the crawl subcommand's settings.
+type crawlParams struct {
+	// MaxDepth is the default number of link hops followed from the
+	// start URL; crawl.depth rules in the config override it per path.
+	MaxDepth int
+	// SameDomain keeps the crawl on the start URL's domain and its
+	// subdomains.
+	SameDomain bool
+	MaxPages   int
+	// MaxBytes caps the body bytes downloaded (e.g. "500MB"); empty is
+	// no cap.
+	MaxBytes string
+	// PerHost limits the requests in flight to any one host, and Delay
+	// spaces successive requests to a host (jittered). QPS caps request
+	// starts per second across all hosts; 0 is no cap.
+	PerHost int
+	Delay   time.Duration
+	QPS     float64
+	// Graph, when set, is the file the link graph is written to.
+	Graph string
+	// Resume, when set, is the state file the crawl is saved to after
+	// every level and resumed from if it exists.
+	Resume string
+}
+
+// runCrawl fetches start and then, breadth first, the pages it links to,
+// up to p.MaxDepth link hops away (or the depth of the first matching
+// crawl.depth rule in the config) and at most p.MaxPages pages. Each level
+// is fetched concurrently through the full fetch pipeline, every page as
+// a click from the page that linked to it, and the pages are written like
+// a parallel fetch's (markdown unless another format is asked for), in
+// the order they were found.
+//
+// Pages the result filters (--only-status, --min-body-bytes,
+// --body-matches, --only-lang) leave out are reported as skipped, but
+// their links are still followed. With --canonical-map, links are fetched
+// at their known canonical URL, aliases of a page already queued aren't
+// fetched again, and the redirects and canonical links seen are recorded.
+//
+// With p.Resume, pages go to --out-dir level by level and the frontier
+// and visited set are saved to the state file after each level, so an
+// interrupted crawl started again with the same file carries on where it
+// stopped. The state file is removed once the frontier is exhausted.
+func runCrawl(start string, p crawlParams) error {
+	if !strings.Contains(start, "://") {
+		start = "https://" + start
+	}
+	su, err := url.Parse(start)
+	if err != nil || su.Host == "" {
+		return fmt.Errorf("invalid URL %q", start)
+	}
+	if p.MaxDepth < 0 || p.MaxPages < 1 {
+		return fmt.Errorf("--depth must be >= 0 and --max-pages >= 1")
+	}
+	if flagMirror && flagOutDir == "" {
+		return fmt.Errorf("--mirror needs --out-dir")
+	}
+	if p.Resume != "" && (flagOutDir == "" || flagMirror) {
+		return fmt.Errorf("--resume needs --out-dir (and can't be combined with --mirror), so pages fetched before an interruption are kept")
+	}
+	if p.QPS < 0 {
+		return fmt.Errorf("invalid --qps %g", p.QPS)
+	}
+	var maxBytes int64
+	if p.MaxBytes != "" {
+		if maxBytes, err = parseByteSize(p.MaxBytes); err != nil {
+			return fmt.Errorf("--max-bytes: %w", err)
+		}
+	}
+	domain := strings.TrimPrefix(strings.ToLower(su.Hostname()), "www.")
+
+	if len(flagProcess) == 0 && !flagMarkdownFull && !flagRaw {
+		flagMarkdown = true
+	}
+	opts, err := newOutputOptions("")
+	if err != nil {
+		return err
+	}
+	filter, err := newResultFilter()
+	if err != nil {
+		return err
+	}
+	canon, err := openCanonicalMap()
+	if err != nil {
+		return err
+	}
+	// canonical returns the known canonical URL of link, or link itself.
+	canonical := func(link string) string {
+		if canon != nil {
+			if c, ok := canon.Lookup(link); ok {
+				return c
+			}
+		}
+		return link
+	}
+	maxPar := flagMaxParallel
+	if maxPar <= 0 {
+		maxPar = 5
+	}
+
+	var nd *resultStream
+	if streamResults() {
+		if nd, err = newResultStream(opts); err != nil {
+			return err
+		}
+		defer nd.Close()
+	}
+
+	state := &crawlState{Start: start, Frontier: []crawlLink{{URL: canonical(start)}}}
+	if p.Resume != "" {
+		loaded, err := loadCrawlState(p.Resume, start)
+		if err != nil {
+			return err
+		}
+		if loaded != nil {
+			state = loaded
+			fmt.Fprintf(os.Stderr, "[*] Resuming crawl: %d page(s) fetched, %d queued\n", state.Fetched, len(state.Frontier))
+		}
+	}
+	if p.Graph != "" && state.Graph == nil {
+		state.Graph = newLinkGraph()
+		state.Graph.node(start, 0)
+	}
+	graph := state.Graph
+
+	seen := map[string]bool{normalizeURL(start): true, normalizeURL(canonical(start)): true}
+	for _, u := range state.Seen {
+		seen[u] = true
+	}
+	level := state.Frontier
+	var results []fetchResult
+	throttle := newHostThrottle(p.PerHost, p.Delay, p.QPS)
+	budget := &byteBudget{limit: maxBytes}
+	budget.used.Store(state.Bytes)
+	for len(level) > 0 && state.Fetched < p.MaxPages && !budget.spent() {
+		// Pages cut off by --max-pages stay queued for a resumed crawl.
+		n := min(len(level), p.MaxPages-state.Fetched)
+		rest := level[n:]
+		level = level[:n]
+		if flagVerbose {
+			depth := fmt.Sprint(level[0].Depth)
+			if last := level[len(level)-1].Depth; last != level[0].Depth {
+				depth += fmt.Sprintf("-%d", last)
+			}
+			fmt.Fprintf(os.Stderr, "[*] Crawling depth %s: %d page(s)\n", depth, len(level))
+		}
+		fetched, unfetched := crawlLevel(level, maxPar, throttle, budget, filter, nd)
+		rest = append(unfetched, rest...)
+		state.Fetched += len(fetched)
+		state.Bytes = budget.used.Load()
+		if canon != nil {
+			for i := range fetched {
+				canon.recordResult(&fetched[i])
+			}
+		}
+
+		var next []crawlLink
+		for i := range fetched {
+			r := &fetched[i]
+			if graph != nil {
+				graph.visit(r)
+			}
+			follow := r.Depth < p.MaxDepth || len(appConfig.Crawl.Depth) > 0
+			if r.Error != nil || !follow && graph == nil {
+				continue
+			}
+			if r.processInput().notHTML() {
+				continue
+			}
+			// Links resolve against the page's final URL, and a page reached
+			// through a redirect isn't fetched again under that URL.
+			base := r.finalURL()
+			seen[normalizeURL(base)] = true
+			for _, l := range extractLinks(r.Body, base) {
+				link, ok := crawlTarget(l.URL)
+				if !ok {
+					continue
+				}
+				if graph != nil {
+					graph.link(r.URL, link, r.Depth+1)
+				}
+				if !follow || seen[normalizeURL(link)] {
+					continue
+				}
+				if c := canonical(link); c != link {
+					seen[normalizeURL(link)] = true
+					if seen[normalizeURL(c)] {
+						if flagVerbose {
+							fmt.Fprintf(os.Stderr, "[*] Skipping %s: alias of %s already in the crawl\n", link, c)
+						}
+						continue
+					}
+					link = c
+				}
+				if p.SameDomain && !inDomain(link, domain) {
+					continue
+				}
+				if r.Depth+1 > depthLimit(appConfig.Crawl.Depth, link, p.MaxDepth) {
+					continue
+				}
+				seen[normalizeURL(link)] = true
+				next = append(next, crawlLink{URL: link, Depth: r.Depth + 1, Referer: base})
+			}
+		}
+		level = append(slices.Clone(rest), next...)
+
+		if p.Resume == "" {
+			if nd == nil {
+				results = append(results, fetched...)
+			}
+			continue
+		}
+		if err := writeParallelFiles(flagOutDir, fetched, opts); err != nil {
+			return err
+		}
+		state.Frontier = level
+		state.Seen = slices.Sorted(maps.Keys(seen))
+		if err := state.save(p.Resume); err != nil {
+			return fmt.Errorf("save crawl state: %w", err)
+		}
+	}
+
+	if len(level) > 0 {
+		limit := "--max-pages"
+		if budget.spent() {
+			limit = "--max-bytes"
+		}
+		fmt.Fprintf(os.Stderr, "[*] Crawl stopped at %s with %d page(s) not fetched\n", limit, len(level))
+	}
+	if graph != nil {
+		if err := graph.writeFile(p.Graph); err != nil {
+			return fmt.Errorf("write graph: %w", err)
+		}
+	}
+	if canon != nil {
+		if err := canon.Save(); err != nil && flagVerbose {
+			fmt.Fprintf(os.Stderr, "[*] Warning: failed to save canonical map: %v\n", err)
+		}
+	}
+	if p.Resume != "" {
+		if len(level) == 0 {
+			os.Remove(p.Resume)
+		} else if flagVerbose {
+			fmt.Fprintf(os.Stderr, "[*] State with %d page(s) queued kept in %s\n", len(level), p.Resume)
+		}
+		return nil
+	}
+	if nd != nil {
+		return nd.Close()
+	}
+	if flagMirror {
+		return writeMirror(flagOutDir, results)
+	}
+	if flagOutDir != "" {
+		return writeParallelFiles(flagOutDir, results, opts)
+	}
+	out, err := openOutput()
+	if err != nil {
+		return err
+	}
+	if opts.asJSON {
+		formatParallelJSON(out, results, opts)
+	} else {
+		formatParallelResults(out, results, opts)
+	}
+	return out.Close()
+}
+
+// crawlLevel fetches one level of the crawl, at most maxPar pages at a
+// time and within throttle's per-host limits, returning the results in
+// the order of links, those filter leaves out marked as skipped. Links not
+// fetched because the byte budget ran out are returned separately, to
+// stay queued. nd, if set, gets each result as soon as it is fetched.
+func crawlLevel(links []crawlLink, maxPar int, throttle *hostThrottle, budget *byteBudget, filter *resultFilter, nd *resultStream) ([]fetchResult, []crawlLink) {
+	results := make([]fetchResult, len(links))
+	fetched := make([]bool, len(links))
+	sem := make(chan struct{}, maxPar)
+	var wg sync.WaitGroup
+	for i, l := range links {
+		wg.Add(1)
+		go func(idx int, l crawlLink) {
+			defer wg.Done()
+			// Wait for the host before taking a global slot, so a busy
+			// host doesn't hold up pages from other hosts.
+			release := throttle.acquire(l.URL)
+			defer release()
+			sem <- struct{}{}        // acquire semaphore slot
+			defer func() { <-sem }() // release semaphore slot
+			if budget.spent() {
+				return
+			}
+			fetched[idx] = true
+
+			fo := newFetchOptions(l.URL)
+			fo.referer = l.Referer
+			res, err := fetchOne(fo)
+			if err != nil {
+				results[idx] = fetchResult{URL: l.URL, Depth: l.Depth, Error: err}
+			} else {
+				budget.used.Add(int64(len(res.Body)))
+				res.Depth = l.Depth
+				res.Skipped = filter.reason(res)
+				results[idx] = *res
+			}
+			if nd != nil {
+				nd.write(&results[idx])
+			}
+		}(i, l)
+	}
+	wg.Wait()
+
+	var done []fetchResult
+	var left []crawlLink
+	for i, l := range links {
+		if fetched[i] {
+			done = append(done, results[i])
+		} else {
+			left = append(left, l)
+		}
+	}
+	return done, left
+}
+
+// crawlTarget returns the page a link points to without its fragment, if
+// it is an http(s) page.
+func crawlTarget(link string) (string, bool) {
+	u, err := url.Parse(link)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", false
+	}
+	u.Fragment, u.RawFragment = "", ""
+	return u.String(), true
+}
+
+// inDomain reports whether link's host is domain or one of its
+// subdomains (domain without "www.").
+func inDomain(link string, domain string) bool {
+	u, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// graphNode is a URL seen during a crawl. Pages that were linked to but
+// not fetched (out of scope, too deep, over --max-pages) have Crawled
+// unset and no status.
+type graphNode struct {
+	URL     string `json:"url"`
+	Depth   int    `json:"depth"`
+	Crawled bool   `json:"crawled"`
+	Status  int    `json:"status,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// graphEdge is a link from one page to another.
+type graphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// linkGraph records the pages of a crawl and the links between them.
+// Nodes are keyed by normalizeURL so links differing only in a fragment
+// share a node.
+type linkGraph struct {
+	Nodes []*graphNode `json:"nodes"`
+	Edges []graphEdge  `json:"edges"`
+	index map[string]*graphNode
+	edges map[graphEdge]bool
+}
+
+func newLinkGraph() *linkGraph {
+	return &linkGraph{
+		Nodes: []*graphNode{},
+		Edges: []graphEdge{},
+		index: make(map[string]*graphNode),
+		edges: make(map[graphEdge]bool),
+	}
+}
+
+// node returns the node for rawURL, adding it at depth if it is new.
+func (g *linkGraph) node(rawURL string, depth int) *graphNode {
+	key := normalizeURL(rawURL)
+	if n, ok := g.index[key]; ok {
+		return n
+	}
+	n := &graphNode{URL: rawURL, Depth: depth}
+	g.index[key] = n
+	g.Nodes = append(g.Nodes, n)
+	return n
+}
+
+// reindex rebuilds the lookup maps of a graph decoded from JSON.
+func (g *linkGraph) reindex() {
+	g.index = make(map[string]*graphNode, len(g.Nodes))
+	for _, n := range g.Nodes {
+		g.index[normalizeURL(n.URL)] = n
+	}
+	g.edges = make(map[graphEdge]bool, len(g.Edges))
+	for _, e := range g.Edges {
+		g.edges[e] = true
+	}
+}
+
+// visit records the outcome of fetching a page.
+func (g *linkGraph) visit(r *fetchResult) {
+	n := g.node(r.URL, r.Depth)
+	n.Crawled = true
+	n.Status = r.StatusCode
+	if r.Error != nil {
+		n.Error = r.Error.Error()
+	}
+}
+
+// link records a link from one page to another, found at depth.
+func (g *linkGraph) link(from, to string, depth int) {
+	e := graphEdge{From: g.node(from, depth-1).URL, To: g.node(to, depth).URL}
+	if e.From == e.To || g.edges[e] {
+		return
+	}
+	g.edges[e] = true
+	g.Edges = append(g.Edges, e)
+}
+
+// writeFile writes the graph to path, as Graphviz DOT when the name ends
+// in .dot or .gv and as JSON otherwise.
+func (g *linkGraph) writeFile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".dot", ".gv":
+		g.writeDOT(f)
+	default:
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "  ")
+		enc.Encode(g)
+	}
+	return f.Close()
+}
+
+// writeDOT writes the graph in Graphviz DOT. Each node is labelled with
+// its URL and status; pages that weren't fetched are drawn dashed and
+// failed ones red.
+func (g *linkGraph) writeDOT(w io.Writer) {
+	fmt.Fprintln(w, "digraph crawl {")
+	fmt.Fprintln(w, "  node [shape=box];")
+	for _, n := range g.Nodes {
+		label := n.URL
+		attrs := ""
+		switch {
+		case !n.Crawled:
+			attrs = ", style=dashed"
+		case n.Error != "":
+			label += "\nerror"
+			attrs = ", color=red"
+		default:
+			label += fmt.Sprintf("\n%d", n.Status)
+			if n.Status >= 400 {
+				attrs = ", color=red"
+			}
+		}
+		fmt.Fprintf(w, "  %s [label=%s%s];\n", dotQuote(n.URL), dotQuote(label), attrs)
+	}
+	for _, e := range g.Edges {
+		fmt.Fprintf(w, "  %s -> %s;\n", dotQuote(e.From), dotQuote(e.To))
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// dotQuote returns s as a DOT quoted string.
+func dotQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// crawlState is what a resumable crawl saves after each level: enough to
+// carry on without fetching any page twice.
+type crawlState struct {
+	Start string `json:"start"`
+	// Fetched counts the pages fetched so far, towards --max-pages.
+	Fetched int `json:"fetched"`
+	// Bytes counts the body bytes downloaded so far, towards --max-bytes.
+	Bytes int64 `json:"bytes,omitempty"`
+	// Seen holds the normalized URLs already fetched or queued.
+	Seen []string `json:"seen"`
+	// Frontier is the next level to fetch.
+	Frontier []crawlLink `json:"frontier"`
+	// Graph is the link graph so far, when --graph is used.
+	Graph *linkGraph `json:"graph,omitempty"`
+}
+
+// loadCrawlState reads the state file at path, returning nil if there is
+// none yet. A state file saved by a crawl of another start URL is an
+// error, so a typo can't silently continue the wrong crawl.
+func loadCrawlState(path, start string) (*crawlState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read crawl state: %w", err)
+	}
+	var s crawlState
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("parse crawl state %s: %w", path, err)
+	}
+	if normalizeURL(s.Start) != normalizeURL(start) {
+		return nil, fmt.Errorf("crawl state %s is for a crawl of %s, not %s", path, s.Start, start)
+	}
+	if s.Graph != nil {
+		s.Graph.reindex()
+	}
+	return &s, nil
+}
+
+// save writes the state to path via a temporary file, so an interruption
+// while saving leaves the previous state intact.
+func (s *crawlState) save(path string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math/rand"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hostThrottle keeps a crawl or batch polite towards each host: at most
+// perHost requests in flight per host, and with a delay, successive
+// requests to a host spaced by delay plus up to half again of random
+// jitter, so the request rate doesn't look machine-regular. With an
+// interval, request starts across all hosts are also spaced by it.
+type hostThrottle struct {
+	perHost  int
+	delay    time.Duration
+	interval time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*hostSlot
+	next  time.Time // earliest start of the next request to any host
+}
+
+// hostSlot is one host's share of a hostThrottle.
+type hostSlot struct {
+	sem  chan struct{}
+	next time.Time // earliest start of the next request
+}
+
+// newHostThrottle returns a throttle allowing perHost requests in flight
+// per host and, if qps is above 0, that many request starts per second
+// overall.
+func newHostThrottle(perHost int, delay time.Duration, qps float64) *hostThrottle {
+	t := &hostThrottle{perHost: max(perHost, 1), delay: delay, hosts: make(map[string]*hostSlot)}
+	if qps > 0 {
+		t.interval = time.Duration(float64(time.Second) / qps)
+	}
+	return t
+}
+
+// acquire waits until a request to rawURL's host may start, returning
+// the function that releases the host's slot once the request is done.
+func (t *hostThrottle) acquire(rawURL string) func() {
+	host := rawURL
+	if u, err := url.Parse(normalizeURL(rawURL)); err == nil {
+		host = strings.ToLower(u.Host)
+	}
+
+	t.mu.Lock()
+	slot, ok := t.hosts[host]
+	if !ok {
+		slot = &hostSlot{sem: make(chan struct{}, t.perHost)}
+		t.hosts[host] = slot
+	}
+	t.mu.Unlock()
+
+	slot.sem <- struct{}{}
+	if t.delay > 0 {
+		t.mu.Lock()
+		now := time.Now()
+		start := now
+		if slot.next.After(now) {
+			start = slot.next
+		}
+		slot.next = start.Add(t.delay + time.Duration(rand.Int63n(int64(t.delay)/2+1)))
+		t.mu.Unlock()
+		time.Sleep(start.Sub(now))
+	}
+	if t.interval > 0 {
+		t.mu.Lock()
+		now := time.Now()
+		start := now
+		if t.next.After(now) {
+			start = t.next
+		}
+		t.next = start.Add(t.interval)
+		t.mu.Unlock()
+		time.Sleep(start.Sub(now))
+	}
+	return func() { <-slot.sem }
+}
+
+// byteBudget caps the body bytes a crawl downloads; a limit of 0 means no
+// cap. Pages already in flight when the budget runs out still complete.
+type byteBudget struct {
+	limit int64
+	used  atomic.Int64
+}
+
+// spent reports whether the budget is used up.
+func (b *byteBudget) spent() bool {
+	return b.limit > 0 && b.used.Load() >= b.limit
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// isDDoSGuard reports whether a response comes from DDoS-Guard: its edge
+// server, its __ddg cookies, or its check script.
+func isDDoSGuard(resp *http.Response, body []byte) bool {
+	if strings.Contains(strings.ToLower(resp.Header.Get("Server")), "ddos-guard") {
+		return true
+	}
+	for _, c := range resp.Cookies() {
+		if strings.HasPrefix(c.Name, "__ddg") {
+			return true
+		}
+	}
+	return containsAny(body, [][]byte{
+		[]byte("check.ddos-guard.net"),
+		[]byte("/.well-known/ddos-guard/"),
+	})
+}
+
+func init() {
+	registerSolver(ddosGuardSolver{})
+}
+
+// ddosGuardSolver solves DDoS-Guard's JS check.
+type ddosGuardSolver struct{}
+
+func (ddosGuardSolver) Name() string { return ChallengeDDoSGuard.String() }
+func (ddosGuardSolver) runsScripts() {}
+
+func (ddosGuardSolver) Detect(resp *http.Response, body []byte) bool {
+	return detectChallenge(resp, body) == ChallengeDDoSGuard
+}
+
+func (ddosGuardSolver) Solve(ctx context.Context, f *Fetcher, resp *http.Response, body []byte) (*Solution, error) {
+	solved, err := solveDDoSGuard(ctx, resp.Request.URL.String(), body, f.Cookies())
+	if err != nil {
+		return nil, err
+	}
+	return &Solution{Cookies: solved}, nil
+}
+
+// solveDDoSGuard runs the check page's inline script in the JS sandbox
+// and returns the __ddg cookies it set, for the retry.
+func solveDDoSGuard(ctx context.Context, pageURL string, body []byte, cookies []*http.Cookie) ([]*http.Cookie, error) {
+	script := extractScriptContent(body)
+	if script == "" {
+		return nil, fmt.Errorf("no inline script on the check page")
+	}
+	solver := newJSSolver(pageURL, body)
+	solver.cookies = cookies
+	result, err := solver.Solve(ctx, script)
+	if err != nil {
+		return nil, err
+	}
+	var solved []*http.Cookie
+	for _, c := range result.Cookies {
+		if strings.HasPrefix(c.Name, "__ddg") {
+			solved = append(solved, c)
+		}
+	}
+	if len(solved) == 0 {
+		return nil, fmt.Errorf("script set no __ddg cookies")
+	}
+	return solved, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// depthRule limits how deep a crawl may go for URLs whose path matches
+// Pattern. Depth counts link hops from the start URL: a URL matching a
+// rule is only fetched if it was reached within Depth hops, so
+// {"/tag/**", 0} keeps tag archives out of the frontier entirely while
+// {"/blog/**", 3} lets the blog be covered thoroughly.
+type depthRule struct {
+	Pattern string `json:"pattern"`
+	Depth   int    `json:"depth"`
+	re      *regexp.Regexp
+}
+
+// crawlConfig holds crawl settings from the config file.
+type crawlConfig struct {
+	// Depth rules are checked in order; the first matching rule wins and
+	// URLs matching no rule fall back to the global depth limit.
+	Depth []depthRule `json:"depth,omitempty"`
+}
+
+// compileDepthRules compiles each rule's glob pattern. In patterns, "**"
+// matches any run of characters (including "/"), "*" matches within one
+// path segment, and "?" matches one character other than "/".
+func compileDepthRules(rules []depthRule) ([]depthRule, error) {
+	compiled := make([]depthRule, len(rules))
+	for i, r := range rules {
+		if r.Depth < 0 {
+			return nil, fmt.Errorf("depth rule %q: depth must be >= 0", r.Pattern)
+		}
+		re, err := globToRegexp(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("depth rule %q: %w", r.Pattern, err)
+		}
+		r.re = re
+		compiled[i] = r
+	}
+	return compiled, nil
+}
+
+// globToRegexp converts a path glob to an anchored regular expression.
+func globToRegexp(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				sb.WriteString(".*")
+				i++
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
+
+// depthLimit returns the maximum depth for rawURL: the first matching
+// rule's depth, or def when no rule matches.
+func depthLimit(rules []depthRule, rawURL string, def int) int {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return def
+	}
+	p := u.Path
+	if p == "" {
+		p = "/"
+	}
+	for _, r := range rules {
+		if r.re != nil && r.re.MatchString(p) {
+			return r.Depth
+		}
+	}
+	return def
+}
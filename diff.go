@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// diffResult is the --json output of diff.
+type diffResult struct {
+	A       string `json:"a"`
+	B       string `json:"b"`
+	Changed bool   `json:"changed"`
+	Added   int    `json:"added"`
+	Removed int    `json:"removed"`
+	Diff    string `json:"diff"`
+}
+
+// diffSide returns the text of one side of a diff: a local file if one
+// exists at src, else the page fetched from src, through the output
+// pipeline. A saved HTML page or PDF is converted like a fetched one; any
+// other file, such as a markdown snapshot, is compared as is.
+func diffSide(src string, opts outputOptions) (string, error) {
+	if body, err := os.ReadFile(src); err == nil {
+		in := newProcessInput(src, 200, nil, body)
+		if !isHTMLType(in.contentType.Sniffed) && !in.isPDF() {
+			return string(body), nil
+		}
+		return opts.pipeline.run(string(body), in), nil
+	}
+	res, err := fetchOne(newFetchOptions(src))
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode >= 400 {
+		return "", fmt.Errorf("%s: HTTP %d", res.URL, res.StatusCode)
+	}
+	return opts.pipeline.run(string(res.Body), res.processInput()), nil
+}
+
+// runDiff compares the text of a and b (URLs or files) and prints a
+// unified diff from a to b with context lines of context, or with --json
+// a summary holding it. Different texts fail with exitChanged. With
+// update, a is a snapshot file: it is rewritten with b's text afterwards,
+// for the next run to compare against; if it doesn't exist yet, it is
+// created and nothing is compared.
+func runDiff(a, b string, context int, update bool) error {
+	if len(flagProcess) == 0 && !flagMarkdownFull && !flagRaw {
+		flagMarkdown = true
+	}
+	opts, err := newOutputOptions("")
+	if err != nil {
+		return err
+	}
+
+	textB, err := diffSide(b, opts)
+	if err != nil {
+		return err
+	}
+	if update {
+		if _, err := os.Stat(a); os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "[*] Saved snapshot %s\n", a)
+			return os.WriteFile(a, []byte(textB), 0o644)
+		}
+	}
+	textA, err := diffSide(a, opts)
+	if err != nil {
+		return err
+	}
+
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(textA),
+		B:        difflib.SplitLines(textB),
+		FromFile: a,
+		ToFile:   b,
+		Context:  context,
+	})
+	if err != nil {
+		return fmt.Errorf("diff: %w", err)
+	}
+	res := diffResult{A: a, B: b, Changed: diff != "", Diff: diff}
+	for line := range strings.Lines(diff) {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"):
+			res.Added++
+		case strings.HasPrefix(line, "-"):
+			res.Removed++
+		}
+	}
+
+	if flagJSONOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprint(os.Stdout, diff)
+	}
+	if !res.Changed {
+		return nil
+	}
+	if update {
+		if err := os.WriteFile(a, []byte(textB), 0o644); err != nil {
+			return fmt.Errorf("update snapshot: %w", err)
+		}
+	}
+	return &exitError{exitChanged, fmt.Errorf("%d line(s) added, %d removed", res.Added, res.Removed)}
+}
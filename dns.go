@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dnsCache holds addresses resolved ahead of time by prefetchDNS, keyed by
+// host name. Dials for hosts not in the cache resolve as usual.
+var dnsCache sync.Map // host -> []string
+
+// maxDNSPrefetch bounds how many lookups prefetchDNS runs at once.
+const maxDNSPrefetch = 16
+
+// prefetchDNS resolves every unique host of urls concurrently with the
+// system resolver and fills dnsCache, so a large batch doesn't pay for
+// lookups one slot at a time. Lookup failures are left for the fetch
+// itself to report. It returns the hosts grouped by resolved address.
+func prefetchDNS(ctx context.Context, urls []string) map[string][]string {
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, raw := range urls {
+		if !strings.Contains(raw, "://") {
+			raw = "https://" + raw
+		}
+		u, err := url.Parse(raw)
+		if err != nil {
+			continue
+		}
+		h := u.Hostname()
+		if h == "" || net.ParseIP(h) != nil || seen[h] {
+			continue
+		}
+		seen[h] = true
+		hosts = append(hosts, h)
+	}
+
+	var mu sync.Mutex
+	byAddr := make(map[string][]string)
+	sem := make(chan struct{}, maxDNSPrefetch)
+	var wg sync.WaitGroup
+	for _, h := range hosts {
+		wg.Add(1)
+		go func(host string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			addrs, err := net.DefaultResolver.LookupHost(ctx, host)
+			if err != nil || len(addrs) == 0 {
+				return
+			}
+			dnsCache.Store(host, addrs)
+			mu.Lock()
+			byAddr[addrs[0]] = append(byAddr[addrs[0]], host)
+			mu.Unlock()
+		}(h)
+	}
+	wg.Wait()
+	return byAddr
+}
+
+// prefetchBatchDNS runs prefetchDNS for a batch and reports the result in
+// verbose mode, including hosts that share an address.
+func prefetchBatchDNS(items []batchItem, verbose bool) {
+	urls := make([]string, len(items))
+	for i, it := range items {
+		urls[i] = it.URL
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	start := time.Now()
+	byAddr := prefetchDNS(ctx, urls)
+	if !verbose {
+		return
+	}
+	n := 0
+	for _, hosts := range byAddr {
+		n += len(hosts)
+	}
+	fmt.Fprintf(os.Stderr, "[*] Resolved %d hosts to %d addresses in %s\n", n, len(byAddr), time.Since(start).Round(time.Millisecond))
+	addrs := make([]string, 0, len(byAddr))
+	for a := range byAddr {
+		addrs = append(addrs, a)
+	}
+	sort.Strings(addrs)
+	for _, a := range addrs {
+		if hosts := byAddr[a]; len(hosts) > 1 {
+			sort.Strings(hosts)
+			fmt.Fprintf(os.Stderr, "[*] %s serves %s\n", a, strings.Join(hosts, ", "))
+		}
+	}
+}
+
+// dialCached dials addr, using prefetched addresses for its host when
+// available and falling back to a normal dial otherwise.
+func dialCached(ctx context.Context, dialer *net.Dialer, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	cached, ok := dnsCache.Load(host)
+	if !ok {
+		return dialer.DialContext(ctx, network, addr)
+	}
+	var lastErr error
+	for _, ip := range cached.([]string) {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, lastErr
+}
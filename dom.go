@@ -0,0 +1,809 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/dop251/goja"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// jsDOM exposes a parsed page to the JS solver as a minimal live DOM.
+// Element objects wrap the *html.Node they stand for, so what a script
+// writes (a field's value, an attribute, innerHTML) is what later reads,
+// and form submission, see.
+type jsDOM struct {
+	vm     *goja.Runtime
+	doc    *html.Node
+	result *SolveResult
+	// objects keeps one JS object per node, so === works.
+	objects map[*html.Node]*goja.Object
+	// props holds expando properties scripts set on elements.
+	props map[*html.Node]map[string]goja.Value
+}
+
+func newJSDOM(vm *goja.Runtime, page []byte, result *SolveResult) *jsDOM {
+	doc, err := html.Parse(bytes.NewReader(page))
+	if err != nil {
+		doc, _ = html.Parse(strings.NewReader(""))
+	}
+	return &jsDOM{
+		vm:      vm,
+		doc:     doc,
+		result:  result,
+		objects: map[*html.Node]*goja.Object{},
+		props:   map[*html.Node]map[string]goja.Value{},
+	}
+}
+
+// wrap returns the JS object for n, or null.
+func (d *jsDOM) wrap(n *html.Node) goja.Value {
+	if n == nil {
+		return goja.Null()
+	}
+	if o, ok := d.objects[n]; ok {
+		return o
+	}
+	o := d.vm.NewDynamicObject(&jsElement{d: d, n: n})
+	d.objects[n] = o
+	return o
+}
+
+// wrapAll returns the JS objects for nodes as an array.
+func (d *jsDOM) wrapAll(nodes []*html.Node) goja.Value {
+	vals := make([]any, len(nodes))
+	for i, n := range nodes {
+		vals[i] = d.wrap(n)
+	}
+	return d.vm.NewArray(vals...)
+}
+
+// nodeOf returns the node behind a JS element object.
+func (d *jsDOM) nodeOf(v goja.Value) *html.Node {
+	if v == nil || goja.IsUndefined(v) || goja.IsNull(v) {
+		return nil
+	}
+	if el, ok := v.Export().(*jsElement); ok {
+		return el.n
+	}
+	return nil
+}
+
+// fn wraps a Go function as a JS function value.
+func (d *jsDOM) fn(f func(call goja.FunctionCall) goja.Value) goja.Value {
+	return d.vm.ToValue(f)
+}
+
+// find returns the first element under root with tag, or nil.
+func (d *jsDOM) find(root *html.Node, tag string) *html.Node {
+	for _, n := range descendants(root) {
+		if n.Data == tag {
+			return n
+		}
+	}
+	return nil
+}
+
+// querySelectorAll returns the elements under root matching sel, in
+// document order.
+func (d *jsDOM) querySelectorAll(root *html.Node, sel string) []*html.Node {
+	groups, err := parseSelectors(sel)
+	if err != nil {
+		panic(d.vm.NewTypeError(err.Error()))
+	}
+	var out []*html.Node
+	for _, n := range descendants(root) {
+		for _, g := range groups {
+			if g.match(n) {
+				out = append(out, n)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// queryMethods adds the query functions elements and the document share.
+func (d *jsDOM) queryMethods(root *html.Node, key string) goja.Value {
+	switch key {
+	case "querySelector":
+		return d.fn(func(call goja.FunctionCall) goja.Value {
+			if m := d.querySelectorAll(root, call.Argument(0).String()); len(m) > 0 {
+				return d.wrap(m[0])
+			}
+			return goja.Null()
+		})
+	case "querySelectorAll":
+		return d.fn(func(call goja.FunctionCall) goja.Value {
+			return d.wrapAll(d.querySelectorAll(root, call.Argument(0).String()))
+		})
+	case "getElementsByTagName":
+		return d.fn(func(call goja.FunctionCall) goja.Value {
+			tag := strings.ToLower(call.Argument(0).String())
+			return d.wrapAll(filterNodes(root, func(n *html.Node) bool { return tag == "*" || n.Data == tag }))
+		})
+	case "getElementsByClassName":
+		return d.fn(func(call goja.FunctionCall) goja.Value {
+			classes := strings.Fields(call.Argument(0).String())
+			return d.wrapAll(filterNodes(root, func(n *html.Node) bool {
+				for _, c := range classes {
+					if !hasClass(n, c) {
+						return false
+					}
+				}
+				return len(classes) > 0
+			}))
+		})
+	}
+	return nil
+}
+
+// document builds the document object.
+func (d *jsDOM) document() *goja.Object {
+	vm := d.vm
+	doc := vm.NewObject()
+	root := d.doc
+	for _, name := range []string{"querySelector", "querySelectorAll", "getElementsByTagName", "getElementsByClassName"} {
+		doc.Set(name, d.queryMethods(root, name))
+	}
+	doc.Set("getElementById", func(call goja.FunctionCall) goja.Value {
+		id := call.Argument(0).String()
+		for _, n := range descendants(root) {
+			if getAttr(n, "id") == id {
+				return d.wrap(n)
+			}
+		}
+		return goja.Null()
+	})
+	doc.Set("getElementsByName", func(call goja.FunctionCall) goja.Value {
+		name := call.Argument(0).String()
+		return d.wrapAll(filterNodes(root, func(n *html.Node) bool { return getAttr(n, "name") == name }))
+	})
+	doc.Set("createElement", func(call goja.FunctionCall) goja.Value {
+		tag := strings.ToLower(call.Argument(0).String())
+		return d.wrap(&html.Node{Type: html.ElementNode, Data: tag, DataAtom: atom.Lookup([]byte(tag))})
+	})
+	doc.Set("createTextNode", func(call goja.FunctionCall) goja.Value {
+		return d.wrap(&html.Node{Type: html.TextNode, Data: call.Argument(0).String()})
+	})
+	// DOMContentLoaded and load listeners run once the script is done.
+	doc.Set("addEventListener", d.onLoad())
+	doc.Set("removeEventListener", func(goja.FunctionCall) goja.Value { return goja.Undefined() })
+	doc.Set("readyState", "complete")
+	doc.Set("documentElement", d.wrap(d.find(root, "html")))
+	doc.Set("head", d.wrap(d.find(root, "head")))
+	doc.Set("body", d.wrap(d.find(root, "body")))
+	doc.Set("forms", d.wrapAll(filterNodes(root, func(n *html.Node) bool { return n.Data == "form" })))
+	title := ""
+	if t := d.find(root, "title"); t != nil {
+		title = strings.TrimSpace(textContent(t))
+	}
+	doc.Set("title", title)
+	return doc
+}
+
+// onLoad returns an addEventListener that schedules DOMContentLoaded and
+// load listeners as timers and ignores other events.
+func (d *jsDOM) onLoad() goja.Value {
+	return d.fn(func(call goja.FunctionCall) goja.Value {
+		switch call.Argument(0).String() {
+		case "DOMContentLoaded", "load":
+			if setTimeout, ok := goja.AssertFunction(d.vm.Get("setTimeout")); ok {
+				setTimeout(goja.Undefined(), call.Argument(1), d.vm.ToValue(0))
+			}
+		}
+		return goja.Undefined()
+	})
+}
+
+// submit records a form submission: its action and its fields' values.
+func (d *jsDOM) submit(form *html.Node) {
+	d.result.Submitted = true
+	d.result.FormAction = getAttr(form, "action")
+	d.result.FormData = map[string]string{}
+	for _, n := range formFields(form) {
+		name := getAttr(n, "name")
+		if name == "" {
+			continue
+		}
+		switch strings.ToLower(getAttr(n, "type")) {
+		case "submit", "button", "reset", "image", "file":
+			continue
+		case "checkbox", "radio":
+			if !hasAttr(n, "checked") {
+				continue
+			}
+		}
+		d.result.FormData[name] = fieldValue(n)
+	}
+}
+
+// jsElement is the goja.DynamicObject behind an element object.
+type jsElement struct {
+	d *jsDOM
+	n *html.Node
+}
+
+// reflectedAttrs are properties that read and write an attribute.
+var reflectedAttrs = map[string]string{
+	"id": "id", "className": "class", "name": "name", "type": "type",
+	"src": "src", "href": "href", "action": "action", "method": "method",
+	"rel": "rel", "content": "content", "title": "title", "placeholder": "placeholder",
+}
+
+func (e *jsElement) Get(key string) goja.Value {
+	d, n, vm := e.d, e.n, e.d.vm
+	if name, ok := reflectedAttrs[key]; ok {
+		return vm.ToValue(getAttr(n, name))
+	}
+	if m := d.queryMethods(n, key); m != nil {
+		return m
+	}
+	switch key {
+	case "nodeType":
+		if n.Type == html.TextNode {
+			return vm.ToValue(3)
+		}
+		return vm.ToValue(1)
+	case "tagName", "nodeName":
+		if n.Type == html.TextNode {
+			return vm.ToValue("#text")
+		}
+		return vm.ToValue(strings.ToUpper(n.Data))
+	case "value":
+		return vm.ToValue(fieldValue(n))
+	case "checked", "disabled", "selected", "hidden":
+		return vm.ToValue(hasAttr(n, key))
+	case "textContent", "nodeValue":
+		return vm.ToValue(textContent(n))
+	case "innerText":
+		return vm.ToValue(innerText(n))
+	case "innerHTML":
+		var b bytes.Buffer
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			html.Render(&b, c)
+		}
+		return vm.ToValue(b.String())
+	case "outerHTML":
+		var b bytes.Buffer
+		html.Render(&b, n)
+		return vm.ToValue(b.String())
+	case "children", "childNodes":
+		return d.wrapAll(childElements(n))
+	case "firstElementChild", "firstChild":
+		if c := childElements(n); len(c) > 0 {
+			return d.wrap(c[0])
+		}
+		return goja.Null()
+	case "parentNode", "parentElement":
+		if n.Parent == nil || n.Parent.Type != html.ElementNode {
+			return goja.Null()
+		}
+		return d.wrap(n.Parent)
+	case "nextElementSibling":
+		for s := n.NextSibling; s != nil; s = s.NextSibling {
+			if s.Type == html.ElementNode {
+				return d.wrap(s)
+			}
+		}
+		return goja.Null()
+	case "form":
+		for p := n.Parent; p != nil; p = p.Parent {
+			if p.Data == "form" {
+				return d.wrap(p)
+			}
+		}
+		return goja.Null()
+	case "elements":
+		return d.wrapAll(formFields(n))
+	case "options":
+		return d.wrapAll(filterNodes(n, func(c *html.Node) bool { return c.Data == "option" }))
+	case "attributes":
+		var list []any
+		for _, a := range n.Attr {
+			o := vm.NewObject()
+			o.Set("name", a.Key)
+			o.Set("value", a.Val)
+			list = append(list, o)
+		}
+		return vm.NewArray(list...)
+	case "dataset":
+		o := vm.NewObject()
+		for _, a := range n.Attr {
+			if k, ok := strings.CutPrefix(a.Key, "data-"); ok {
+				o.Set(dataKey(k), a.Val)
+			}
+		}
+		return o
+	case "getAttribute":
+		return d.fn(func(call goja.FunctionCall) goja.Value {
+			k := strings.ToLower(call.Argument(0).String())
+			if !hasAttr(n, k) {
+				return goja.Null()
+			}
+			return vm.ToValue(getAttr(n, k))
+		})
+	case "setAttribute":
+		return d.fn(func(call goja.FunctionCall) goja.Value {
+			setAttr(n, strings.ToLower(call.Argument(0).String()), call.Argument(1).String())
+			return goja.Undefined()
+		})
+	case "hasAttribute":
+		return d.fn(func(call goja.FunctionCall) goja.Value {
+			return vm.ToValue(hasAttr(n, strings.ToLower(call.Argument(0).String())))
+		})
+	case "removeAttribute":
+		return d.fn(func(call goja.FunctionCall) goja.Value {
+			removeAttr(n, strings.ToLower(call.Argument(0).String()))
+			return goja.Undefined()
+		})
+	case "appendChild", "insertBefore", "prepend", "append":
+		return d.fn(func(call goja.FunctionCall) goja.Value {
+			child := d.nodeOf(call.Argument(0))
+			if child == nil {
+				return call.Argument(0)
+			}
+			if child.Parent != nil {
+				child.Parent.RemoveChild(child)
+			}
+			n.AppendChild(child)
+			// A browser would load an appended script next.
+			if child.Data == "script" && getAttr(child, "src") != "" {
+				d.result.Scripts = append(d.result.Scripts, getAttr(child, "src"))
+			}
+			return call.Argument(0)
+		})
+	case "removeChild":
+		return d.fn(func(call goja.FunctionCall) goja.Value {
+			if child := d.nodeOf(call.Argument(0)); child != nil && child.Parent == n {
+				n.RemoveChild(child)
+			}
+			return call.Argument(0)
+		})
+	case "remove":
+		return d.fn(func(goja.FunctionCall) goja.Value {
+			if n.Parent != nil {
+				n.Parent.RemoveChild(n)
+			}
+			return goja.Undefined()
+		})
+	case "submit", "requestSubmit":
+		if n.Data != "form" {
+			break
+		}
+		return d.fn(func(goja.FunctionCall) goja.Value {
+			d.submit(n)
+			return goja.Undefined()
+		})
+	case "click":
+		return d.fn(func(goja.FunctionCall) goja.Value {
+			// Clicking a submit button submits its form.
+			if t := strings.ToLower(getAttr(n, "type")); (n.Data == "button" && t != "button") || (n.Data == "input" && t == "submit") {
+				for p := n.Parent; p != nil; p = p.Parent {
+					if p.Data == "form" {
+						d.submit(p)
+						break
+					}
+				}
+			}
+			return goja.Undefined()
+		})
+	case "addEventListener":
+		return d.onLoad()
+	case "removeEventListener", "focus", "blur", "dispatchEvent":
+		return d.fn(func(goja.FunctionCall) goja.Value { return goja.Undefined() })
+	case "getBoundingClientRect":
+		return d.fn(func(goja.FunctionCall) goja.Value {
+			o := vm.NewObject()
+			for _, k := range []string{"x", "y", "top", "left", "right", "bottom", "width", "height"} {
+				o.Set(k, 0)
+			}
+			return o
+		})
+	case "style":
+		if v, ok := d.props[n]["style"]; ok {
+			return v
+		}
+		e.Set("style", vm.NewObject())
+		return d.props[n]["style"]
+	}
+	if v, ok := d.props[n][key]; ok {
+		return v
+	}
+	return nil
+}
+
+func (e *jsElement) Set(key string, val goja.Value) bool {
+	n := e.n
+	if name, ok := reflectedAttrs[key]; ok {
+		setAttr(n, name, val.String())
+		return true
+	}
+	switch key {
+	case "value":
+		setFieldValue(n, val.String())
+	case "checked", "disabled", "selected", "hidden":
+		if val.ToBoolean() {
+			setAttr(n, key, "")
+		} else {
+			removeAttr(n, key)
+		}
+	case "textContent", "innerText":
+		clearChildren(n)
+		n.AppendChild(&html.Node{Type: html.TextNode, Data: val.String()})
+	case "innerHTML":
+		clearChildren(n)
+		nodes, err := html.ParseFragment(strings.NewReader(val.String()), n)
+		if err != nil {
+			return false
+		}
+		for _, c := range nodes {
+			n.AppendChild(c)
+		}
+	default:
+		if e.d.props[n] == nil {
+			e.d.props[n] = map[string]goja.Value{}
+		}
+		e.d.props[n][key] = val
+	}
+	return true
+}
+
+func (e *jsElement) Has(key string) bool {
+	return e.Get(key) != nil
+}
+
+func (e *jsElement) Delete(key string) bool {
+	delete(e.d.props[e.n], key)
+	return true
+}
+
+func (e *jsElement) Keys() []string {
+	var keys []string
+	for k := range e.d.props[e.n] {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// descendants returns the elements under root in document order.
+func descendants(root *html.Node) []*html.Node {
+	return filterNodes(root, func(*html.Node) bool { return true })
+}
+
+// filterNodes returns the elements under root, in document order, for
+// which keep returns true.
+func filterNodes(root *html.Node, keep func(*html.Node) bool) []*html.Node {
+	var out []*html.Node
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode && keep(c) {
+				out = append(out, c)
+			}
+			walk(c)
+		}
+	}
+	walk(root)
+	return out
+}
+
+func childElements(n *html.Node) []*html.Node {
+	var out []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func clearChildren(n *html.Node) {
+	for n.FirstChild != nil {
+		n.RemoveChild(n.FirstChild)
+	}
+}
+
+// formFields returns a form's input, select and textarea elements.
+func formFields(form *html.Node) []*html.Node {
+	return filterNodes(form, func(n *html.Node) bool {
+		return n.Data == "input" || n.Data == "select" || n.Data == "textarea"
+	})
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func setAttr(n *html.Node, key, val string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+func removeAttr(n *html.Node, key string) {
+	for i, a := range n.Attr {
+		if a.Key == key {
+			n.Attr = append(n.Attr[:i], n.Attr[i+1:]...)
+			return
+		}
+	}
+}
+
+// dataKey turns a data-* attribute suffix into its dataset key.
+func dataKey(k string) string {
+	parts := strings.Split(k, "-")
+	for i := 1; i < len(parts); i++ {
+		if parts[i] != "" {
+			parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// innerText returns the text under n without the contents of script
+// and style elements.
+func innerText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode || (c.Data != "script" && c.Data != "style") {
+			b.WriteString(innerText(c))
+		}
+	}
+	return b.String()
+}
+
+// fieldValue returns the current value of a form field.
+func fieldValue(n *html.Node) string {
+	switch n.Data {
+	case "textarea":
+		return textContent(n)
+	case "select":
+		options := filterNodes(n, func(c *html.Node) bool { return c.Data == "option" })
+		for _, o := range options {
+			if hasAttr(o, "selected") {
+				return fieldValue(o)
+			}
+		}
+		if len(options) > 0 {
+			return fieldValue(options[0])
+		}
+		return ""
+	case "option":
+		if hasAttr(n, "value") {
+			return getAttr(n, "value")
+		}
+		return strings.TrimSpace(textContent(n))
+	case "input":
+		if !hasAttr(n, "value") && strings.EqualFold(getAttr(n, "type"), "checkbox") {
+			return "on"
+		}
+	}
+	return getAttr(n, "value")
+}
+
+func setFieldValue(n *html.Node, val string) {
+	switch n.Data {
+	case "textarea":
+		clearChildren(n)
+		n.AppendChild(&html.Node{Type: html.TextNode, Data: val})
+	case "select":
+		for _, o := range filterNodes(n, func(c *html.Node) bool { return c.Data == "option" }) {
+			if fieldValue(o) == val {
+				setAttr(o, "selected", "")
+			} else {
+				removeAttr(o, "selected")
+			}
+		}
+	default:
+		setAttr(n, "value", val)
+	}
+}
+
+// cssSelector is one selector of a group: compound selectors joined by
+// combinators, matched right to left.
+type cssSelector []cssStep
+
+type cssStep struct {
+	comb    byte // relation to the previous step: ' ' descendant, '>' child
+	tag     string
+	id      string
+	classes []string
+	attrs   []cssAttr
+}
+
+type cssAttr struct {
+	name, op, val string
+}
+
+// parseSelectors parses the subset of CSS selectors challenge scripts
+// use: type, #id, .class and [attr], [attr=v], [attr^=v], [attr$=v],
+// [attr*=v], [attr~=v] selectors, descendant and child combinators, and
+// comma-separated groups.
+func parseSelectors(s string) ([]cssSelector, error) {
+	var groups []cssSelector
+	var cur cssSelector
+	step := cssStep{comb: ' '}
+	empty := true
+	pendingComb := byte(0)
+	flush := func() {
+		if !empty {
+			if pendingComb != 0 {
+				step.comb = pendingComb
+			}
+			cur = append(cur, step)
+		}
+		step = cssStep{comb: ' '}
+		empty = true
+		pendingComb = 0
+	}
+	ident := func(i int) (string, int) {
+		j := i
+		for j < len(s) && (s[j] == '-' || s[j] == '_' || s[j] >= 0x80 ||
+			'a' <= s[j] && s[j] <= 'z' || 'A' <= s[j] && s[j] <= 'Z' || '0' <= s[j] && s[j] <= '9') {
+			j++
+		}
+		return s[i:j], j
+	}
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if !empty {
+				flush()
+				pendingComb = ' '
+			}
+			i++
+		case c == '>':
+			if !empty {
+				flush()
+			}
+			pendingComb = '>'
+			i++
+		case c == ',':
+			flush()
+			if len(cur) == 0 {
+				return nil, fmt.Errorf("invalid selector %q", s)
+			}
+			groups = append(groups, cur)
+			cur = nil
+			i++
+		case c == '*':
+			empty = false
+			i++
+		case c == '#' || c == '.':
+			name, j := ident(i + 1)
+			if name == "" {
+				return nil, fmt.Errorf("invalid selector %q", s)
+			}
+			if c == '#' {
+				step.id = name
+			} else {
+				step.classes = append(step.classes, name)
+			}
+			empty, i = false, j
+		case c == '[':
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid selector %q", s)
+			}
+			body := s[i+1 : i+end]
+			a := cssAttr{name: strings.ToLower(strings.TrimSpace(body))}
+			if k := strings.IndexByte(body, '='); k >= 0 {
+				a.op = "="
+				name := body[:k]
+				if k > 0 && strings.ContainsRune("^$*~|", rune(body[k-1])) {
+					a.op = body[k-1:k] + "="
+					name = body[:k-1]
+				}
+				a.name = strings.ToLower(strings.TrimSpace(name))
+				a.val = strings.Trim(strings.TrimSpace(body[k+1:]), `"'`)
+			}
+			step.attrs = append(step.attrs, a)
+			empty, i = false, i+end+1
+		default:
+			name, j := ident(i)
+			if name == "" {
+				// Pseudo-classes and the like are not supported.
+				return nil, fmt.Errorf("unsupported selector %q", s)
+			}
+			step.tag = strings.ToLower(name)
+			empty, i = false, j
+		}
+	}
+	flush()
+	if len(cur) == 0 {
+		return nil, fmt.Errorf("invalid selector %q", s)
+	}
+	return append(groups, cur), nil
+}
+
+func (sel cssSelector) match(n *html.Node) bool {
+	return sel.matchAt(len(sel)-1, n)
+}
+
+func (sel cssSelector) matchAt(i int, n *html.Node) bool {
+	if !sel[i].matches(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	for p := n.Parent; p != nil && p.Type == html.ElementNode; p = p.Parent {
+		if sel.matchAt(i-1, p) {
+			return true
+		}
+		if sel[i].comb == '>' {
+			break
+		}
+	}
+	return false
+}
+
+func (st cssStep) matches(n *html.Node) bool {
+	if n.Type != html.ElementNode {
+		return false
+	}
+	if st.tag != "" && st.tag != n.Data {
+		return false
+	}
+	if st.id != "" && getAttr(n, "id") != st.id {
+		return false
+	}
+	for _, c := range st.classes {
+		if !hasClass(n, c) {
+			return false
+		}
+	}
+	for _, a := range st.attrs {
+		if !hasAttr(n, a.name) {
+			return false
+		}
+		v := getAttr(n, a.name)
+		switch a.op {
+		case "=":
+			if v != a.val {
+				return false
+			}
+		case "^=":
+			if !strings.HasPrefix(v, a.val) {
+				return false
+			}
+		case "$=":
+			if !strings.HasSuffix(v, a.val) {
+				return false
+			}
+		case "*=":
+			if !strings.Contains(v, a.val) {
+				return false
+			}
+		case "~=":
+			found := false
+			for _, f := range strings.Fields(v) {
+				found = found || f == a.val
+			}
+			if !found {
+				return false
+			}
+		case "|=":
+			if v != a.val && !strings.HasPrefix(v, a.val+"-") {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// runDownloads downloads each item in turn with -O, printing the saved
+// paths on stdout. A failed download is reported and the rest continue.
+func runDownloads(items []batchItem) error {
+	failed := 0
+	for _, it := range items {
+		file, err := runDownload(it.fetchOptions(), flagOutDir, flagSplit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[!] %s: %v\n", it.URL, err)
+			failed++
+			continue
+		}
+		fmt.Println(file)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d downloads failed", failed, len(items))
+	}
+	return nil
+}
+
+// runDownload fetches rawURL and streams the body straight to a file named
+// after the Content-Disposition header or the URL path, like curl -O. The
+// body is never held in memory, so there is no challenge solving: a
+// challenged download should be retried after clearing the challenge with
+// a normal fetch, whose cookies are then reused. The --timeout applies
+// until the response headers arrive; after that only --read-timeout can
+// cut off a stalled transfer. With a dir (--out-dir), a .meta.json
+// sidecar is written next to the file, as for fetched pages. It returns
+// the path written.
+func runDownload(opts fetchOptions, dir string, split int) (string, error) {
+	start := time.Now()
+	targetURL := opts.url
+	if !strings.Contains(targetURL, "://") {
+		targetURL = "https://" + targetURL
+	}
+	timeout := opts.timeout
+	if timeout == "" {
+		timeout = "30s"
+	}
+	dur, err := time.ParseDuration(timeout)
+	if err != nil {
+		return "", fmt.Errorf("invalid timeout %q: %w", timeout, err)
+	}
+	trOpts, err := opts.transportOptions()
+	if err != nil {
+		return "", err
+	}
+
+	profile := getProfile(opts.browser)
+	tr, err := sessionTransport(profile, trOpts)
+	if err != nil {
+		return "", fmt.Errorf("failed to create transport: %w", err)
+	}
+
+	var jar *PersistentJar
+	var cookies []*http.Cookie
+	if !opts.noCookies {
+		if jar, err = sessionJar(defaultCookieJarPath()); err != nil {
+			return "", fmt.Errorf("failed to load cookie jar: %w", err)
+		}
+		if u, err := url.Parse(targetURL); err == nil {
+			cookies = jar.Cookies(u)
+		}
+	}
+	inline, err := inlineCookies(opts.cookieFlags, targetURL)
+	if err != nil {
+		return "", err
+	}
+	cookies = mergeCookies(cookies, inline)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	limit, err := opts.bodyLimit()
+	if err != nil {
+		return "", err
+	}
+	headerTimer := time.AfterFunc(dur, cancel)
+
+	if opts.verbose {
+		fmt.Fprintf(os.Stderr, "[*] Downloading %s\n", targetURL)
+	}
+	extraHeaders := opts.requestHeaders(targetURL)
+	if split > 1 {
+		// Ranges only line up with an unencoded body; this is what
+		// browsers send for ranged media requests.
+		extraHeaders = append(extraHeaders, [2]string{"Accept-Encoding", "identity;q=1, *;q=0"})
+	}
+	resp, err := sendRequest(ctx, tr, profile, "GET", targetURL, extraHeaders, cookies, "")
+	if err != nil {
+		return "", fmt.Errorf("download failed: %w", err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized && opts.user != "" && opts.digest {
+		if c, ok := pickDigestChallenge(parseDigestChallenges(resp.Header)); ok {
+			resp.Body.Close()
+			authz, err := digestAuthHeader(c, opts.user, "GET", resp.Request.URL)
+			if err != nil {
+				return "", fmt.Errorf("digest auth failed: %w", err)
+			}
+			extraHeaders = append(extraHeaders, [2]string{"Authorization", authz})
+			resp, err = sendRequest(ctx, tr, profile, "GET", resp.Request.URL.String(), extraHeaders, cookies, "")
+			if err != nil {
+				return "", fmt.Errorf("download with digest auth failed: %w", err)
+			}
+		}
+	}
+	headerTimer.Stop()
+	defer resp.Body.Close()
+
+	if jar != nil && resp.Request != nil {
+		if respCookies := resp.Cookies(); len(respCookies) > 0 {
+			jar.SetResponseCookies(resp.Request.URL, respCookies, profile.Name)
+		}
+		if err := jar.Save(); err != nil && opts.verbose {
+			fmt.Fprintf(os.Stderr, "[*] Warning: failed to save cookies: %v\n", err)
+		}
+	}
+
+	if resp.StatusCode >= 400 {
+		return "", fmt.Errorf("download failed: HTTP %d", resp.StatusCode)
+	}
+	if limit > 0 && resp.ContentLength > limit {
+		return "", fmt.Errorf("download is %s, larger than --max-body-size %s", humanBytes(resp.ContentLength), opts.maxBodySize)
+	}
+
+	name := remoteFileName(resp)
+	full := filepath.Join(dir, name)
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return "", err
+		}
+	}
+
+	// Write to a temporary name so an interrupted transfer never leaves a
+	// truncated file under the final name.
+	part := full + ".part"
+	f, err := os.Create(part)
+	if err != nil {
+		return "", err
+	}
+	progress := newProgressWriter(name, resp.ContentLength)
+	if split > 1 && canSplit(resp, split) {
+		if opts.verbose {
+			fmt.Fprintf(os.Stderr, "[*] Downloading %s in %d ranged segments\n", humanBytes(resp.ContentLength), split)
+		}
+		err = splitDownload(ctx, tr, profile, resp, extraHeaders, cookies, f, progress, split)
+	} else {
+		if split > 1 && opts.verbose {
+			fmt.Fprintf(os.Stderr, "[*] Server doesn't support ranges for this file; downloading in one piece\n")
+		}
+		var body io.Reader
+		body, err = decodingReader(resp, io.TeeReader(resp.Body, progress))
+		if err == nil {
+			_, err = io.Copy(f, capReader(body, limit))
+		}
+	}
+	progress.finish()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(part)
+		return "", fmt.Errorf("download failed: %w", err)
+	}
+	if err := os.Rename(part, full); err != nil {
+		return "", err
+	}
+	if dir != "" {
+		r := &fetchResult{
+			URL:        targetURL,
+			StatusCode: resp.StatusCode,
+			Headers:    resp.Header,
+			FetchedAt:  start,
+			Elapsed:    time.Since(start),
+			resp:       resp,
+		}
+		if err := writeFileSidecar(full, r); err != nil {
+			return "", err
+		}
+	}
+	return full, nil
+}
+
+// minSplitSegment is the smallest segment worth a separate connection.
+const minSplitSegment = 256 << 10
+
+// canSplit reports whether resp can be fetched as n ranged segments: the
+// server advertises byte ranges, the length is known, the body is not
+// content-encoded, and each segment is worth a separate request.
+func canSplit(resp *http.Response, n int) bool {
+	return resp.StatusCode == http.StatusOK &&
+		strings.EqualFold(resp.Header.Get("Accept-Ranges"), "bytes") &&
+		resp.Header.Get("Content-Encoding") == "" &&
+		resp.ContentLength >= int64(n)*minSplitSegment
+}
+
+// splitDownload writes resp's body into f as n segments fetched in
+// parallel. The first segment is read from resp itself; the others are
+// Range requests for the final URL over the same fingerprinted transport,
+// guarded with If-Range so a file that changes mid-download fails
+// instead of being stitched from two versions.
+func splitDownload(ctx context.Context, tr http.RoundTripper, profile BrowserProfile, resp *http.Response, headers [][2]string, cookies []*http.Cookie, f *os.File, progress io.Writer, n int) error {
+	size := resp.ContentLength
+	seg := size / int64(n)
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	finalURL := resp.Request.URL.String()
+
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		start := int64(i) * seg
+		end := start + seg - 1
+		if i == n-1 {
+			end = size - 1
+		}
+		wg.Add(1)
+		go func(i int, start, end int64) {
+			defer wg.Done()
+			body := resp.Body
+			if i > 0 {
+				h := append(headers[:len(headers):len(headers)], [2]string{"Range", fmt.Sprintf("bytes=%d-%d", start, end)})
+				if validator != "" {
+					h = append(h, [2]string{"If-Range", validator})
+				}
+				r, err := sendRequest(ctx, tr, profile, "GET", finalURL, h, cookies, "")
+				if err != nil {
+					errs <- fmt.Errorf("segment %d: %w", i+1, err)
+					return
+				}
+				defer r.Body.Close()
+				if r.StatusCode != http.StatusPartialContent || !strings.HasPrefix(r.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", start)) {
+					errs <- fmt.Errorf("segment %d: server answered HTTP %d instead of the requested range", i+1, r.StatusCode)
+					return
+				}
+				body = r.Body
+			}
+			want := end - start + 1
+			w := io.NewOffsetWriter(f, start)
+			got, err := io.Copy(w, io.TeeReader(io.LimitReader(body, want), progress))
+			if err == nil && got != want {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				errs <- fmt.Errorf("segment %d: %w", i+1, err)
+			}
+		}(i, start, end)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// decodingReader wraps r (the raw response body) to undo the response's
+// Content-Encoding while streaming.
+func decodingReader(resp *http.Response, r io.Reader) (io.Reader, error) {
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "br":
+		return brotli.NewReader(r), nil
+	}
+	return r, nil
+}
+
+// remoteFileName picks the local file name for a download: the
+// Content-Disposition filename if present, otherwise the last segment of
+// the final URL's path, otherwise "index.html". Directory components are
+// stripped so a server cannot write outside the target directory.
+func remoteFileName(resp *http.Response) string {
+	if cd := resp.Header.Get("Content-Disposition"); cd != "" {
+		if _, params, err := mime.ParseMediaType(cd); err == nil {
+			if name := safeFileName(params["filename"]); name != "" {
+				return name
+			}
+		}
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		if name := safeFileName(path.Base(resp.Request.URL.Path)); name != "" {
+			return name
+		}
+	}
+	return "index.html"
+}
+
+// safeFileName returns the base name of name, or empty string if nothing
+// usable remains.
+func safeFileName(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == ".." || name == "/" || strings.HasPrefix(name, ".") {
+		return ""
+	}
+	return name
+}
+
+// progressWriter draws a progress line (size, speed, ETA) on stderr as
+// bytes pass through it. It stays silent when stderr is not a terminal.
+type progressWriter struct {
+	mu      sync.Mutex // segments of a split download write concurrently
+	name    string
+	total   int64 // -1 when unknown
+	n       int64
+	start   time.Time
+	last    time.Time
+	enabled bool
+}
+
+func newProgressWriter(name string, total int64) *progressWriter {
+	enabled := false
+	if fi, err := os.Stderr.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		enabled = true
+	}
+	return &progressWriter{name: name, total: total, start: time.Now(), enabled: enabled}
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.n += int64(len(b))
+	if p.enabled && time.Since(p.last) >= 200*time.Millisecond {
+		p.last = time.Now()
+		p.draw()
+	}
+	return len(b), nil
+}
+
+func (p *progressWriter) draw() {
+	elapsed := time.Since(p.start).Seconds()
+	speed := 0.0
+	if elapsed > 0 {
+		speed = float64(p.n) / elapsed
+	}
+	if p.total > 0 {
+		frac := float64(p.n) / float64(p.total)
+		if frac > 1 {
+			frac = 1
+		}
+		const width = 24
+		bar := strings.Repeat("=", int(frac*width)) + strings.Repeat(" ", width-int(frac*width))
+		eta := "--"
+		if speed > 0 {
+			eta = time.Duration(float64(p.total-p.n) / speed * float64(time.Second)).Round(time.Second).String()
+		}
+		fmt.Fprintf(os.Stderr, "\r%s %3.0f%% [%s] %s / %s  %s/s  ETA %s   ",
+			p.name, frac*100, bar, humanBytes(p.n), humanBytes(p.total), humanBytes(int64(speed)), eta)
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\r%s %s  %s/s   ", p.name, humanBytes(p.n), humanBytes(int64(speed)))
+}
+
+// finish draws the final state and ends the progress line.
+func (p *progressWriter) finish() {
+	if !p.enabled {
+		return
+	}
+	p.draw()
+	fmt.Fprintln(os.Stderr)
+}
+
+// humanBytes formats n with a binary unit suffix.
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes, so scripts can tell failures apart. Where curl has a code
+// for the same failure, it is reused.
+const (
+	exitFailure   = 1  // anything not listed below
+	exitChanged   = 3  // diff: the two sides differ
+	exitDNS       = 6  // the host name didn't resolve
+	exitHTTP      = 22 // --fail: HTTP status 400 or above
+	exitTimeout   = 28 // --timeout or another deadline ran out
+	exitTLS       = 35 // the TLS handshake failed
+	exitChallenge = 40 // --fail: an anti-bot challenge couldn't be solved
+	exitCaptcha   = 41 // a captcha couldn't be solved
+)
+
+var (
+	// errTLSHandshake wraps TLS handshake failures.
+	errTLSHandshake = errors.New("TLS handshake failed")
+	// errCaptchaSolve wraps failures of the captcha solving services.
+	errCaptchaSolve = errors.New("captcha solve failed")
+)
+
+// exitError is an error with a set exit code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// exitCode returns the exit code for err.
+func exitCode(err error) int {
+	var ee *exitError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &ee):
+		return ee.code
+	case errors.As(err, &dnsErr) && !dnsErr.IsTimeout:
+		return exitDNS
+	case errors.Is(err, errTLSHandshake):
+		return exitTLS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return exitTimeout
+	case errors.Is(err, errCaptchaSolve):
+		return exitCaptcha
+	}
+	return exitFailure
+}
+
+// responseFailure returns the --fail error for a fetched result: a
+// challenge still in place, or an HTTP error status. It returns nil when
+// --fail isn't set or the response is fine.
+func responseFailure(r *fetchResult) error {
+	if !flagFail && !flagFailWithBody || r.Error != nil {
+		return nil
+	}
+	switch {
+	case r.Challenge == ChallengeCaptcha.String():
+		return &exitError{exitCaptcha, fmt.Errorf("%s: captcha not solved (HTTP %d)", r.URL, r.StatusCode)}
+	case r.Challenge != "":
+		return &exitError{exitChallenge, fmt.Errorf("%s: %s challenge not solved (HTTP %d)", r.URL, r.Challenge, r.StatusCode)}
+	case r.StatusCode >= 400:
+		return &exitError{exitHTTP, fmt.Errorf("%s: HTTP %d", r.URL, r.StatusCode)}
+	}
+	return nil
+}
+
+// silenceFailureUsage keeps cobra from printing the usage text after a
+// --fail error: the command line was fine, the response wasn't.
+func silenceFailureUsage(cmd *cobra.Command, err error) error {
+	switch exitCode(err) {
+	case exitHTTP, exitChallenge, exitCaptcha:
+		cmd.SilenceUsage = true
+	}
+	return err
+}
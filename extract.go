@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// extractContext is how many characters of text around an entity are
+// kept by default.
+const extractContext = 40
+
+// pageEntity is an entity found on a page: one entry per distinct value.
+type pageEntity struct {
+	// Type is "email", "phone" or "pattern".
+	Type string `json:"type"`
+	// Pattern is the --pattern regex a "pattern" entity matched.
+	Pattern string `json:"pattern,omitempty"`
+	Value   string `json:"value"`
+	// Count is how often the value occurs; Context is the text around
+	// its first occurrence.
+	Count   int    `json:"count"`
+	Context string `json:"context"`
+}
+
+var (
+	emailRe = regexp.MustCompile(`(?i)\b[a-z0-9][a-z0-9._%+-]*@[a-z0-9](?:[a-z0-9-]*[a-z0-9])?(?:\.[a-z0-9](?:[a-z0-9-]*[a-z0-9])?)*\.[a-z]{2,}\b`)
+	// emailAssetRe matches file names that look like addresses, such as
+	// retina images ("logo@2x.png").
+	emailAssetRe = regexp.MustCompile(`(?i)\.(png|jpe?g|gif|webp|svg|avif|css|js)$`)
+	// phoneRe matches phone-like runs of digits: an optional +country
+	// code, an optional (area code), and digit groups separated by spaces,
+	// dots or dashes.
+	phoneRe = regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?(?:\(\d{1,5}\)[ .-]?)?\d[\d .-]{4,}\d`)
+	// dateLikeRe matches dates and version numbers phoneRe would take.
+	dateLikeRe = regexp.MustCompile(`^\d{4}[.-]\d{1,2}[.-]\d{1,2}$|^\d{1,2}[.-]\d{1,2}[.-]\d{2,4}$|^\d+(\.\d+){2,}$`)
+)
+
+// extractor finds entities in text.
+type extractor struct {
+	emails   bool
+	phones   bool
+	patterns []*regexp.Regexp
+	context  int
+}
+
+// find returns the entities in text, deduplicated, in the order they
+// first appear within each type: emails, phones, then each pattern.
+func (x extractor) find(text string) []pageEntity {
+	var entities []pageEntity
+	seen := make(map[string]int)
+	add := func(typ, pattern, value, key string, start, end int) {
+		key = typ + "\x00" + pattern + "\x00" + key
+		if i, ok := seen[key]; ok {
+			entities[i].Count++
+			return
+		}
+		seen[key] = len(entities)
+		entities = append(entities, pageEntity{
+			Type:    typ,
+			Pattern: pattern,
+			Value:   value,
+			Count:   1,
+			Context: entityContext(text, start, end, x.context),
+		})
+	}
+
+	if x.emails {
+		for _, m := range emailRe.FindAllStringIndex(text, -1) {
+			email := text[m[0]:m[1]]
+			if emailAssetRe.MatchString(email) {
+				continue
+			}
+			add("email", "", email, strings.ToLower(email), m[0], m[1])
+		}
+	}
+	if x.phones {
+		for _, m := range phoneRe.FindAllStringIndex(text, -1) {
+			phone := strings.TrimRight(text[m[0]:m[1]], " .-")
+			if digits, ok := phoneDigits(phone); ok {
+				add("phone", "", phone, digits, m[0], m[0]+len(phone))
+			}
+		}
+	}
+	for _, re := range x.patterns {
+		for _, m := range re.FindAllStringSubmatchIndex(text, -1) {
+			// With a capture group, the first group is the value.
+			start, end := m[0], m[1]
+			if len(m) >= 4 && m[2] >= 0 {
+				start, end = m[2], m[3]
+			}
+			value := text[start:end]
+			if value != "" {
+				add("pattern", re.String(), value, value, m[0], m[1])
+			}
+		}
+	}
+	return entities
+}
+
+// phoneDigits returns the digits of a phone-like match (with a leading +
+// kept) for deduplication, rejecting what is more likely a date, a
+// version number or another plain number: fewer than 7 or more than 15
+// digits, or no + and no separators at all.
+func phoneDigits(s string) (string, bool) {
+	if dateLikeRe.MatchString(s) {
+		return "", false
+	}
+	var b strings.Builder
+	if strings.HasPrefix(s, "+") {
+		b.WriteByte('+')
+	}
+	n := 0
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+			n++
+		}
+	}
+	if n < 7 || n > 15 {
+		return "", false
+	}
+	if !strings.HasPrefix(s, "+") && !strings.ContainsAny(s, " .-()") {
+		return "", false
+	}
+	return b.String(), true
+}
+
+// entityContext returns up to n characters of text on each side of
+// text[start:end], with whitespace collapsed.
+func entityContext(text string, start, end, n int) string {
+	// n characters are at most 4n bytes; only that much is decoded.
+	before := []rune(strings.ToValidUTF8(text[max(start-4*n, 0):start], ""))
+	after := []rune(strings.ToValidUTF8(text[end:min(end+4*n, len(text))], ""))
+	before = before[max(len(before)-n, 0):]
+	after = after[:min(n, len(after))]
+	return strings.Join(strings.Fields(string(before)+text[start:end]+string(after)), " ")
+}
+
+// pageText returns the visible text of an HTML page, one line per block
+// element. Addresses that only appear in mailto: and tel: links are added
+// after the link text so they are found too.
+func pageText(body []byte) string {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return string(body)
+	}
+	var sb strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			sb.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			switch n.Data {
+			case "script", "style", "noscript", "template", "svg":
+				return
+			case "br", "p", "div", "li", "tr", "td", "th", "h1", "h2", "h3", "h4", "h5", "h6",
+				"section", "article", "header", "footer", "address", "blockquote", "pre", "dd", "dt", "title":
+				defer sb.WriteByte('\n')
+				sb.WriteByte('\n')
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+		if n.Type == html.ElementNode && n.Data == "a" {
+			href := strings.TrimSpace(getAttr(n, "href"))
+			scheme, addr, _ := strings.Cut(href, ":")
+			if scheme = strings.ToLower(scheme); scheme == "mailto" || scheme == "tel" {
+				addr, _, _ = strings.Cut(addr, "?")
+				if a, err := url.PathUnescape(addr); err == nil {
+					addr = a
+				}
+				if addr != "" && !strings.Contains(textContent(n), addr) {
+					sb.WriteString(" " + addr + " ")
+				}
+			}
+		}
+	}
+	walk(doc)
+	return sb.String()
+}
+
+// runExtract fetches rawURL and prints the entities in its text as a JSON
+// array. Pages are reduced to their visible text and PDFs to their text
+// layer; other bodies are scanned as they are.
+func runExtract(rawURL string, x extractor) error {
+	res, err := fetchOne(newFetchOptions(rawURL))
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("%s: HTTP %d", res.URL, res.StatusCode)
+	}
+
+	in := res.processInput()
+	text := string(res.Body)
+	switch {
+	case in.isPDF():
+		if text, err = pdfToText(res.Body); err != nil {
+			return fmt.Errorf("%s: %w", res.URL, err)
+		}
+	case isHTMLType(in.contentType.Effective):
+		text = pageText(res.Body)
+	}
+
+	entities := x.find(text)
+	if entities == nil {
+		entities = []pageEntity{}
+	}
+	if flagVerbose {
+		fmt.Fprintf(os.Stderr, "[*] Entities: %d\n", len(entities))
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entities)
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// feedTypes are the <link rel="alternate"> types autodiscovery looks for,
+// in order of preference. Plain application/json is left out: WordPress
+// advertises its REST API with it on every page.
+var feedTypes = []string{"application/atom+xml", "application/rss+xml", "application/feed+json"}
+
+// feedAccept is the Accept header sent for feeds.
+const feedAccept = "application/rss+xml, application/atom+xml, application/feed+json, application/xml;q=0.9, text/xml;q=0.9, */*;q=0.8"
+
+// maxFeedSummary bounds the summary kept for each item, in characters.
+const maxFeedSummary = 500
+
+// feedItem is one entry of a feed.
+type feedItem struct {
+	Title   string `json:"title"`
+	Link    string `json:"link,omitempty"`
+	Date    string `json:"date,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+// pageFeed is a parsed feed of any of the supported formats.
+type pageFeed struct {
+	URL   string     `json:"url"`
+	Title string     `json:"title,omitempty"`
+	Items []feedItem `json:"items"`
+}
+
+// feedXML covers the XML feed formats: RSS 2.0 (<rss><channel>), RSS 1.0
+// (<rdf:RDF>, whose items are siblings of the channel) and Atom (<feed>).
+type feedXML struct {
+	XMLName      xml.Name
+	ChannelTitle string      `xml:"channel>title"`
+	ChannelItems []rssItem   `xml:"channel>item"`
+	RDFItems     []rssItem   `xml:"item"`
+	Title        string      `xml:"title"`
+	Entries      []atomEntry `xml:"entry"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	GUID        string `xml:"guid"`
+	PubDate     string `xml:"pubDate"`
+	DCDate      string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Description string `xml:"description"`
+	Content     string `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+}
+
+type atomEntry struct {
+	Title     string     `xml:"title"`
+	Links     []atomLink `xml:"link"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Summary   string     `xml:"summary"`
+	Content   string     `xml:"content"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+}
+
+// jsonFeed is the part of a JSON Feed (jsonfeed.org) that is read.
+type jsonFeed struct {
+	Version string `json:"version"`
+	Title   string `json:"title"`
+	Items   []struct {
+		ID            string `json:"id"`
+		URL           string `json:"url"`
+		Title         string `json:"title"`
+		Summary       string `json:"summary"`
+		ContentText   string `json:"content_text"`
+		ContentHTML   string `json:"content_html"`
+		DatePublished string `json:"date_published"`
+		DateModified  string `json:"date_modified"`
+	} `json:"items"`
+}
+
+// discoverFeeds returns the feeds an HTML page advertises with
+// <link rel="alternate">, resolved against pageURL, preferred types first.
+func discoverFeeds(body []byte, pageURL string) []string {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+	rank := func(n *html.Node) int {
+		t, _, _ := mime.ParseMediaType(getAttr(n, "type"))
+		return slices.Index(feedTypes, t)
+	}
+	links := filterNodes(doc, func(n *html.Node) bool {
+		return n.Data == "link" && getAttr(n, "href") != "" && rank(n) >= 0 &&
+			slices.Contains(strings.Fields(strings.ToLower(getAttr(n, "rel"))), "alternate")
+	})
+	slices.SortStableFunc(links, func(a, b *html.Node) int { return rank(a) - rank(b) })
+
+	var feeds []string
+	for _, n := range links {
+		if u, ok := resolveRef(base, getAttr(n, "href")); ok && !slices.Contains(feeds, u.String()) {
+			feeds = append(feeds, u.String())
+		}
+	}
+	return feeds
+}
+
+// parseFeed parses an RSS, Atom or JSON Feed document fetched from
+// feedURL. Item links are resolved against it, dates converted to RFC
+// 3339 where they parse and summaries reduced to plain text.
+func parseFeed(body []byte, feedURL string) (*pageFeed, error) {
+	base, err := url.Parse(feedURL)
+	if err != nil {
+		return nil, err
+	}
+	resolve := func(ref string) string {
+		ref = strings.TrimSpace(ref)
+		if ref == "" {
+			return ""
+		}
+		return resolveItemURL(base, ref)
+	}
+	summary := func(texts ...string) string {
+		for _, t := range texts {
+			if s := fragmentText(t); s != "" {
+				if len([]rune(s)) > maxFeedSummary {
+					s = strings.TrimSpace(truncateRunes(s, maxFeedSummary)) + "…"
+				}
+				return s
+			}
+		}
+		return ""
+	}
+	feed := &pageFeed{URL: feedURL, Items: []feedItem{}}
+
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		var jf jsonFeed
+		if err := json.Unmarshal(trimmed, &jf); err != nil {
+			return nil, fmt.Errorf("parse JSON feed: %w", err)
+		}
+		if !strings.Contains(jf.Version, "jsonfeed.org") {
+			return nil, fmt.Errorf("not a JSON Feed")
+		}
+		feed.Title = strings.TrimSpace(jf.Title)
+		for _, it := range jf.Items {
+			feed.Items = append(feed.Items, feedItem{
+				Title:   fragmentText(it.Title),
+				Link:    resolve(cmp.Or(it.URL, it.ID)),
+				Date:    feedTime(cmp.Or(it.DatePublished, it.DateModified)),
+				Summary: summary(it.Summary, it.ContentText, it.ContentHTML),
+			})
+		}
+		return feed, nil
+	}
+
+	var doc feedXML
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	dec.CharsetReader = charset.NewReaderLabel
+	dec.Strict = false
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("parse feed: %w", err)
+	}
+	switch doc.XMLName.Local {
+	case "rss", "RDF":
+		feed.Title = strings.TrimSpace(doc.ChannelTitle)
+		for _, it := range append(doc.ChannelItems, doc.RDFItems...) {
+			feed.Items = append(feed.Items, feedItem{
+				Title:   fragmentText(it.Title),
+				Link:    resolve(cmp.Or(it.Link, it.GUID)),
+				Date:    feedTime(cmp.Or(it.PubDate, it.DCDate)),
+				Summary: summary(it.Description, it.Content),
+			})
+		}
+	case "feed":
+		feed.Title = fragmentText(doc.Title)
+		for _, e := range doc.Entries {
+			feed.Items = append(feed.Items, feedItem{
+				Title:   fragmentText(e.Title),
+				Link:    resolve(atomAlternate(e.Links)),
+				Date:    feedTime(cmp.Or(e.Published, e.Updated)),
+				Summary: summary(e.Summary, e.Content),
+			})
+		}
+	default:
+		return nil, fmt.Errorf("not a feed (root element <%s>)", doc.XMLName.Local)
+	}
+	return feed, nil
+}
+
+// atomAlternate returns the href of an Atom entry's alternate link, which
+// is the one without a rel or with rel="alternate".
+func atomAlternate(links []atomLink) string {
+	for _, l := range links {
+		if l.Rel == "" || l.Rel == "alternate" {
+			return l.Href
+		}
+	}
+	return ""
+}
+
+// feedTime converts a feed date (RFC 3339 in Atom and JSON Feed, RFC 1123
+// in RSS) to RFC 3339 in UTC, keeping it as written if it doesn't parse.
+func feedTime(s string) string {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC().Format(time.RFC3339)
+	}
+	return newsTime(s)
+}
+
+// formatFeed renders a feed as a numbered markdown list under its title.
+func formatFeed(feed *pageFeed) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "## %s\n\n", cmp.Or(feed.Title, feed.URL))
+	for i, it := range feed.Items {
+		title := cmp.Or(it.Title, it.Link, "(untitled)")
+		if it.Link != "" {
+			fmt.Fprintf(&sb, "%d. **[%s](%s)**\n", i+1, title, it.Link)
+		} else {
+			fmt.Fprintf(&sb, "%d. **%s**\n", i+1, title)
+		}
+		if it.Date != "" {
+			fmt.Fprintf(&sb, "   _%s_\n", it.Date)
+		}
+		if it.Summary != "" {
+			fmt.Fprintf(&sb, "   %s\n", it.Summary)
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// runFeed reads the feed at rawURL, or the first one the page at rawURL
+// advertises, and prints its items (limit > 0 keeps the first limit) as
+// markdown or, with --json, as JSON.
+func runFeed(rawURL string, limit int) error {
+	fo := newFetchOptions(rawURL)
+	fo.accept = feedAccept
+	res, err := fetchOne(fo)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode >= 400 {
+		return fmt.Errorf("%s: HTTP %d", res.URL, res.StatusCode)
+	}
+
+	feedURL := res.finalURL()
+	if isHTMLType(res.processInput().contentType.Effective) {
+		feeds := discoverFeeds(res.Body, feedURL)
+		if len(feeds) == 0 {
+			return fmt.Errorf("no feed found on %s", feedURL)
+		}
+		if flagVerbose {
+			fmt.Fprintf(os.Stderr, "[*] Feeds: %s\n", strings.Join(feeds, ", "))
+		}
+		fo = newFetchOptions(feeds[0])
+		fo.accept = feedAccept
+		fo.referer = feedURL
+		if res, err = fetchOne(fo); err != nil {
+			return err
+		}
+		if res.StatusCode >= 400 {
+			return fmt.Errorf("%s: HTTP %d", res.URL, res.StatusCode)
+		}
+		feedURL = res.finalURL()
+	}
+
+	feed, err := parseFeed(res.Body, feedURL)
+	if err != nil {
+		return fmt.Errorf("%s: %w", feedURL, err)
+	}
+	if limit > 0 && len(feed.Items) > limit {
+		feed.Items = feed.Items[:limit]
+	}
+
+	if flagJSONOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(feed)
+	}
+	fmt.Fprint(os.Stdout, formatFeed(feed))
+	return nil
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"strings"
 	"time"
+
+	"golang.org/x/net/publicsuffix"
 )
 
 // fetchOptions holds the parameters for a single fetch operation.
@@ -17,10 +22,103 @@ type fetchOptions struct {
 	url            string
 	browser        string
 	timeout        string
-	noCookies      bool
+	connectTimeout string // TCP connect + TLS handshake, per connection
+	readTimeout    string // max wait for any single read from the server
+	http10         bool
+	noKeepAlive    bool
+	accept         string // overrides the profile's Accept header when set
+	acceptLanguage string // overrides the profile's Accept-Language header when set
+	referer        string // page we "navigated" from; sets Referer and Sec-Fetch-Site
+	// navigateFromHome retries a challenged deep URL after first visiting
+	// the site root, as a person clicking through from the homepage would.
+	navigateFromHome bool
+	// user is "user:password" for HTTP authentication: Basic by default,
+	// or Digest (answering the server's 401 challenge) when digest is set.
+	user   string
+	digest bool
+	// netrcFile, when set, supplies per-host credentials if user is empty.
+	netrcFile string
+	// profileMismatch is what to do when a clearance cookie was obtained
+	// with a different profile: "warn" (default) or "switch" to it.
+	profileMismatch string
+	noCookies       bool
+	// maxBodySize caps how much of a body is read (e.g. "10MB"); a larger
+	// body fails the fetch, or is cut off and flagged truncated when
+	// maxBodyTruncate is set.
+	maxBodySize     string
+	maxBodyTruncate bool
+	// redirects decides which redirects are followed.
+	redirects redirectPolicy
+	// jsTimeout and jsMaxMemory bound each run of the JS challenge solver
+	// (e.g. "10s", "256MB"); noJS skips the solver altogether, leaving
+	// JS challenges unsolved.
+	jsTimeout   string
+	jsMaxMemory string
+	noJS        bool
+	// browserFallback loads a page still challenged after every other
+	// solver in a local headless Chrome, and keeps the cookies it earns.
+	browserFallback bool
+	// solvers are tried, in order, on the challenge: the built-in ones,
+	// then other compiled-in solvers and plugins.
+	solvers []Solver
+	// stream, when set, receives bodies that need no challenge handling
+	// (successful non-HTML responses) as they arrive, instead of buffering
+	// them; see fetchStreaming.
+	stream         io.Writer
 	verbose        bool
 	captchaService string
 	captchaKey     string
+	// cookies are sent on top of the jar's, overriding any of the same
+	// name (e.g. a search engine's session).
+	cookies []*http.Cookie
+	// cookieFlags are the --cookie values, sent on top of both; see
+	// inlineCookies.
+	cookieFlags []string
+	// captcha holds the captcha polling and 2captcha settings.
+	captcha captchaConfig
+	// tesseract, when set, is the tesseract binary used to read image
+	// captchas instead of the captcha service.
+	tesseract string
+}
+
+// fromSearchReferer is the Referer fetches send with --from-search, set
+// once the flag has been checked. newRootCmd clears it along with the
+// flags, so it doesn't carry over to the next --next segment.
+var fromSearchReferer string
+
+// newFetchOptions returns fetchOptions for rawURL populated from the
+// global flags.
+func newFetchOptions(rawURL string) fetchOptions {
+	return fetchOptions{
+		url:              rawURL,
+		browser:          flagBrowser,
+		timeout:          flagTimeout,
+		connectTimeout:   flagConnectTimeout,
+		readTimeout:      flagReadTimeout,
+		http10:           flagHTTP10,
+		noKeepAlive:      flagNoKeepAlive,
+		navigateFromHome: flagNavigateFromHome,
+		referer:          fromSearchReferer,
+		user:             resolveUser(),
+		digest:           flagDigest,
+		netrcFile:        resolveNetrcFile(),
+		profileMismatch:  flagProfileMismatch,
+		maxBodySize:      flagMaxBodySize,
+		maxBodyTruncate:  flagMaxBodyTruncate,
+		redirects:        resolveRedirectPolicy(),
+		jsTimeout:        flagJSTimeout,
+		jsMaxMemory:      flagJSMaxMemory,
+		noJS:             flagNoJS,
+		browserFallback:  flagBrowserFallback,
+		solvers:          registeredSolvers(),
+		noCookies:        flagNoCookies,
+		verbose:          flagVerbose,
+		captchaService:   flagCaptchaService,
+		captchaKey:       flagCaptchaKey,
+		captcha:          resolveCaptchaConfig(),
+		tesseract:        flagTesseract,
+		cookieFlags:      flagCookie,
+	}
 }
 
 // fetchResult holds the outcome of a fetch operation.
@@ -29,6 +127,37 @@ type fetchResult struct {
 	StatusCode int
 	Headers    http.Header
 	Body       []byte
+	// Truncated is set when the body read failed mid-stream and Body holds
+	// only the bytes received before the failure.
+	Truncated bool
+	// Streamed is set when the body was copied to fetchOptions.stream
+	// instead of being buffered; Body is then empty.
+	Streamed bool
+	// Challenge names an anti-bot challenge still present in the final
+	// response ("js", "captcha", "akamai"), or is empty.
+	Challenge string
+	// FetchedAt and Elapsed record when the fetch started and how long the
+	// whole pipeline (including challenge solving) took.
+	FetchedAt time.Time
+	Elapsed   time.Duration
+	// Skipped is set by batch filters (e.g. --only-lang) to the reason the
+	// result is withheld from output; such results are only reported.
+	Skipped string
+	// Vars holds the batch variables (e.g. glob values) that produced URL.
+	Vars map[string]string
+	// Depth is the number of link hops from a crawl's start URL.
+	Depth int
+	// Timing breaks down the final request's duration; nil for results
+	// that weren't fetched.
+	Timing *fetchTiming
+	// Profile is the browser profile the fetch used and CookieJar the path
+	// of the cookie jar, empty with --no-cookies.
+	Profile   string
+	CookieJar string
+	// Attempts counts the fetches of a batch item with --retry-failed.
+	Attempts int
+	// OutName is the --out-dir file name a --manifest entry asked for.
+	OutName string
 	// Error is set by parallel fetch callers, not by fetchOne().
 	// fetchOne returns errors via its second return value.
 	Error error
@@ -37,10 +166,25 @@ type fetchResult struct {
 	resp *http.Response
 }
 
+// processInput returns the pipeline input describing this result.
+func (r *fetchResult) processInput() processInput {
+	return newProcessInput(r.URL, r.StatusCode, r.Headers, r.Body)
+}
+
+// finalURL returns the URL the result was served from after redirects.
+func (r *fetchResult) finalURL() string {
+	if r.resp != nil && r.resp.Request != nil && r.resp.Request.URL != nil {
+		return r.resp.Request.URL.String()
+	}
+	return r.URL
+}
+
 // fetchOne executes the full fetch pipeline: URL parsing, timeout, transport
 // creation, cookie jar loading, initial fetch, challenge detection/solving,
 // captcha handling, and cookie saving. It returns a fetchResult or an error.
 func fetchOne(opts fetchOptions) (*fetchResult, error) {
+	start := time.Now()
+
 	// 1. Parse the URL (prepend "https://" if no scheme).
 	targetURL := opts.url
 	if !strings.Contains(targetURL, "://") {
@@ -57,9 +201,40 @@ func fetchOne(opts fetchOptions) (*fetchResult, error) {
 		return nil, fmt.Errorf("invalid timeout %q: %w", timeout, err)
 	}
 
+	trOpts, err := opts.transportOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	switch opts.profileMismatch {
+	case "", "warn", "switch":
+	default:
+		return nil, fmt.Errorf("invalid profile mismatch mode %q (want warn or switch)", opts.profileMismatch)
+	}
+
 	// 3. Create context with timeout.
 	ctx, cancel := context.WithTimeout(context.Background(), dur)
 	defer cancel()
+	if opts.maxBodySize != "" {
+		limit, err := opts.bodyLimit()
+		if err != nil {
+			return nil, err
+		}
+		ctx = withBodyLimit(ctx, limit)
+	}
+	if err := opts.redirects.validate(); err != nil {
+		return nil, err
+	}
+	ctx = withRedirectPolicy(ctx, opts.redirects)
+	limits, err := opts.jsLimits()
+	if err != nil {
+		return nil, err
+	}
+	ctx = withJSLimits(ctx, limits)
+	captchaOpts, err := opts.captcha.options()
+	if err != nil {
+		return nil, err
+	}
 
 	// 4. Get browser profile.
 	browser := opts.browser
@@ -71,22 +246,40 @@ func fetchOne(opts fetchOptions) (*fetchResult, error) {
 		fmt.Fprintf(os.Stderr, "[*] Using %s profile\n", profile.Name)
 	}
 
-	// 5. Create transport.
-	tr, err := newTransport(profile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create transport: %w", err)
-	}
-
-	// 6. Load cookie jar if cookies are enabled.
+	// 5. Load cookie jar if cookies are enabled.
 	var jar *PersistentJar
+	jarPath := ""
 	if !opts.noCookies {
-		jarPath := defaultCookieJarPath()
-		jar = newPersistentJar(jarPath)
-		if err := jar.Load(); err != nil {
+		jarPath = defaultCookieJarPath()
+		if jar, err = sessionJar(jarPath); err != nil {
 			return nil, fmt.Errorf("failed to load cookie jar: %w", err)
 		}
 	}
 
+	// A clearance cookie only stays valid under the fingerprint that
+	// earned it; warn about (or avoid) replaying it under another profile.
+	if jar != nil {
+		if u, err := url.Parse(targetURL); err == nil {
+			if pinned, name := jar.PinnedProfile(u); pinned != "" && pinned != profile.Name {
+				if opts.profileMismatch == "switch" {
+					profile = getProfile(pinned)
+					if opts.verbose {
+						fmt.Fprintf(os.Stderr, "[*] Switching to %s profile: %s was obtained with it\n", pinned, name)
+					}
+				} else {
+					fmt.Fprintf(os.Stderr, "[*] Warning: %s for %s was obtained with the %s profile; replaying it as %s will likely invalidate it (use -b %s or --profile-mismatch switch)\n",
+						name, u.Hostname(), pinned, profile.Name, pinned)
+				}
+			}
+		}
+	}
+
+	// 6. Create transport.
+	tr, err := sessionTransport(profile, trOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create transport: %w", err)
+	}
+
 	// 7. Build initial cookies from jar.
 	var cookies []*http.Cookie
 	if jar != nil {
@@ -94,108 +287,371 @@ func fetchOne(opts fetchOptions) (*fetchResult, error) {
 			cookies = jar.Cookies(u)
 		}
 	}
+	cookies = mergeCookies(cookies, opts.cookies)
+	inline, err := inlineCookies(opts.cookieFlags, targetURL)
+	if err != nil {
+		return nil, err
+	}
+	cookies = mergeCookies(cookies, inline)
+
+	// A clearance cookie this profile obtained earlier and that is still
+	// valid means the site already let us through.
+	var clearance savedCookie
+	cachedClearance := false
+	if jar != nil {
+		if u, err := url.Parse(targetURL); err == nil {
+			clearance, cachedClearance = jar.Clearance(u, profile.Name)
+		}
+	}
+	if cachedClearance && opts.verbose {
+		fmt.Fprintf(os.Stderr, "[*] Using cached %s (solved %s ago, valid until %s)\n",
+			clearance.Name, time.Since(clearance.SolvedAt).Round(time.Second), clearance.validUntil().Format(time.RFC3339))
+	}
 
 	if opts.verbose {
 		fmt.Fprintf(os.Stderr, "[*] Fetching %s\n", targetURL)
 	}
 
+	extraHeaders := opts.requestHeaders(targetURL)
+
+	// get (re)fetches targetURL with the current headers and cookies. A body
+	// cut off mid-stream is kept and flagged rather than failing the fetch;
+	// the flag always describes the latest response.
+	var (
+		resp      *http.Response
+		body      []byte
+		truncated *truncatedBodyError
+		timing    *fetchTiming
+	)
+	streamed := false
+	get := func() error {
+		var err error
+		truncated, streamed = nil, false
+		tctx, trace := withRequestTrace(ctx)
+		if opts.stream == nil {
+			resp, body, err = doFetch(tctx, tr, profile, "GET", targetURL, extraHeaders, cookies)
+		} else {
+			resp, body, streamed, err = fetchStreaming(tctx, tr, profile, targetURL, extraHeaders, cookies, opts.stream)
+		}
+		timing = trace.done()
+		if errors.As(err, &truncated) {
+			if errors.Is(err, errBodyTooLarge) && !opts.maxBodyTruncate {
+				return fmt.Errorf("response body is larger than --max-body-size %s (use --max-body-truncate to keep the first part)", opts.maxBodySize)
+			}
+			return nil
+		}
+		return err
+	}
+
 	// 8. Perform the fetch (read-only GET request, no custom headers).
-	resp, body, err := doFetch(ctx, tr, profile, "GET", targetURL, nil, cookies)
-	if err != nil {
+	if err := get(); err != nil {
 		return nil, fmt.Errorf("fetch failed: %w", err)
 	}
 
+	// 9. Answer a Digest authentication challenge.
+	if resp.StatusCode == http.StatusUnauthorized && opts.user != "" && opts.digest {
+		if c, ok := pickDigestChallenge(parseDigestChallenges(resp.Header)); ok {
+			authURL := resp.Request.URL
+			authz, err := digestAuthHeader(c, opts.user, "GET", authURL)
+			if err != nil {
+				return nil, fmt.Errorf("digest auth failed: %w", err)
+			}
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "[*] Answering Digest challenge (%s, realm %q)\n", c.algorithm, c.realm)
+			}
+			extraHeaders = append(extraHeaders, [2]string{"Authorization", authz})
+			targetURL = authURL.String()
+			err = get()
+			if err != nil {
+				return nil, fmt.Errorf("fetch with digest auth failed: %w", err)
+			}
+		} else if opts.verbose {
+			fmt.Fprintf(os.Stderr, "[*] 401 without a supported Digest challenge\n")
+		}
+	}
+
 	// 10. Detect challenges.
 	challenge := detectChallenge(resp, body)
 	if opts.verbose {
 		fmt.Fprintf(os.Stderr, "[*] Challenge: %s\n", challenge)
 	}
 
-	// 11. Handle JS challenge.
-	if challenge == ChallengeJS {
-		script := extractScriptContent(body)
-		if script != "" {
-			solver := newJSSolver(targetURL)
-			result, err := solver.Solve(script)
-			if err != nil {
-				if opts.verbose {
-					fmt.Fprintf(os.Stderr, "[*] JS solver error: %v\n", err)
-				}
-			} else if result.CookieName != "" {
-				// Add the solved cookie and retry.
-				solvedCookie := &http.Cookie{
-					Name:  result.CookieName,
-					Value: result.CookieValue,
-				}
-				cookies = append(cookies, solvedCookie)
+	// With a valid clearance, a successful response is the page itself:
+	// challenge markers in it (a comment form's captcha, say) are not
+	// solved. An error response means the site revoked the clearance;
+	// drop it and solve afresh.
+	clearanceValid := false
+	if cachedClearance && challenge != ChallengeNone {
+		if resp.StatusCode < 400 {
+			clearanceValid = true
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "[*] Skipping challenge solving: %s is still valid\n", clearance.Name)
+			}
+			challenge = ChallengeNone
+		} else {
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "[*] Cached %s was rejected (HTTP %d); solving again\n", clearance.Name, resp.StatusCode)
+			}
+			jar.Forget(clearance)
+		}
+	}
 
-				// Store solved cookie in jar.
-				if jar != nil {
-					if u, err := url.Parse(targetURL); err == nil {
-						jar.SetCookies(u, []*http.Cookie{solvedCookie})
+	// 10a. Homepage-first navigation: visit the site root as a fresh
+	// navigation, then request the target again as an in-site click.
+	if challenge != ChallengeNone && opts.navigateFromHome {
+		if home, ok := siteRoot(targetURL); ok {
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "[*] Navigating from %s\n", home)
+			}
+			// Only the homepage's cookies matter, so a truncated body is fine.
+			homeResp, _, err := doFetch(ctx, tr, profile, "GET", home, nil, cookies)
+			if homeResp != nil {
+				if homeCookies := homeResp.Cookies(); len(homeCookies) > 0 {
+					cookies = mergeCookies(cookies, homeCookies)
+					if jar != nil && homeResp.Request != nil {
+						jar.SetResponseCookies(homeResp.Request.URL, homeCookies, profile.Name)
 					}
 				}
-
-				if opts.verbose {
-					fmt.Fprintf(os.Stderr, "[*] Retrying with solved JS cookie: %s\n", result.CookieName)
-				}
-				resp, body, err = doFetch(ctx, tr, profile, "GET", targetURL, nil, cookies)
+				extraHeaders = append(extraHeaders,
+					[2]string{"Referer", home},
+					[2]string{"Sec-Fetch-Site", "same-origin"},
+				)
+				err = get()
 				if err != nil {
-					return nil, fmt.Errorf("retry fetch failed: %w", err)
+					return nil, fmt.Errorf("fetch after homepage visit failed: %w", err)
 				}
+				challenge = detectChallenge(resp, body)
+				if opts.verbose {
+					fmt.Fprintf(os.Stderr, "[*] Challenge after homepage visit: %s\n", challenge)
+				}
+			} else if opts.verbose {
+				fmt.Fprintf(os.Stderr, "[*] Homepage visit failed: %v\n", err)
+			}
+		}
+	}
+
+	// 11. Hand the challenge to the solvers: the built-in Cloudflare,
+	// Incapsula and DDoS-Guard solvers, then compiled-in and plugin
+	// solvers. Each one that detects its challenge in the response the
+	// previous ones left solves it, and the page is fetched again.
+	if opts.noJS && opts.verbose && (challenge == ChallengeJS || challenge == ChallengeIncapsula || challenge == ChallengeDDoSGuard) {
+		fmt.Fprintf(os.Stderr, "[*] Not solving %s challenge (--no-js)\n", challenge)
+	}
+	solveJS := !opts.noJS
+	solvers := opts.solvers
+	if !solveJS {
+		solvers = withoutScriptSolvers(solvers)
+	}
+	for _, solver := range solvers {
+		if clearanceValid || !solver.Detect(resp, body) {
+			continue
+		}
+		if opts.verbose {
+			fmt.Fprintf(os.Stderr, "[*] Solving %s challenge with its solver\n", solver.Name())
+		}
+		f := newFetcher(tr, profile, targetURL, mergeCookies(cookies, resp.Cookies()), opts.verbose)
+		solution, err := solver.Solve(ctx, f, resp, body)
+		if err != nil {
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "[*] %s solver error: %v\n", solver.Name(), err)
 			}
+			continue
+		}
+		cookies = mergeCookies(f.Cookies(), solution.Cookies)
+		extraHeaders = append(extraHeaders, solution.Headers...)
+		if jar != nil && len(solution.Cookies) > 0 {
+			if u, err := url.Parse(targetURL); err == nil {
+				jar.SetClearanceCookies(u, solution.Cookies, profile.Name)
+			}
+		}
+		if opts.verbose {
+			fmt.Fprintf(os.Stderr, "[*] Retrying with the %s solution\n", solver.Name())
+		}
+		if err := get(); err != nil {
+			return nil, fmt.Errorf("retry fetch after %s solver failed: %w", solver.Name(), err)
 		}
 	}
 
 	// 12. Handle captcha challenge.
 	if challenge == ChallengeCaptcha {
-		sitekey, captchaType := extractSitekey(body)
-		if sitekey != "" {
-			svc := opts.captchaService
-			if svc == "" {
-				svc = os.Getenv("GHOSTFETCH_CAPTCHA_SERVICE")
+		svc, key := captchaCredentials(opts.captchaService, opts.captchaKey)
+
+		// Providers are tried in order: the next one takes over when one
+		// is out of balance, times out, or its answer is rejected.
+		var providers []*CaptchaSolver
+		if svc != "" && key != "" {
+			var err error
+			if providers, err = newCaptchaSolvers(svc, key, captchaOpts); err != nil {
+				return nil, fmt.Errorf("captcha solver init failed: %w", err)
+			}
+			// Solves can outlast --timeout (hCaptcha Enterprise often
+			// takes minutes), so the fetch's deadline is pushed back by
+			// each provider's max wait. The -t deadline is the only thing
+			// that cancels ctx, so it is dropped deliberately and the
+			// extended one set from it: the whole fetch, solves included,
+			// still ends by then.
+			if deadline, ok := ctx.Deadline(); ok {
+				var cancelSolve context.CancelFunc
+				ctx, cancelSolve = context.WithDeadline(context.WithoutCancel(ctx), deadline.Add(time.Duration(len(providers))*captchaOpts.maxWait))
+				defer cancelSolve()
 			}
-			key := opts.captchaKey
-			if key == "" {
-				key = os.Getenv("GHOSTFETCH_CAPTCHA_KEY")
+		}
+		// failover reports whether to move on from chain[i] to the next
+		// after err.
+		failover := func(chain []string, i int, err error) bool {
+			if i == len(chain)-1 || !captchaFailover(err) {
+				return false
+			}
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "[*] %s failed (%v); trying %s\n", chain[i], err, chain[i+1])
 			}
+			return true
+		}
+		// rejected reports whether the retry after chain[i]'s answer is
+		// still a captcha, and another provider is left to try.
+		rejected := func(chain []string, i int) bool {
+			if i == len(chain)-1 || detectChallenge(resp, body) != ChallengeCaptcha {
+				return false
+			}
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "[*] %s answer was rejected; trying %s\n", chain[i], chain[i+1])
+			}
+			return true
+		}
+		var services []string
+		for _, p := range providers {
+			services = append(services, p.service)
+		}
 
-			if svc == "" || key == "" {
+		sitekey, captchaType := extractSitekey(body)
+		if sitekey != "" {
+			if len(providers) == 0 && opts.verbose {
+				fmt.Fprintf(os.Stderr, "[*] Captcha detected but no service/key configured\n")
+			}
+			for i, captchaSolver := range providers {
 				if opts.verbose {
-					fmt.Fprintf(os.Stderr, "[*] Captcha detected but no service/key configured\n")
+					fmt.Fprintf(os.Stderr, "[*] Solving %s captcha via %s\n", captchaType, captchaSolver.service)
 				}
-			} else {
-				captchaSolver, err := newCaptchaSolver(svc, key)
+				token, err := captchaSolver.Solve(ctx, sitekey, targetURL, captchaType)
 				if err != nil {
-					return nil, fmt.Errorf("captcha solver init failed: %w", err)
+					if failover(services, i, err) {
+						continue
+					}
+					return nil, fmt.Errorf("%w: %w", errCaptchaSolve, err)
 				}
-				if opts.verbose {
-					fmt.Fprintf(os.Stderr, "[*] Solving %s captcha via %s\n", captchaType, svc)
+				if isCloudflareManagedChallenge(resp, body) {
+					// Cloudflare's own challenge page takes the token as
+					// its clearance cookie.
+					if opts.verbose {
+						fmt.Fprintf(os.Stderr, "[*] Captcha solved, retrying fetch\n")
+					}
+					solvedCookie := &http.Cookie{
+						Name:  "cf_clearance",
+						Value: token,
+					}
+					cookies = mergeCookies(cookies, []*http.Cookie{solvedCookie})
+
+					if jar != nil {
+						if u, err := url.Parse(targetURL); err == nil {
+							jar.SetClearanceCookies(u, []*http.Cookie{solvedCookie}, profile.Name)
+						}
+					}
+				} else {
+					// A widget on the site's own page: its token goes in
+					// with the page's form, as the widget's script would
+					// submit it.
+					if opts.verbose {
+						fmt.Fprintf(os.Stderr, "[*] Captcha solved, submitting the page's form\n")
+					}
+					earned, err := submitCaptchaToken(ctx, tr, profile, targetURL, body, sitekey, captchaType, token, cookies, extraHeaders, opts.verbose)
+					if err != nil {
+						return nil, fmt.Errorf("captcha token submission failed: %w", err)
+					}
+					cookies = mergeCookies(cookies, earned)
+					if jar != nil {
+						if u, err := url.Parse(targetURL); err == nil {
+							jar.SetResponseCookies(u, earned, profile.Name)
+						}
+					}
 				}
-				token, err := captchaSolver.Solve(ctx, sitekey, targetURL, captchaType)
+
+				err = get()
 				if err != nil {
-					return nil, fmt.Errorf("captcha solve failed: %w", err)
+					return nil, fmt.Errorf("retry fetch after captcha failed: %w", err)
 				}
-				if opts.verbose {
-					fmt.Fprintf(os.Stderr, "[*] Captcha solved, retrying fetch\n")
+				if !rejected(services, i) {
+					break
 				}
-				solvedCookie := &http.Cookie{
-					Name:  "cf_clearance",
-					Value: token,
+			}
+		} else if _, ok := extractImageCaptcha(body); ok {
+			// A plain image captcha: read it locally with tesseract, or
+			// through the providers' image recognition.
+			names, readers := services, []func(context.Context, []byte) (string, error){}
+			if opts.tesseract != "" {
+				names = []string{"tesseract"}
+				readers = append(readers, tesseractReader(opts.tesseract))
+			} else {
+				for _, p := range providers {
+					readers = append(readers, p.SolveImage)
 				}
-				cookies = append(cookies, solvedCookie)
-
+			}
+			if len(readers) == 0 && opts.verbose {
+				fmt.Fprintf(os.Stderr, "[*] Image captcha detected but no service/key or --tesseract configured\n")
+			}
+			for i, read := range readers {
+				solved, err := solveImageCaptcha(ctx, tr, profile, targetURL, body, cookies, extraHeaders, read, opts.verbose)
+				if err != nil {
+					if failover(names, i, err) {
+						continue
+					}
+					return nil, fmt.Errorf("image %w: %w", errCaptchaSolve, err)
+				}
+				cookies = mergeCookies(cookies, solved)
 				if jar != nil {
 					if u, err := url.Parse(targetURL); err == nil {
-						jar.SetCookies(u, []*http.Cookie{solvedCookie})
+						jar.SetResponseCookies(u, solved, profile.Name)
 					}
 				}
+				if opts.verbose {
+					fmt.Fprintf(os.Stderr, "[*] Image captcha answered, retrying fetch\n")
+				}
+				if err := get(); err != nil {
+					return nil, fmt.Errorf("retry fetch after image captcha failed: %w", err)
+				}
+				// A rejected answer comes back with a fresh captcha for
+				// the next provider.
+				if !rejected(names, i) {
+					break
+				}
+			}
+		}
+	}
 
-				resp, body, err = doFetch(ctx, tr, profile, "GET", targetURL, nil, cookies)
-				if err != nil {
-					return nil, fmt.Errorf("retry fetch after captcha failed: %w", err)
+	// 12a. Fall back to a real browser for a challenge still in place,
+	// then carry on with its cookies over the lightweight transport.
+	if opts.browserFallback && solveJS && !clearanceValid &&
+		(detectChallenge(resp, body) != ChallengeNone || findSolver(opts.solvers, resp, body) != nil) {
+		if profile.Name != "chrome" {
+			fmt.Fprintf(os.Stderr, "[*] Warning: the browser fallback runs Chrome; its clearance may not hold for the %s profile (use -b chrome)\n", profile.Name)
+		}
+		solved, err := browserClearance(ctx, targetURL, cookies, body, opts.verbose)
+		if err != nil {
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "[*] Browser fallback failed: %v\n", err)
+			}
+		} else {
+			cookies = mergeCookies(cookies, solved)
+			if jar != nil {
+				if u, err := url.Parse(targetURL); err == nil {
+					jar.SetResponseCookies(u, solved, chromeProfile().Name)
 				}
 			}
+			if opts.verbose {
+				fmt.Fprintf(os.Stderr, "[*] Retrying with the browser's cookies\n")
+			}
+			if err := get(); err != nil {
+				return nil, fmt.Errorf("retry fetch after browser fallback failed: %w", err)
+			}
 		}
 	}
 
@@ -204,7 +660,7 @@ func fetchOne(opts fetchOptions) (*fetchResult, error) {
 		// Store response cookies in the jar.
 		if resp != nil && resp.Request != nil && resp.Request.URL != nil {
 			if respCookies := resp.Cookies(); len(respCookies) > 0 {
-				jar.SetCookies(resp.Request.URL, respCookies)
+				jar.SetResponseCookies(resp.Request.URL, respCookies, profile.Name)
 			}
 		}
 		if err := jar.Save(); err != nil {
@@ -214,11 +670,281 @@ func fetchOne(opts fetchOptions) (*fetchResult, error) {
 		}
 	}
 
+	if truncated != nil {
+		fmt.Fprintf(os.Stderr, "[*] Warning: %s: %v\n", targetURL, truncated)
+	}
+
+	// Report a challenge that is still in place after solving attempts.
+	final := detectChallenge(resp, body)
+	if final == ChallengeAkamai && opts.verbose {
+		fmt.Fprintf(os.Stderr, "[*] Blocked by Akamai Bot Manager (HTTP %d); it needs a browser-generated sensor payload, so try warm, --navigate-from-home, another -b profile or network\n", resp.StatusCode)
+	}
+	var challengeName string
+	if final != ChallengeNone {
+		challengeName = final.String()
+	} else if solver := findSolver(opts.solvers, resp, body); solver != nil {
+		challengeName = solver.Name()
+	}
+
 	return &fetchResult{
 		URL:        targetURL,
 		StatusCode: resp.StatusCode,
 		Headers:    resp.Header,
 		Body:       body,
+		Truncated:  truncated != nil,
+		Streamed:   streamed,
+		Challenge:  challengeName,
+		FetchedAt:  start,
+		Elapsed:    time.Since(start),
+		Timing:     timing,
+		Profile:    profile.Name,
+		CookieJar:  jarPath,
 		resp:       resp,
 	}, nil
 }
+
+// fetchStreaming performs a GET and copies the decoded body straight to w
+// when the response is a success that isn't a page (so it can't be a
+// challenge): files, media, JSON. Other responses are buffered as usual
+// so challenge detection still sees them. It reports whether the body
+// was streamed.
+func fetchStreaming(ctx context.Context, tr http.RoundTripper, profile BrowserProfile, targetURL string, extraHeaders [][2]string, cookies []*http.Cookie, w io.Writer) (*http.Response, []byte, bool, error) {
+	resp, err := sendRequest(ctx, tr, profile, "GET", targetURL, extraHeaders, cookies, "")
+	if err != nil {
+		return nil, nil, false, err
+	}
+	defer resp.Body.Close()
+
+	// A body known to exceed the limit goes through the buffered path, so
+	// the caller can fail before anything reaches w.
+	if limit := bodyLimit(resp); !streamable(resp) || (limit > 0 && resp.ContentLength > limit) {
+		body, err := readBody(resp)
+		return resp, body, false, err
+	}
+	dec, err := decodingReader(resp, resp.Body)
+	var n int64
+	if err == nil {
+		n, err = io.Copy(w, capReader(dec, bodyLimit(resp)))
+	}
+	if errors.Is(err, errBodyTooLarge) {
+		return resp, nil, true, &truncatedBodyError{n: int(n), err: err}
+	}
+	if err != nil {
+		return resp, nil, true, fmt.Errorf("stream body: %w", err)
+	}
+	return resp, nil, true, nil
+}
+
+// streamable reports whether resp's body can bypass buffering: a 2xx
+// response whose declared type isn't HTML.
+func streamable(resp *http.Response) bool {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return false
+	}
+	ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	return ct != "" && !isHTMLType(strings.ToLower(ct))
+}
+
+// transportOptions parses the connect and read timeouts. Both are
+// optional; zero means bounded only by the overall timeout.
+func (opts fetchOptions) transportOptions() (transportOptions, error) {
+	trOpts := transportOptions{
+		http10:      opts.http10,
+		noKeepAlive: opts.noKeepAlive,
+		verbose:     opts.verbose,
+	}
+	var err error
+	if opts.connectTimeout != "" {
+		if trOpts.connectTimeout, err = time.ParseDuration(opts.connectTimeout); err != nil {
+			return trOpts, fmt.Errorf("invalid connect timeout %q: %w", opts.connectTimeout, err)
+		}
+	}
+	if opts.readTimeout != "" {
+		if trOpts.readTimeout, err = time.ParseDuration(opts.readTimeout); err != nil {
+			return trOpts, fmt.Errorf("invalid read timeout %q: %w", opts.readTimeout, err)
+		}
+	}
+	return trOpts, nil
+}
+
+// bodyLimit parses --max-body-size; 0 means no limit.
+func (opts fetchOptions) bodyLimit() (int64, error) {
+	if opts.maxBodySize == "" {
+		return 0, nil
+	}
+	limit, err := parseByteSize(opts.maxBodySize)
+	if err != nil {
+		return 0, fmt.Errorf("invalid max body size: %w", err)
+	}
+	return limit, nil
+}
+
+// jsLimits parses the JS solver's timeout and memory budget, falling back
+// to the defaults for unset values.
+func (opts fetchOptions) jsLimits() (jsLimits, error) {
+	limits := defaultJSLimits
+	var err error
+	if opts.jsTimeout != "" {
+		if limits.timeout, err = time.ParseDuration(opts.jsTimeout); err != nil {
+			return limits, fmt.Errorf("invalid JS timeout %q: %w", opts.jsTimeout, err)
+		}
+		if limits.timeout <= 0 {
+			return limits, fmt.Errorf("invalid JS timeout %q: must be positive", opts.jsTimeout)
+		}
+	}
+	if opts.jsMaxMemory != "" {
+		if limits.maxMemory, err = parseByteSize(opts.jsMaxMemory); err != nil {
+			return limits, fmt.Errorf("invalid JS max memory: %w", err)
+		}
+	}
+	return limits, nil
+}
+
+// requestHeaders returns the headers added on top of the browser profile
+// for a request to targetURL. Callers may only adjust navigation-related
+// headers: Accept, so API endpoints can be asked for JSON,
+// Accept-Language, so searches come back in the asked-for language,
+// Referer with
+// the matching Sec-Fetch-Site, so follow-up requests look like in-site
+// clicks, and Basic Authorization. If no user is set, it is looked up in
+// the netrc file and stored in opts for a later Digest answer.
+func (opts *fetchOptions) requestHeaders(targetURL string) [][2]string {
+	var extraHeaders [][2]string
+	if opts.accept != "" {
+		extraHeaders = append(extraHeaders, [2]string{"Accept", opts.accept})
+	}
+	if opts.acceptLanguage != "" {
+		extraHeaders = append(extraHeaders, [2]string{"Accept-Language", opts.acceptLanguage})
+	}
+	if opts.referer != "" {
+		extraHeaders = append(extraHeaders,
+			[2]string{"Referer", opts.referer},
+			[2]string{"Sec-Fetch-Site", secFetchSite(opts.referer, targetURL)},
+		)
+	}
+
+	if opts.user == "" && opts.netrcFile != "" {
+		if u, err := url.Parse(targetURL); err == nil {
+			if cred, ok := netrcCredentials(opts.netrcFile, u.Hostname()); ok {
+				opts.user = cred
+				if opts.verbose {
+					fmt.Fprintf(os.Stderr, "[*] Using credentials for %s from %s\n", u.Hostname(), opts.netrcFile)
+				}
+			}
+		}
+	}
+	if opts.user != "" && !opts.digest {
+		extraHeaders = append(extraHeaders, [2]string{"Authorization", basicAuthHeader(opts.user)})
+	}
+	return extraHeaders
+}
+
+// applyDataURLEncode appends --data-urlencode items to the URL's query
+// string, like curl -G --data-urlencode. ghostfetch never sends a request
+// body, so form data always travels in the query. Each item is one of
+// "name=value", "=value", "value", or "name@file" (value read from file);
+// values are URL-encoded for the caller.
+func applyDataURLEncode(rawURL string, items []string) (string, error) {
+	if len(items) == 0 {
+		return rawURL, nil
+	}
+	var pairs []string
+	for _, item := range items {
+		eq := strings.IndexByte(item, '=')
+		at := strings.IndexByte(item, '@')
+		switch {
+		case at >= 0 && (eq < 0 || at < eq):
+			name, file := item[:at], item[at+1:]
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return "", fmt.Errorf("data-urlencode %q: %w", item, err)
+			}
+			pairs = append(pairs, encodePair(name, string(data)))
+		case eq >= 0:
+			pairs = append(pairs, encodePair(item[:eq], item[eq+1:]))
+		default:
+			pairs = append(pairs, url.QueryEscape(item))
+		}
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	query := strings.Join(pairs, "&")
+	if u.RawQuery != "" {
+		query = u.RawQuery + "&" + query
+	}
+	u.RawQuery = query
+	return u.String(), nil
+}
+
+// encodePair renders name=value with value URL-encoded; an empty name
+// yields just the encoded value, matching curl.
+func encodePair(name, value string) string {
+	if name == "" {
+		return url.QueryEscape(value)
+	}
+	return name + "=" + url.QueryEscape(value)
+}
+
+// secFetchSite returns the Sec-Fetch-Site value a browser would send when
+// navigating from referer to target: "same-origin", "same-site" or
+// "cross-site".
+func secFetchSite(referer, target string) string {
+	ru, err1 := url.Parse(referer)
+	tu, err2 := url.Parse(target)
+	if err1 != nil || err2 != nil {
+		return "cross-site"
+	}
+	if ru.Scheme == tu.Scheme && ru.Host == tu.Host {
+		return "same-origin"
+	}
+	rs, err1 := publicsuffix.EffectiveTLDPlusOne(ru.Hostname())
+	ts, err2 := publicsuffix.EffectiveTLDPlusOne(tu.Hostname())
+	if err1 == nil && err2 == nil && rs == ts && ru.Scheme == tu.Scheme {
+		return "same-site"
+	}
+	return "cross-site"
+}
+
+// siteRoot returns the "/" URL of target's origin. The boolean is false
+// when target already is the root, so there is nothing to navigate from.
+func siteRoot(target string) (string, bool) {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+	if (u.Path == "" || u.Path == "/") && u.RawQuery == "" {
+		return "", false
+	}
+	return u.Scheme + "://" + u.Host + "/", true
+}
+
+// mergeCookies returns cookies with each of updates added, replacing any
+// existing cookie of the same name.
+func mergeCookies(cookies, updates []*http.Cookie) []*http.Cookie {
+	merged := make([]*http.Cookie, 0, len(cookies)+len(updates))
+	for _, c := range cookies {
+		replaced := false
+		for _, u := range updates {
+			if u.Name == c.Name {
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			merged = append(merged, c)
+		}
+	}
+	return append(merged, updates...)
+}
+
+// resolveUser returns the credentials from --user, falling back to the
+// GHOSTFETCH_USER environment variable so secrets can stay out of argv.
+func resolveUser() string {
+	if flagUser != "" {
+		return flagUser
+	}
+	return os.Getenv("GHOSTFETCH_USER")
+}
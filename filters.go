@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// resultFilter holds the post-fetch filters applied before batch output
+// or storage.
+type resultFilter struct {
+	onlyLang     string
+	statuses     []string // exact codes ("200") or classes ("2xx")
+	minBodyBytes int
+	bodyMatches  *regexp.Regexp
+}
+
+// newResultFilter builds the filter from flags, validating them before any
+// request is made.
+func newResultFilter() (*resultFilter, error) {
+	f := &resultFilter{onlyLang: flagOnlyLang, minBodyBytes: flagMinBodyBytes}
+	for _, s := range flagOnlyStatus {
+		s = strings.ToLower(strings.TrimSpace(s))
+		if !validStatusSpec(s) {
+			return nil, fmt.Errorf("invalid --only-status %q (want e.g. 200 or 2xx)", s)
+		}
+		f.statuses = append(f.statuses, s)
+	}
+	if flagBodyMatches != "" {
+		re, err := regexp.Compile(flagBodyMatches)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --body-matches: %w", err)
+		}
+		f.bodyMatches = re
+	}
+	return f, nil
+}
+
+// validStatusSpec reports whether s is a three-digit status code or a
+// class such as "2xx".
+func validStatusSpec(s string) bool {
+	if len(s) != 3 || s[0] < '1' || s[0] > '5' {
+		return false
+	}
+	if s[1:] == "xx" {
+		return true
+	}
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// statusAllowed reports whether code matches one of the status specs.
+func (f *resultFilter) statusAllowed(code int) bool {
+	if len(f.statuses) == 0 {
+		return true
+	}
+	c := strconv.Itoa(code)
+	for _, s := range f.statuses {
+		if s == c || (strings.HasSuffix(s, "xx") && len(c) == 3 && c[0] == s[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply marks results that should not be emitted or stored by setting
+// their Skipped reason. Errors are left untouched so they are still
+// reported.
+func (f *resultFilter) apply(results []fetchResult) {
+	for i := range results {
+		r := &results[i]
+		if r.Error != nil || r.Skipped != "" {
+			continue
+		}
+		r.Skipped = f.reason(r)
+	}
+}
+
+// reason returns why r is filtered out, or empty string to keep it.
+// Cheap checks run first so language detection only sees survivors.
+func (f *resultFilter) reason(r *fetchResult) string {
+	if !f.statusAllowed(r.StatusCode) {
+		return fmt.Sprintf("status %d", r.StatusCode)
+	}
+	if len(r.Body) < f.minBodyBytes {
+		return fmt.Sprintf("body %d bytes < %d", len(r.Body), f.minBodyBytes)
+	}
+	if f.bodyMatches != nil && !f.bodyMatches.Match(r.Body) {
+		return "body does not match " + f.bodyMatches.String()
+	}
+	if f.onlyLang != "" {
+		if lang := detectLanguage(r.Headers, r.Body); !langAllowed(lang, f.onlyLang) {
+			return "language " + lang
+		}
+	}
+	return ""
+}
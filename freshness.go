@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// freshnessInfo summarizes the caching headers of a response. A CDN node
+// serving a cached copy reports a non-zero age; two fetches that differ
+// only because one hit a stale node can be told apart by these fields.
+type freshnessInfo struct {
+	Date         *time.Time `json:"date,omitempty"`
+	LastModified *time.Time `json:"last_modified,omitempty"`
+	Expires      *time.Time `json:"expires,omitempty"`
+	// AgeSeconds is the response's current age per RFC 9111 §4.2.3: the
+	// larger of the Age header and the time since Date.
+	AgeSeconds int64 `json:"age_seconds"`
+	// LifetimeSeconds is how long the response stays fresh: from
+	// max-age/s-maxage, Expires−Date, or the 10% Last-Modified heuristic.
+	LifetimeSeconds int64 `json:"lifetime_seconds"`
+	// Heuristic is true when LifetimeSeconds came from Last-Modified.
+	Heuristic bool `json:"heuristic,omitempty"`
+	Fresh     bool `json:"fresh"`
+	// ContentAgeSeconds is the time since Last-Modified, if known.
+	ContentAgeSeconds int64 `json:"content_age_seconds,omitempty"`
+}
+
+// computeFreshness derives freshnessInfo from response headers as seen at
+// responseTime. It returns nil when the response carries no caching
+// headers at all.
+func computeFreshness(h http.Header, responseTime time.Time) *freshnessInfo {
+	if h == nil {
+		return nil
+	}
+	date := parseHTTPTime(h.Get("Date"))
+	lastMod := parseHTTPTime(h.Get("Last-Modified"))
+	expires := parseHTTPTime(h.Get("Expires"))
+	ageHdr, hasAge := parseSeconds(h.Get("Age"))
+	maxAge, hasMaxAge := cacheControlMaxAge(h.Get("Cache-Control"))
+
+	if date == nil && lastMod == nil && expires == nil && !hasAge && !hasMaxAge {
+		return nil
+	}
+
+	f := &freshnessInfo{Date: date, LastModified: lastMod, Expires: expires}
+
+	// Current age: max(apparent age, Age header).
+	if date != nil {
+		if apparent := int64(responseTime.Sub(*date).Seconds()); apparent > 0 {
+			f.AgeSeconds = apparent
+		}
+	}
+	if ageHdr > f.AgeSeconds {
+		f.AgeSeconds = ageHdr
+	}
+
+	// Freshness lifetime.
+	switch {
+	case hasMaxAge:
+		f.LifetimeSeconds = maxAge
+	case expires != nil:
+		base := responseTime
+		if date != nil {
+			base = *date
+		}
+		if lt := int64(expires.Sub(base).Seconds()); lt > 0 {
+			f.LifetimeSeconds = lt
+		}
+	case lastMod != nil && date != nil:
+		if since := date.Sub(*lastMod); since > 0 {
+			f.LifetimeSeconds = int64(since.Seconds() / 10)
+			f.Heuristic = true
+		}
+	}
+	f.Fresh = f.LifetimeSeconds > f.AgeSeconds
+
+	if lastMod != nil {
+		if ca := int64(responseTime.Sub(*lastMod).Seconds()); ca > 0 {
+			f.ContentAgeSeconds = ca
+		}
+	}
+	return f
+}
+
+// parseHTTPTime parses an HTTP date header, returning nil if absent or invalid.
+func parseHTTPTime(v string) *time.Time {
+	if v == "" {
+		return nil
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// parseSeconds parses a non-negative integer number of seconds.
+func parseSeconds(v string) (int64, bool) {
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// cacheControlMaxAge returns s-maxage (preferred, as seen by shared caches)
+// or max-age from a Cache-Control header. no-cache and no-store yield zero.
+func cacheControlMaxAge(cc string) (int64, bool) {
+	var maxAge, sMaxAge int64
+	var hasMaxAge, hasSMaxAge bool
+	for _, part := range strings.Split(cc, ",") {
+		name, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache":
+			return 0, true
+		case "max-age":
+			maxAge, hasMaxAge = parseSeconds(strings.Trim(val, `"`))
+		case "s-maxage":
+			sMaxAge, hasSMaxAge = parseSeconds(strings.Trim(val, `"`))
+		}
+	}
+	if hasSMaxAge {
+		return sMaxAge, true
+	}
+	return maxAge, hasMaxAge
+}
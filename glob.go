@@ -0,0 +1,253 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// maxGlobExpansion caps how many URLs one glob pattern may produce.
+const maxGlobExpansion = 100000
+
+// batchItem is one URL of a batch together with the variables that
+// produced it (glob expansion values), which are carried into the output.
+type batchItem struct {
+	URL  string
+	Vars map[string]string
+	// Name, Browser, Accept and Timeout are a --manifest entry's own
+	// options; empty ones fall back to the command line's.
+	Name    string
+	Browser string
+	Accept  string
+	Timeout string
+}
+
+// fetchOptions returns the options it is fetched with: the command
+// line's, with its own browser and timeout if set.
+func (it batchItem) fetchOptions() fetchOptions {
+	fo := newFetchOptions(it.URL)
+	if it.Browser != "" {
+		fo.browser = it.Browser
+	}
+	if it.Timeout != "" {
+		fo.timeout = it.Timeout
+	}
+	return fo
+}
+
+// globPart is a literal string or a set of alternatives.
+type globPart struct {
+	literal string
+	alts    []string // nil for literals
+}
+
+// expandGlob expands curl-style URL globs: {a,b,c} alternatives and
+// [1-100], [001-100:10] or [a-z] ranges. Each expansion's values are exposed as vars
+// "1", "2", ... in pattern order, like curl's #1, #2. A bracket that is
+// not a valid range (e.g. an IPv6 literal) is kept verbatim.
+func expandGlob(pattern string) ([]batchItem, error) {
+	parts, err := parseGlob(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	total := 1
+	for _, p := range parts {
+		if p.alts != nil {
+			total *= len(p.alts)
+			if total > maxGlobExpansion {
+				return nil, fmt.Errorf("glob %q expands to more than %d URLs", pattern, maxGlobExpansion)
+			}
+		}
+	}
+
+	items := []batchItem{{Vars: map[string]string{}}}
+	n := 0
+	for _, p := range parts {
+		if p.alts == nil {
+			for i := range items {
+				items[i].URL += p.literal
+			}
+			continue
+		}
+		n++
+		key := strconv.Itoa(n)
+		next := make([]batchItem, 0, len(items)*len(p.alts))
+		for _, it := range items {
+			for _, alt := range p.alts {
+				vars := make(map[string]string, len(it.Vars)+1)
+				for k, v := range it.Vars {
+					vars[k] = v
+				}
+				vars[key] = alt
+				next = append(next, batchItem{URL: it.URL + alt, Vars: vars})
+			}
+		}
+		items = next
+	}
+
+	if n == 0 {
+		return []batchItem{{URL: pattern}}, nil
+	}
+	return items, nil
+}
+
+// parseGlob splits a pattern into literal and alternative parts.
+func parseGlob(pattern string) ([]globPart, error) {
+	var parts []globPart
+	var lit strings.Builder
+	flush := func() {
+		if lit.Len() > 0 {
+			parts = append(parts, globPart{literal: lit.String()})
+			lit.Reset()
+		}
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch c {
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				lit.WriteByte(pattern[i])
+				continue
+			}
+			lit.WriteByte(c)
+		case '{':
+			end := strings.IndexByte(pattern[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unmatched '{' in %q", pattern)
+			}
+			flush()
+			parts = append(parts, globPart{alts: strings.Split(pattern[i+1:i+end], ",")})
+			i += end
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end < 0 {
+				lit.WriteByte(c)
+				continue
+			}
+			alts, ok := parseRange(pattern[i+1 : i+end])
+			if !ok {
+				lit.WriteString(pattern[i : i+end+1])
+				i += end
+				continue
+			}
+			flush()
+			parts = append(parts, globPart{alts: alts})
+			i += end
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	flush()
+	return parts, nil
+}
+
+// parseRange parses a curl-style range: "N-M" numeric (zero-padded when
+// N is written with leading zeros, e.g. "001-100"), "a-z" alphabetic, and
+// an optional ":step" suffix on either.
+func parseRange(spec string) ([]string, bool) {
+	step := 1
+	if body, st, ok := strings.Cut(spec, ":"); ok {
+		n, err := strconv.Atoi(st)
+		if err != nil || n < 1 {
+			return nil, false
+		}
+		spec, step = body, n
+	}
+	lo, hi, ok := strings.Cut(spec, "-")
+	if !ok {
+		return nil, false
+	}
+
+	if len(lo) == 1 && len(hi) == 1 && isASCIILetter(lo[0]) && isASCIILetter(hi[0]) {
+		if lo[0] > hi[0] || isLower(lo[0]) != isLower(hi[0]) {
+			return nil, false
+		}
+		var alts []string
+		for c := int(lo[0]); c <= int(hi[0]); c += step {
+			alts = append(alts, string(rune(c)))
+		}
+		return alts, true
+	}
+
+	from, err1 := strconv.Atoi(lo)
+	to, err2 := strconv.Atoi(hi)
+	if err1 != nil || err2 != nil || from < 0 || from > to || (to-from)/step >= maxGlobExpansion {
+		return nil, false
+	}
+	width := 0
+	if len(lo) > 1 && lo[0] == '0' {
+		width = len(lo)
+	}
+	alts := make([]string, 0, (to-from)/step+1)
+	for n := from; n <= to; n += step {
+		alts = append(alts, fmt.Sprintf("%0*d", width, n))
+	}
+	return alts, true
+}
+
+func isASCIILetter(c byte) bool { return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') }
+
+func isLower(c byte) bool { return c >= 'a' && c <= 'z' }
+
+// mergeVars returns the union of a and b; b wins on conflicts.
+func mergeVars(a, b map[string]string) map[string]string {
+	if len(a) == 0 {
+		return b
+	}
+	if len(b) == 0 {
+		return a
+	}
+	out := make(map[string]string, len(a)+len(b))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		out[k] = v
+	}
+	return out
+}
+
+// expandOutName substitutes #1, #2, ... in an output name template with
+// the item's glob values, then executes any {{.field}} references
+// against the item's variables.
+func expandOutName(tmpl string, vars map[string]string) string {
+	tmpl = expandGlobRefs(tmpl, vars)
+	if !strings.Contains(tmpl, "{{") {
+		return tmpl
+	}
+	t, err := template.New("name").Parse(tmpl)
+	if err != nil {
+		return tmpl
+	}
+	var sb strings.Builder
+	if err := t.Execute(&sb, vars); err != nil {
+		return tmpl
+	}
+	return sb.String()
+}
+
+// expandGlobRefs replaces curl-style #N references with glob values.
+func expandGlobRefs(tmpl string, vars map[string]string) string {
+	var sb strings.Builder
+	for i := 0; i < len(tmpl); i++ {
+		if tmpl[i] == '#' {
+			j := i + 1
+			for j < len(tmpl) && tmpl[j] >= '0' && tmpl[j] <= '9' {
+				j++
+			}
+			if j > i+1 {
+				if v, ok := vars[tmpl[i+1:j]]; ok {
+					sb.WriteString(v)
+					i = j - 1
+					continue
+				}
+			}
+		}
+		sb.WriteByte(tmpl[i])
+	}
+	return sb.String()
+}
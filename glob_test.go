@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []globPart
+		wantErr bool
+	}{
+		{
+			pattern: "https://example.com/",
+			want:    []globPart{{literal: "https://example.com/"}},
+		},
+		{
+			pattern: "https://{www,api}.example.com/",
+			want: []globPart{
+				{literal: "https://"},
+				{alts: []string{"www", "api"}},
+				{literal: ".example.com/"},
+			},
+		},
+		{
+			pattern: "https://example.com/p/[1-3]",
+			want: []globPart{
+				{literal: "https://example.com/p/"},
+				{alts: []string{"1", "2", "3"}},
+			},
+		},
+		{
+			pattern: "https://example.com/[08-10]",
+			want: []globPart{
+				{literal: "https://example.com/"},
+				{alts: []string{"08", "09", "10"}},
+			},
+		},
+		{
+			pattern: "https://example.com/[0-20:10]",
+			want: []globPart{
+				{literal: "https://example.com/"},
+				{alts: []string{"0", "10", "20"}},
+			},
+		},
+		{
+			pattern: "https://example.com/[a-c].html",
+			want: []globPart{
+				{literal: "https://example.com/"},
+				{alts: []string{"a", "b", "c"}},
+				{literal: ".html"},
+			},
+		},
+		{
+			pattern: "https://example.com/{x}",
+			want: []globPart{
+				{literal: "https://example.com/"},
+				{alts: []string{"x"}},
+			},
+		},
+		{
+			// Not a range: kept as a literal, like an IPv6 host.
+			pattern: "http://[::1]:8080/",
+			want:    []globPart{{literal: "http://[::1]:8080/"}},
+		},
+		{
+			pattern: `https://example.com/\{a,b\}`,
+			want:    []globPart{{literal: "https://example.com/{a,b}"}},
+		},
+		{
+			pattern: "https://example.com/[a-Z]",
+			want:    []globPart{{literal: "https://example.com/[a-Z]"}},
+		},
+		{
+			pattern: "https://example.com/{a,b",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseGlob(tt.pattern)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseGlob(%q) error = %v, wantErr %v", tt.pattern, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseGlob(%q) = %+v, want %+v", tt.pattern, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// grepProcessor compiles a --grep pattern into a pipeline stage that
+// keeps the lines of the content matching it, like grep. With only set
+// it outputs the matches instead, one per line like grep -o; when the
+// pattern has capture groups each match is a line of JSON instead: an
+// object of the named groups, or an array of the groups in order.
+func grepProcessor(expr string, only bool) (processorFunc, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("--grep: %w", err)
+	}
+	return func(content string, in processInput) (string, error) {
+		var sb strings.Builder
+		for line := range strings.Lines(content) {
+			line = strings.TrimRight(line, "\r\n")
+			if !only {
+				if re.MatchString(line) {
+					sb.WriteString(line)
+					sb.WriteByte('\n')
+				}
+				continue
+			}
+			for _, m := range re.FindAllStringSubmatch(line, -1) {
+				sb.WriteString(grepMatch(re, m))
+				sb.WriteByte('\n')
+			}
+		}
+		return sb.String(), nil
+	}, nil
+}
+
+// grepMatch formats one --grep-only match m of re: the matched text, or
+// its capture groups as JSON.
+func grepMatch(re *regexp.Regexp, m []string) string {
+	if re.NumSubexp() == 0 {
+		return m[0]
+	}
+	var v any = m[1:]
+	if names := re.SubexpNames(); slices.ContainsFunc(names, func(n string) bool { return n != "" }) {
+		groups := make(map[string]string)
+		for i, name := range names {
+			if name != "" {
+				groups[name] = m[i]
+			}
+		}
+		v = groups
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
@@ -0,0 +1,37 @@
+package main
+
+import "testing"
+
+func TestGrepProcessor(t *testing.T) {
+	const content = "order 42 shipped to bob@example.com\r\nnothing here\norder 7 and 8 for amy@test.com\n"
+	run := func(expr string, only bool) string {
+		t.Helper()
+		fn, err := grepProcessor(expr, only)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := fn(content, processInput{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	if got, want := run(`order`, false), "order 42 shipped to bob@example.com\norder 7 and 8 for amy@test.com\n"; got != want {
+		t.Errorf("--grep lines = %q, want %q", got, want)
+	}
+	if got, want := run(`\d+`, true), "42\n7\n8\n"; got != want {
+		t.Errorf("--grep-only = %q, want %q", got, want)
+	}
+	if got, want := run(`(\w+)@(\w+)\.com`, true), `["bob","example"]`+"\n"+`["amy","test"]`+"\n"; got != want {
+		t.Errorf("--grep-only with groups = %q, want %q", got, want)
+	}
+	// Once a group is named, unnamed ones are left out.
+	if got, want := run(`(?P<user>\w+)@(\w+)\.com`, true), `{"user":"bob"}`+"\n"+`{"user":"amy"}`+"\n"; got != want {
+		t.Errorf("--grep-only with named groups = %q, want %q", got, want)
+	}
+
+	if _, err := grepProcessor(`(`, false); err == nil {
+		t.Errorf("grepProcessor accepted an invalid pattern")
+	}
+}
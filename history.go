@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// historyEntry is one fetch in the history log.
+type historyEntry struct {
+	// ID is the entry's line number in the log, counting from 1. It isn't
+	// stored, so runs appending at the same time can't collide.
+	ID     int       `json:"id,omitempty"`
+	Time   time.Time `json:"time"`
+	URL    string    `json:"url"`
+	Status int       `json:"status,omitempty"`
+	Error  string    `json:"error,omitempty"`
+	// Profile is the browser profile used and CookieJar the cookie jar's
+	// path, empty with --no-cookies.
+	Profile   string `json:"profile,omitempty"`
+	CookieJar string `json:"cookie_jar,omitempty"`
+	// SHA256 is the hex SHA-256 of the response body.
+	SHA256 string `json:"sha256,omitempty"`
+	// Dir and Args are the working directory and the arguments of the
+	// run that made the fetch, to replay it.
+	Dir  string   `json:"dir"`
+	Args []string `json:"args"`
+}
+
+// invocationArgs holds the arguments of the running --next segment, for
+// the history log.
+var invocationArgs []string
+
+// redactedValue stands in the history log for the value of a secret flag.
+const redactedValue = "REDACTED"
+
+// secretFlags are the flags whose values are credentials, by long name,
+// with their shorthand.
+var secretFlags = map[string]string{
+	"user":        "u",
+	"captcha-key": "",
+	"cookie":      "",
+}
+
+// redactArgs returns args with the values of secretFlags replaced by
+// redactedValue. A --cookie @file keeps its value: it names a file, not a
+// cookie.
+func redactArgs(args []string) []string {
+	out := slices.Clone(args)
+	redact := func(name, value string) string {
+		if name == "cookie" && strings.HasPrefix(value, "@") {
+			return value
+		}
+		return redactedValue
+	}
+	for i := 0; i < len(out); i++ {
+		a := out[i]
+		if a == "--" {
+			break
+		}
+		if name, value, ok := strings.Cut(strings.TrimPrefix(a, "--"), "="); strings.HasPrefix(a, "--") {
+			if _, secret := secretFlags[name]; !secret {
+				continue
+			}
+			if ok {
+				out[i] = "--" + name + "=" + redact(name, value)
+			} else if i+1 < len(out) {
+				i++
+				out[i] = redact(name, out[i])
+			}
+			continue
+		}
+		for name, short := range secretFlags {
+			switch {
+			case short == "" || !strings.HasPrefix(a, "-"+short):
+			case a == "-"+short && i+1 < len(out):
+				i++
+				out[i] = redact(name, out[i])
+			case a != "-"+short:
+				out[i] = "-" + short + redact(name, strings.TrimPrefix(a[2:], "="))
+			}
+		}
+	}
+	return out
+}
+
+// unredactArgs drops the secret flags redactArgs blanked out from args,
+// returning the remaining arguments and the dropped flags' names.
+func unredactArgs(args []string) (kept, dropped []string) {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case i+1 < len(args) && args[i+1] == redactedValue && strings.HasPrefix(a, "-"):
+			dropped = append(dropped, a)
+			i++
+		case strings.HasSuffix(a, redactedValue) && strings.HasPrefix(a, "-"):
+			dropped = append(dropped, strings.TrimSuffix(strings.TrimSuffix(a, redactedValue), "="))
+		default:
+			kept = append(kept, a)
+		}
+	}
+	return kept, dropped
+}
+
+// defaultHistoryPath returns the default path for the history log:
+// ~/.ghostfetch/history.jsonl
+func defaultHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return filepath.Join(home, ".ghostfetch", "history.jsonl")
+}
+
+// newHistoryEntry describes a fetch result for the history log.
+func newHistoryEntry(r *fetchResult) historyEntry {
+	e := historyEntry{
+		Time:      r.FetchedAt,
+		URL:       r.URL,
+		Status:    r.StatusCode,
+		Profile:   r.Profile,
+		CookieJar: r.CookieJar,
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if r.Error != nil {
+		e.Error = r.Error.Error()
+	}
+	if len(r.Body) > 0 {
+		e.SHA256 = bodySHA256(r.Body)
+	}
+	return e
+}
+
+// recordHistory appends entries to the history log, unless --no-history
+// is set. The values of secret flags are redacted from the command line,
+// and the log is only readable by the user. Failures are only reported with -v: a
+// fetch never fails over its history.
+func recordHistory(entries ...historyEntry) {
+	if flagNoHistory || len(entries) == 0 {
+		return
+	}
+	dir, _ := os.Getwd()
+	args := redactArgs(invocationArgs)
+	var buf bytes.Buffer
+	for _, e := range entries {
+		e.ID, e.Dir, e.Args = 0, dir, args
+		b, err := json.Marshal(e)
+		if err != nil {
+			continue
+		}
+		buf.Write(b)
+		buf.WriteByte('\n')
+	}
+	err := appendHistory(defaultHistoryPath(), buf.Bytes())
+	if err != nil && flagVerbose {
+		fmt.Fprintf(os.Stderr, "[*] Warning: failed to record history: %v\n", err)
+	}
+}
+
+// appendHistory appends data to the log at path in a single write, so
+// lines from concurrent runs don't interleave.
+func appendHistory(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// loadHistory reads the history log, numbering the entries. A missing log
+// is an empty history; unreadable lines are skipped but keep their ID.
+func loadHistory() ([]historyEntry, error) {
+	f, err := os.Open(defaultHistoryPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for id := 1; sc.Scan(); id++ {
+		var e historyEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			continue
+		}
+		e.ID = id
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return entries, nil
+}
+
+// findHistoryEntry returns the entry with the given ID.
+func findHistoryEntry(id string) (historyEntry, error) {
+	n, err := strconv.Atoi(id)
+	if err != nil || n < 1 {
+		return historyEntry{}, fmt.Errorf("invalid history ID %q", id)
+	}
+	entries, err := loadHistory()
+	if err != nil {
+		return historyEntry{}, err
+	}
+	for _, e := range entries {
+		if e.ID == n {
+			return e, nil
+		}
+	}
+	return historyEntry{}, fmt.Errorf("no history entry %d", n)
+}
+
+// runHistoryList prints the last limit entries of the history (all if
+// limit is 0), oldest first, as a table or JSON.
+func runHistoryList(limit int) error {
+	entries, err := loadHistory()
+	if err != nil {
+		return err
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+
+	if flagJSONOutput {
+		if entries == nil {
+			entries = []historyEntry{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tTIME\tSTATUS\tURL")
+	for _, e := range entries {
+		status := strconv.Itoa(e.Status)
+		if e.Error != "" {
+			status = "error"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", e.ID, e.Time.Local().Format("2006-01-02 15:04:05"), status, e.URL)
+	}
+	return tw.Flush()
+}
+
+// runHistoryShow prints one history entry as JSON.
+func runHistoryShow(id string) error {
+	e, err := findHistoryEntry(id)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(e)
+}
+
+// replayHistory runs the command line of e again, in its working
+// directory, as a fresh invocation: the config file and environment are
+// read anew, everything else is as given then. Secret flags weren't
+// recorded, so they are left out and their environment variables or
+// files apply instead. A run that fetched several URLs fetches them all
+// again.
+func replayHistory(e historyEntry) error {
+	if e.Dir != "" {
+		if err := os.Chdir(e.Dir); err != nil {
+			return fmt.Errorf("replay: %w", err)
+		}
+	}
+	args, dropped := unredactArgs(e.Args)
+	for _, flag := range dropped {
+		fmt.Fprintf(os.Stderr, "[*] Warning: %s wasn't recorded; replaying without it\n", flag)
+	}
+	fmt.Fprintf(os.Stderr, "[*] Replaying #%d: ghostfetch %s\n", e.ID, strings.Join(args, " "))
+	invocationArgs = args
+	cmd := newRootCmd()
+	cmd.SetArgs(args)
+	return cmd.Execute()
+}
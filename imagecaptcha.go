@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// imageCaptchaMaxPage is the largest successful page an image captcha is
+// treated as a challenge on; bigger pages merely contain one (in a
+// comment or signup form, say).
+const imageCaptchaMaxPage = 20000
+
+// imageCaptcha is a form that asks for the text shown in an image.
+type imageCaptcha struct {
+	image string // image src, as written in the page
+	field string // name of the input the text goes in
+	form  *pageForm
+}
+
+// hasImageCaptcha reports whether body has a form with a captcha image.
+func hasImageCaptcha(body []byte) bool {
+	lower := bytes.ToLower(body)
+	if !bytes.Contains(lower, []byte("captcha")) || !bytes.Contains(lower, []byte("<img")) {
+		return false
+	}
+	_, ok := extractImageCaptcha(body)
+	return ok
+}
+
+// extractImageCaptcha finds the first form holding an image whose src,
+// id, class, alt or name mentions "captcha", along with the input its
+// text goes in: one whose name or id mentions "captcha", or else the
+// form's first empty text input.
+func extractImageCaptcha(body []byte) (*imageCaptcha, bool) {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, false
+	}
+	var found *imageCaptcha
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if found != nil {
+			return
+		}
+		if n.Type == html.ElementNode && n.Data == "form" {
+			found = imageCaptchaForm(n)
+			return
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return found, found != nil
+}
+
+func imageCaptchaForm(form *html.Node) *imageCaptcha {
+	var image, field, firstEmpty string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "img":
+				if image == "" && mentionsCaptcha(n, "src", "id", "class", "alt", "name") {
+					image = getAttr(n, "src")
+				}
+			case "input":
+				name := getAttr(n, "name")
+				if name == "" {
+					break
+				}
+				switch strings.ToLower(getAttr(n, "type")) {
+				case "", "text", "tel", "number":
+					if field == "" && mentionsCaptcha(n, "name", "id") {
+						field = name
+					} else if firstEmpty == "" && getAttr(n, "value") == "" {
+						firstEmpty = name
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(form)
+	if field == "" {
+		field = firstEmpty
+	}
+	if image == "" || field == "" {
+		return nil
+	}
+	return &imageCaptcha{image: image, field: field, form: newPageForm(form)}
+}
+
+// mentionsCaptcha reports whether one of n's attrs contains "captcha".
+func mentionsCaptcha(n *html.Node, attrs ...string) bool {
+	for _, a := range attrs {
+		if strings.Contains(strings.ToLower(getAttr(n, a)), "captcha") {
+			return true
+		}
+	}
+	return false
+}
+
+// solveImageCaptcha downloads the page's captcha image, reads its text
+// with read, and submits the form with the answer, as a person would. It
+// returns the cookies the image and the submission set. The form is only
+// submitted to the page's own origin.
+func solveImageCaptcha(ctx context.Context, tr http.RoundTripper, profile BrowserProfile, pageURL string, body []byte, cookies []*http.Cookie, headers [][2]string, read func(context.Context, []byte) (string, error), verbose bool) ([]*http.Cookie, error) {
+	captcha, ok := extractImageCaptcha(body)
+	if !ok {
+		return nil, fmt.Errorf("no image captcha form")
+	}
+	page, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+
+	image, earned, err := fetchCaptchaImage(ctx, tr, profile, page, captcha.image, cookies)
+	if err != nil {
+		return nil, err
+	}
+	answer, err := read(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return nil, fmt.Errorf("no text read from the captcha image")
+	}
+	if verbose {
+		fmt.Fprintf(os.Stderr, "[*] Read image captcha as %q\n", answer)
+	}
+
+	captcha.form.values.Set(captcha.field, answer)
+	submitted, err := captcha.form.submit(ctx, tr, profile, pageURL, mergeCookies(cookies, earned), headers, verbose)
+	if err != nil {
+		return nil, fmt.Errorf("captcha form: %w", err)
+	}
+	return mergeCookies(earned, submitted), nil
+}
+
+// fetchCaptchaImage returns the image src points to, either inline (a
+// data: URL) or fetched as the page's subresource, with the cookies the
+// image response set: captcha answers are often tied to them.
+func fetchCaptchaImage(ctx context.Context, tr http.RoundTripper, profile BrowserProfile, page *url.URL, src string, cookies []*http.Cookie) ([]byte, []*http.Cookie, error) {
+	if rest, ok := strings.CutPrefix(src, "data:"); ok {
+		meta, data, ok := strings.Cut(rest, ",")
+		if !ok || !strings.HasSuffix(meta, ";base64") {
+			return nil, nil, fmt.Errorf("unsupported captcha image data URL")
+		}
+		image, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("decode captcha image: %w", err)
+		}
+		return image, nil, nil
+	}
+	ref, err := url.Parse(src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad captcha image URL %q: %w", src, err)
+	}
+	u := page.ResolveReference(ref)
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, nil, fmt.Errorf("unsupported captcha image URL %q", src)
+	}
+	site := "same-origin"
+	if u.Host != page.Host {
+		site = "cross-site"
+	}
+	resp, image, err := doFetch(ctx, tr, profile, "GET", u.String(), [][2]string{
+		{"Accept", "image/avif,image/webp,image/apng,image/*,*/*;q=0.8"},
+		{"Referer", page.String()},
+		{"Sec-Fetch-Dest", "image"},
+		{"Sec-Fetch-Mode", "no-cors"},
+		{"Sec-Fetch-Site", site},
+	}, cookies)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fetch captcha image: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("fetch captcha image: HTTP %d", resp.StatusCode)
+	}
+	return image, redirectCookies(resp), nil
+}
+
+// tesseractReader returns a reader for solveImageCaptcha that runs the
+// tesseract OCR binary at path on the image, treating it as one line of
+// text.
+func tesseractReader(path string) func(context.Context, []byte) (string, error) {
+	return func(ctx context.Context, image []byte) (string, error) {
+		cmd := exec.CommandContext(ctx, path, "stdin", "stdout", "--psm", "7")
+		cmd.Stdin = bytes.NewReader(image)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("tesseract: %w: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		// Captcha text has no spaces; OCR sometimes splits characters.
+		return strings.Join(strings.Fields(string(out)), ""), nil
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+// pageImage is an image referenced by a page.
+type pageImage struct {
+	URL string `json:"url"`
+	Alt string `json:"alt,omitempty"`
+	// Source says where the page references it: "img", "srcset",
+	// "og:image" or "twitter:image".
+	Source string `json:"source"`
+}
+
+// extractImages returns the images of an HTML page in document order:
+// <img> and <picture> sources including every srcset candidate and the
+// data-src/data-srcset attributes of lazy loaders, plus og:image and
+// twitter:image. URLs are resolved against the page's <base href> or
+// pageURL and deduplicated; data: URIs are skipped.
+func extractImages(body []byte, pageURL string) []pageImage {
+	doc, err := html.Parse(strings.NewReader(string(body)))
+	if err != nil {
+		return nil
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+	if bases := filterNodes(doc, func(n *html.Node) bool { return n.Data == "base" && getAttr(n, "href") != "" }); len(bases) > 0 {
+		if u, err := base.Parse(getAttr(bases[0], "href")); err == nil {
+			base = u
+		}
+	}
+
+	seen := make(map[string]bool)
+	var images []pageImage
+	add := func(ref, alt, source string) {
+		u, ok := resolveRef(base, ref)
+		if !ok {
+			return
+		}
+		if s := u.String(); !seen[s] {
+			seen[s] = true
+			images = append(images, pageImage{URL: s, Alt: alt, Source: source})
+		}
+	}
+	srcset := func(val, alt string) {
+		for _, c := range strings.Split(val, ",") {
+			if f := strings.Fields(c); len(f) > 0 {
+				add(f[0], alt, "srcset")
+			}
+		}
+	}
+
+	for _, n := range filterNodes(doc, func(n *html.Node) bool {
+		return n.Data == "img" || n.Data == "source" || n.Data == "meta"
+	}) {
+		switch n.Data {
+		case "img":
+			alt := strings.TrimSpace(getAttr(n, "alt"))
+			add(getAttr(n, "src"), alt, "img")
+			add(getAttr(n, "data-src"), alt, "img")
+			srcset(getAttr(n, "srcset"), alt)
+			srcset(getAttr(n, "data-srcset"), alt)
+		case "source":
+			// <source> in <video>/<audio> is media, not an image.
+			if n.Parent == nil || n.Parent.Data == "picture" {
+				srcset(getAttr(n, "srcset"), "")
+				srcset(getAttr(n, "data-srcset"), "")
+			}
+		case "meta":
+			switch name := strings.ToLower(getAttr(n, "property") + getAttr(n, "name")); name {
+			case "og:image", "og:image:url", "og:image:secure_url":
+				add(getAttr(n, "content"), "", "og:image")
+			case "twitter:image", "twitter:image:src":
+				add(getAttr(n, "content"), "", "twitter:image")
+			}
+		}
+	}
+	return images
+}
+
+// formatImages renders images as a markdown list of image links.
+func formatImages(images []pageImage) string {
+	var sb strings.Builder
+	for _, img := range images {
+		fmt.Fprintf(&sb, "- ![%s](%s)\n", img.Alt, img.URL)
+	}
+	return sb.String()
+}
+
+// runImages fetches a page and lists its images or, with dir, downloads
+// them there in parallel.
+func runImages(rawURL string, dir string, minSize string) error {
+	var minBytes int64
+	if minSize != "" {
+		if dir == "" {
+			return fmt.Errorf("--min-size applies to --download")
+		}
+		var err error
+		if minBytes, err = parseByteSize(minSize); err != nil {
+			return fmt.Errorf("--min-size: %w", err)
+		}
+	}
+
+	res, err := fetchOne(newFetchOptions(rawURL))
+	if err != nil {
+		return err
+	}
+	if in := res.processInput(); in.notHTML() {
+		return fmt.Errorf("%s is not an HTML page (%s)", res.URL, in.contentType.Effective)
+	}
+	pageURL := res.finalURL()
+	images := extractImages(res.Body, pageURL)
+
+	if dir != "" {
+		return downloadImages(images, pageURL, dir, minBytes)
+	}
+	if flagJSONOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(images)
+	}
+	fmt.Fprint(os.Stdout, formatImages(images))
+	return nil
+}
+
+// downloadImages fetches images through the fetch pipeline, at most
+// flagMaxParallel at a time, each as requested by the page at referer,
+// and saves those of at least minBytes bytes under dir by URL path, printing
+// the written paths and recording every image in dir's index.json.
+func downloadImages(images []pageImage, referer, dir string, minBytes int64) error {
+	maxPar := flagMaxParallel
+	if maxPar <= 0 {
+		maxPar = 5
+	}
+
+	results := make([]fetchResult, len(images))
+	sem := make(chan struct{}, maxPar)
+	var wg sync.WaitGroup
+	for i, img := range images {
+		wg.Add(1)
+		go func(idx int, imageURL string) {
+			defer wg.Done()
+			sem <- struct{}{}        // acquire semaphore slot
+			defer func() { <-sem }() // release semaphore slot
+
+			fo := newFetchOptions(imageURL)
+			fo.referer = referer
+			fo.accept = assetAccept["image"]
+			res, err := fetchOne(fo)
+			switch {
+			case err != nil:
+				results[idx] = fetchResult{URL: imageURL, Error: err}
+			case res.StatusCode >= 400:
+				results[idx] = fetchResult{URL: imageURL, StatusCode: res.StatusCode, Error: fmt.Errorf("HTTP %d", res.StatusCode)}
+			default:
+				if int64(len(res.Body)) < minBytes {
+					res.Skipped = fmt.Sprintf("%d bytes, below --min-size", len(res.Body))
+				}
+				results[idx] = *res
+			}
+		}(i, img.URL)
+	}
+	wg.Wait()
+
+	manifest := make([]manifestEntry, 0, len(results))
+	failed := 0
+	for i := range results {
+		r := &results[i]
+		entry := manifestEntry{URL: r.URL, Status: r.StatusCode}
+		switch {
+		case r.Error != nil:
+			fmt.Fprintf(os.Stderr, "[!] %s: %v\n", r.URL, r.Error)
+			entry.Error = r.Error.Error()
+			failed++
+		case r.Skipped != "":
+			fmt.Fprintf(os.Stderr, "[-] skipped %s: %s\n", r.URL, r.Skipped)
+			entry.Skipped = r.Skipped
+		default:
+			rel := outputRelPath(r.URL, assetExt(r.URL, r.Headers.Get("Content-Type")))
+			full := filepath.Join(dir, rel)
+			if err := writeMirrorFile(full, r.Body); err != nil {
+				return fmt.Errorf("write %s: %w", r.URL, err)
+			}
+			fmt.Fprintln(os.Stdout, full)
+			entry.File = filepath.ToSlash(rel)
+		}
+		manifest = append(manifest, entry)
+	}
+	if err := writeManifest(dir, manifest); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d images failed", failed, len(results))
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// incapsulaScriptRe matches the interstitial's external challenge script.
+var incapsulaScriptRe = regexp.MustCompile(`(?i)<script[^>]+src\s*=\s*["']([^"']*_Incapsula_Resource[^"']*)["']`)
+
+// isIncapsula reports whether a response comes from an Imperva Incapsula
+// protected site: its visid_incap_/incap_ses_ cookies or resource scripts.
+func isIncapsula(resp *http.Response, body []byte) bool {
+	for _, c := range resp.Cookies() {
+		if strings.HasPrefix(c.Name, "visid_incap_") || strings.HasPrefix(c.Name, "incap_ses_") {
+			return true
+		}
+	}
+	return containsAny(body, [][]byte{
+		[]byte("_Incapsula_Resource"),
+		[]byte("Incapsula incident ID"),
+	})
+}
+
+// isIncapsulaInterstitial tells the challenge page apart from ordinary
+// pages of a protected site, which also load _Incapsula_Resource scripts:
+// the interstitial is tiny or an error status, or carries an incident ID.
+func isIncapsulaInterstitial(resp *http.Response, body []byte) bool {
+	if !isIncapsula(resp, body) {
+		return false
+	}
+	if containsAny(body, [][]byte{[]byte("Incapsula incident ID")}) {
+		return true
+	}
+	return containsAny(body, [][]byte{[]byte("_Incapsula_Resource")}) &&
+		(len(body) < 20000 || resp.StatusCode >= 400)
+}
+
+func init() {
+	registerSolver(incapsulaSolver{})
+}
+
+// incapsulaSolver solves an Incapsula interstitial: it runs the page's
+// scripts for the session cookies, then the page is reloaded as the
+// interstitial itself would.
+type incapsulaSolver struct{}
+
+func (incapsulaSolver) Name() string { return ChallengeIncapsula.String() }
+func (incapsulaSolver) runsScripts() {}
+
+func (incapsulaSolver) Detect(resp *http.Response, body []byte) bool {
+	return detectChallenge(resp, body) == ChallengeIncapsula
+}
+
+func (incapsulaSolver) Solve(ctx context.Context, f *Fetcher, resp *http.Response, body []byte) (*Solution, error) {
+	solved, err := solveIncapsula(ctx, f, resp.Request.URL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	return &Solution{Cookies: solved}, nil
+}
+
+// solveIncapsula runs the interstitial's inline script and its
+// _Incapsula_Resource scripts in the JS sandbox, with the session cookies
+// the interstitial just set visible through document.cookie, and returns
+// those cookies plus the ones the scripts set.
+func solveIncapsula(ctx context.Context, f *Fetcher, pageURL string, body []byte) ([]*http.Cookie, error) {
+	scripts := []string{extractScriptContent(body)}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, err
+	}
+	// Subresource requests, not navigations.
+	scriptHeaders := [][2]string{
+		{"Accept", "*/*"},
+		{"Referer", pageURL},
+		{"Sec-Fetch-Dest", "script"},
+		{"Sec-Fetch-Mode", "no-cors"},
+		{"Sec-Fetch-Site", "same-origin"},
+	}
+	for _, m := range incapsulaScriptRe.FindAllSubmatch(body, -1) {
+		ref, err := url.Parse(strings.ReplaceAll(string(m[1]), "&amp;", "&"))
+		if err != nil {
+			continue
+		}
+		_, sbody, err := f.Fetch(ctx, "GET", base.ResolveReference(ref).String(), scriptHeaders, "")
+		if err != nil {
+			return nil, fmt.Errorf("fetch Incapsula script: %w", err)
+		}
+		scripts = append(scripts, string(sbody))
+	}
+
+	solver := newJSSolver(pageURL, body)
+	solver.cookies = f.Cookies()
+	result, err := solver.Solve(ctx, strings.Join(scripts, "\n;\n"))
+	if err != nil {
+		return nil, err
+	}
+	return mergeCookies(f.Cookies(), result.Cookies), nil
+}
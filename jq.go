@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/itchyny/gojq"
+)
+
+// errNotJSON makes the --jq stage skip bodies of another type.
+var errNotJSON = errors.New("content is not JSON")
+
+// jqProcessor compiles a jq filter into a pipeline stage. The stage runs
+// the filter over each JSON value in the body (one, or several for JSON
+// Lines) and outputs every result on its own line, pretty-printed like
+// jq does, or with raw strings printed without quotes like jq -r.
+func jqProcessor(expr string, raw bool) (processorFunc, error) {
+	query, err := gojq.Parse(expr)
+	if err != nil {
+		return nil, fmt.Errorf("--jq: %w", err)
+	}
+	code, err := gojq.Compile(query)
+	if err != nil {
+		return nil, fmt.Errorf("--jq: %w", err)
+	}
+	return func(content string, in processInput) (string, error) {
+		var out string
+		var err error
+		// JSON Lines often comes as text/plain or octet-stream, so only
+		// pages are turned away before decoding.
+		if t := in.contentType.Effective; isHTMLType(t) {
+			err = fmt.Errorf("%w (%s)", errNotJSON, t)
+		} else {
+			out, err = runJQ(code, content, raw)
+		}
+		if err != nil {
+			// The pipeline passes the body through on errors; say why.
+			fmt.Fprintf(os.Stderr, "[*] Warning: --jq: %v\n", err)
+		}
+		return out, err
+	}, nil
+}
+
+// runJQ runs code over every JSON value in body.
+func runJQ(code *gojq.Code, body string, raw bool) (string, error) {
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.UseNumber() // keep large IDs exact
+	var sb strings.Builder
+	for {
+		var v any
+		if err := dec.Decode(&v); err == io.EOF {
+			break
+		} else if err != nil {
+			return "", fmt.Errorf("%w: %v", errNotJSON, err)
+		}
+		iter := code.Run(v)
+		for {
+			result, ok := iter.Next()
+			if !ok {
+				break
+			}
+			if err, ok := result.(error); ok {
+				var halt *gojq.HaltError
+				if errors.As(err, &halt) && halt.Value() == nil {
+					break
+				}
+				return "", err
+			}
+			if s, ok := result.(string); ok && raw {
+				sb.WriteString(s)
+				sb.WriteByte('\n')
+				continue
+			}
+			b, _ := gojq.Marshal(result)
+			var indented bytes.Buffer
+			if json.Indent(&indented, b, "", "  ") == nil {
+				b = indented.Bytes()
+			}
+			sb.Write(b)
+			sb.WriteByte('\n')
+		}
+	}
+	return sb.String(), nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"strings"
+
+	"github.com/dop251/goja"
+)
+
+// jsHashes maps Web Crypto hash names to Go implementations.
+var jsHashes = map[string]func() hash.Hash{
+	"SHA-1":   sha1.New,
+	"SHA-256": sha256.New,
+	"SHA-384": sha512.New384,
+	"SHA-512": sha512.New,
+}
+
+// setupCrypto registers crypto.getRandomValues, crypto.randomUUID and the
+// subset of crypto.subtle that proof-of-work challenges use (digest, and
+// importKey/sign for HMAC), backed by Go's crypto packages, along with
+// the TextEncoder/TextDecoder they feed data through.
+func setupCrypto(vm *goja.Runtime) {
+	hashFor := func(name string) func() hash.Hash {
+		h, ok := jsHashes[strings.ToUpper(name)]
+		if !ok {
+			panic(vm.NewTypeError("unsupported hash algorithm: " + name))
+		}
+		return h
+	}
+	bytesOf := func(v goja.Value) []byte {
+		if buf, ok := v.Export().(goja.ArrayBuffer); ok {
+			return buf.Bytes()
+		}
+		panic(vm.NewTypeError("expected an ArrayBuffer"))
+	}
+
+	vm.Set("__randomBytes", func(call goja.FunctionCall) goja.Value {
+		b := make([]byte, call.Argument(0).ToInteger())
+		rand.Read(b)
+		return vm.ToValue(vm.NewArrayBuffer(b))
+	})
+	vm.Set("__digest", func(call goja.FunctionCall) goja.Value {
+		h := hashFor(call.Argument(0).String())()
+		h.Write(bytesOf(call.Argument(1)))
+		return vm.ToValue(vm.NewArrayBuffer(h.Sum(nil)))
+	})
+	vm.Set("__hmac", func(call goja.FunctionCall) goja.Value {
+		mac := hmac.New(hashFor(call.Argument(0).String()), bytesOf(call.Argument(1)))
+		mac.Write(bytesOf(call.Argument(2)))
+		return vm.ToValue(vm.NewArrayBuffer(mac.Sum(nil)))
+	})
+	vm.Set("__utf8Encode", func(call goja.FunctionCall) goja.Value {
+		return vm.ToValue(vm.NewArrayBuffer([]byte(call.Argument(0).String())))
+	})
+	vm.Set("__utf8Decode", func(call goja.FunctionCall) goja.Value {
+		return vm.ToValue(string(bytesOf(call.Argument(0))))
+	})
+
+	vm.RunString(`
+		(function() {
+			// __buffer copies an ArrayBuffer, typed array or DataView into a
+			// fresh ArrayBuffer.
+			function __buffer(data) {
+				if (data instanceof ArrayBuffer) return data.slice(0);
+				if (data && data.buffer instanceof ArrayBuffer)
+					return data.buffer.slice(data.byteOffset, data.byteOffset + data.byteLength);
+				throw new TypeError("expected an ArrayBuffer or ArrayBufferView");
+			}
+			function algName(alg) { return typeof alg === "string" ? alg : alg && alg.name; }
+			function async(fn) {
+				return new Promise(function(resolve) { resolve(fn()); });
+			}
+
+			var subtle = {
+				digest: function(alg, data) {
+					return async(function() { return __digest(algName(alg), __buffer(data)); });
+				},
+				importKey: function(format, keyData, alg, extractable, usages) {
+					return async(function() {
+						if (format !== "raw") throw new TypeError("unsupported key format: " + format);
+						if (String(algName(alg)).toUpperCase() !== "HMAC") throw new TypeError("unsupported key algorithm: " + algName(alg));
+						return {
+							type: "secret",
+							extractable: !!extractable,
+							usages: usages || [],
+							algorithm: { name: "HMAC", hash: { name: algName(alg.hash) } },
+							_raw: __buffer(keyData)
+						};
+					});
+				},
+				sign: function(alg, key, data) {
+					return async(function() {
+						if (String(algName(alg)).toUpperCase() !== "HMAC") throw new TypeError("unsupported sign algorithm: " + algName(alg));
+						return __hmac(key.algorithm.hash.name, key._raw, __buffer(data));
+					});
+				}
+			};
+
+			crypto = {
+				subtle: subtle,
+				getRandomValues: function(arr) {
+					var b = new Uint8Array(__randomBytes(arr.byteLength));
+					new Uint8Array(arr.buffer, arr.byteOffset, arr.byteLength).set(b);
+					return arr;
+				},
+				randomUUID: function() {
+					var b = new Uint8Array(__randomBytes(16));
+					b[6] = (b[6] & 0x0f) | 0x40;
+					b[8] = (b[8] & 0x3f) | 0x80;
+					var h = Array.prototype.map.call(b, function(x) { return (x + 0x100).toString(16).slice(1); }).join("");
+					return h.slice(0, 8) + "-" + h.slice(8, 12) + "-" + h.slice(12, 16) + "-" + h.slice(16, 20) + "-" + h.slice(20);
+				}
+			};
+
+			TextEncoder = function() { this.encoding = "utf-8"; };
+			TextEncoder.prototype.encode = function(s) {
+				return new Uint8Array(__utf8Encode(s === undefined ? "" : String(s)));
+			};
+			TextDecoder = function() { this.encoding = "utf-8"; };
+			TextDecoder.prototype.decode = function(data) {
+				return data === undefined ? "" : __utf8Decode(__buffer(data));
+			};
+		})();
+	`)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// kagiSession returns the Kagi session cookie for searches, from
+// GHOSTFETCH_KAGI_TOKEN. The token may be given bare or as the session
+// link Kagi shows under Settings (https://kagi.com/search?token=...).
+func kagiSession() ([]*http.Cookie, error) {
+	token := strings.TrimSpace(os.Getenv("GHOSTFETCH_KAGI_TOKEN"))
+	if token == "" {
+		return nil, fmt.Errorf("kagi needs a session token: set GHOSTFETCH_KAGI_TOKEN to your session link or its token")
+	}
+	if strings.Contains(token, "://") {
+		u, err := url.Parse(token)
+		if err != nil || u.Query().Get("token") == "" {
+			return nil, fmt.Errorf("GHOSTFETCH_KAGI_TOKEN is a URL without a token parameter")
+		}
+		token = u.Query().Get("token")
+	}
+	return []*http.Cookie{{Name: "kagi_session", Value: token}}, nil
+}
+
+// parseKagiResults parses Kagi search result HTML and extracts results,
+// both top-level results and those grouped under a site.
+func parseKagiResults(body []byte) []searchResult {
+	doc, err := html.Parse(strings.NewReader(string(body)))
+	if err != nil {
+		return nil
+	}
+
+	var results []searchResult
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "div" && (hasClass(n, "search-result") || hasClass(n, "__srgi")) {
+			if r, ok := extractKagiResult(n); ok {
+				results = append(results, r)
+			}
+			// Keep walking: grouped results sit inside their site's.
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	return results
+}
+
+// extractKagiResult extracts a single search result from a <div class="search-result">
+// or grouped <div class="__srgi"> block. The title link is <a class="__sri_title_link">
+// (or the grouped result's <a class="__srgi-title">), and the description lives in
+// <div class="__sri-desc">. A result's grouped children are left for their own pass.
+func extractKagiResult(n *html.Node) (searchResult, bool) {
+	var r searchResult
+
+	var walk func(*html.Node)
+	walk = func(node *html.Node) {
+		if node.Type == html.ElementNode {
+			if node != n && (hasClass(node, "__srgi") || hasClass(node, "sri-group")) {
+				return
+			}
+			if node.Data == "a" && r.URL == "" && (hasClass(node, "__sri_title_link") || hasClass(node, "__srgi-title")) {
+				if href := getAttr(node, "href"); strings.HasPrefix(href, "http") {
+					r.URL = href
+					r.Title = strings.Join(strings.Fields(textContent(node)), " ")
+				}
+			}
+			if (hasClass(node, "__sri-desc") || hasClass(node, "__srgi-desc")) && r.Snippet == "" {
+				r.Snippet = strings.Join(strings.Fields(textContent(node)), " ")
+			}
+		}
+		for c := node.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+
+	if r.URL == "" {
+		return r, false
+	}
+	return r, true
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// htmlLangRe matches the lang attribute on the <html> element.
+var htmlLangRe = regexp.MustCompile(`(?is)<html[^>]*\slang\s*=\s*["']?([A-Za-z]{2,3})`)
+
+// stopwords holds very common short words per language. Counting them in
+// the page text is a cheap but reliable detector for Latin-script pages
+// that don't declare their language.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "for", "with", "this"},
+	"de": {"der", "die", "und", "das", "ist", "nicht", "mit", "ein", "auf", "für"},
+	"fr": {"le", "la", "les", "et", "des", "est", "une", "pour", "dans", "pas"},
+	"es": {"el", "la", "los", "y", "que", "del", "las", "por", "una", "para"},
+	"it": {"il", "che", "di", "e", "la", "per", "non", "una", "sono", "della"},
+	"pt": {"o", "que", "de", "não", "uma", "para", "com", "os", "do", "da"},
+	"nl": {"de", "het", "een", "en", "van", "is", "niet", "dat", "op", "voor"},
+}
+
+// detectLanguage returns the primary language subtag of a page ("en",
+// "de", ...), or empty string if it cannot tell. The declared <html lang>
+// wins, then Content-Language, then script and stopword detection on the
+// visible text.
+func detectLanguage(h http.Header, body []byte) string {
+	if m := htmlLangRe.FindSubmatch(body); m != nil {
+		return strings.ToLower(string(m[1]))
+	}
+	if cl := h.Get("Content-Language"); cl != "" {
+		first, _, _ := strings.Cut(cl, ",")
+		primary, _, _ := strings.Cut(strings.TrimSpace(first), "-")
+		if primary != "" {
+			return strings.ToLower(primary)
+		}
+	}
+
+	text := string(body)
+	if md, err := htmlToMarkdown(text, "", true); err == nil {
+		text = md
+	}
+	if lang := detectScript(text); lang != "" {
+		return lang
+	}
+	return detectByStopwords(text)
+}
+
+// detectScript identifies languages written in a distinctive script.
+func detectScript(text string) string {
+	counts := map[string]int{}
+	letters := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Hiragana, r) || unicode.Is(unicode.Katakana, r):
+			counts["ja"]++
+		case unicode.Is(unicode.Hangul, r):
+			counts["ko"]++
+		case unicode.Is(unicode.Han, r):
+			counts["zh"]++
+		case unicode.Is(unicode.Cyrillic, r):
+			counts["ru"]++
+		case unicode.Is(unicode.Arabic, r):
+			counts["ar"]++
+		case unicode.Is(unicode.Greek, r):
+			counts["el"]++
+		case unicode.Is(unicode.Hebrew, r):
+			counts["he"]++
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	// Japanese mixes kana with Han characters; any kana means Japanese.
+	if counts["ja"] > 0 {
+		return "ja"
+	}
+	best, bestN := "", 0
+	for lang, n := range counts {
+		if n > bestN {
+			best, bestN = lang, n
+		}
+	}
+	if bestN*2 > letters {
+		return best
+	}
+	return ""
+}
+
+// detectByStopwords picks the language whose stopwords occur most often.
+func detectByStopwords(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	freq := make(map[string]int, len(words))
+	for _, w := range words {
+		freq[w]++
+	}
+	best, bestN := "", 0
+	for lang, list := range stopwords {
+		n := 0
+		for _, w := range list {
+			n += freq[w]
+		}
+		if n > bestN {
+			best, bestN = lang, n
+		}
+	}
+	// Require a handful of hits so a few stray words don't decide.
+	if bestN < 3 {
+		return ""
+	}
+	return best
+}
+
+// langAllowed reports whether lang is in the comma-separated allow list.
+// Pages whose language is unknown are allowed rather than dropped.
+func langAllowed(lang, allow string) bool {
+	if lang == "" {
+		return true
+	}
+	for _, a := range strings.Split(allow, ",") {
+		if strings.EqualFold(strings.TrimSpace(a), lang) {
+			return true
+		}
+	}
+	return false
+}
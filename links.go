@@ -95,15 +95,7 @@ func formatLinks(links []pageLink) string {
 // runLinks fetches a URL, extracts links, optionally filters them, and outputs
 // the result as markdown text or JSON.
 func runLinks(rawURL string, filterPattern string) error {
-	result, err := fetchOne(fetchOptions{
-		url:            rawURL,
-		browser:        flagBrowser,
-		timeout:        flagTimeout,
-		noCookies:      flagNoCookies,
-		verbose:        flagVerbose,
-		captchaService: flagCaptchaService,
-		captchaKey:     flagCaptchaKey,
-	})
+	result, err := fetchOne(newFetchOptions(rawURL))
 	if err != nil {
 		return err
 	}
@@ -1,34 +1,173 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 // Package-level flag variables shared across subcommands.
 var (
-	flagBrowser        string
-	flagJSONOutput     bool
-	flagFollowRedirs   bool
-	flagNoCookies      bool
-	flagTimeout        string
-	flagVerbose        bool
-	flagCaptchaService string
-	flagCaptchaKey     string
-	flagMarkdown       bool
-	flagMarkdownFull   bool
-	flagRaw            bool
-	flagMaxParallel    int
-	searchEngineName   string
-	searchMaxResults   int
-	linksFilter        string
+	flagBrowser             string
+	flagJSONOutput          bool
+	flagFollowRedirs        bool
+	flagMaxRedirs           int
+	flagRedirectPolicy      string
+	flagNoHTTPSDowngrade    bool
+	flagNoCookies           bool
+	flagNoHistory           bool
+	flagCookie              []string
+	flagTimeout             string
+	flagVerbose             bool
+	flagCaptchaService      string
+	flagCaptchaKey          string
+	flagMarkdown            bool
+	flagMarkdownFull        bool
+	flagPlain               bool
+	flagGrep                string
+	flagGrepOnly            bool
+	flagRaw                 bool
+	flagMaxParallel         int
+	flagPerHost             int
+	flagQPS                 float64
+	flagFromSearch          string
+	searchEngineName        string
+	searchVertical          string
+	searchLang              string
+	searchRegion            string
+	searchSince             string
+	searchBefore            string
+	searchSites             []string
+	searchFileType          string
+	searchInTitle           string
+	searchExcludeSites      []string
+	searchMaxResults        int
+	searchFetchResults      int
+	suggestEngine           string
+	newsEngine              string
+	wikiLang                string
+	cookiesDomain           string
+	cookiesOlderThan        string
+	linksFilter             string
+	warmPages               int
+	crawlFlags              crawlParams
+	schemaTypes             []string
+	extractEmails           bool
+	extractPhones           bool
+	extractPatterns         []string
+	extractContextChars     int
+	diffContext             int
+	diffUpdate              bool
+	historyLimit            int
+	imagesDownload          string
+	imagesMinSize           string
+	feedLimit               int
+	sitemapFetch            bool
+	sitemapLimit            int
+	warmDelay               time.Duration
+	flagProcess             []string
+	flagMaxChars            int
+	flagMaxTokens           int
+	flagMDNoImages          bool
+	flagMDLinks             string
+	flagMDTableStyle        string
+	flagMDNoCodeLang        bool
+	flagMDHeadingOffset     int
+	flagJQ                  string
+	flagJQRaw               bool
+	flagNDJSON              bool
+	flagStream              bool
+	flagConfig              string
+	flagCanonicalMap        bool
+	flagConnectTimeout      string
+	flagReadTimeout         string
+	flagHTTP10              bool
+	flagNoKeepAlive         bool
+	flagAccept              string
+	flagOutDir              string
+	flagMirror              bool
+	flagDataURLEncode       []string
+	flagNavigateFromHome    bool
+	flagStore               string
+	flagUser                string
+	flagDigest              bool
+	flagOnlyLang            string
+	flagOnlyStatus          []string
+	flagMinBodyBytes        int
+	flagBodyMatches         string
+	flagOutName             string
+	flagNetrc               bool
+	flagNetrcFile           string
+	flagVarsFile            string
+	flagManifest            string
+	flagGlobOff             bool
+	flagProfileMismatch     string
+	flagRemoteName          bool
+	flagInclude             bool
+	flagDumpHeader          string
+	flagFail                bool
+	flagFailWithBody        bool
+	flagRetryFailed         int
+	flagRetryBrowser        string
+	flagSplit               int
+	flagPreset              string
+	flagMaxBodySize         string
+	flagMaxBodyTruncate     bool
+	flagOutput              string
+	flagGzipOutput          bool
+	flagJSTimeout           string
+	flagJSMaxMemory         string
+	flagNoJS                bool
+	flagSolverPlugins       []string
+	flagBrowserFallback     bool
+	flagTesseract           string
+	flagCaptchaPollInterval string
+	flagCaptchaMaxWait      string
+	flagCaptchaSoftID       string
+	flagCaptchaInvisible    bool
+	flagCaptchaEnterprise   bool
+	flagCaptchaPingback     string
 )
 
 func main() {
+	if code := run(os.Args[1:]); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// run executes the command line args and returns the exit code. Each
+// --next segment is parsed and run as its own invocation; they share one
+// session so connections and cookies carry over.
+func run(args []string) int {
+	segments := splitNext(args)
+	if len(segments) > 1 {
+		currentSession = newSession()
+	}
+	// The first failing segment decides the exit code.
+	code := 0
+	for _, args := range segments {
+		rootCmd := newRootCmd()
+		rootCmd.SetArgs(args)
+		invocationArgs = args
+		if err := rootCmd.Execute(); err != nil && code == 0 {
+			code = exitCode(err)
+		}
+	}
+	return code
+}
+
+// newRootCmd builds the command tree. Registering the flags resets every
+// flag variable to its default, so each --next segment starts clean.
+func newRootCmd() *cobra.Command {
+	fromSearchReferer = ""
 	rootCmd := &cobra.Command{
 		Use:   "ghostfetch [flags] <query>",
 		Short: "Search the web and fetch pages with bot detection bypass",
@@ -38,17 +177,30 @@ TLS fingerprints, invisible to bot detection, no full browser needed.
 By default, running ghostfetch with a query performs a web search.
 Use subcommands (fetch, links) for other operations.`,
 		TraverseChildren: true,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			if err := loadAppConfig(); err != nil {
+				return err
+			}
+			if err := applyPreset(cmd); err != nil {
+				return err
+			}
+			return loadSolverPlugins(append(appConfig.SolverPlugins, flagSolverPlugins...))
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) == 0 {
 				return cmd.Help()
 			}
 			// If argument looks like a URL, fetch it.
 			if looksLikeURL(args[0]) {
-				return runFetch(args)
+				return silenceFailureUsage(cmd, runFetch(args))
 			}
 			// Otherwise, treat it as a search query.
 			query := strings.Join(args, " ")
-			return runSearch(query, searchEngineName, searchMaxResults)
+			p, err := newSearchParams(query)
+			if err != nil {
+				return err
+			}
+			return runSearch(searchEngineName, searchVertical, p)
 		},
 	}
 
@@ -56,28 +208,101 @@ Use subcommands (fetch, links) for other operations.`,
 	pf := rootCmd.PersistentFlags()
 	pf.StringVarP(&flagBrowser, "browser", "b", "chrome", "browser to impersonate: chrome, firefox")
 	pf.BoolVarP(&flagJSONOutput, "json", "j", false, "output JSON with body, status, headers, cookies")
-	pf.BoolVarP(&flagFollowRedirs, "follow", "L", true, "follow redirects (up to 10)")
+	pf.BoolVarP(&flagFollowRedirs, "follow", "L", true, "follow redirects (-L=false is --redirect-policy none)")
+	pf.IntVar(&flagMaxRedirs, "max-redirs", 10, "most redirects to follow; more is an error")
+	pf.StringVar(&flagRedirectPolicy, "redirect-policy", "any", "which redirects to follow: same-host, same-domain, any, none (others are returned as is)")
+	pf.BoolVar(&flagNoHTTPSDowngrade, "no-https-downgrade", false, "don't follow redirects from https to http; report them and return the redirect")
 	pf.BoolVar(&flagNoCookies, "no-cookies", false, "don't load/save cookies")
+	pf.BoolVar(&flagNoHistory, "no-history", false, "don't record fetches in ~/.ghostfetch/history.jsonl")
+	pf.StringArrayVar(&flagCookie, "cookie", nil, `send cookies with this request: "name=value; other=2", or @file with the same or a Netscape cookies.txt; repeatable`)
 	pf.StringVarP(&flagTimeout, "timeout", "t", "30s", "request timeout")
+	pf.StringVar(&flagConnectTimeout, "connect-timeout", "", "TCP connect + TLS handshake timeout per connection (e.g. 10s)")
+	pf.StringVar(&flagReadTimeout, "read-timeout", "", "max time to wait for data from the server on each read (e.g. 15s)")
+	pf.BoolVar(&flagNavigateFromHome, "navigate-from-home", false, "on a challenge for a deep URL, visit the site root first and retry as an in-site click")
+	pf.BoolVar(&flagHTTP10, "http1.0", false, "send HTTP/1.0 requests (for servers that mishandle HTTP/1.1 and h2)")
+	pf.BoolVar(&flagNoKeepAlive, "no-keepalive", false, "disable connection reuse and HTTP/2")
 	pf.BoolVarP(&flagVerbose, "verbose", "v", false, "print request/response details to stderr")
-	pf.StringVar(&flagCaptchaService, "captcha-service", "", "captcha service: 2captcha, anticaptcha")
-	pf.StringVar(&flagCaptchaKey, "captcha-key", "", "captcha service API key")
+	pf.StringVarP(&flagUser, "user", "u", "", "HTTP auth credentials user:password (or set GHOSTFETCH_USER)")
+	pf.BoolVar(&flagNetrc, "netrc", false, "read per-host credentials from ~/.netrc")
+	pf.StringVar(&flagNetrcFile, "netrc-file", "", "read per-host credentials from this netrc file")
+	pf.BoolVar(&flagDigest, "digest", false, "use HTTP Digest authentication (RFC 7616) instead of Basic")
+	pf.StringVar(&flagMaxBodySize, "max-body-size", "", "abort when a response body exceeds this size (e.g. 10MB)")
+	pf.BoolVar(&flagMaxBodyTruncate, "max-body-truncate", false, "with --max-body-size, keep the first part of a larger body and mark it truncated instead of failing")
+	pf.StringVar(&flagJSTimeout, "js-timeout", "10s", "max time the JS challenge solver may run per script, timers included")
+	pf.StringVar(&flagJSMaxMemory, "js-max-memory", "256MB", `stop the JS challenge solver once the heap grows by this much (e.g. 64MB; "0" for no limit)`)
+	pf.BoolVar(&flagNoJS, "no-js", false, "don't run challenge scripts; JS challenges are reported instead of solved")
+	pf.BoolVar(&flagBrowserFallback, "browser-fallback", false, "when a challenge can't be solved otherwise, pass it in a local headless Chrome and reuse its cookies")
+	pf.StringArrayVar(&flagSolverPlugins, "solver-plugin", nil, "challenge solver plugin executable speaking JSON over stdio, repeatable")
+	pf.StringVar(&flagProfileMismatch, "profile-mismatch", "warn", `when a clearance cookie was obtained with another browser profile: "warn" or "switch" to that profile`)
+	pf.StringVar(&flagCaptchaService, "captcha-service", "", "captcha service: 2captcha, anticaptcha, nopecha; a comma-separated list fails over in order")
+	pf.StringVar(&flagCaptchaKey, "captcha-key", "", "captcha service API key; comma-separated, one per service")
+	pf.StringVar(&flagCaptchaPollInterval, "captcha-poll-interval", "", "how often to poll the captcha service for an answer (default 2s)")
+	pf.StringVar(&flagCaptchaMaxWait, "captcha-max-wait", "", "how long each captcha service may take to answer (default 2m)")
+	pf.StringVar(&flagCaptchaSoftID, "captcha-soft-id", "", "2captcha soft_id to send with tasks")
+	pf.BoolVar(&flagCaptchaInvisible, "captcha-invisible", false, "tell 2captcha the reCAPTCHA is invisible")
+	pf.BoolVar(&flagCaptchaEnterprise, "captcha-enterprise", false, "tell 2captcha the captcha is an Enterprise one")
+	pf.StringVar(&flagCaptchaPingback, "captcha-pingback", "", "URL 2captcha notifies when a task is solved")
+	pf.StringVar(&flagTesseract, "tesseract", "", "read image captchas locally with this tesseract binary instead of the captcha service")
 	pf.BoolVarP(&flagMarkdown, "markdown", "m", false, "convert to markdown (reader mode: extracts main content)")
 	pf.BoolVar(&flagMarkdownFull, "markdown-full", false, "convert full page HTML to markdown")
+	pf.BoolVar(&flagPlain, "plain", false, "print markdown as is, without terminal styling")
 	pf.BoolVar(&flagRaw, "raw", false, "output raw HTML without any processing")
+	pf.StringArrayVar(&flagDataURLEncode, "data-urlencode", nil, "append URL-encoded name=value (or name@file) to the query string, repeatable")
+	pf.StringVar(&flagAccept, "accept", "", `Accept header for fetches: "auto" (application/json with --json) or a literal value`)
+	pf.StringArrayVar(&flagProcess, "process", nil, "post-processing pipeline, repeatable or comma-separated: readability, markdown, strip-links, truncate:N, max-chars:N, max-tokens:N, frontmatter")
+	pf.IntVar(&flagMaxChars, "max-chars", 0, "cut converted output to this many characters at a paragraph boundary, with a truncation notice")
+	pf.IntVar(&flagMaxTokens, "max-tokens", 0, "like --max-chars, for an estimated token count (about 4 characters per token)")
+	pf.BoolVar(&flagMDNoImages, "md-no-images", false, "leave images out of markdown")
+	pf.StringVar(&flagMDLinks, "md-links", "", `markdown link style: "inline" (default), "reference" (numbered, URLs listed at the end) or "none" (text only)`)
+	pf.StringVar(&flagMDTableStyle, "md-table-style", "", `markdown tables: "plain" (default, cell text) or "gfm" (GitHub Flavored Markdown tables)`)
+	pf.BoolVar(&flagMDNoCodeLang, "md-no-code-lang", false, "leave markdown code fences without a language")
+	pf.IntVar(&flagMDHeadingOffset, "md-heading-offset", 0, "shift markdown heading levels by N (e.g. 1 turns <h1> into ##)")
+	pf.StringVar(&flagJQ, "jq", "", `filter JSON responses with a jq expression (e.g. '.items[].name')`)
+	pf.BoolVar(&flagJQRaw, "jq-raw", false, "with --jq, print string results without quotes, like jq -r")
+	pf.StringVar(&flagGrep, "grep", "", "only output the lines matching this regex (after any markdown conversion)")
+	pf.BoolVar(&flagGrepOnly, "grep-only", false, "with --grep, output only the matches, or their capture groups as JSON lines")
+	pf.BoolVar(&flagCanonicalMap, "canonical-map", false, "record redirect/canonical aliases in ~/.ghostfetch/canonical.json and fetch known aliases at their canonical URL")
+	pf.StringVar(&flagPreset, "preset", "", "apply a flag bundle for unset flags: agent, archiver, monitor (or one defined in the config file)")
+	pf.StringVar(&flagConfig, "config", "", "config file (default ~/.ghostfetch/config.json)")
 
 	// Search flags on root command (so `web_search -e brave "query"` works).
-	rootCmd.Flags().StringVarP(&searchEngineName, "engine", "e", "duckduckgo", "search engine: duckduckgo, bing, brave, google")
+	rootCmd.Flags().StringVarP(&searchEngineName, "engine", "e", "duckduckgo", "search engine: duckduckgo, bing, brave, google, kagi, serpapi, serper; a comma-separated list or \"all\" merges several")
 	rootCmd.Flags().IntVarP(&searchMaxResults, "results", "n", 10, "number of results")
+	rootCmd.Flags().IntVar(&searchFetchResults, "fetch-results", 0, "also fetch the first N result pages and include them as reader-mode markdown")
+	rootCmd.Flags().StringVar(&searchVertical, "vertical", "web", "what to search: web, news (google, bing), videos (bing, duckduckgo)")
+	rootCmd.Flags().StringVar(&searchLang, "lang", "", "language of the results, as an ISO 639-1 code (e.g. de)")
+	rootCmd.Flags().StringVar(&searchRegion, "region", "", "country to search from, as an ISO 3166-1 code (e.g. at)")
+	rootCmd.Flags().StringVar(&searchSince, "since", "", "only results from this date (2024-01-01) or age (12h, 7d, 2w, 3m, 1y) on")
+	rootCmd.Flags().StringVar(&searchBefore, "before", "", "only results from before this date (2024-01-01) or age")
+	rootCmd.Flags().StringArrayVar(&searchSites, "site", nil, "only results from this site (e.g. example.com), repeatable")
+	rootCmd.Flags().StringVar(&searchFileType, "filetype", "", "only results of this file type (e.g. pdf)")
+	rootCmd.Flags().StringVar(&searchInTitle, "intitle", "", "only results with these words in their title")
+	rootCmd.Flags().StringArrayVar(&searchExcludeSites, "exclude-site", nil, "leave out results from this site, repeatable")
+	// A URL given without the fetch subcommand is fetched too, so it takes
+	// --globoff as well.
+	rootCmd.Flags().BoolVarP(&flagGlobOff, "globoff", "g", false, "don't expand {a,b} and [1-10] globs in URLs")
 
 	// Subcommands.
 	rootCmd.AddCommand(newFetchCmd())
 	rootCmd.AddCommand(newSearchCmd())
+	rootCmd.AddCommand(newSuggestCmd())
+	rootCmd.AddCommand(newNewsCmd())
+	rootCmd.AddCommand(newWikiCmd())
 	rootCmd.AddCommand(newLinksCmd())
-
-	if err := rootCmd.Execute(); err != nil {
-		os.Exit(1)
-	}
+	rootCmd.AddCommand(newMetaCmd())
+	rootCmd.AddCommand(newSchemaCmd())
+	rootCmd.AddCommand(newExtractCmd())
+	rootCmd.AddCommand(newDiffCmd())
+	rootCmd.AddCommand(newImagesCmd())
+	rootCmd.AddCommand(newFeedCmd())
+	rootCmd.AddCommand(newCanonicalCmd())
+	rootCmd.AddCommand(newWarmCmd())
+	rootCmd.AddCommand(newCrawlCmd())
+	rootCmd.AddCommand(newSitemapCmd())
+	rootCmd.AddCommand(newCaptchaCmd())
+	rootCmd.AddCommand(newCookiesCmd())
+	rootCmd.AddCommand(newHistoryCmd())
+	return rootCmd
 }
 
 // looksLikeURL returns true if the argument looks like a URL rather than
@@ -91,15 +316,61 @@ func newFetchCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "fetch <url> [url2] [url3...]",
 		Short: "Fetch one or more URLs",
-		Args:  cobra.MinimumNArgs(1),
+		Args: func(cmd *cobra.Command, args []string) error {
+			// With --manifest the URLs can all come from the file.
+			if flagManifest != "" {
+				return nil
+			}
+			return cobra.MinimumNArgs(1)(cmd, args)
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runFetch(args)
+			return silenceFailureUsage(cmd, runFetch(args))
 		},
 	}
 	cmd.Flags().IntVarP(&flagMaxParallel, "max-parallel", "p", 5, "max parallel fetches")
+	cmd.Flags().IntVar(&flagPerHost, "per-host", 0, "max parallel fetches to any one host (default: no cap beyond -p)")
+	cmd.Flags().Float64Var(&flagQPS, "qps", 0, "max requests started per second, across all hosts (e.g. 0.5 for one every 2s)")
+	cmd.Flags().StringVar(&flagFromSearch, "from-search", "", "fetch as a click on a result of this search engine (sets Referer and Sec-Fetch-Site)")
+	addResultFilterFlags(cmd)
+	cmd.Flags().StringVar(&flagStore, "store", "", "content-addressed store for bodies: cas:<dir> (objects by SHA-256 plus index.jsonl)")
+	cmd.Flags().BoolVarP(&flagRemoteName, "remote-name", "O", false, "download to a file named from Content-Disposition or the URL path (into --out-dir if set), streaming with a progress bar")
+	cmd.Flags().IntVar(&flagSplit, "split", 1, "with -O, download large files in N parallel ranged segments when the server supports ranges")
+	cmd.Flags().BoolVarP(&flagGlobOff, "globoff", "g", false, "don't expand {a,b} and [1-10] globs in URLs")
+	cmd.Flags().StringVar(&flagVarsFile, "vars", "", "CSV or JSONL rows; each URL is a template like https://site/items/{{.sku}} expanded once per row")
+	cmd.Flags().StringVar(&flagManifest, "manifest", "", "JSON (or .yaml) list of requests, each a url with its own name, browser, accept and timeout, fetched with the other URLs")
+	cmd.Flags().StringVar(&flagOutName, "out-name", "", "file name template for --out-dir; #1, #2... are replaced by URL glob values and {{.field}} by --vars row fields")
+	cmd.Flags().StringVarP(&flagOutput, "output", "o", "", "write output to this file instead of stdout (gzip-compressed if it ends in .gz)")
+	cmd.Flags().BoolVar(&flagGzipOutput, "gzip-output", false, "gzip-compress output: -o/stdout, and --out-dir files (adding .gz)")
+	cmd.Flags().BoolVarP(&flagInclude, "include", "i", false, "start the output with the response's status line and headers, like curl -i")
+	cmd.Flags().BoolVar(&flagFail, "fail", false, "exit with an error, without output, on HTTP 400 and above or an unsolved challenge (exit codes 22, 40, 41)")
+	cmd.Flags().BoolVar(&flagFailWithBody, "fail-with-body", false, "like --fail, but still output the response")
+	cmd.Flags().IntVar(&flagRetryFailed, "retry-failed", 0, "after the batch, fetch URLs that failed, hit a challenge, or got HTTP 429 or 5xx again, up to N more times")
+	cmd.Flags().StringVar(&flagRetryBrowser, "retry-browser", "", "browser profile for --retry-failed retries (default: the -b profile)")
+	cmd.Flags().StringVarP(&flagDumpHeader, "dump-header", "D", "", "write the status line and headers of each response to this file (- for stdout), like curl -D")
+	addOutDirFlag(cmd)
 	return cmd
 }
 
+// addOutDirFlag registers the batch output flags on cmd: --ndjson,
+// --stream, --out-dir (also accepted as --output-dir) and --mirror.
+func addOutDirFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&flagNDJSON, "ndjson", false, "write one JSON object per result and line, as results come in, instead of a JSON array at the end")
+	cmd.Flags().BoolVar(&flagStream, "stream", false, "write each result as soon as it is fetched, in completion order, instead of all at the end (with --json, as --ndjson)")
+	cmd.Flags().StringVar(&flagOutDir, "out-dir", "", "write each page to a file under this directory, with a .meta.json sidecar and an index.json manifest")
+	cmd.Flags().StringVar(&flagOutDir, "output-dir", "", "alias for --out-dir")
+	cmd.Flags().MarkHidden("output-dir")
+	cmd.Flags().BoolVar(&flagMirror, "mirror", false, "with --out-dir, save HTML pages with their CSS, scripts and images, links rewritten for offline viewing")
+}
+
+// addResultFilterFlags registers the filters that keep results out of the
+// output (see newResultFilter) on a command fetching many pages.
+func addResultFilterFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&flagOnlyStatus, "only-status", nil, "only emit/store responses with these status codes (e.g. 200 or 2xx)")
+	cmd.Flags().IntVar(&flagMinBodyBytes, "min-body-bytes", 0, "only emit/store responses with at least this many body bytes")
+	cmd.Flags().StringVar(&flagBodyMatches, "body-matches", "", "only emit/store responses whose body matches this regex")
+	cmd.Flags().StringVar(&flagOnlyLang, "only-lang", "", "only emit/store pages in these languages (comma-separated, e.g. en,de)")
+}
+
 // newSearchCmd creates the "search" subcommand.
 func newSearchCmd() *cobra.Command {
 	cmd := &cobra.Command{
@@ -107,11 +378,80 @@ func newSearchCmd() *cobra.Command {
 		Short: "Search the web",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runSearch(args[0], searchEngineName, searchMaxResults)
+			p, err := newSearchParams(args[0])
+			if err != nil {
+				return err
+			}
+			return runSearch(searchEngineName, searchVertical, p)
 		},
 	}
-	cmd.Flags().StringVarP(&searchEngineName, "engine", "e", "duckduckgo", "search engine: duckduckgo, bing, brave, google")
+	cmd.Flags().StringVarP(&searchEngineName, "engine", "e", "duckduckgo", "search engine: duckduckgo, bing, brave, google, kagi, serpapi, serper; a comma-separated list or \"all\" merges several")
 	cmd.Flags().IntVarP(&searchMaxResults, "results", "n", 10, "number of results")
+	cmd.Flags().IntVar(&searchFetchResults, "fetch-results", 0, "also fetch the first N result pages and include them as reader-mode markdown")
+	cmd.Flags().StringVar(&searchVertical, "vertical", "web", "what to search: web, news (google, bing), videos (bing, duckduckgo)")
+	cmd.Flags().StringVar(&searchLang, "lang", "", "language of the results, as an ISO 639-1 code (e.g. de)")
+	cmd.Flags().StringVar(&searchRegion, "region", "", "country to search from, as an ISO 3166-1 code (e.g. at)")
+	cmd.Flags().StringVar(&searchSince, "since", "", "only results from this date (2024-01-01) or age (12h, 7d, 2w, 3m, 1y) on")
+	cmd.Flags().StringVar(&searchBefore, "before", "", "only results from before this date (2024-01-01) or age")
+	cmd.Flags().StringArrayVar(&searchSites, "site", nil, "only results from this site (e.g. example.com), repeatable")
+	cmd.Flags().StringVar(&searchFileType, "filetype", "", "only results of this file type (e.g. pdf)")
+	cmd.Flags().StringVar(&searchInTitle, "intitle", "", "only results with these words in their title")
+	cmd.Flags().StringArrayVar(&searchExcludeSites, "exclude-site", nil, "leave out results from this site, repeatable")
+	return cmd
+}
+
+// newSuggestCmd creates the "suggest" subcommand.
+func newSuggestCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "suggest <prefix>",
+		Short: "Show a search engine's query suggestions for a prefix",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSuggest(suggestEngine, args[0])
+		},
+	}
+	cmd.Flags().StringVarP(&suggestEngine, "engine", "e", "duckduckgo", "suggestion engine: duckduckgo, google, brave")
+	cmd.Flags().StringVar(&searchLang, "lang", "", "language of the suggestions, as an ISO 639-1 code (e.g. de)")
+	cmd.Flags().StringVar(&searchRegion, "region", "", "country to suggest for, as an ISO 3166-1 code (e.g. at)")
+	return cmd
+}
+
+// newNewsCmd creates the "news" subcommand.
+func newNewsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "news <topic>",
+		Short: "Search several engines' news and list the articles newest first",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			p, err := newSearchParams(args[0])
+			if err != nil {
+				return err
+			}
+			return runNews(newsEngine, p)
+		},
+	}
+	cmd.Flags().StringVarP(&newsEngine, "engine", "e", "all", "news engines: \"all\" or a comma-separated list of google, bing")
+	cmd.Flags().IntVarP(&searchMaxResults, "results", "n", 10, "number of articles")
+	cmd.Flags().IntVar(&searchFetchResults, "fetch-results", 0, "also fetch the first N articles and include them as reader-mode markdown")
+	cmd.Flags().StringVar(&searchLang, "lang", "", "language of the articles, as an ISO 639-1 code (e.g. de)")
+	cmd.Flags().StringVar(&searchRegion, "region", "", "country to search from, as an ISO 3166-1 code (e.g. at)")
+	cmd.Flags().StringVar(&searchSince, "since", "", "only articles from this date (2024-01-01) or age (12h, 7d, 2w, 3m, 1y) on")
+	cmd.Flags().StringVar(&searchBefore, "before", "", "only articles from before this date (2024-01-01) or age")
+	cmd.Flags().StringArrayVar(&searchSites, "site", nil, "only articles from this site (e.g. example.com), repeatable")
+	return cmd
+}
+
+// newWikiCmd creates the "wiki" subcommand.
+func newWikiCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "wiki <term>",
+		Short: "Fetch the Wikipedia article best matching a term",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runWiki(wikiLang, args[0])
+		},
+	}
+	cmd.Flags().StringVar(&wikiLang, "lang", "en", "Wikipedia language edition (e.g. de, simple)")
 	return cmd
 }
 
@@ -129,38 +469,453 @@ func newLinksCmd() *cobra.Command {
 	return cmd
 }
 
+// newMetaCmd creates the "meta" subcommand.
+func newMetaCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "meta <url>",
+		Short: "Show a page's title, description, canonical URL, OpenGraph and Twitter Card tags",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runMeta(args[0])
+		},
+	}
+}
+
+// newSchemaCmd creates the "schema" subcommand.
+func newSchemaCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "schema <url>",
+		Short: "Extract a page's JSON-LD and microdata (schema.org) as a JSON array",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSchema(args[0], schemaTypes)
+		},
+	}
+	cmd.Flags().StringSliceVar(&schemaTypes, "type", nil, "only items of these schema.org types (e.g. Recipe,Product)")
+	return cmd
+}
+
+// newExtractCmd creates the "extract" subcommand.
+func newExtractCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "extract <url>",
+		Short: "Find email addresses, phone numbers or custom patterns in a page, as a JSON list with context",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			x := extractor{emails: extractEmails, phones: extractPhones, context: extractContextChars}
+			for _, p := range extractPatterns {
+				re, err := regexp.Compile(p)
+				if err != nil {
+					return fmt.Errorf("--pattern: %w", err)
+				}
+				x.patterns = append(x.patterns, re)
+			}
+			if !x.emails && !x.phones && len(x.patterns) == 0 {
+				x.emails, x.phones = true, true
+			}
+			return runExtract(args[0], x)
+		},
+	}
+	cmd.Flags().BoolVar(&extractEmails, "emails", false, "find email addresses")
+	cmd.Flags().BoolVar(&extractPhones, "phones", false, "find phone numbers")
+	cmd.Flags().StringArrayVar(&extractPatterns, "pattern", nil, "find matches of this regex (its first capture group, if any); repeatable")
+	cmd.Flags().IntVar(&extractContextChars, "context", extractContext, "characters of surrounding text to keep on each side")
+	return cmd
+}
+
+// newDiffCmd creates the "diff" subcommand.
+func newDiffCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "diff <url-or-file> <url-or-file>",
+		Short: "Show a unified diff of two pages' text, or of a page against a saved snapshot",
+		Long: `Fetch both sides (a side naming an existing file is read from disk),
+convert them to markdown like -m, and print a unified diff. The exit code
+is 0 when they are the same and 3 when they differ.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := runDiff(args[0], args[1], diffContext, diffUpdate)
+			// A change is an outcome, not a mistake: only the code says so.
+			if exitCode(err) == exitChanged {
+				cmd.SilenceErrors, cmd.SilenceUsage = true, true
+			}
+			return err
+		},
+	}
+	cmd.Flags().IntVarP(&diffContext, "unified", "U", 3, "lines of context around each change")
+	cmd.Flags().BoolVar(&diffUpdate, "update", false, "treat the first argument as a snapshot file: create it if missing, and on a change rewrite it with the second side")
+	return cmd
+}
+
+// newImagesCmd creates the "images" subcommand.
+func newImagesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "images <url>",
+		Short: "List a page's images, or download them",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runImages(args[0], imagesDownload, imagesMinSize)
+		},
+	}
+	cmd.Flags().StringVar(&imagesDownload, "download", "", "download the images into this directory")
+	cmd.Flags().StringVar(&imagesMinSize, "min-size", "", "with --download, skip images smaller than this (e.g. 10KB)")
+	cmd.Flags().IntVarP(&flagMaxParallel, "max-parallel", "p", 5, "max parallel fetches")
+	return cmd
+}
+
+// newFeedCmd creates the "feed" subcommand.
+func newFeedCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "feed <url>",
+		Short: "Read an RSS, Atom or JSON feed, or the one a page links to",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runFeed(args[0], feedLimit)
+		},
+	}
+	cmd.Flags().IntVarP(&feedLimit, "limit", "n", 0, "only the first N items (0 = all)")
+	return cmd
+}
+
+// newCanonicalCmd creates the "canonical" subcommand.
+func newCanonicalCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "canonical <url>",
+		Short: "Show the known canonical URL for an alias",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCanonical(args[0])
+		},
+	}
+}
+
+// newWarmCmd creates the "warm" subcommand.
+func newWarmCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "warm <domain>",
+		Short: "Visit a site's homepage and a few pages to pass challenges and collect cookies",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runWarm(args[0], warmPages, warmDelay)
+		},
+	}
+	cmd.Flags().IntVar(&warmPages, "pages", 2, "number of internal pages to visit after the homepage")
+	cmd.Flags().DurationVar(&warmDelay, "delay", time.Second, "base delay between page visits (jittered)")
+	return cmd
+}
+
+// newCrawlCmd creates the "crawl" subcommand.
+func newCrawlCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "crawl <url>",
+		Short: "Fetch a page and the pages it links to, breadth first",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCrawl(args[0], crawlFlags)
+		},
+	}
+	cmd.Flags().IntVar(&crawlFlags.MaxDepth, "depth", 1, "link hops to follow from the start URL")
+	cmd.Flags().BoolVar(&crawlFlags.SameDomain, "same-domain", false, "only follow links to the start URL's domain and its subdomains")
+	cmd.Flags().IntVar(&crawlFlags.MaxPages, "max-pages", 100, "stop after fetching this many pages")
+	cmd.Flags().StringVar(&crawlFlags.MaxBytes, "max-bytes", "", "stop once this much body data has been downloaded (e.g. 500MB)")
+	cmd.Flags().IntVar(&crawlFlags.PerHost, "per-host", 2, "max parallel fetches to any one host")
+	cmd.Flags().DurationVar(&crawlFlags.Delay, "delay", 0, "minimum pause between requests to the same host (jittered by up to +50%)")
+	cmd.Flags().Float64Var(&crawlFlags.QPS, "qps", 0, "max requests started per second, across all hosts (e.g. 0.5 for one every 2s)")
+	cmd.Flags().StringVar(&crawlFlags.Resume, "resume", "", "save the crawl's progress to this state file after every level and resume from it if it exists (needs --out-dir)")
+	cmd.Flags().StringVar(&crawlFlags.Graph, "graph", "", "also write the link graph to this file: Graphviz DOT if it ends in .dot or .gv, JSON otherwise")
+	cmd.Flags().IntVarP(&flagMaxParallel, "max-parallel", "p", 5, "max parallel fetches")
+	addOutDirFlag(cmd)
+	addResultFilterFlags(cmd)
+	return cmd
+}
+
+// newSitemapCmd creates the "sitemap" subcommand.
+func newSitemapCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sitemap <url>",
+		Short: "List the pages in a site's sitemaps, or fetch them",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSitemap(args[0], sitemapFetch, sitemapLimit)
+		},
+	}
+	cmd.Flags().BoolVar(&sitemapFetch, "fetch", false, "fetch the listed pages in parallel instead of listing them")
+	cmd.Flags().IntVarP(&sitemapLimit, "limit", "n", 0, "only the first N pages (0 = all)")
+	cmd.Flags().IntVarP(&flagMaxParallel, "max-parallel", "p", 5, "max parallel fetches")
+	addOutDirFlag(cmd)
+	return cmd
+}
+
+// newCaptchaCmd creates the "captcha" subcommand and its "balance" child.
+func newCaptchaCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "captcha",
+		Short: "Captcha service utilities",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "balance",
+		Short: "Show the credit left with the configured captcha services",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCaptchaBalance()
+		},
+	})
+	return cmd
+}
+
+// newCookiesCmd creates the "cookies" subcommand and its list, delete
+// and clear children, which work on the persistent cookie jar.
+func newCookiesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cookies",
+		Short: "List or remove cookies in the cookie jar (~/.ghostfetch/cookies.json)",
+	}
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List stored cookies: name, domain, path and expiry",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCookiesList(cookiesDomain)
+		},
+	}
+	del := &cobra.Command{
+		Use:   "delete <name> [name...]",
+		Short: "Delete stored cookies by name",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCookiesDelete(args, cookiesDomain)
+		},
+	}
+	clear := &cobra.Command{
+		Use:   "clear",
+		Short: "Delete all stored cookies, or all of --domain",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCookiesDelete(nil, cookiesDomain)
+		},
+	}
+	for _, c := range []*cobra.Command{list, del, clear} {
+		c.Flags().StringVar(&cookiesDomain, "domain", "", "only cookies of this domain and its subdomains")
+	}
+	gc := &cobra.Command{
+		Use:   "gc",
+		Short: "Remove expired cookies and stale clearances, or cookies not set again for a while",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runCookiesGC(cookiesOlderThan)
+		},
+	}
+	gc.Flags().StringVar(&cookiesOlderThan, "older-than", "", "also remove cookies not set again since this date (2024-01-01) or age (e.g. 30d)")
+	cmd.AddCommand(list, del, clear, gc)
+	return cmd
+}
+
+// newHistoryCmd creates the "history" subcommand and its list, show and
+// replay children, which work on the fetch history log.
+func newHistoryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "history",
+		Short: "List, show or replay past fetches (~/.ghostfetch/history.jsonl)",
+	}
+	list := &cobra.Command{
+		Use:   "list",
+		Short: "List the latest fetches: ID, time, status and URL",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runHistoryList(historyLimit)
+		},
+	}
+	list.Flags().IntVarP(&historyLimit, "limit", "n", 20, "only the last N fetches (0 for all)")
+	show := &cobra.Command{
+		Use:   "show <id>",
+		Short: "Show a fetch: URL, status, profile, cookie jar, body hash and command line",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runHistoryShow(args[0])
+		},
+	}
+	replay := &cobra.Command{
+		Use:   "replay <id>",
+		Short: "Run the command that made a fetch again, with the same arguments",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			e, err := findHistoryEntry(args[0])
+			if err != nil {
+				return err
+			}
+			// The replayed command reports its own errors.
+			cmd.SilenceErrors, cmd.SilenceUsage = true, true
+			return replayHistory(e)
+		},
+	}
+	cmd.AddCommand(list, show, replay)
+	return cmd
+}
+
 // runFetch dispatches to runSingleFetch for a single URL or
-// runParallelFetch for multiple URLs.
+// runParallelFetch for multiple URLs (including glob expansions).
 func runFetch(urls []string) error {
-	if len(urls) == 1 {
-		return runSingleFetch(urls[0])
+	if flagMirror && flagOutDir == "" {
+		return fmt.Errorf("--mirror needs --out-dir")
+	}
+	if flagFromSearch != "" {
+		if _, ok := engines[flagFromSearch]; !ok {
+			return fmt.Errorf("unknown search engine for --from-search: %s", flagFromSearch)
+		}
+		fromSearchReferer = searchReferer(flagFromSearch, "")
+	}
+
+	// With --vars, each URL is a template executed once per row.
+	templated := make([]batchItem, 0, len(urls))
+	if flagVarsFile != "" {
+		rows, err := loadVarRows(flagVarsFile)
+		if err != nil {
+			return fmt.Errorf("failed to load vars: %w", err)
+		}
+		for _, u := range urls {
+			expanded, err := expandURLTemplate(u, rows)
+			if err != nil {
+				return err
+			}
+			templated = append(templated, expanded...)
+		}
+	} else {
+		for _, u := range urls {
+			templated = append(templated, batchItem{URL: u})
+		}
+	}
+
+	// Expand URL globs ({a,b}, [1-10]) into batch items unless --globoff.
+	// Template output is taken as is: braces and brackets in a row's
+	// values aren't globs.
+	var items []batchItem
+	for _, t := range templated {
+		if flagGlobOff || flagVarsFile != "" {
+			items = append(items, t)
+			continue
+		}
+		expanded, err := expandGlob(t.URL)
+		if err != nil {
+			return err
+		}
+		for _, it := range expanded {
+			it.Vars = mergeVars(t.Vars, it.Vars)
+			items = append(items, it)
+		}
+	}
+
+	// Manifest entries are fetched as given, without glob expansion.
+	if flagManifest != "" {
+		manifest, err := loadManifest(flagManifest)
+		if err != nil {
+			return err
+		}
+		items = append(items, manifest...)
+	}
+	if len(items) == 0 {
+		return fmt.Errorf("nothing to fetch: no URLs given and the manifest is empty")
+	}
+
+	if len(flagDataURLEncode) > 0 {
+		for i := range items {
+			encoded, err := applyDataURLEncode(normalizeURL(items[i].URL), flagDataURLEncode)
+			if err != nil {
+				return err
+			}
+			items[i].URL = encoded
+		}
+	}
+	if flagRemoteName {
+		return runDownloads(items)
 	}
-	return runParallelFetch(urls)
+	// A glob with a single expansion, such as {x}, is still one page; only
+	// --vars rows make a one-URL batch.
+	if len(items) == 1 && flagVarsFile == "" && !batchOnly() {
+		return runSingleFetch(items[0].URL)
+	}
+	return runParallelFetch(items)
+}
+
+// batchOnly reports whether a flag that only the batch path implements
+// (file output, stores, result filters, --ndjson, --stream, --manifest)
+// is set, so that even a single URL must go through runParallelFetch.
+func batchOnly() bool {
+	return flagOutDir != "" || flagStore != "" || flagOnlyLang != "" || streamResults() || flagManifest != "" ||
+		len(flagOnlyStatus) > 0 || flagMinBodyBytes > 0 || flagBodyMatches != ""
 }
 
 // runSingleFetch fetches a single URL and writes the formatted output to stdout.
 func runSingleFetch(rawURL string) error {
-	result, err := fetchOne(fetchOptions{
-		url:            rawURL,
-		browser:        flagBrowser,
-		timeout:        flagTimeout,
-		noCookies:      flagNoCookies,
-		verbose:        flagVerbose,
-		captchaService: flagCaptchaService,
-		captchaKey:     flagCaptchaKey,
-	})
+	opts, err := newOutputOptions("")
 	if err != nil {
 		return err
 	}
 
-	formatOutput(os.Stdout, result.resp, result.Body, outputOptions{
-		asJSON:       flagJSONOutput,
-		markdown:     flagMarkdown,
-		markdownFull: flagMarkdownFull,
-		pageURL:      result.URL,
-	})
+	canon, err := openCanonicalMap()
+	if err != nil {
+		return err
+	}
+	if canon != nil {
+		if c, ok := canon.Lookup(rawURL); ok {
+			if flagVerbose {
+				fmt.Fprintf(os.Stderr, "[*] Known alias, fetching canonical %s\n", c)
+			}
+			rawURL = c
+		}
+	}
+
+	out, err := openOutput()
+	if err != nil {
+		return err
+	}
+	defer out.Abort()
+
+	fo := newFetchOptions(rawURL)
+	fo.accept = resolveAccept()
+	// Raw output needs no post-processing, so large files and media are
+	// piped to stdout as they arrive instead of being held in memory.
+	// With -i the headers have to come first, so the body is buffered.
+	// The streamed body is hashed on the way for the history log.
+	streamHash := sha256.New()
+	if !opts.asJSON && len(opts.pipeline) == 0 && !opts.include {
+		fo.stream = io.MultiWriter(out, streamHash)
+	}
+	result, err := fetchOne(fo)
+	if err != nil {
+		recordHistory(newHistoryEntry(&fetchResult{URL: rawURL, Error: err}))
+		return err
+	}
+
+	if canon != nil {
+		canon.recordResult(result)
+		if err := canon.Save(); err != nil && flagVerbose {
+			fmt.Fprintf(os.Stderr, "[*] Warning: failed to save canonical map: %v\n", err)
+		}
+	}
+	if err := dumpHeaders(flagDumpHeader, []fetchResult{*result}); err != nil {
+		return err
+	}
+
+	entry := newHistoryEntry(result)
+	if result.Streamed {
+		entry.SHA256 = hex.EncodeToString(streamHash.Sum(nil))
+	}
+	recordHistory(entry)
 
-	return nil
+	failure := responseFailure(result)
+	if failure != nil && !flagFailWithBody {
+		return failure
+	}
+	if !result.Streamed {
+		opts.pageURL = result.URL
+		opts.truncated = result.Truncated
+		opts.challenge = result.Challenge
+		opts.timing = result.Timing
+		formatOutput(out, result.resp, result.Body, opts)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return failure
 }
 
 // defaultCookieJarPath returns the default path for the persistent cookie jar:
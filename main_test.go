@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestNextSegmentReferer checks that --from-search applies only to the
+// --next segment it was given in.
+func TestNextSegmentReferer(t *testing.T) {
+	var mu sync.Mutex
+	referers := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		referers[r.URL.Path] = r.Header.Get("Referer")
+		mu.Unlock()
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+	t.Setenv("HOME", t.TempDir())
+	t.Cleanup(func() { currentSession = nil })
+
+	out := filepath.Join(t.TempDir(), "out")
+	args := []string{
+		"fetch", srv.URL + "/first", "--from-search", "google", "-o", out,
+		"--next", "fetch", srv.URL + "/second", "-o", out,
+	}
+	if code := run(args); code != 0 {
+		t.Fatalf("run(%q) = %d", args, code)
+	}
+	if got, want := referers["/first"], searchReferer("google", ""); got != want {
+		t.Errorf("first segment sent Referer %q, want %q", got, want)
+	}
+	if got, ok := referers["/second"]; !ok || got != "" {
+		t.Errorf("second segment sent Referer %q (requested: %v), want none", got, ok)
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"regexp"
 	"strings"
 
-	htmltomarkdown "github.com/JohannesKaufmann/html-to-markdown/v2"
 	"github.com/JohannesKaufmann/html-to-markdown/v2/converter"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/base"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/commonmark"
 	"golang.org/x/net/html"
 )
 
@@ -24,6 +26,7 @@ var stripTags = map[string]bool{
 
 // htmlToMarkdown converts raw HTML to markdown.
 // If readerMode is true, it first extracts the main content and strips boilerplate.
+// The --md-* options and the config file's "markdown" section shape the output.
 func htmlToMarkdown(rawHTML string, pageURL string, readerMode bool) (string, error) {
 	doc, err := html.Parse(strings.NewReader(rawHTML))
 	if err != nil {
@@ -37,17 +40,24 @@ func htmlToMarkdown(rawHTML string, pageURL string, readerMode bool) (string, er
 		}
 	}
 
+	mdConfig := resolveMarkdownConfig()
+	mdConfig.prepare(doc)
+
 	opts := []converter.ConvertOptionFunc{}
 	if pageURL != "" {
 		opts = append(opts, converter.WithDomain(pageURL))
 	}
 
-	md, err := htmltomarkdown.ConvertNode(doc, opts...)
+	conv := converter.NewConverter(converter.WithPlugins(append([]converter.Plugin{
+		base.NewBasePlugin(),
+		commonmark.NewCommonmarkPlugin(),
+	}, mdConfig.plugins()...)...))
+	md, err := conv.ConvertNode(doc, opts...)
 	if err != nil {
 		return "", err
 	}
 
-	result := strings.TrimSpace(string(md))
+	result := strings.TrimSpace(mdConfig.finish(string(md)))
 	return result, nil
 }
 
@@ -72,9 +82,189 @@ func collectUnwanted(n *html.Node, toRemove *[]*html.Node) {
 	}
 }
 
-// findMainContent looks for <article> or <main> tags.
+// findMainContent looks for <article> or <main> tags, falling back to
+// scoring the page's blocks when it has neither.
 func findMainContent(doc *html.Node) *html.Node {
-	return findElement(doc, "article", "main")
+	if n := findElement(doc, "article", "main"); n != nil {
+		return n
+	}
+	return scoreMainContent(doc)
+}
+
+var (
+	// unlikelyRe matches the class or id of page furniture that is removed
+	// before scoring, unless maybeRe matches too.
+	unlikelyRe = regexp.MustCompile(`(?i)-ad-|banner|breadcrumb|combx|comment|community|cookie|disqus|extra|footer|gdpr|header|menu|modal|pagination|pager|popup|related|remark|replies|rss|share|shoutbox|sidebar|skyscraper|social|sponsor|subscribe|supplemental`)
+	maybeRe    = regexp.MustCompile(`(?i)and|article|body|column|content|main|shadow`)
+	// positiveRe and negativeRe adjust a block's score by its class and id.
+	positiveRe = regexp.MustCompile(`(?i)article|body|content|entry|hentry|h-entry|main|page|post|text|blog|story`)
+	negativeRe = regexp.MustCompile(`(?i)-ad-|hidden|^hid$| hid$| hid |^hid |banner|byline|combx|comment|com-|contact|foot|footer|footnote|gdpr|masthead|media|meta|outbrain|promo|related|scroll|share|shoutbox|sidebar|skyscraper|sponsor|shopping|tags|tool|widget`)
+)
+
+// minScoredText is the shortest paragraph that counts towards scoring, in
+// characters, and minMainScore the score the best block needs to be used
+// instead of the whole page.
+const (
+	minScoredText = 25
+	minMainScore  = 20
+)
+
+// scoreMainContent finds the block holding a page's main text the way
+// Readability does. Each paragraph scores for its length and commas, and
+// the score goes to its parent and, halved, its grandparent. Containers
+// start with a bonus or penalty by tag and class/id, and their total is
+// scaled down by the share of their text inside links. The best container
+// is returned together with siblings that score nearly as well or read
+// like prose, wrapped in a <div>. It returns nil when no block scores
+// minMainScore.
+func scoreMainContent(doc *html.Node) *html.Node {
+	removeUnlikely(doc)
+
+	scores := make(map[*html.Node]float64)
+	var candidates []*html.Node
+	addScore := func(n *html.Node, score float64) {
+		if n == nil || n.Type != html.ElementNode || n.Data == "html" {
+			return
+		}
+		if _, ok := scores[n]; !ok {
+			scores[n] = initialScore(n)
+			candidates = append(candidates, n)
+		}
+		scores[n] += score
+	}
+	for _, p := range filterNodes(doc, isScoredBlock) {
+		text := strings.Join(strings.Fields(textContent(p)), " ")
+		if len(text) < minScoredText {
+			continue
+		}
+		score := 1 + float64(strings.Count(text, ",")) + min(float64(len(text))/100, 3)
+		addScore(p.Parent, score)
+		if p.Parent != nil {
+			addScore(p.Parent.Parent, score/2)
+		}
+	}
+
+	var top *html.Node
+	for _, n := range candidates {
+		scores[n] *= 1 - linkDensity(n)
+		if top == nil || scores[n] > scores[top] {
+			top = n
+		}
+	}
+	if top == nil || scores[top] < minMainScore {
+		return nil
+	}
+	if top.Data == "body" || top.Parent == nil {
+		return top
+	}
+
+	// Articles are often split across sibling blocks, such as a lead
+	// paragraph next to the body container.
+	threshold := max(10, scores[top]*0.2)
+	wrapper := &html.Node{Type: html.ElementNode, Data: "div"}
+	for c := top.Parent.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c == top || scores[c] >= threshold || isProseParagraph(c) {
+			top.Parent.RemoveChild(c)
+			wrapper.AppendChild(c)
+		}
+		c = next
+	}
+	return wrapper
+}
+
+// removeUnlikely removes elements whose class or id marks them as page
+// furniture rather than content.
+func removeUnlikely(doc *html.Node) {
+	for _, n := range filterNodes(doc, func(n *html.Node) bool {
+		if n.Data == "html" || n.Data == "body" || n.Data == "a" {
+			return false
+		}
+		match := getAttr(n, "class") + " " + getAttr(n, "id")
+		return unlikelyRe.MatchString(match) && !maybeRe.MatchString(match)
+	}) {
+		if n.Parent != nil {
+			n.Parent.RemoveChild(n)
+		}
+	}
+}
+
+// isScoredBlock reports whether n is a paragraph-like block whose text is
+// scored: a <p>, <pre>, <td> or <blockquote>, or a <div> used as a
+// paragraph because it has no block children.
+func isScoredBlock(n *html.Node) bool {
+	switch n.Data {
+	case "p", "pre", "td", "blockquote":
+		return true
+	case "div":
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.ElementNode {
+				switch c.Data {
+				case "div", "p", "pre", "table", "ul", "ol", "dl", "blockquote", "section", "article", "h1", "h2", "h3", "h4", "h5", "h6":
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// initialScore is a container's score before its paragraphs are counted.
+func initialScore(n *html.Node) float64 {
+	var score float64
+	switch n.Data {
+	case "div", "section":
+		score = 5
+	case "pre", "td", "blockquote":
+		score = 3
+	case "address", "ol", "ul", "dl", "dd", "dt", "li", "form":
+		score = -3
+	case "h1", "h2", "h3", "h4", "h5", "h6", "th":
+		score = -5
+	}
+	for _, attr := range []string{getAttr(n, "class"), getAttr(n, "id")} {
+		if attr == "" {
+			continue
+		}
+		if negativeRe.MatchString(attr) {
+			score -= 25
+		}
+		if positiveRe.MatchString(attr) {
+			score += 25
+		}
+	}
+	return score
+}
+
+// linkDensity is the share of n's text that is inside links.
+func linkDensity(n *html.Node) float64 {
+	total := len(strings.Join(strings.Fields(textContent(n)), " "))
+	if total == 0 {
+		return 0
+	}
+	var linked int
+	for _, a := range filterNodes(n, func(c *html.Node) bool { return c.Data == "a" }) {
+		linked += len(strings.Join(strings.Fields(textContent(a)), " "))
+	}
+	return min(float64(linked)/float64(total), 1)
+}
+
+// isProseParagraph reports whether n is a <p> that reads like article
+// text: long with few links, or short but link-free and ending a sentence.
+func isProseParagraph(n *html.Node) bool {
+	if n.Type != html.ElementNode || n.Data != "p" {
+		return false
+	}
+	text := strings.Join(strings.Fields(textContent(n)), " ")
+	density := linkDensity(n)
+	switch {
+	case len(text) > 80:
+		return density < 0.25
+	case len(text) > 0:
+		return density == 0 && strings.HasSuffix(text, ".")
+	}
+	return false
 }
 
 func findElement(n *html.Node, tags ...string) *html.Node {
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/JohannesKaufmann/html-to-markdown/v2/converter"
+	"github.com/JohannesKaufmann/html-to-markdown/v2/plugin/table"
+	"golang.org/x/net/html"
+)
+
+// markdownConfig holds the HTML to markdown conversion options, from the
+// config file's "markdown" section and the --md-* flags.
+type markdownConfig struct {
+	// NoImages drops images instead of rendering them as ![alt](src).
+	NoImages bool `json:"no_images,omitempty"`
+	// Links is "inline" (default), "reference" for numbered [text][n]
+	// links listed at the end, or "none" for the link text only.
+	Links string `json:"links,omitempty"`
+	// Tables is "plain" (default, cell text only) or "gfm" for GitHub
+	// Flavored Markdown tables.
+	Tables string `json:"tables,omitempty"`
+	// NoCodeLang leaves code fences without a language.
+	NoCodeLang bool `json:"no_code_lang,omitempty"`
+	// HeadingOffset shifts heading levels, e.g. 1 turns <h1> into ##.
+	HeadingOffset int `json:"heading_offset,omitempty"`
+}
+
+// resolveMarkdownConfig returns the conversion options from the flags,
+// with the config file filling in those left unset.
+func resolveMarkdownConfig() markdownConfig {
+	c := appConfig.Markdown
+	c.NoImages = c.NoImages || flagMDNoImages
+	c.NoCodeLang = c.NoCodeLang || flagMDNoCodeLang
+	if flagMDLinks != "" {
+		c.Links = flagMDLinks
+	}
+	if flagMDTableStyle != "" {
+		c.Tables = flagMDTableStyle
+	}
+	if flagMDHeadingOffset != 0 {
+		c.HeadingOffset = flagMDHeadingOffset
+	}
+	return c
+}
+
+// validate checks the option values, so a typo fails up front instead of
+// every page falling back to unconverted HTML.
+func (c markdownConfig) validate() error {
+	switch c.Links {
+	case "", "inline", "reference", "none":
+	default:
+		return fmt.Errorf("invalid markdown link style %q (inline, reference, none)", c.Links)
+	}
+	switch c.Tables {
+	case "", "plain", "gfm":
+	default:
+		return fmt.Errorf("invalid markdown table style %q (plain, gfm)", c.Tables)
+	}
+	if c.HeadingOffset < -5 || c.HeadingOffset > 5 {
+		return fmt.Errorf("markdown heading offset %d out of range (-5 to 5)", c.HeadingOffset)
+	}
+	return nil
+}
+
+// plugins returns the converter plugins the options need beyond the
+// defaults.
+func (c markdownConfig) plugins() []converter.Plugin {
+	if c.Tables == "gfm" {
+		return []converter.Plugin{table.NewTablePlugin()}
+	}
+	return nil
+}
+
+// prepare applies the options that work on the document before it is
+// converted.
+func (c markdownConfig) prepare(doc *html.Node) {
+	if c.NoImages {
+		for _, n := range filterNodes(doc, func(n *html.Node) bool { return n.Data == "img" || n.Data == "picture" }) {
+			if n.Parent != nil {
+				n.Parent.RemoveChild(n)
+			}
+		}
+	}
+	if c.HeadingOffset != 0 {
+		for _, n := range filterNodes(doc, isHeading) {
+			level := min(max(int(n.Data[1]-'0')+c.HeadingOffset, 1), 6)
+			n.Data = "h" + strconv.Itoa(level)
+			n.DataAtom = 0
+		}
+	}
+	for _, pre := range filterNodes(doc, func(n *html.Node) bool { return n.Data == "pre" }) {
+		if c.NoCodeLang {
+			clearCodeLanguage(pre)
+		} else if lang := codeLanguage(pre); lang != "" {
+			setAttr(pre, "class", "language-"+lang)
+		}
+	}
+}
+
+// finish applies the options that work on the converted markdown.
+func (c markdownConfig) finish(md string) string {
+	switch c.Links {
+	case "reference":
+		return referenceLinks(md)
+	case "none":
+		return stripInlineLinks(md)
+	}
+	return md
+}
+
+// isHeading reports whether n is an <h1> to <h6> element.
+func isHeading(n *html.Node) bool {
+	return len(n.Data) == 2 && n.Data[0] == 'h' && n.Data[1] >= '1' && n.Data[1] <= '6'
+}
+
+var (
+	// codeClassLangRe matches the class names highlighters put a code
+	// block's language in: GitHub's "highlight-source-go", SyntaxHighlighter's
+	// "brush: js" and Pandoc's "sourceCode python".
+	codeClassLangRe = regexp.MustCompile(`(?i)(?:^|\s)(?:highlight-source-|brush:\s*|sourceCode\s+)([a-z0-9_+#-]+)`)
+	// codeLangClassRe matches the classes the converter itself reads.
+	codeLangClassRe = regexp.MustCompile(`(?:^|\s)(?:language|lang)-`)
+)
+
+// codeLanguage finds the language of the code block pre when it isn't
+// given in the language-X class the converter understands: in a data-lang
+// or data-language attribute, or in a highlighter's class, on the <pre>,
+// its <code> or a wrapping <div>. It returns "" when the converter will
+// find the language itself or there is none.
+func codeLanguage(pre *html.Node) string {
+	nodes := []*html.Node{pre}
+	if code := findElement(pre, "code"); code != nil {
+		nodes = append(nodes, code)
+	}
+	for _, n := range nodes {
+		if codeLangClassRe.MatchString(getAttr(n, "class")) {
+			return ""
+		}
+	}
+	if pre.Parent != nil && pre.Parent.Type == html.ElementNode && pre.Parent.Data == "div" {
+		nodes = append(nodes, pre.Parent)
+	}
+	for _, n := range nodes {
+		if lang := strings.TrimSpace(getAttr(n, "data-lang") + getAttr(n, "data-language")); lang != "" {
+			return strings.ToLower(strings.Fields(lang)[0])
+		}
+		if m := codeClassLangRe.FindStringSubmatch(getAttr(n, "class")); m != nil {
+			return strings.ToLower(m[1])
+		}
+	}
+	return ""
+}
+
+// clearCodeLanguage removes the classes the converter reads a code block's
+// language from.
+func clearCodeLanguage(pre *html.Node) {
+	for _, n := range append([]*html.Node{pre}, filterNodes(pre, func(n *html.Node) bool { return n.Data == "code" })...) {
+		if class := getAttr(n, "class"); codeLangClassRe.MatchString(class) {
+			setAttr(n, "class", "")
+		}
+	}
+}
+
+// inlineLinkRe matches markdown links, but not images: an optional "!"
+// is captured so images can be left alone.
+var inlineLinkRe = regexp.MustCompile(`(!?)\[([^\]]*)\]\(([^)\s]+)(?:\s+"[^"]*")?\)`)
+
+// referenceLinks turns inline links into numbered reference links with
+// the URLs listed at the end, one number per distinct URL. Images stay
+// inline.
+func referenceLinks(md string) string {
+	index := make(map[string]int)
+	var urls []string
+	md = inlineLinkRe.ReplaceAllStringFunc(md, func(m string) string {
+		sub := inlineLinkRe.FindStringSubmatch(m)
+		if sub[1] == "!" {
+			return m
+		}
+		n, ok := index[sub[3]]
+		if !ok {
+			urls = append(urls, sub[3])
+			n = len(urls)
+			index[sub[3]] = n
+		}
+		return fmt.Sprintf("[%s][%d]", sub[2], n)
+	})
+	if len(urls) == 0 {
+		return md
+	}
+	var sb strings.Builder
+	sb.WriteString(md)
+	sb.WriteString("\n")
+	for i, u := range urls {
+		fmt.Fprintf(&sb, "\n[%d]: %s", i+1, u)
+	}
+	return sb.String()
+}
+
+// stripInlineLinks replaces links with their text, leaving images.
+func stripInlineLinks(md string) string {
+	return inlineLinkRe.ReplaceAllStringFunc(md, func(m string) string {
+		if sub := inlineLinkRe.FindStringSubmatch(m); sub[1] != "!" {
+			return sub[2]
+		}
+		return m
+	})
+}
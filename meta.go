@@ -0,0 +1,183 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// pageMeta is the metadata a link preview needs from a page.
+type pageMeta struct {
+	URL         string `json:"url"`
+	Status      int    `json:"status,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Canonical   string `json:"canonical,omitempty"`
+	Favicon     string `json:"favicon,omitempty"`
+	Lang        string `json:"lang,omitempty"`
+	// OpenGraph and Twitter hold the og:* and twitter:* tags, keyed
+	// without the prefix ("title", "image", "card", ...).
+	OpenGraph map[string]string `json:"opengraph,omitempty"`
+	Twitter   map[string]string `json:"twitter,omitempty"`
+}
+
+// metaURLKeys are the OpenGraph and Twitter Card properties holding URLs,
+// which are resolved against the page like href attributes.
+var metaURLKeys = map[string]bool{
+	"url": true, "image": true, "image:url": true, "image:secure_url": true,
+	"video": true, "video:url": true, "video:secure_url": true, "audio": true, "player": true,
+}
+
+// extractMeta reads the title, description, canonical URL, favicon,
+// OpenGraph and Twitter Card tags of an HTML page at pageURL. The first
+// occurrence of each tag wins. Without an icon link the favicon is the
+// site's /favicon.ico.
+func extractMeta(body []byte, pageURL string) pageMeta {
+	meta := pageMeta{URL: pageURL}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		base = &url.URL{}
+	}
+	resolve := func(ref string) string {
+		u, err := base.Parse(strings.TrimSpace(ref))
+		if err != nil {
+			return ref
+		}
+		return u.String()
+	}
+	doc, err := html.Parse(strings.NewReader(string(body)))
+	if err != nil {
+		return meta
+	}
+
+	var favicon string
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "title":
+				if meta.Title == "" {
+					meta.Title = strings.Join(strings.Fields(textContent(n)), " ")
+				}
+			case "meta":
+				name := strings.ToLower(cmp.Or(getAttr(n, "property"), getAttr(n, "name")))
+				content := strings.TrimSpace(getAttr(n, "content"))
+				if content == "" {
+					break
+				}
+				switch {
+				case name == "description":
+					if meta.Description == "" {
+						meta.Description = content
+					}
+				case strings.HasPrefix(name, "og:"):
+					meta.OpenGraph = addMetaTag(meta.OpenGraph, strings.TrimPrefix(name, "og:"), content, resolve)
+				case strings.HasPrefix(name, "twitter:"):
+					meta.Twitter = addMetaTag(meta.Twitter, strings.TrimPrefix(name, "twitter:"), content, resolve)
+				}
+			case "link":
+				href := getAttr(n, "href")
+				if href == "" {
+					break
+				}
+				rel := strings.Fields(strings.ToLower(getAttr(n, "rel")))
+				switch {
+				case slices.Contains(rel, "canonical") && meta.Canonical == "":
+					meta.Canonical = resolve(href)
+				case slices.Contains(rel, "icon") && favicon == "":
+					favicon = resolve(href)
+				case slices.Contains(rel, "apple-touch-icon") && meta.Favicon == "":
+					// Only used when the page has no plain icon link.
+					meta.Favicon = resolve(href)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	switch {
+	case favicon != "":
+		meta.Favicon = favicon
+	case meta.Favicon == "" && base.Host != "":
+		meta.Favicon = base.Scheme + "://" + base.Host + "/favicon.ico"
+	}
+	return meta
+}
+
+// addMetaTag sets key in tags unless already set, resolving URL-valued
+// properties, and returns the (possibly new) map.
+func addMetaTag(tags map[string]string, key, value string, resolve func(string) string) map[string]string {
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	if _, ok := tags[key]; ok {
+		return tags
+	}
+	if metaURLKeys[key] {
+		value = resolve(value)
+	}
+	tags[key] = value
+	return tags
+}
+
+// formatMeta renders metadata as a compact markdown block: the title as
+// a heading, the description as a quote, then one line per field and tag.
+func formatMeta(m pageMeta) string {
+	var sb strings.Builder
+	if m.Title != "" {
+		fmt.Fprintf(&sb, "# %s\n\n", m.Title)
+	}
+	if m.Description != "" {
+		fmt.Fprintf(&sb, "> %s\n\n", m.Description)
+	}
+	fmt.Fprintf(&sb, "- url: %s\n", m.URL)
+	for _, f := range [][2]string{{"canonical", m.Canonical}, {"lang", m.Lang}, {"favicon", m.Favicon}} {
+		if f[1] != "" {
+			fmt.Fprintf(&sb, "- %s: %s\n", f[0], f[1])
+		}
+	}
+	groups := []struct {
+		prefix string
+		tags   map[string]string
+	}{{"og", m.OpenGraph}, {"twitter", m.Twitter}}
+	for _, g := range groups {
+		for _, k := range slices.Sorted(maps.Keys(g.tags)) {
+			fmt.Fprintf(&sb, "- %s:%s: %s\n", g.prefix, k, g.tags[k])
+		}
+	}
+	return sb.String()
+}
+
+// runMeta fetches a page and prints its metadata as markdown or, with
+// --json, as JSON.
+func runMeta(rawURL string) error {
+	res, err := fetchOne(newFetchOptions(rawURL))
+	if err != nil {
+		return err
+	}
+	in := res.processInput()
+	if in.notHTML() {
+		return fmt.Errorf("%s is not an HTML page (%s)", res.URL, in.contentType.Effective)
+	}
+	meta := extractMeta(res.Body, res.finalURL())
+	meta.Status = res.StatusCode
+	meta.Lang = detectLanguage(res.Headers, res.Body)
+
+	if flagJSONOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(meta)
+	}
+	fmt.Fprint(os.Stdout, formatMeta(meta))
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// contentTypeInfo reports the server's declared media type next to the
+// one sniffed from the body. Effective is what the processing pipeline
+// acted on.
+type contentTypeInfo struct {
+	Declared  string `json:"declared,omitempty"`
+	Sniffed   string `json:"sniffed,omitempty"`
+	Effective string `json:"effective,omitempty"`
+}
+
+// genericTypes are declared types that say nothing about the content, so
+// a confident sniff always wins over them.
+var genericTypes = map[string]bool{
+	"":                         true,
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+	"application/unknown":      true,
+	"text/plain":               true,
+}
+
+// sniffContentType compares the declared Content-Type with the body and
+// returns both plus the effective type. Servers often send text/html for
+// JSON APIs or octet-stream for pages; the sniffed type overrides the
+// declared one when the declaration is generic or clearly contradicted
+// (JSON declared as HTML or vice versa).
+func sniffContentType(h http.Header, body []byte) contentTypeInfo {
+	declared, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	declared = strings.ToLower(declared)
+	sniffed := sniffBody(body)
+	info := contentTypeInfo{Declared: declared, Sniffed: sniffed, Effective: declared}
+
+	switch {
+	case sniffed == "":
+	case genericTypes[declared]:
+		info.Effective = sniffed
+	case isHTMLType(declared) && isJSONType(sniffed):
+		info.Effective = sniffed
+	case isJSONType(declared) && isHTMLType(sniffed):
+		info.Effective = sniffed
+	}
+	return info
+}
+
+// sniffBody identifies JSON and HTML bodies, falling back to the WHATWG
+// sniffing algorithm for everything else. It returns empty string for
+// bodies it cannot classify beyond plain text or raw bytes.
+func sniffBody(body []byte) string {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return ""
+	}
+	if (trimmed[0] == '{' || trimmed[0] == '[') && json.Valid(trimmed) {
+		return "application/json"
+	}
+	ct, _, _ := mime.ParseMediaType(http.DetectContentType(body))
+	if ct == "text/plain" || ct == "application/octet-stream" {
+		return ""
+	}
+	return ct
+}
+
+func isHTMLType(t string) bool {
+	return t == "text/html" || t == "application/xhtml+xml"
+}
+
+func isJSONType(t string) bool {
+	return t == "application/json" || strings.HasSuffix(t, "+json")
+}
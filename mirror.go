@@ -0,0 +1,540 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/html"
+)
+
+// Accept headers a browser sends for each kind of subresource.
+var assetAccept = map[string]string{
+	"style":  "text/css,*/*;q=0.1",
+	"script": "*/*",
+	"image":  "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8",
+	"font":   "*/*",
+	"media":  "*/*",
+}
+
+// cssURLRe matches url(...) references in CSS, quoted or not.
+var cssURLRe = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^'")\s]+))\s*\)`)
+
+// cssImportRe matches @import "..." references in CSS.
+var cssImportRe = regexp.MustCompile(`@import\s+(?:"([^"]*)"|'([^']*)')`)
+
+// mirrorRef is one rewritable URL in a page: an attribute of n (for
+// srcset, one of several URLs) or, with attr -1, the text of a <style>.
+type mirrorRef struct {
+	n    *html.Node
+	attr int
+	kind string // asset kind, or "" for a link to another page
+}
+
+// mirrorFile is an asset saved (or attempted) by a mirror run.
+type mirrorFile struct {
+	url     string
+	kind    string
+	referer string
+	rel     string // path under the output dir, empty if not saved
+	status  int
+	err     error
+	css     []byte // stylesheet body, rewritten once all assets are known
+}
+
+// mirror holds the state of one mirror run into dir.
+type mirror struct {
+	dir    string
+	pages  map[string]string // normalizeURL(page) -> rel path
+	assets map[string]*mirrorFile
+	order  []*mirrorFile
+}
+
+// writeMirror saves the HTML pages among results under dir, with the
+// stylesheets, scripts, images, fonts and media they reference, and
+// rewrites their links so the copy can be browsed offline: references to
+// saved files become relative paths and all others absolute URLs.
+// Subresources are fetched through the full pipeline with the page as
+// Referer, so a challenge-protected site's assets come with its cookies.
+// Results that aren't HTML are saved as they are.
+func writeMirror(dir string, results []fetchResult) error {
+	m := &mirror{
+		dir:    dir,
+		pages:  make(map[string]string),
+		assets: make(map[string]*mirrorFile),
+	}
+	for i := range results {
+		r := &results[i]
+		if r.Error == nil && r.Skipped == "" {
+			m.pages[normalizeURL(r.URL)] = mirrorPagePath(r)
+		}
+	}
+
+	type parsedPage struct {
+		r    *fetchResult
+		doc  *html.Node
+		refs []mirrorRef
+		base *url.URL
+	}
+	var parsed []parsedPage
+	var manifest []manifestEntry
+	for i := range results {
+		r := &results[i]
+		entry := manifestEntry{URL: r.URL, Status: r.StatusCode, Depth: r.Depth}
+		switch {
+		case r.Error != nil:
+			fmt.Fprintf(os.Stderr, "[!] %s: %v\n", r.URL, r.Error)
+			entry.Error = r.Error.Error()
+		case r.Skipped != "":
+			fmt.Fprintf(os.Stderr, "[-] skipped %s: %s\n", r.URL, r.Skipped)
+			entry.Skipped = r.Skipped
+		default:
+			entry.File = filepath.ToSlash(m.pages[normalizeURL(r.URL)])
+			if r.processInput().notHTML() {
+				break
+			}
+			doc, err := html.Parse(bytes.NewReader(r.Body))
+			if err != nil {
+				break
+			}
+			base, _ := url.Parse(r.URL)
+			base = stripBase(doc, base)
+			refs := collectMirrorRefs(doc)
+			for _, ref := range refs {
+				if ref.kind == "" {
+					continue
+				}
+				for _, a := range refAssets(ref) {
+					m.want(base, a.url, a.kind, r.URL)
+				}
+			}
+			parsed = append(parsed, parsedPage{r, doc, refs, base})
+		}
+		manifest = append(manifest, entry)
+	}
+
+	m.download()
+
+	for _, p := range parsed {
+		rel := m.pages[normalizeURL(p.r.URL)]
+		for _, ref := range p.refs {
+			m.rewriteRef(ref, p.base, rel)
+		}
+		var buf bytes.Buffer
+		if err := html.Render(&buf, p.doc); err != nil {
+			return fmt.Errorf("render %s: %w", p.r.URL, err)
+		}
+		p.r.Body = buf.Bytes()
+	}
+	for i := range results {
+		r := &results[i]
+		if r.Error != nil || r.Skipped != "" {
+			continue
+		}
+		full := filepath.Join(dir, m.pages[normalizeURL(r.URL)])
+		if err := writeMirrorFile(full, r.Body); err != nil {
+			return fmt.Errorf("write %s: %w", r.URL, err)
+		}
+		if err := writeSidecar(full, r, r.Body); err != nil {
+			return fmt.Errorf("write %s: %w", r.URL, err)
+		}
+		fmt.Fprintln(os.Stdout, full)
+	}
+
+	saved := 0
+	for _, a := range m.order {
+		entry := manifestEntry{URL: a.url, Status: a.status}
+		if a.err != nil {
+			entry.Error = a.err.Error()
+			manifest = append(manifest, entry)
+			continue
+		}
+		if a.css != nil {
+			base, _ := url.Parse(a.url)
+			a.css = m.rewriteCSS(a.css, base, a.rel)
+			if err := writeMirrorFile(filepath.Join(dir, a.rel), a.css); err != nil {
+				return fmt.Errorf("write %s: %w", a.url, err)
+			}
+		}
+		entry.File = filepath.ToSlash(a.rel)
+		manifest = append(manifest, entry)
+		saved++
+	}
+	if flagVerbose {
+		fmt.Fprintf(os.Stderr, "[*] Mirror: %d page(s), %d of %d asset(s) saved\n", len(m.pages), saved, len(m.order))
+	}
+	if err := writeManifest(dir, manifest); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	return nil
+}
+
+// mirrorPagePath returns where a mirrored page is saved: its URL path,
+// with an .html extension for HTML so it opens in a browser from disk.
+func mirrorPagePath(r *fetchResult) string {
+	if r.processInput().notHTML() {
+		return outputRelPath(r.URL, "")
+	}
+	return outputRelPath(r.URL, ".html")
+}
+
+// stripBase removes <base href> elements, which would redirect the
+// rewritten relative paths, and returns the base URL they set.
+func stripBase(doc *html.Node, pageURL *url.URL) *url.URL {
+	base := pageURL
+	for _, n := range filterNodes(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "base"
+	}) {
+		if href := getAttr(n, "href"); href != "" && base == pageURL {
+			if u, err := pageURL.Parse(href); err == nil {
+				base = u
+			}
+		}
+		n.Parent.RemoveChild(n)
+	}
+	return base
+}
+
+// collectMirrorRefs returns the URL-bearing attributes and <style>
+// elements of doc, with the kind of asset each one loads.
+func collectMirrorRefs(doc *html.Node) []mirrorRef {
+	var refs []mirrorRef
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if n.Data == "style" && n.FirstChild != nil {
+				refs = append(refs, mirrorRef{n: n, attr: -1, kind: "style"})
+			}
+			for i, a := range n.Attr {
+				if kind, ok := refKind(n, a.Key); ok {
+					refs = append(refs, mirrorRef{n: n, attr: i, kind: kind})
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return refs
+}
+
+// refKind reports whether attribute key of n holds a URL worth rewriting,
+// and the kind of asset it loads ("" for links to pages).
+func refKind(n *html.Node, key string) (string, bool) {
+	switch {
+	case key == "style":
+		return "style", true
+	case n.Data == "a" && key == "href", n.Data == "area" && key == "href":
+		return "", true
+	case n.Data == "link" && key == "href":
+		rel := " " + strings.ToLower(getAttr(n, "rel")) + " "
+		switch {
+		case strings.Contains(rel, " stylesheet "):
+			return "style", true
+		case strings.Contains(rel, "icon"):
+			return "image", true
+		case strings.Contains(rel, " preload ") || strings.Contains(rel, " modulepreload "):
+			switch as := getAttr(n, "as"); as {
+			case "style", "script", "image", "font":
+				return as, true
+			}
+			return "script", strings.Contains(rel, " modulepreload ")
+		}
+	case n.Data == "script" && key == "src":
+		return "script", true
+	case (n.Data == "img" || n.Data == "source") && (key == "src" || key == "srcset"):
+		if n.Parent != nil && (n.Parent.Data == "video" || n.Parent.Data == "audio") {
+			return "media", true
+		}
+		return "image", true
+	case (n.Data == "video" || n.Data == "audio") && key == "src":
+		return "media", true
+	case n.Data == "video" && key == "poster", n.Data == "input" && key == "src":
+		return "image", true
+	}
+	return "", false
+}
+
+// assetRef is a URL as written in a page or stylesheet, with the kind of
+// asset it loads.
+type assetRef struct {
+	url  string
+	kind string
+}
+
+// refAssets returns the assets ref loads.
+func refAssets(ref mirrorRef) []assetRef {
+	if ref.attr < 0 {
+		return cssRefs([]byte(ref.n.FirstChild.Data))
+	}
+	a := ref.n.Attr[ref.attr]
+	switch a.Key {
+	case "style":
+		return cssRefs([]byte(a.Val))
+	case "srcset":
+		var refs []assetRef
+		for _, c := range strings.Split(a.Val, ",") {
+			if f := strings.Fields(c); len(f) > 0 {
+				refs = append(refs, assetRef{f[0], ref.kind})
+			}
+		}
+		return refs
+	}
+	return []assetRef{{a.Val, ref.kind}}
+}
+
+// cssRefs returns the @import (stylesheets) and url() (fonts by their
+// extension, otherwise images) references in CSS.
+func cssRefs(css []byte) []assetRef {
+	var refs []assetRef
+	for _, re := range []*regexp.Regexp{cssImportRe, cssURLRe} {
+		for _, m := range re.FindAllSubmatch(css, -1) {
+			ref := string(bytes.Join(m[1:], nil))
+			if ref == "" || strings.HasPrefix(ref, "data:") {
+				continue
+			}
+			kind := "image"
+			if re == cssImportRe {
+				kind = "style"
+			} else if fontExts[strings.ToLower(path.Ext(strings.SplitN(ref, "?", 2)[0]))] {
+				kind = "font"
+			}
+			refs = append(refs, assetRef{ref, kind})
+		}
+	}
+	return refs
+}
+
+// fontExts are the file extensions of web fonts.
+var fontExts = map[string]bool{".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true}
+
+// resolveRef resolves ref against base, returning ok only for http(s).
+func resolveRef(base *url.URL, ref string) (*url.URL, bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") || strings.HasPrefix(ref, "data:") {
+		return nil, false
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, false
+	}
+	return u, true
+}
+
+// want queues the asset ref, found on the page or stylesheet referer,
+// for download unless it is already queued or a mirrored page.
+func (m *mirror) want(base *url.URL, ref, kind, referer string) {
+	u, ok := resolveRef(base, ref)
+	if !ok {
+		return
+	}
+	key := normalizeURL(u.String())
+	if _, ok := m.pages[key]; ok {
+		return
+	}
+	if _, ok := m.assets[key]; ok {
+		return
+	}
+	u.Fragment = ""
+	a := &mirrorFile{url: u.String(), kind: kind, referer: referer}
+	m.assets[key] = a
+	m.order = append(m.order, a)
+}
+
+// download fetches the queued assets, at most flagMaxParallel at a time,
+// repeating for assets found in downloaded stylesheets.
+func (m *mirror) download() {
+	maxPar := flagMaxParallel
+	if maxPar <= 0 {
+		maxPar = 5
+	}
+	for done := 0; done < len(m.order); {
+		batch := m.order[done:]
+		done = len(m.order)
+		sem := make(chan struct{}, maxPar)
+		var wg sync.WaitGroup
+		for _, a := range batch {
+			wg.Add(1)
+			go func(a *mirrorFile) {
+				defer wg.Done()
+				sem <- struct{}{}        // acquire semaphore slot
+				defer func() { <-sem }() // release semaphore slot
+				m.fetchAsset(a)
+			}(a)
+		}
+		wg.Wait()
+
+		for _, a := range batch {
+			if a.css == nil {
+				continue
+			}
+			base, _ := url.Parse(a.url)
+			for _, ref := range cssRefs(a.css) {
+				m.want(base, ref.url, ref.kind, a.url)
+			}
+		}
+	}
+}
+
+// fetchAsset fetches one asset and, unless it is a stylesheet (written
+// after its references are rewritten), saves it.
+func (m *mirror) fetchAsset(a *mirrorFile) {
+	if flagVerbose {
+		fmt.Fprintf(os.Stderr, "[*] Mirroring %s\n", a.url)
+	}
+	fo := newFetchOptions(a.url)
+	fo.referer = a.referer
+	fo.accept = assetAccept[a.kind]
+	res, err := fetchOne(fo)
+	if err == nil && res.StatusCode >= 400 {
+		a.status = res.StatusCode
+		err = fmt.Errorf("HTTP %d", res.StatusCode)
+	}
+	if err != nil {
+		a.err = err
+		fmt.Fprintf(os.Stderr, "[*] Warning: asset %s: %v\n", a.url, err)
+		return
+	}
+	a.status = res.StatusCode
+	a.rel = outputRelPath(a.url, assetExt(a.url, res.Headers.Get("Content-Type")))
+	if a.kind == "style" {
+		a.css = res.Body
+		return
+	}
+	if err := writeMirrorFile(filepath.Join(m.dir, a.rel), res.Body); err != nil {
+		a.err = err
+		a.rel = ""
+	}
+}
+
+// assetExt returns the extension to give an asset whose URL has none,
+// from its Content-Type; "" keeps the URL's own.
+func assetExt(rawURL, contentType string) string {
+	if u, err := url.Parse(rawURL); err == nil && path.Ext(u.Path) != "" {
+		return ""
+	}
+	mt, _, _ := mime.ParseMediaType(contentType)
+	switch mt {
+	case "text/css":
+		return ".css"
+	case "text/javascript", "application/javascript":
+		return ".js"
+	case "image/jpeg":
+		return ".jpg"
+	}
+	if exts, _ := mime.ExtensionsByType(mt); len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// local returns the saved file for the resource at u, if any.
+func (m *mirror) local(u *url.URL) (string, bool) {
+	key := normalizeURL(u.String())
+	if rel, ok := m.pages[key]; ok {
+		return rel, true
+	}
+	if a, ok := m.assets[key]; ok && a.rel != "" {
+		return a.rel, true
+	}
+	return "", false
+}
+
+// localRef returns ref rewritten for the file at from: a relative path to
+// the saved copy of its target, or else its absolute URL.
+func (m *mirror) localRef(ref string, base *url.URL, from string) string {
+	u, ok := resolveRef(base, ref)
+	if !ok {
+		return ref
+	}
+	rel, ok := m.local(u)
+	if !ok {
+		return u.String()
+	}
+	p, err := filepath.Rel(filepath.Dir(from), rel)
+	if err != nil {
+		return u.String()
+	}
+	p = filepath.ToSlash(p)
+	if u.Fragment != "" {
+		p += "#" + u.EscapedFragment()
+	}
+	return p
+}
+
+// rewriteRef rewrites one reference of the page saved at from.
+func (m *mirror) rewriteRef(ref mirrorRef, base *url.URL, from string) {
+	if ref.attr < 0 {
+		ref.n.FirstChild.Data = string(m.rewriteCSS([]byte(ref.n.FirstChild.Data), base, from))
+		return
+	}
+	a := &ref.n.Attr[ref.attr]
+	switch a.Key {
+	case "style":
+		a.Val = string(m.rewriteCSS([]byte(a.Val), base, from))
+	case "srcset":
+		parts := strings.Split(a.Val, ",")
+		for i, c := range parts {
+			f := strings.Fields(c)
+			if len(f) == 0 {
+				continue
+			}
+			f[0] = m.localRef(f[0], base, from)
+			parts[i] = strings.Join(f, " ")
+		}
+		a.Val = strings.Join(parts, ", ")
+	default:
+		a.Val = m.localRef(a.Val, base, from)
+	}
+	// Rewritten stylesheets no longer match their subresource integrity
+	// hashes, and file:// pages can't satisfy CORS for them anyway.
+	if ref.kind != "" {
+		disableIntegrity(ref.n)
+	}
+}
+
+// disableIntegrity renames n's integrity attribute to data-integrity.
+// Renaming rather than removing keeps the indexes of collected
+// mirrorRefs valid.
+func disableIntegrity(n *html.Node) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == "integrity" {
+			n.Attr[i].Key = "data-integrity"
+		}
+	}
+}
+
+// rewriteCSS rewrites the url() and @import references of CSS saved at
+// from (a stylesheet, or the page for inline styles).
+func (m *mirror) rewriteCSS(css []byte, base *url.URL, from string) []byte {
+	rewrite := func(re *regexp.Regexp, format string) {
+		css = re.ReplaceAllFunc(css, func(match []byte) []byte {
+			sub := re.FindSubmatch(match)
+			for _, g := range sub[1:] {
+				if len(g) > 0 {
+					return fmt.Appendf(nil, format, m.localRef(string(g), base, from))
+				}
+			}
+			return match
+		})
+	}
+	rewrite(cssURLRe, `url("%s")`)
+	rewrite(cssImportRe, `@import "%s"`)
+	return css
+}
+
+// writeMirrorFile writes data to full, creating its directory.
+func writeMirrorFile(full string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(full, data, 0644)
+}
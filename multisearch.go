@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// multiSearch runs p on several engines concurrently, as many at a time
+// as a parallel fetch, and merges their results; see
+// mergeSearchResults. names is "all" (every engine that can run p without
+// further setup) or a comma-separated list. An engine that fails is
+// reported and left out; the search fails only if every engine does. It
+// also returns the engines searched, comma-separated.
+func multiSearch(names string, vertical string, p searchParams) ([]searchResult, string, error) {
+	var list []string
+	if names == "all" {
+		all := slices.Sorted(maps.Keys(engines))
+		if vertical != "" {
+			all = verticalEngines(vertical)
+		}
+		for _, name := range all {
+			if _, err := searchEngineFor(name, vertical, p); err != nil {
+				if flagVerbose {
+					fmt.Fprintf(os.Stderr, "[*] Skipping %s: %v\n", name, err)
+				}
+				continue
+			}
+			list = append(list, name)
+		}
+		if len(list) == 0 {
+			return nil, "", fmt.Errorf("no search engine can run this search")
+		}
+	} else {
+		for _, name := range strings.Split(names, ",") {
+			if name = strings.TrimSpace(name); name != "" && !slices.Contains(list, name) {
+				if _, err := searchEngineFor(name, vertical, p); err != nil {
+					return nil, "", err
+				}
+				list = append(list, name)
+			}
+		}
+	}
+
+	maxPar := flagMaxParallel
+	if maxPar <= 0 {
+		maxPar = 5
+	}
+	perEngine := make([]searchPage, len(list))
+	errs := make([]error, len(list))
+	sem := make(chan struct{}, maxPar)
+	var wg sync.WaitGroup
+	for i, name := range list {
+		wg.Add(1)
+		go func(idx int, name string) {
+			defer wg.Done()
+			sem <- struct{}{}        // acquire semaphore slot
+			defer func() { <-sem }() // release semaphore slot
+			perEngine[idx], errs[idx] = engineSearch(name, vertical, p)
+		}(i, name)
+	}
+	wg.Wait()
+
+	var searched []string
+	var lists [][]searchResult
+	for i, name := range list {
+		if errs[i] != nil {
+			fmt.Fprintf(os.Stderr, "[*] Warning: %s search failed: %v\n", name, errs[i])
+			continue
+		}
+		searched = append(searched, name)
+		lists = append(lists, perEngine[i].Results)
+	}
+	if len(searched) == 0 {
+		return nil, "", fmt.Errorf("every search engine failed")
+	}
+	return mergeSearchResults(searched, lists), strings.Join(searched, ","), nil
+}
+
+// mergeSearchResults merges the engines' result lists by normalized URL
+// (see searchResultKey). Results found by more engines rank first, then
+// those placed higher by any engine. Each merged result lists its engines
+// and keeps the first engine's title, filling in what it lacks from the
+// others.
+func mergeSearchResults(names []string, lists [][]searchResult) []searchResult {
+	type merged struct {
+		searchResult
+		best  int // best position across engines
+		first int // order first seen, for stable ties
+	}
+	byKey := map[string]*merged{}
+	var all []*merged
+	for i, results := range lists {
+		for pos, r := range results {
+			key := searchResultKey(r.URL)
+			m, ok := byKey[key]
+			if !ok {
+				m = &merged{searchResult: r, best: pos, first: len(all)}
+				m.Engines = nil
+				byKey[key] = m
+				all = append(all, m)
+			}
+			if !slices.Contains(m.Engines, names[i]) {
+				m.Engines = append(m.Engines, names[i])
+			}
+			m.best = min(m.best, pos)
+			if m.Title == "" {
+				m.Title = r.Title
+			}
+			if m.Snippet == "" {
+				m.Snippet = r.Snippet
+			}
+			if m.DisplayURL == "" {
+				m.DisplayURL = r.DisplayURL
+			}
+			if m.Published == "" {
+				m.Published = r.Published
+			}
+		}
+	}
+	sort.SliceStable(all, func(a, b int) bool {
+		if len(all[a].Engines) != len(all[b].Engines) {
+			return len(all[a].Engines) > len(all[b].Engines)
+		}
+		if all[a].best != all[b].best {
+			return all[a].best < all[b].best
+		}
+		return all[a].first < all[b].first
+	})
+	out := make([]searchResult, len(all))
+	for i, m := range all {
+		out[i] = m.searchResult
+	}
+	return out
+}
+
+// searchResultKey normalizes a result URL so engines' links to the same
+// page match: the scheme, a leading "www.", the fragment and a trailing
+// slash are ignored, and the host is lowercased.
+func searchResultKey(rawURL string) string {
+	u, err := url.Parse(normalizeURL(rawURL))
+	if err != nil || u.Host == "" {
+		return rawURL
+	}
+	host := strings.TrimPrefix(strings.ToLower(u.Host), "www.")
+	return host + strings.TrimSuffix(u.EscapedPath(), "/") + "?" + u.RawQuery
+}
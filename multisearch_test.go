@@ -0,0 +1,66 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchResultKey(t *testing.T) {
+	same := [][]string{
+		{"https://example.com/a", "http://www.Example.COM/a/", "https://example.com/a#section", "example.com/a"},
+		{"https://example.com/", "https://www.example.com"},
+		{"https://example.com/search?q=go", "http://example.com/search/?q=go#top"},
+	}
+	for _, urls := range same {
+		for _, u := range urls[1:] {
+			if searchResultKey(u) != searchResultKey(urls[0]) {
+				t.Errorf("%q and %q have different keys: %q, %q", urls[0], u, searchResultKey(urls[0]), searchResultKey(u))
+			}
+		}
+	}
+
+	different := [][2]string{
+		{"https://example.com/A", "https://example.com/a"},
+		{"https://example.com/search?q=go", "https://example.com/search?q=rust"},
+		{"https://example.com/a", "https://api.example.com/a"},
+	}
+	for _, pair := range different {
+		if searchResultKey(pair[0]) == searchResultKey(pair[1]) {
+			t.Errorf("%q and %q share the key %q", pair[0], pair[1], searchResultKey(pair[0]))
+		}
+	}
+
+	// Unparseable URLs are their own key.
+	if got := searchResultKey("%zz"); got != "%zz" {
+		t.Errorf("searchResultKey(%q) = %q", "%zz", got)
+	}
+}
+
+func TestMergeSearchResults(t *testing.T) {
+	got := mergeSearchResults([]string{"ddg", "brave"}, [][]searchResult{
+		{
+			{Title: "Only DDG", URL: "https://ddg-only.example/"},
+			{Title: "Both", URL: "https://www.example.com/page/"},
+		},
+		{
+			{Title: "Both (Brave)", URL: "https://example.com/page", Snippet: "from brave"},
+			{Title: "Only Brave", URL: "https://brave-only.example/"},
+		},
+	})
+
+	var titles []string
+	for _, r := range got {
+		titles = append(titles, r.Title)
+	}
+	// The page both engines found ranks first; ties go to the best
+	// position, then to the first seen.
+	if want := []string{"Both", "Only DDG", "Only Brave"}; !reflect.DeepEqual(titles, want) {
+		t.Fatalf("merged titles = %q, want %q", titles, want)
+	}
+	if want := []string{"ddg", "brave"}; !reflect.DeepEqual(got[0].Engines, want) {
+		t.Errorf("engines = %q, want %q", got[0].Engines, want)
+	}
+	if got[0].Snippet != "from brave" {
+		t.Errorf("snippet = %q, want the one filled in from brave", got[0].Snippet)
+	}
+}
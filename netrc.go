@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// netrcEntry holds the credentials of one machine (or default) entry.
+type netrcEntry struct {
+	machine  string // empty for the "default" entry
+	login    string
+	password string
+}
+
+// defaultNetrcPath returns ~/.netrc.
+func defaultNetrcPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// parseNetrc parses a .netrc file. Macro definitions (macdef) are skipped.
+func parseNetrc(path string) ([]netrcEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []netrcEntry
+	var cur *netrcEntry
+	sc := bufio.NewScanner(strings.NewReader(string(data)))
+	inMacro := false
+	for sc.Scan() {
+		line := sc.Text()
+		if inMacro {
+			// A macro body ends at the first empty line.
+			if strings.TrimSpace(line) == "" {
+				inMacro = false
+			}
+			continue
+		}
+		fields := strings.Fields(line)
+		for i := 0; i < len(fields); i++ {
+			if strings.HasPrefix(fields[i], "#") {
+				break
+			}
+			next := func() string {
+				if i+1 < len(fields) {
+					i++
+					return fields[i]
+				}
+				return ""
+			}
+			switch fields[i] {
+			case "machine":
+				entries = append(entries, netrcEntry{machine: next()})
+				cur = &entries[len(entries)-1]
+			case "default":
+				entries = append(entries, netrcEntry{})
+				cur = &entries[len(entries)-1]
+			case "login":
+				if v := next(); cur != nil {
+					cur.login = v
+				}
+			case "password":
+				if v := next(); cur != nil {
+					cur.password = v
+				}
+			case "account":
+				next()
+			case "macdef":
+				next()
+				inMacro = true
+				i = len(fields)
+			}
+		}
+	}
+	return entries, sc.Err()
+}
+
+// netrcCredentials returns "login:password" for host from the netrc file
+// at path, falling back to the default entry. The boolean is false if
+// nothing matches.
+func netrcCredentials(path, host string) (string, bool) {
+	entries, err := parseNetrc(path)
+	if err != nil {
+		return "", false
+	}
+	var def *netrcEntry
+	for i, e := range entries {
+		if e.machine == "" {
+			if def == nil {
+				def = &entries[i]
+			}
+			continue
+		}
+		if strings.EqualFold(e.machine, host) && e.login != "" {
+			return e.login + ":" + e.password, true
+		}
+	}
+	if def != nil && def.login != "" {
+		return def.login + ":" + def.password, true
+	}
+	return "", false
+}
+
+// resolveNetrcFile returns the netrc path selected by --netrc-file or
+// --netrc, or empty string when netrc lookup is disabled.
+func resolveNetrcFile() string {
+	if flagNetrcFile != "" {
+		return flagNetrcFile
+	}
+	if flagNetrc {
+		return defaultNetrcPath()
+	}
+	return ""
+}
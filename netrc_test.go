@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeNetrc writes content to a netrc file in a temporary directory and
+// returns its path.
+func writeNetrc(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "netrc")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNetrcCredentials(t *testing.T) {
+	path := writeNetrc(t, `# credentials
+macdef init
+machine evil.example.com login mallory password x
+
+machine a.example.com
+  login alice # the login
+  password one
+machine b.example.com login bob password two account acct
+machine nologin.example.com password three
+default login anon password guest
+`)
+
+	check := func(host, want string) {
+		t.Helper()
+		got, ok := netrcCredentials(path, host)
+		if !ok || got != want {
+			t.Errorf("netrcCredentials(%q) = %q, %v; want %q", host, got, ok, want)
+		}
+	}
+	check("a.example.com", "alice:one")
+	check("B.Example.COM", "bob:two")
+	// Hosts without a usable entry fall back to the default one, and so
+	// does the host named only in the skipped macro.
+	check("nologin.example.com", "anon:guest")
+	check("evil.example.com", "anon:guest")
+	check("other.example.com", "anon:guest")
+}
+
+func TestNetrcCredentialsWithoutDefault(t *testing.T) {
+	path := writeNetrc(t, "login nobody password none\nmachine example.com login alice password s3cret\n")
+	if got, ok := netrcCredentials(path, "example.com"); !ok || got != "alice:s3cret" {
+		t.Errorf("netrcCredentials = %q, %v; want alice:s3cret", got, ok)
+	}
+	// Tokens before the first machine don't make a default entry.
+	if got, ok := netrcCredentials(path, "other.example.com"); ok {
+		t.Errorf("netrcCredentials matched an unknown host: %q", got)
+	}
+	if _, ok := netrcCredentials(filepath.Join(t.TempDir(), "missing"), "example.com"); ok {
+		t.Errorf("netrcCredentials matched with a missing file")
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// News searches read the engines' RSS feeds rather than their result
+// pages: the feeds carry each article's publication and timestamp as
+// plain fields, and change far less often than the HTML.
+
+// googleNewsURL returns the Google News RSS search feed for p. Its
+// edition (ceid) is a country and language, US English by default; dates
+// go in the query as after:/before: operators.
+func googleNewsURL(p searchParams) string {
+	lang, region := cmp.Or(p.Lang, "en"), strings.ToUpper(cmp.Or(p.Region, "us"))
+	query := strings.TrimSpace(p.Query + " " + googleNewsDateQuery(p))
+	return fmt.Sprintf("https://news.google.com/rss/search?q=%s&hl=%s-%s&gl=%s&ceid=%s:%s",
+		url.QueryEscape(query), lang, region, region, region, lang)
+}
+
+// bingNewsURL returns the Bing News RSS search feed for p.
+func bingNewsURL(p searchParams) string {
+	v := bingLocale(p)
+	v.Set("q", p.Query)
+	v.Set("format", "rss")
+	v.Set("count", strconv.Itoa(p.MaxResults))
+	return "https://www.bing.com/news/search?" + v.Encode()
+}
+
+// newsFeed is the part of an RSS news feed that is read. Source is
+// Google's <source> and Bing's <News:Source>.
+type newsFeed struct {
+	Items []struct {
+		Title       string `xml:"title"`
+		Link        string `xml:"link"`
+		Description string `xml:"description"`
+		PubDate     string `xml:"pubDate"`
+		Source      string `xml:"Source"`
+		GoogleSrc   string `xml:"source"`
+	} `xml:"channel>item"`
+}
+
+// parseNewsFeed parses an RSS news search feed into results with their
+// publication and timestamp.
+func parseNewsFeed(body []byte) []searchResult {
+	var feed newsFeed
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		return nil
+	}
+	var results []searchResult
+	for _, item := range feed.Items {
+		source := strings.TrimSpace(item.Source)
+		if source == "" {
+			source = strings.TrimSpace(item.GoogleSrc)
+		}
+		title := strings.TrimSpace(item.Title)
+		// Google appends the publication to each headline.
+		if source != "" {
+			title = strings.TrimSuffix(title, " - "+source)
+		}
+		r := searchResult{
+			Title:     title,
+			URL:       cleanBingNewsURL(strings.TrimSpace(item.Link)),
+			Source:    source,
+			Published: newsTime(item.PubDate),
+		}
+		// Google's descriptions only repeat the headline as a link.
+		if item.GoogleSrc == "" {
+			r.Snippet = fragmentText(item.Description)
+		}
+		if r.URL != "" {
+			results = append(results, r)
+		}
+	}
+	return results
+}
+
+// newsTime converts an RSS pubDate to RFC 3339 in UTC, keeping it as
+// written if it doesn't parse.
+func newsTime(pubDate string) string {
+	pubDate = strings.TrimSpace(pubDate)
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123} {
+		if t, err := time.Parse(layout, pubDate); err == nil {
+			return t.UTC().Format(time.RFC3339)
+		}
+	}
+	return pubDate
+}
+
+// cleanBingNewsURL extracts the article URL from a Bing News click
+// tracking link ("http://www.bing.com/news/apiclick.aspx?...&url=...").
+func cleanBingNewsURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	if strings.HasSuffix(parsed.Host, "bing.com") && strings.HasSuffix(parsed.Path, "/apiclick.aspx") {
+		if target := parsed.Query().Get("url"); target != "" {
+			return target
+		}
+	}
+	return rawURL
+}
+
+// runNews searches the news verticals of engineName ("all" for every
+// engine that has one, or a comma-separated list) for topic and lists the
+// articles newest first. Engines that can't filter by date search without
+// one; every article is then checked against p's range by its publish
+// time, and articles without one are left out of a dated search.
+func runNews(engineName string, topic searchParams) error {
+	p := topic
+	if err := p.normalize(); err != nil {
+		return err
+	}
+	p.LooseDates = true
+
+	results, engineName, err := multiSearch(engineName, "news", p)
+	if err != nil {
+		return err
+	}
+	results = recentNews(results, p)
+	if len(results) > p.MaxResults {
+		results = results[:p.MaxResults]
+	}
+	for i := range results {
+		results[i].Position = i + 1
+	}
+	if searchFetchResults > 0 {
+		fetchSearchResults(results, "news", searchFetchResults)
+	}
+
+	if flagJSONOutput {
+		out := searchJSONOutput{
+			Query:    p.Query,
+			Engine:   engineName,
+			Vertical: "news",
+			Page:     1,
+			Results:  results,
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(out)
+	}
+
+	fmt.Print(formatResultList("News", p.Query, results))
+	return nil
+}
+
+// recentNews keeps the articles published within p's date range and
+// sorts them newest first. Articles without a publish time sort last.
+func recentNews(results []searchResult, p searchParams) []searchResult {
+	published := func(r searchResult) time.Time {
+		t, _ := time.Parse(time.RFC3339, r.Published)
+		return t
+	}
+	var kept []searchResult
+	for _, r := range results {
+		t := published(r)
+		if p.hasDates() && (t.IsZero() || t.Before(p.Since) || (!p.Before.IsZero() && !t.Before(p.Before))) {
+			continue
+		}
+		kept = append(kept, r)
+	}
+	slices.SortStableFunc(kept, func(a, b searchResult) int {
+		return published(b).Compare(published(a))
+	})
+	return kept
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// sidecarMeta is written next to every output file as <file>.meta.json so
+// that a directory of fetched pages stays self-describing.
+type sidecarMeta struct {
+	URL       string              `json:"url"`
+	FinalURL  string              `json:"final_url,omitempty"`
+	Status    int                 `json:"status"`
+	Headers   map[string][]string `json:"headers,omitempty"`
+	FetchedAt time.Time           `json:"fetched_at"`
+	ElapsedMS int64               `json:"elapsed_ms"`
+	SHA256    string              `json:"sha256"`
+	Size      int                 `json:"size"`
+	File      string              `json:"file"`
+	Truncated bool                `json:"truncated,omitempty"`
+	// Gzip is set when the file is gzip-compressed; SHA256 and Size
+	// describe the uncompressed content.
+	Gzip bool `json:"gzip,omitempty"`
+}
+
+// unsafeNameRe matches characters not allowed in generated file names.
+var unsafeNameRe = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// outputRelPath derives a relative file path from a URL: host/path, with
+// "index" for directory URLs, a short hash suffix for query strings, and
+// ext appended when the name lacks one. Path components are sanitized so
+// that no URL can escape the output directory.
+func outputRelPath(rawURL, ext string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		sum := sha256.Sum256([]byte(rawURL))
+		return hex.EncodeToString(sum[:8]) + ext
+	}
+
+	parts := []string{sanitizeName(u.Host)}
+	p := u.Path
+	if p == "" || strings.HasSuffix(p, "/") {
+		p += "index"
+	}
+	for _, seg := range strings.Split(strings.Trim(p, "/"), "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			continue
+		}
+		parts = append(parts, sanitizeName(seg))
+	}
+
+	name := parts[len(parts)-1]
+	if u.RawQuery != "" {
+		sum := sha256.Sum256([]byte(u.RawQuery))
+		stem := strings.TrimSuffix(name, path.Ext(name))
+		name = stem + "_" + hex.EncodeToString(sum[:4]) + path.Ext(name)
+	}
+	if ext != "" {
+		name = strings.TrimSuffix(name, path.Ext(name)) + ext
+	} else if path.Ext(name) == "" {
+		name += ".html"
+	}
+	parts[len(parts)-1] = name
+	return filepath.Join(parts...)
+}
+
+// sanitizePath splits a relative path into sanitized components, dropping
+// empty, "." and ".." segments so the result stays inside the output dir.
+func sanitizePath(p string) []string {
+	var parts []string
+	for _, seg := range strings.Split(filepath.ToSlash(p), "/") {
+		if seg == "" || seg == "." || seg == ".." {
+			continue
+		}
+		parts = append(parts, sanitizeName(seg))
+	}
+	if len(parts) == 0 {
+		parts = []string{"_"}
+	}
+	return parts
+}
+
+func sanitizeName(s string) string {
+	s = unsafeNameRe.ReplaceAllString(s, "_")
+	if s == "" {
+		return "_"
+	}
+	return s
+}
+
+// writeOutputFile writes content for r under dir and a .meta.json sidecar
+// next to it. The file name is derived from the URL unless nameTmpl is
+// set, in which case #1, #2, ... and {{.field}} are filled from r.Vars. It
+// returns the path of the written content file.
+func writeOutputFile(dir string, r *fetchResult, content []byte, ext, nameTmpl string) (string, error) {
+	rel := outputRelPath(r.URL, ext)
+	if nameTmpl != "" {
+		rel = filepath.Join(sanitizePath(expandOutName(nameTmpl, r.Vars))...)
+	}
+	full := filepath.Join(dir, rel)
+	if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+		return "", err
+	}
+	data := content
+	if flagGzipOutput {
+		full += ".gz"
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(content); err != nil {
+			return "", err
+		}
+		if err := gz.Close(); err != nil {
+			return "", err
+		}
+		data = buf.Bytes()
+	}
+	if err := os.WriteFile(full, data, 0644); err != nil {
+		return "", err
+	}
+	if err := writeSidecar(full, r, content); err != nil {
+		return "", err
+	}
+	return full, nil
+}
+
+// writeSidecar writes <file>.meta.json describing the fetch that produced
+// content.
+func writeSidecar(file string, r *fetchResult, content []byte) error {
+	sum := sha256.Sum256(content)
+	return writeSidecarMeta(file, r, sum[:], len(content), flagGzipOutput)
+}
+
+// writeFileSidecar writes <file>.meta.json for a file holding the body as
+// is, such as a download streamed to disk, hashing the file itself.
+func writeFileSidecar(file string, r *fetchResult) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	return writeSidecarMeta(file, r, h.Sum(nil), int(n), false)
+}
+
+// writeSidecarMeta writes <file>.meta.json for content of size bytes with
+// the given SHA-256.
+func writeSidecarMeta(file string, r *fetchResult, sum []byte, size int, gzipped bool) error {
+	meta := sidecarMeta{
+		URL:       r.URL,
+		Status:    r.StatusCode,
+		Headers:   r.Headers,
+		FetchedAt: r.FetchedAt,
+		ElapsedMS: r.Elapsed.Milliseconds(),
+		SHA256:    hex.EncodeToString(sum),
+		Size:      size,
+		File:      filepath.Base(file),
+		Truncated: r.Truncated,
+		Gzip:      gzipped,
+	}
+	if r.resp != nil && r.resp.Request != nil && r.resp.Request.URL != nil {
+		if final := r.resp.Request.URL.String(); final != r.URL {
+			meta.FinalURL = final
+		}
+	}
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file+".meta.json", data, 0644)
+}
+
+// manifestEntry is one URL's line in an output directory's index.json.
+type manifestEntry struct {
+	URL     string `json:"url"`
+	File    string `json:"file,omitempty"`
+	Status  int    `json:"status,omitempty"`
+	Depth   int    `json:"depth,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// manifestName is the manifest file written at the top of an output dir.
+const manifestName = "index.json"
+
+// manifestPath returns file relative to dir, with forward slashes.
+func manifestPath(dir, file string) string {
+	if rel, err := filepath.Rel(dir, file); err == nil {
+		file = rel
+	}
+	return filepath.ToSlash(file)
+}
+
+// writeManifest merges entries into dir's index.json: URLs already listed
+// by an earlier run into the same directory are updated in place, new
+// ones are appended.
+func writeManifest(dir string, entries []manifestEntry) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	file := filepath.Join(dir, manifestName)
+	var manifest []manifestEntry
+	if data, err := os.ReadFile(file); err == nil {
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	index := make(map[string]int, len(manifest))
+	for i, e := range manifest {
+		index[e.URL] = i
+	}
+	for _, e := range entries {
+		if i, ok := index[e.URL]; ok {
+			manifest[i] = e
+			continue
+		}
+		index[e.URL] = len(manifest)
+		manifest = append(manifest, e)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(data, '\n'), 0644)
+}
+
+// outputExt returns the file extension for processed output: ".md" when
+// the pipeline converts to markdown, otherwise empty (keep the URL's own).
+func outputExt(p pipeline) string {
+	for _, step := range p {
+		if step.name == "readability" || step.name == "markdown" {
+			return ".md"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputRelPath(t *testing.T) {
+	for rawURL, want := range map[string]string{
+		"https://example.com/":             "example.com/index.html",
+		"https://example.com":              "example.com/index.html",
+		"https://example.com/docs/":        "example.com/docs/index.html",
+		"https://example.com/docs/page":    "example.com/docs/page.html",
+		"https://example.com/a/b.pdf":      "example.com/a/b.pdf",
+		"https://example.com:8080/x":       "example.com_8080/x.html",
+		"https://example.com/a%20b/c d":    "example.com/a_b/c_d.html",
+		"https://example.com/../../etc/pw": "example.com/etc/pw.html",
+		// A query gets a short hash of itself so pages don't collide.
+		"https://example.com/search?a=1":           "example.com/search_c22fea5d.html",
+		"https://example.com/list.php?q=go&page=2": "example.com/list_8c47aa91.php",
+	} {
+		if got := outputRelPath(rawURL, ""); got != filepath.FromSlash(want) {
+			t.Errorf("outputRelPath(%q) = %q, want %q", rawURL, got, want)
+		}
+	}
+
+	// A converted page takes the extension of its format.
+	if got, want := outputRelPath("https://example.com/a/b.pdf", ".md"), filepath.FromSlash("example.com/a/b.md"); got != want {
+		t.Errorf("outputRelPath with .md = %q, want %q", got, want)
+	}
+}
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"time"
 )
 
 type JSONOutput struct {
@@ -11,43 +17,177 @@ type JSONOutput struct {
 	Headers map[string][]string `json:"headers"`
 	Body    string              `json:"body"`
 	URL     string              `json:"url,omitempty"`
+	// Freshness is computed from Date, Age, Last-Modified, Expires and
+	// Cache-Control; omitted when the response has none of them.
+	Freshness *freshnessInfo `json:"freshness,omitempty"`
+	// Truncated marks a body cut off by a failed read; Body holds the
+	// bytes received before the failure.
+	Truncated bool `json:"truncated,omitempty"`
+	// Challenge names an unsolved anti-bot challenge, e.g. "akamai".
+	Challenge string `json:"challenge,omitempty"`
+	// Trailers holds HTTP trailers sent after a chunked body.
+	Trailers map[string][]string `json:"trailers,omitempty"`
+	// ContentType holds the declared and sniffed media types.
+	ContentType *contentTypeInfo `json:"content_type,omitempty"`
+	// SHA256 and ContentLength describe the body as received (decoded
+	// but before any processing), so it can be verified later.
+	SHA256        string `json:"sha256"`
+	ContentLength int    `json:"content_length"`
+	// FinalURL is the URL after redirects and Protocol the HTTP version
+	// the response came over, e.g. "HTTP/2.0".
+	FinalURL string       `json:"final_url,omitempty"`
+	Protocol string       `json:"protocol,omitempty"`
+	Timing   *fetchTiming `json:"timing,omitempty"`
 }
 
 type outputOptions struct {
-	asJSON       bool
-	markdown     bool // reader mode: extract main content + convert to markdown
-	markdownFull bool // full page HTML-to-markdown
-	pageURL      string
+	asJSON   bool
+	pipeline pipeline // post-processing stages applied to the body in order
+	pageURL  string
+	// truncated is reported in JSON output for partially read bodies.
+	truncated bool
+	// challenge is reported in JSON output when a challenge remains.
+	challenge string
+	// timing is reported in JSON output.
+	timing *fetchTiming
+	// include starts text output with the response head (-i).
+	include bool
+	// render styles markdown converted from pages for the terminal.
+	render bool
 }
 
-func formatOutput(w io.Writer, resp *http.Response, body []byte, opts outputOptions) {
-	content := string(body)
-
-	// Apply markdown conversion if requested.
-	if opts.markdown || opts.markdownFull {
-		readerMode := opts.markdown // --markdown uses reader mode, --markdown-full does not
-		md, err := htmlToMarkdown(content, opts.pageURL, readerMode)
-		if err == nil {
-			content = md
-		}
-		// On error, fall through with raw HTML.
+// newOutputOptions builds outputOptions from the global flags and config.
+func newOutputOptions(pageURL string) (outputOptions, error) {
+	p, err := resolvePipeline()
+	if err != nil {
+		return outputOptions{}, err
+	}
+	return outputOptions{
+		asJSON:   flagJSONOutput,
+		pipeline: p,
+		pageURL:  pageURL,
+		// "-D -" puts the headers on stdout, which is what -i does.
+		include: flagInclude || flagDumpHeader == "-",
+		render:  renderInTerminal(),
+	}, nil
+}
+
+// autoAccept holds the Accept headers used by --accept auto. Modes that
+// are missing keep the browser profile's header.
+var autoAccept = map[string]string{
+	"json": "application/json, text/html;q=0.9, */*;q=0.8",
+}
+
+// outputMode names the current output mode for Accept selection:
+// "json", "markdown" or "raw".
+func outputMode() string {
+	switch {
+	case flagJSONOutput || flagNDJSON:
+		return "json"
+	case flagMarkdown || flagMarkdownFull || len(flagProcess) > 0 || flagMaxChars > 0 || flagMaxTokens > 0:
+		return "markdown"
+	default:
+		return "raw"
 	}
+}
+
+// resolveAccept returns the Accept header for fetches in the current
+// output mode: --accept auto picks a mode-appropriate value, any other
+// --accept value is used verbatim, and otherwise the config's per-mode
+// value applies. Empty means keep the browser profile's header.
+func resolveAccept() string {
+	mode := outputMode()
+	switch flagAccept {
+	case "":
+		return appConfig.Accept[mode]
+	case "auto":
+		return autoAccept[mode]
+	default:
+		return flagAccept
+	}
+}
+
+// bodySHA256 returns the hex SHA-256 of body.
+func bodySHA256(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func formatOutput(w io.Writer, resp *http.Response, body []byte, opts outputOptions) {
+	in := newProcessInput(opts.pageURL, resp.StatusCode, resp.Header, body)
+	content := opts.pipeline.run(string(body), in)
 
 	if !opts.asJSON {
-		w.Write([]byte(content))
+		if opts.include {
+			writeResponseHead(w, resp)
+		}
+		w.Write([]byte(opts.display(content, in)))
 		return
 	}
 
 	out := JSONOutput{
-		Status:  resp.StatusCode,
-		Headers: resp.Header,
-		Body:    content,
+		Status:        resp.StatusCode,
+		Headers:       resp.Header,
+		Body:          content,
+		Freshness:     computeFreshness(resp.Header, time.Now()),
+		Truncated:     opts.truncated,
+		Challenge:     opts.challenge,
+		Trailers:      resp.Trailer,
+		ContentType:   &in.contentType,
+		SHA256:        bodySHA256(body),
+		ContentLength: len(body),
+		Protocol:      resp.Proto,
+		Timing:        opts.timing,
 	}
 	if resp.Request != nil && resp.Request.URL != nil {
 		out.URL = resp.Request.URL.String()
+		out.FinalURL = out.URL
 	}
 
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
 	enc.Encode(out)
 }
+
+// display returns processed content as it is shown: rendered for the
+// terminal when it is markdown converted from a page. Other content,
+// such as JSON passed through, is left alone.
+func (opts outputOptions) display(content string, in processInput) string {
+	if opts.render && isHTMLType(in.contentType.Effective) {
+		return renderMarkdown(content)
+	}
+	return content
+}
+
+// writeResponseHead writes resp's status line and headers the way curl -i
+// does: "HTTP/1.1 200 OK", one "Name: value" line per header value and a
+// blank line, with CRLF line endings. Headers come in sorted order.
+func writeResponseHead(w io.Writer, resp *http.Response) {
+	fmt.Fprintf(w, "%s %s\r\n", resp.Proto, resp.Status)
+	resp.Header.Write(w)
+	io.WriteString(w, "\r\n")
+}
+
+// dumpHeaders writes the response heads of results, in order, to path
+// for -D. Failed fetches have none and are left out. Nothing is written
+// when path is empty, or "-", which -i takes care of.
+func dumpHeaders(path string, results []fetchResult) error {
+	if path == "" || path == "-" {
+		return nil
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("dump headers: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	for i := range results {
+		if r := &results[i]; r.resp != nil {
+			writeResponseHead(w, r.resp)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return fmt.Errorf("dump headers: %w", err)
+	}
+	return f.Close()
+}
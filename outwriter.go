@@ -0,0 +1,93 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// outputWriter is where fetch output goes: stdout, or the -o file,
+// gzip-compressed when the name ends in .gz or --gzip-output is set.
+type outputWriter struct {
+	io.Writer
+	file *os.File
+	gz   *gzip.Writer
+	// target is the -o path; the output goes to file, a temporary file
+	// next to it, which Close renames over target.
+	target string
+}
+
+// openOutput opens the -o destination, or returns stdout when -o is unset.
+// The file is written under a temporary name so a failed run leaves an
+// existing target untouched. Close must be called to flush compressed
+// output and put the file in place; Abort discards it.
+func openOutput() (*outputWriter, error) {
+	if flagOutput == "" || flagOutput == "-" {
+		if flagGzipOutput {
+			gz := gzip.NewWriter(os.Stdout)
+			return &outputWriter{Writer: gz, gz: gz}, nil
+		}
+		return &outputWriter{Writer: os.Stdout}, nil
+	}
+	f, err := os.CreateTemp(filepath.Dir(flagOutput), "."+filepath.Base(flagOutput)+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	out := &outputWriter{Writer: f, file: f, target: flagOutput}
+	if gzipOutput(flagOutput) {
+		out.gz = gzip.NewWriter(f)
+		out.Writer = out.gz
+	}
+	return out, nil
+}
+
+// Close flushes the gzip stream and closes the file, if any, renaming it
+// over the -o target once everything is written. If anything fails the
+// temporary file is removed and the target is left as it was. Calling it
+// again is a no-op.
+func (o *outputWriter) Close() error {
+	var err error
+	if o.gz != nil {
+		err = o.gz.Close()
+		o.gz = nil
+	}
+	if o.file == nil {
+		return err
+	}
+	tmp := o.file.Name()
+	if cerr := o.file.Close(); err == nil {
+		err = cerr
+	}
+	o.file = nil
+	if err == nil {
+		err = os.Rename(tmp, o.target)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// Abort closes and removes the temporary -o file without touching the
+// target. It is a no-op after Close, so it can be deferred.
+func (o *outputWriter) Abort() {
+	if o.file == nil {
+		return
+	}
+	o.gz = nil
+	o.file.Close()
+	os.Remove(o.file.Name())
+	o.file = nil
+}
+
+// gzipOutput reports whether a file written to name should be compressed.
+func gzipOutput(name string) bool {
+	return flagGzipOutput || strings.HasSuffix(strings.ToLower(name), ".gz")
+}
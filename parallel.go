@@ -1,67 +1,307 @@
 package main
 
 import (
+	"cmp"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
+	"slices"
+	"strings"
 	"sync"
+	"time"
 )
 
 // runParallelFetch fetches multiple URLs concurrently using goroutines.
-// Concurrency is limited by flagMaxParallel (default 5).
-// Results are output in input-URL order, not completion order.
-func runParallelFetch(urls []string) error {
+// Concurrency is limited by flagMaxParallel (default 5), and by
+// --per-host and --qps.
+// Results are output in input-URL order, not completion order, unless
+// --ndjson or --stream writes them as they come in.
+func runParallelFetch(items []batchItem) error {
 	maxPar := flagMaxParallel
 	if maxPar <= 0 {
 		maxPar = 5
 	}
+	if flagRetryFailed < 0 {
+		return fmt.Errorf("invalid --retry-failed %d", flagRetryFailed)
+	}
+	if flagQPS < 0 {
+		return fmt.Errorf("invalid --qps %g", flagQPS)
+	}
+	perHost := flagPerHost
+	if perHost <= 0 {
+		perHost = maxPar
+	}
+	throttle := newHostThrottle(perHost, 0, flagQPS)
 
-	results := make([]fetchResult, len(urls))
-	sem := make(chan struct{}, maxPar)
-	var wg sync.WaitGroup
-
-	for i, u := range urls {
-		wg.Add(1)
-		go func(idx int, rawURL string) {
-			defer wg.Done()
-			sem <- struct{}{}        // acquire semaphore slot
-			defer func() { <-sem }() // release semaphore slot
-
-			res, err := fetchOne(fetchOptions{
-				url:            rawURL,
-				browser:        flagBrowser,
-				timeout:        flagTimeout,
-				noCookies:      flagNoCookies,
-				verbose:        flagVerbose,
-				captchaService: flagCaptchaService,
-				captchaKey:     flagCaptchaKey,
-			})
-			if err != nil {
-				results[idx] = fetchResult{
-					URL:   rawURL,
-					Error: err,
+	opts, err := newOutputOptions("")
+	if err != nil {
+		return err
+	}
+
+	filter, err := newResultFilter()
+	if err != nil {
+		return err
+	}
+
+	var store *casStore
+	if flagStore != "" {
+		if store, err = parseStore(flagStore); err != nil {
+			return err
+		}
+	}
+
+	canon, err := openCanonicalMap()
+	if err != nil {
+		return err
+	}
+	if canon != nil {
+		items = dedupeAliases(canon, items)
+	}
+
+	// Resolve all hosts up front so slots don't wait on DNS one by one.
+	if len(items) > 1 {
+		prefetchBatchDNS(items, flagVerbose)
+	}
+
+	// With --ndjson or --stream each result is written as soon as it is
+	// fetched.
+	var nd *resultStream
+	if streamResults() {
+		if nd, err = newResultStream(opts); err != nil {
+			return err
+		}
+		defer nd.Close()
+	}
+
+	accept := resolveAccept()
+	results := make([]fetchResult, len(items))
+	pending := make([]int, len(items))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	// The first pass fetches every item; with --retry-failed, each further
+	// round fetches again those that failed in a way worth retrying.
+	for round := 0; ; round++ {
+		last := round == flagRetryFailed
+		sem := make(chan struct{}, maxPar)
+		var wg sync.WaitGroup
+
+		for _, i := range pending {
+			wg.Add(1)
+			go func(idx int, it batchItem) {
+				defer wg.Done()
+				// Wait for the host before taking a global slot, so a
+				// busy host doesn't hold up URLs of other hosts.
+				release := throttle.acquire(it.URL)
+				defer release()
+				sem <- struct{}{}        // acquire semaphore slot
+				defer func() { <-sem }() // release semaphore slot
+
+				attempts := results[idx].Attempts + 1
+				fo := it.fetchOptions()
+				fo.accept = cmp.Or(it.Accept, accept)
+				if round > 0 && flagRetryBrowser != "" {
+					fo.browser = flagRetryBrowser
+				}
+				res, err := fetchOne(fo)
+				if err != nil {
+					results[idx] = fetchResult{
+						URL:   it.URL,
+						Vars:  it.Vars,
+						Error: err,
+					}
+				} else {
+					res.Vars = it.Vars
+					results[idx] = *res
+					if err := responseFailure(&results[idx]); err != nil && !flagFailWithBody {
+						results[idx].Error = err
+					}
 				}
-				return
+				results[idx].OutName = it.Name
+				if flagRetryFailed > 0 {
+					results[idx].Attempts = attempts
+				}
+				// A result about to be retried isn't final yet.
+				if nd != nil && (last || !retryable(&results[idx])) {
+					if err == nil {
+						results[idx].Skipped = filter.reason(&results[idx])
+					}
+					nd.write(&results[idx])
+				}
+			}(i, items[i])
+		}
+		wg.Wait()
+
+		if last {
+			break
+		}
+		pending = slices.DeleteFunc(pending, func(i int) bool { return !retryable(&results[i]) })
+		if len(pending) == 0 {
+			break
+		}
+		delay := time.Duration(round+1) * retryFailedDelay
+		if flagVerbose {
+			fmt.Fprintf(os.Stderr, "[*] Retrying %d failed URL(s) in %s (retry %d of %d)\n", len(pending), delay, round+1, flagRetryFailed)
+		}
+		time.Sleep(delay)
+	}
+
+	entries := make([]historyEntry, len(results))
+	for i := range results {
+		entries[i] = newHistoryEntry(&results[i])
+	}
+	recordHistory(entries...)
+
+	if err := dumpHeaders(flagDumpHeader, results); err != nil {
+		return err
+	}
+	if canon != nil {
+		for i := range results {
+			canon.recordResult(&results[i])
+		}
+		if err := canon.Save(); err != nil && flagVerbose {
+			fmt.Fprintf(os.Stderr, "[*] Warning: failed to save canonical map: %v\n", err)
+		}
+	}
+
+	// With --fail, the batch fails if any fetch did, with the exit code of
+	// the first failure.
+	var failed error
+	if flagFail || flagFailWithBody {
+		n := 0
+		for i := range results {
+			if err := cmp.Or(results[i].Error, responseFailure(&results[i])); err != nil {
+				if n == 0 {
+					failed = err
+				}
+				n++
 			}
-			results[idx] = *res
-		}(i, u)
+		}
+		if n > 0 {
+			failed = &exitError{exitCode(failed), fmt.Errorf("%d of %d fetches failed", n, len(results))}
+		}
 	}
 
-	wg.Wait()
+	if nd != nil {
+		if err := nd.Close(); err != nil {
+			return err
+		}
+		return failed
+	}
 
-	opts := outputOptions{
-		asJSON:       flagJSONOutput,
-		markdown:     flagMarkdown,
-		markdownFull: flagMarkdownFull,
+	filter.apply(results)
+
+	if err := writeParallelOutput(results, store, opts); err != nil {
+		return err
 	}
+	return failed
+}
 
+// writeParallelOutput writes batch results to the store, --out-dir, or
+// the output as JSON or text.
+func writeParallelOutput(results []fetchResult, store *casStore, opts outputOptions) error {
+	if store != nil {
+		return writeParallelStore(store, results, opts)
+	}
+	if flagMirror {
+		return writeMirror(flagOutDir, results)
+	}
+	if flagOutDir != "" {
+		return writeParallelFiles(flagOutDir, results, opts)
+	}
+
+	out, err := openOutput()
+	if err != nil {
+		return err
+	}
 	if opts.asJSON {
-		formatParallelJSON(os.Stdout, results, opts)
+		formatParallelJSON(out, results, opts)
 	} else {
-		formatParallelResults(os.Stdout, results, opts)
+		formatParallelResults(out, results, opts)
+	}
+	return out.Close()
+}
+
+// dedupeAliases rewrites each URL to its known canonical address and drops
+// URLs whose canonical address is already in the batch.
+func dedupeAliases(canon *canonicalMap, items []batchItem) []batchItem {
+	seen := make(map[string]bool)
+	var out []batchItem
+	for _, it := range items {
+		target := it.URL
+		if c, ok := canon.Lookup(it.URL); ok {
+			target = c
+		}
+		key := normalizeURL(target)
+		if seen[key] {
+			if flagVerbose {
+				fmt.Fprintf(os.Stderr, "[*] Skipping %s: alias of %s already in batch\n", it.URL, target)
+			}
+			continue
+		}
+		seen[key] = true
+		it.URL = target
+		out = append(out, it)
 	}
+	return out
+}
 
+// writeParallelFiles writes each successful result to its own file under
+// dir, with a .meta.json sidecar, prints the written paths to stdout and
+// records every result in dir's index.json manifest. Failed fetches are
+// reported on stderr.
+func writeParallelFiles(dir string, results []fetchResult, opts outputOptions) error {
+	ext := outputExt(opts.pipeline)
+	manifest := make([]manifestEntry, 0, len(results))
+	for i := range results {
+		r := &results[i]
+		entry := manifestEntry{URL: r.URL, Status: r.StatusCode, Depth: r.Depth}
+		switch {
+		case r.Error != nil:
+			fmt.Fprintf(os.Stderr, "[!] %s: %v\n", r.URL, r.Error)
+			entry.Error = r.Error.Error()
+		case r.Skipped != "":
+			fmt.Fprintf(os.Stderr, "[-] skipped %s: %s\n", r.URL, r.Skipped)
+			entry.Skipped = r.Skipped
+		default:
+			content := opts.pipeline.run(string(r.Body), r.processInput())
+			path, err := writeOutputFile(dir, r, []byte(content), ext, cmp.Or(r.OutName, flagOutName))
+			if err != nil {
+				return fmt.Errorf("write %s: %w", r.URL, err)
+			}
+			fmt.Fprintln(os.Stdout, path)
+			entry.File = manifestPath(dir, path)
+		}
+		manifest = append(manifest, entry)
+	}
+	if err := writeManifest(dir, manifest); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	return nil
+}
+
+// writeParallelStore puts each successful result into the content-addressed
+// store and prints "<sha256>  <url>" lines to stdout.
+func writeParallelStore(store *casStore, results []fetchResult, opts outputOptions) error {
+	for i := range results {
+		r := &results[i]
+		if r.Error != nil {
+			fmt.Fprintf(os.Stderr, "[!] %s: %v\n", r.URL, r.Error)
+			continue
+		}
+		if r.Skipped != "" {
+			fmt.Fprintf(os.Stderr, "[-] skipped %s: %s\n", r.URL, r.Skipped)
+			continue
+		}
+		content := opts.pipeline.run(string(r.Body), r.processInput())
+		sum, err := store.Put(r, []byte(content))
+		if err != nil {
+			return fmt.Errorf("store %s: %w", r.URL, err)
+		}
+		fmt.Fprintf(os.Stdout, "%s  %s\n", sum, r.URL)
+	}
 	return nil
 }
 
@@ -83,20 +323,8 @@ func runParallelFetch(urls []string) error {
 //
 //	<error message>
 func formatParallelResults(w io.Writer, results []fetchResult, opts outputOptions) {
-	for i, r := range results {
-		if r.Error != nil {
-			fmt.Fprintf(w, "---\n# Error: %s\n---\n\n%s\n", r.URL, r.Error.Error())
-		} else {
-			content := string(r.Body)
-			if opts.markdown || opts.markdownFull {
-				readerMode := opts.markdown
-				md, err := htmlToMarkdown(content, r.URL, readerMode)
-				if err == nil {
-					content = md
-				}
-			}
-			fmt.Fprintf(w, "---\n# Page: %s\nurl: %s\n---\n\n%s\n", r.URL, r.URL, content)
-		}
+	for i := range results {
+		io.WriteString(w, formatParallelResult(&results[i], opts))
 		// Add a blank line between results (but not after the last one).
 		if i < len(results)-1 {
 			fmt.Fprintln(w)
@@ -104,42 +332,96 @@ func formatParallelResults(w io.Writer, results []fetchResult, opts outputOption
 	}
 }
 
+// formatParallelResult returns the text block for one result: its page,
+// error or skip reason under a header naming the URL.
+func formatParallelResult(r *fetchResult, opts outputOptions) string {
+	var sb strings.Builder
+	switch {
+	case r.Error != nil:
+		fmt.Fprintf(&sb, "---\n# Error: %s\n%s---\n\n%s\n", r.URL, attemptsLine(r), r.Error.Error())
+	case r.Skipped != "":
+		fmt.Fprintf(&sb, "---\n# Skipped: %s\n---\n\n%s\n", r.URL, r.Skipped)
+	default:
+		in := r.processInput()
+		content := opts.display(opts.pipeline.run(string(r.Body), in), in)
+		fmt.Fprintf(&sb, "---\n# Page: %s\nurl: %s\n%s---\n\n", r.URL, r.URL, attemptsLine(r))
+		if opts.include && r.resp != nil {
+			writeResponseHead(&sb, r.resp)
+		}
+		fmt.Fprintf(&sb, "%s\n", content)
+	}
+	return sb.String()
+}
+
 // parallelJSONEntry represents a single result in the JSON array output.
 type parallelJSONEntry struct {
-	URL     string              `json:"url"`
-	Status  int                 `json:"status"`
-	Headers map[string][]string `json:"headers,omitempty"`
-	Body    string              `json:"body,omitempty"`
-	Error   string              `json:"error,omitempty"`
+	URL         string              `json:"url"`
+	Status      int                 `json:"status"`
+	Headers     map[string][]string `json:"headers,omitempty"`
+	Body        string              `json:"body,omitempty"`
+	Error       string              `json:"error,omitempty"`
+	Skipped     string              `json:"skipped,omitempty"`
+	Vars        map[string]string   `json:"vars,omitempty"`
+	Depth       int                 `json:"depth,omitempty"`
+	Freshness   *freshnessInfo      `json:"freshness,omitempty"`
+	Truncated   bool                `json:"truncated,omitempty"`
+	Challenge   string              `json:"challenge,omitempty"`
+	Trailers    map[string][]string `json:"trailers,omitempty"`
+	ContentType *contentTypeInfo    `json:"content_type,omitempty"`
+	SHA256      string              `json:"sha256,omitempty"`
+	// ContentLength is a pointer so failed results leave it out.
+	ContentLength *int         `json:"content_length,omitempty"`
+	FinalURL      string       `json:"final_url,omitempty"`
+	Protocol      string       `json:"protocol,omitempty"`
+	Timing        *fetchTiming `json:"timing,omitempty"`
+	// Attempts is how many fetches the result took, with --retry-failed.
+	Attempts int `json:"attempts,omitempty"`
 }
 
 // formatParallelJSON outputs a JSON array of result objects.
 // Each object has url, status, headers, body, and error fields.
 func formatParallelJSON(w io.Writer, results []fetchResult, opts outputOptions) {
 	entries := make([]parallelJSONEntry, len(results))
-	for i, r := range results {
-		entry := parallelJSONEntry{
-			URL:    r.URL,
-			Status: r.StatusCode,
-		}
-		if r.Error != nil {
-			entry.Error = r.Error.Error()
-		} else {
-			entry.Headers = r.Headers
-			content := string(r.Body)
-			if opts.markdown || opts.markdownFull {
-				readerMode := opts.markdown
-				md, err := htmlToMarkdown(content, r.URL, readerMode)
-				if err == nil {
-					content = md
-				}
-			}
-			entry.Body = content
-		}
-		entries[i] = entry
+	for i := range results {
+		entries[i] = newParallelJSONEntry(&results[i], opts)
 	}
 
 	enc := json.NewEncoder(w)
 	enc.SetIndent("", "  ")
 	enc.Encode(entries)
 }
+
+// newParallelJSONEntry builds the JSON object for one result, running its
+// body through the output pipeline.
+func newParallelJSONEntry(r *fetchResult, opts outputOptions) parallelJSONEntry {
+	entry := parallelJSONEntry{
+		URL:      r.URL,
+		Status:   r.StatusCode,
+		Vars:     r.Vars,
+		Depth:    r.Depth,
+		Attempts: r.Attempts,
+	}
+	if r.Error != nil {
+		entry.Error = r.Error.Error()
+	} else if r.Skipped != "" {
+		entry.Skipped = r.Skipped
+	} else {
+		entry.Headers = r.Headers
+		entry.Freshness = computeFreshness(r.Headers, r.FetchedAt.Add(r.Elapsed))
+		entry.Truncated = r.Truncated
+		entry.Challenge = r.Challenge
+		if r.resp != nil {
+			entry.Trailers = r.resp.Trailer
+			entry.Protocol = r.resp.Proto
+		}
+		entry.SHA256 = bodySHA256(r.Body)
+		size := len(r.Body)
+		entry.ContentLength = &size
+		entry.FinalURL = r.finalURL()
+		entry.Timing = r.Timing
+		in := r.processInput()
+		entry.ContentType = &in.contentType
+		entry.Body = opts.pipeline.run(string(r.Body), in)
+	}
+	return entry
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// pdfToText extracts the text of a PDF, with pages separated by a blank
+// line. Pages whose content can't be decoded are skipped; a PDF with no
+// extractable text at all, such as a scan, is an error.
+func pdfToText(body []byte) (text string, err error) {
+	// The PDF reader panics on some malformed files.
+	defer func() {
+		if r := recover(); r != nil {
+			text, err = "", fmt.Errorf("read PDF: %v", r)
+		}
+	}()
+
+	r, err := pdf.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return "", fmt.Errorf("read PDF: %w", err)
+	}
+	var pages []string
+	for i := 1; i <= r.NumPage(); i++ {
+		if text := pdfPageText(r.Page(i)); text != "" {
+			pages = append(pages, text)
+		}
+	}
+	if len(pages) == 0 {
+		return "", fmt.Errorf("no text in PDF (scanned or image-only?)")
+	}
+	return strings.Join(pages, "\n\n") + "\n", nil
+}
+
+// pdfPageText lays out the glyphs of a page as lines of text. PDFs place
+// glyphs by position rather than storing words and lines, so a change of
+// baseline starts a new line and a horizontal gap wider than a fraction of
+// the font size becomes a space. A page that can't be decoded is empty.
+func pdfPageText(p pdf.Page) (text string) {
+	defer func() {
+		if r := recover(); r != nil {
+			text = ""
+		}
+	}()
+	if p.V.IsNull() {
+		return ""
+	}
+
+	var lines []string
+	var line strings.Builder
+	var lastY, lastEnd float64
+	flush := func() {
+		// Glyphs missing from the font's encoding come out as U+FFFD.
+		l := strings.ReplaceAll(line.String(), "\uFFFD", "")
+		if l = strings.Join(strings.Fields(l), " "); l != "" {
+			lines = append(lines, l)
+		}
+		line.Reset()
+	}
+	for i, t := range p.Content().Text {
+		size := math.Abs(t.FontSize)
+		switch {
+		case i > 0 && math.Abs(t.Y-lastY) > size/2:
+			flush()
+		case i > 0 && t.X-lastEnd > size*0.15:
+			line.WriteByte(' ')
+		}
+		line.WriteString(t.S)
+		lastY, lastEnd = t.Y, t.X+t.W
+	}
+	flush()
+	return strings.Join(lines, "\n")
+}
+
+// isPDF reports whether in is a PDF response, by type or by its %PDF- header.
+func (in processInput) isPDF() bool {
+	return in.contentType.Effective == "application/pdf" || bytes.HasPrefix(in.rawBody, []byte("%PDF-"))
+}
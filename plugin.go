@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+)
+
+// pluginDescribeTimeout bounds a plugin's answer to the describe message.
+const pluginDescribeTimeout = 5 * time.Second
+
+// Solver plugins are executables that speak JSON over stdio, one message
+// per value:
+//
+//   - Loading a plugin runs it once with {"type":"describe"} on stdin; it
+//     answers {"type":"describe","name":...,"detect":{...}} with the
+//     rules that pick out its challenge, so detection never runs it.
+//   - Solving runs it again with {"type":"solve",...} describing the
+//     challenged response. The plugin may then send {"type":"fetch",
+//     "id":N,...} requests, each answered with a {"type":"response",
+//     "id":N,...} made through ghostfetch's transport, and finishes with
+//     {"type":"result","cookies":[...],"headers":[...]} or
+//     {"type":"error","error":"..."}.
+
+// pluginMessage is every message of the plugin protocol; Type says which
+// fields are set.
+type pluginMessage struct {
+	Type string `json:"type"`
+	// describe
+	Name   string        `json:"name,omitempty"`
+	Detect *pluginDetect `json:"detect,omitempty"`
+	// solve, fetch and response
+	ID      int            `json:"id,omitempty"`
+	Method  string         `json:"method,omitempty"`
+	URL     string         `json:"url,omitempty"`
+	Status  int            `json:"status,omitempty"`
+	Headers [][2]string    `json:"headers,omitempty"`
+	Body    string         `json:"body,omitempty"`
+	Cookies []pluginCookie `json:"cookies,omitempty"`
+	Profile string         `json:"profile,omitempty"`
+	Error   string         `json:"error,omitempty"`
+}
+
+type pluginCookie struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Domain string `json:"domain,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+// pluginDetect is a plugin's detection rule: the status must be one of
+// Status (any, if empty), and the body must contain one of Body, or a
+// header named in Headers must contain its value (any value, if empty),
+// or the response must set one of Cookies.
+type pluginDetect struct {
+	Status  []int             `json:"status,omitempty"`
+	Body    []string          `json:"body,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Cookies []string          `json:"cookies,omitempty"`
+}
+
+func (d *pluginDetect) match(resp *http.Response, body []byte) bool {
+	if len(d.Status) > 0 && !slices.Contains(d.Status, resp.StatusCode) {
+		return false
+	}
+	for _, s := range d.Body {
+		if bytes.Contains(body, []byte(s)) {
+			return true
+		}
+	}
+	for name, want := range d.Headers {
+		for _, v := range resp.Header.Values(name) {
+			if strings.Contains(strings.ToLower(v), strings.ToLower(want)) {
+				return true
+			}
+		}
+	}
+	for _, c := range resp.Cookies() {
+		if slices.Contains(d.Cookies, c.Name) {
+			return true
+		}
+	}
+	return false
+}
+
+// execSolver is a Solver backed by a plugin executable.
+type execSolver struct {
+	path   string
+	name   string
+	detect pluginDetect
+}
+
+// loadSolverPlugins describes each plugin executable and makes them the
+// plugin solvers for this invocation.
+func loadSolverPlugins(paths []string) error {
+	pluginSolvers = nil
+	for _, path := range paths {
+		s, err := loadSolverPlugin(path)
+		if err != nil {
+			return fmt.Errorf("solver plugin %s: %w", path, err)
+		}
+		pluginSolvers = append(pluginSolvers, s)
+	}
+	return nil
+}
+
+func loadSolverPlugin(path string) (*execSolver, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pluginDescribeTimeout)
+	defer cancel()
+	var out bytes.Buffer
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Stdin = strings.NewReader(`{"type":"describe"}` + "\n")
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	var msg pluginMessage
+	if err := json.NewDecoder(&out).Decode(&msg); err != nil {
+		return nil, fmt.Errorf("bad describe answer: %w", err)
+	}
+	if msg.Type != "describe" || msg.Name == "" || msg.Detect == nil {
+		return nil, fmt.Errorf(`describe answer needs "type":"describe", a name and a detect rule`)
+	}
+	if d := msg.Detect; len(d.Body) == 0 && len(d.Headers) == 0 && len(d.Cookies) == 0 {
+		return nil, fmt.Errorf("detect rule needs body, headers or cookies")
+	}
+	return &execSolver{path: path, name: msg.Name, detect: *msg.Detect}, nil
+}
+
+func (s *execSolver) Name() string { return s.name }
+
+func (s *execSolver) Detect(resp *http.Response, body []byte) bool {
+	return s.detect.match(resp, body)
+}
+
+// Solve runs the plugin on the challenge and serves its fetch requests
+// until it sends a result. Bodies travel as JSON strings, so binary
+// content doesn't survive the trip.
+func (s *execSolver) Solve(ctx context.Context, f *Fetcher, resp *http.Response, body []byte) (*Solution, error) {
+	cmd := exec.CommandContext(ctx, s.path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = io.Discard
+	if f.verbose {
+		cmd.Stderr = os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	defer cmd.Wait()
+	defer stdin.Close()
+
+	enc := json.NewEncoder(stdin)
+	dec := json.NewDecoder(stdout)
+	if err := enc.Encode(pluginMessage{
+		Type:    "solve",
+		URL:     resp.Request.URL.String(),
+		Status:  resp.StatusCode,
+		Headers: headerPairs(resp.Header),
+		Body:    string(body),
+		Cookies: toPluginCookies(f.Cookies()),
+		Profile: f.profile.Name,
+	}); err != nil {
+		return nil, fmt.Errorf("send challenge: %w", err)
+	}
+
+	for {
+		var msg pluginMessage
+		if err := dec.Decode(&msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, fmt.Errorf("plugin exited without a result")
+			}
+			return nil, fmt.Errorf("read plugin message: %w", err)
+		}
+		switch msg.Type {
+		case "fetch":
+			reply := s.fetch(ctx, f, msg)
+			if err := enc.Encode(reply); err != nil {
+				return nil, fmt.Errorf("send response: %w", err)
+			}
+		case "result":
+			return &Solution{Cookies: fromPluginCookies(msg.Cookies), Headers: msg.Headers}, nil
+		case "error":
+			return nil, fmt.Errorf("%s", msg.Error)
+		default:
+			return nil, fmt.Errorf("unexpected plugin message type %q", msg.Type)
+		}
+	}
+}
+
+// fetch makes a plugin's request and builds the response message. Only
+// GET and POST are allowed, as for the built-in solvers.
+func (s *execSolver) fetch(ctx context.Context, f *Fetcher, msg pluginMessage) pluginMessage {
+	reply := pluginMessage{Type: "response", ID: msg.ID}
+	method := strings.ToUpper(msg.Method)
+	if method == "" {
+		method = "GET"
+	}
+	if method != "GET" && method != "POST" {
+		reply.Error = fmt.Sprintf("method %s not allowed", method)
+		return reply
+	}
+	resp, body, err := f.Fetch(ctx, method, msg.URL, msg.Headers, msg.Body)
+	if err != nil {
+		reply.Error = err.Error()
+		return reply
+	}
+	reply.URL = resp.Request.URL.String()
+	reply.Status = resp.StatusCode
+	reply.Headers = headerPairs(resp.Header)
+	reply.Body = string(body)
+	reply.Cookies = toPluginCookies(redirectCookies(resp))
+	return reply
+}
+
+// headerPairs flattens h into name/value pairs, sorted by name.
+func headerPairs(h http.Header) [][2]string {
+	var pairs [][2]string
+	for _, name := range slices.Sorted(maps.Keys(h)) {
+		for _, v := range h[name] {
+			pairs = append(pairs, [2]string{name, v})
+		}
+	}
+	return pairs
+}
+
+func toPluginCookies(cookies []*http.Cookie) []pluginCookie {
+	var out []pluginCookie
+	for _, c := range cookies {
+		out = append(out, pluginCookie{Name: c.Name, Value: c.Value, Domain: c.Domain, Path: c.Path})
+	}
+	return out
+}
+
+func fromPluginCookies(cookies []pluginCookie) []*http.Cookie {
+	var out []*http.Cookie
+	for _, c := range cookies {
+		out = append(out, &http.Cookie{Name: c.Name, Value: c.Value, Domain: c.Domain, Path: c.Path})
+	}
+	return out
+}
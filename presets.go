@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// builtinPresets bundle flag values for common personas. A preset only
+// fills in flags the user didn't set, and the config file's "presets"
+// section can override these values or define new presets.
+var builtinPresets = map[string]map[string]string{
+	// agent: compact reader-mode markdown for LLM context windows, with
+	// tight timeouts and a body cap so a slow or huge response can't
+	// stall a tool call or exhaust memory.
+	"agent": {
+		"process":           "readability,truncate:20000",
+		"timeout":           "20s",
+		"read-timeout":      "10s",
+		"max-body-size":     "5MB",
+		"max-body-truncate": "true",
+	},
+	// archiver: untouched bodies saved with their assets, polite
+	// concurrency (one request at a time per host, paced in crawls), and
+	// alias tracking so the same page isn't archived twice under
+	// different URLs.
+	"archiver": {
+		"raw":           "true",
+		"mirror":        "true",
+		"max-parallel":  "2",
+		"per-host":      "1",
+		"delay":         "1s",
+		"canonical-map": "true",
+	},
+	// monitor: machine-readable status checks that fail fast, exit
+	// non-zero on an error status or a challenge, and don't depend on (or
+	// disturb) the cookie jar.
+	"monitor": {
+		"json":            "true",
+		"fail":            "true",
+		"timeout":         "15s",
+		"connect-timeout": "5s",
+		"no-cookies":      "true",
+	},
+}
+
+// presetNeeds maps flags to the flag they only work with: a preset sets
+// them only when that one is set, so archiver still prints to stdout
+// without --out-dir.
+var presetNeeds = map[string]string{
+	"mirror": "out-dir",
+}
+
+// resolvePreset returns the flag values for a preset: the built-in values
+// overlaid with the config file's.
+func resolvePreset(name string) (map[string]string, error) {
+	builtin, okBuiltin := builtinPresets[name]
+	custom, okCustom := appConfig.Presets[name]
+	if !okBuiltin && !okCustom {
+		return nil, fmt.Errorf("unknown preset %q (available: %s)", name, strings.Join(presetNames(), ", "))
+	}
+	values := make(map[string]string, len(builtin)+len(custom))
+	for k, v := range builtin {
+		values[k] = v
+	}
+	for k, v := range custom {
+		values[k] = v
+	}
+	return values, nil
+}
+
+// presetNames lists built-in and configured presets.
+func presetNames() []string {
+	seen := make(map[string]bool)
+	for n := range builtinPresets {
+		seen[n] = true
+	}
+	for n := range appConfig.Presets {
+		seen[n] = true
+	}
+	names := make([]string, 0, len(seen))
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// outputModeFlags choose how the body is processed. They interact (e.g.
+// --process beats -m), so a preset sets none of them if the user chose
+// any one explicitly.
+var outputModeFlags = []string{"process", "markdown", "markdown-full", "raw"}
+
+// applyPreset sets the --preset's flag values on cmd for every flag the
+// user didn't pass explicitly. Flags the command doesn't have (e.g.
+// max-parallel outside fetch) are ignored.
+func applyPreset(cmd *cobra.Command) error {
+	if flagPreset == "" {
+		return nil
+	}
+	values, err := resolvePreset(flagPreset)
+	if err != nil {
+		return err
+	}
+	flags := cmd.Flags()
+	userOutputMode := false
+	for _, name := range outputModeFlags {
+		if f := flags.Lookup(name); f != nil && f.Changed {
+			userOutputMode = true
+		}
+	}
+	for name, value := range values {
+		f := flags.Lookup(name)
+		if f == nil || f.Changed {
+			continue
+		}
+		if userOutputMode && slices.Contains(outputModeFlags, name) {
+			continue
+		}
+		if need, ok := presetNeeds[name]; ok {
+			if nf := flags.Lookup(need); nf == nil || !nf.Changed {
+				continue
+			}
+		}
+		if err := flags.Set(name, value); err != nil {
+			return fmt.Errorf("preset %s: --%s=%s: %w", flagPreset, name, value, err)
+		}
+	}
+	return nil
+}
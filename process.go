@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// processInput carries the response metadata a processor may need in
+// addition to the content produced by the previous stage.
+type processInput struct {
+	pageURL string
+	status  int
+	headers http.Header
+	rawBody []byte
+	// contentType is the effective media type after sniffing; markdown
+	// conversion is skipped for bodies known not to be HTML.
+	contentType contentTypeInfo
+}
+
+// newProcessInput builds the pipeline input for a response, sniffing the
+// effective content type from the body.
+func newProcessInput(pageURL string, status int, headers http.Header, body []byte) processInput {
+	return processInput{
+		pageURL:     pageURL,
+		status:      status,
+		headers:     headers,
+		rawBody:     body,
+		contentType: sniffContentType(headers, body),
+	}
+}
+
+// errNotHTML makes HTML-only stages skip bodies of another type.
+var errNotHTML = errors.New("content is not HTML")
+
+// notHTML reports whether the body is known to be something other than
+// HTML. Unknown types are still converted, as before sniffing existed.
+func (in processInput) notHTML() bool {
+	t := in.contentType.Effective
+	return t != "" && !isHTMLType(t) && !strings.HasPrefix(t, "text/")
+}
+
+// processorFunc transforms content as one stage of the output pipeline.
+type processorFunc func(content string, in processInput) (string, error)
+
+// processStep is a single parsed pipeline stage such as "truncate:4000".
+type processStep struct {
+	name string
+	arg  string
+	fn   processorFunc
+}
+
+// pipeline is an ordered list of processors applied to a response body.
+type pipeline []processStep
+
+// processors is the registry of available pipeline stages. Each factory
+// receives the text after the colon (empty if none) and returns the stage.
+var processors = map[string]func(arg string) (processorFunc, error){
+	"readability": func(arg string) (processorFunc, error) {
+		return func(content string, in processInput) (string, error) {
+			if in.isPDF() {
+				return pdfToText(in.rawBody)
+			}
+			if in.notHTML() {
+				return "", errNotHTML
+			}
+			return htmlToMarkdown(content, in.pageURL, true)
+		}, nil
+	},
+	"markdown": func(arg string) (processorFunc, error) {
+		return func(content string, in processInput) (string, error) {
+			if in.isPDF() {
+				return pdfToText(in.rawBody)
+			}
+			if in.notHTML() {
+				return "", errNotHTML
+			}
+			return htmlToMarkdown(content, in.pageURL, false)
+		}, nil
+	},
+	"strip-links": func(arg string) (processorFunc, error) {
+		return func(content string, in processInput) (string, error) {
+			return stripLinks(content), nil
+		}, nil
+	},
+	"truncate": func(arg string) (processorFunc, error) {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("truncate needs a positive character count, e.g. truncate:4000")
+		}
+		return func(content string, in processInput) (string, error) {
+			return truncateRunes(content, n), nil
+		}, nil
+	},
+	"max-chars": func(arg string) (processorFunc, error) {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("max-chars needs a positive character count, e.g. max-chars:8000")
+		}
+		return func(content string, in processInput) (string, error) {
+			return truncateAtBoundary(content, n), nil
+		}, nil
+	},
+	"max-tokens": func(arg string) (processorFunc, error) {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("max-tokens needs a positive token count, e.g. max-tokens:2000")
+		}
+		return func(content string, in processInput) (string, error) {
+			return truncateAtBoundary(content, n*charsPerToken), nil
+		}, nil
+	},
+	"frontmatter": func(arg string) (processorFunc, error) {
+		return func(content string, in processInput) (string, error) {
+			return frontmatter(in) + content, nil
+		}, nil
+	},
+}
+
+// parsePipeline parses processor specs of the form "name" or "name:arg".
+// Each spec may itself be a comma-separated list.
+func parsePipeline(specs []string) (pipeline, error) {
+	var p pipeline
+	for _, spec := range specs {
+		for _, part := range strings.Split(spec, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, arg, _ := strings.Cut(part, ":")
+			factory, ok := processors[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown processor %q", name)
+			}
+			fn, err := factory(arg)
+			if err != nil {
+				return nil, fmt.Errorf("processor %q: %w", part, err)
+			}
+			p = append(p, processStep{name: name, arg: arg, fn: fn})
+		}
+	}
+	return p, nil
+}
+
+// run applies each stage in order. A stage that fails is skipped and the
+// content from the previous stage is passed on unchanged, matching the
+// old behavior of falling back to raw HTML when conversion failed.
+func (p pipeline) run(content string, in processInput) string {
+	for _, step := range p {
+		out, err := step.fn(content, in)
+		if err != nil {
+			continue
+		}
+		content = out
+	}
+	return content
+}
+
+// markdownLinkRe matches markdown links and images: [text](url) and ![alt](src).
+var markdownLinkRe = regexp.MustCompile(`!?\[([^\]]*)\]\([^)]*\)`)
+
+// stripLinks replaces markdown links with their text, dropping the URLs.
+func stripLinks(content string) string {
+	return markdownLinkRe.ReplaceAllString(content, "$1")
+}
+
+// truncateRunes cuts content to at most n characters.
+func truncateRunes(content string, n int) string {
+	runes := []rune(content)
+	if len(runes) <= n {
+		return content
+	}
+	return string(runes[:n])
+}
+
+// charsPerToken is the rough number of characters per LLM token in
+// English prose, used to turn a token budget into a character budget.
+const charsPerToken = 4
+
+// truncationNotice ends content cut by truncateAtBoundary.
+const truncationNotice = "\n\n[... truncated: %d of %d characters shown]\n"
+
+// truncateAtBoundary cuts content to at most n characters, notice
+// included. The cut goes at the last paragraph break (which also comes
+// before every heading) in the second half of the budget, else at the last
+// line break or space, so no word or paragraph is split when it can be
+// avoided. A code block left open by the cut is closed, and a notice with
+// the number of characters kept is appended.
+func truncateAtBoundary(content string, n int) string {
+	runes := []rune(content)
+	if len(runes) <= n {
+		return content
+	}
+	// Reserve room for the notice and a closing code fence.
+	budget := n - len(fmt.Sprintf(truncationNotice, len(runes), len(runes))) - len("\n```")
+	if budget <= 0 {
+		return truncateRunes(content, n)
+	}
+
+	cut := string(runes[:budget])
+	for _, sep := range []string{"\n\n", "\n", " "} {
+		if i := strings.LastIndex(cut, sep); i >= len(cut)/2 {
+			cut = cut[:i]
+			break
+		}
+	}
+	cut = strings.TrimRight(cut, " \t\n")
+	if strings.Count(cut, "```")%2 == 1 {
+		cut += "\n```"
+	}
+	return cut + fmt.Sprintf(truncationNotice, len([]rune(cut)), len(runes))
+}
+
+// titleRe matches the contents of the first <title> element.
+var titleRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+
+// frontmatter renders a YAML front matter block describing the page.
+func frontmatter(in processInput) string {
+	var sb strings.Builder
+	sb.WriteString("---\n")
+	if in.pageURL != "" {
+		sb.WriteString(fmt.Sprintf("url: %s\n", in.pageURL))
+	}
+	if m := titleRe.FindSubmatch(in.rawBody); m != nil {
+		title := strings.TrimSpace(string(m[1]))
+		if title != "" {
+			sb.WriteString(fmt.Sprintf("title: %s\n", strconv.Quote(title)))
+		}
+	}
+	if in.status != 0 {
+		sb.WriteString(fmt.Sprintf("status: %d\n", in.status))
+	}
+	if ct := in.contentType.Effective; ct != "" {
+		sb.WriteString(fmt.Sprintf("content_type: %s\n", ct))
+	}
+	sb.WriteString("---\n\n")
+	return sb.String()
+}
+
+// resolvePipeline builds the output pipeline from flags and config.
+// An explicit --process list wins; otherwise --markdown and --markdown-full
+// map to "readability" and "markdown"; otherwise the config default is used.
+// --max-chars and --max-tokens add their stages at the end and --jq its
+// stage at the start.
+func resolvePipeline() (pipeline, error) {
+	if err := resolveMarkdownConfig().validate(); err != nil {
+		return nil, err
+	}
+	specs := flagProcess
+	if len(specs) == 0 {
+		switch {
+		case flagMarkdown:
+			specs = []string{"readability"}
+		case flagMarkdownFull:
+			specs = []string{"markdown"}
+		}
+	}
+	if len(specs) == 0 && !flagRaw {
+		specs = appConfig.Process
+	}
+	var limits []string
+	if flagMaxChars > 0 || flagMaxTokens > 0 {
+		// A budget is for converted text: without an output mode it
+		// implies reader mode.
+		if len(specs) == 0 && !flagRaw {
+			specs = []string{"readability"}
+		}
+		if flagMaxChars > 0 {
+			limits = append(limits, fmt.Sprintf("max-chars:%d", flagMaxChars))
+		}
+		if flagMaxTokens > 0 {
+			limits = append(limits, fmt.Sprintf("max-tokens:%d", flagMaxTokens))
+		}
+	}
+	p, err := parsePipeline(specs)
+	if err != nil {
+		return nil, err
+	}
+	// Like --jq, the pattern can't be a --process spec. It runs on the
+	// converted text, before any budget cuts it.
+	if flagGrep != "" {
+		fn, err := grepProcessor(flagGrep, flagGrepOnly)
+		if err != nil {
+			return nil, err
+		}
+		p = append(p, processStep{name: "grep", arg: flagGrep, fn: fn})
+	} else if flagGrepOnly {
+		return nil, fmt.Errorf("--grep-only needs --grep")
+	}
+	tail, err := parsePipeline(limits)
+	if err != nil {
+		return nil, err
+	}
+	p = append(p, tail...)
+	if flagJQ == "" {
+		return p, nil
+	}
+	// The filter can't be a --process spec, as jq uses commas itself. It
+	// runs first, on the JSON as received.
+	fn, err := jqProcessor(flagJQ, flagJQRaw)
+	if err != nil {
+		return nil, err
+	}
+	return append(pipeline{{name: "jq", arg: flagJQ, fn: fn}}, p...), nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// redirectPolicy decides which redirects a fetch follows.
+type redirectPolicy struct {
+	// max is the most redirects followed in a row; one more is an error.
+	max int
+	// scope is "any", "same-host", "same-domain" (same registrable
+	// domain, e.g. www.example.com to docs.example.com) or "none".
+	scope string
+	// noDowngrade refuses redirects from https to http.
+	noDowngrade bool
+	verbose     bool
+}
+
+// defaultRedirectPolicy follows up to 10 redirects anywhere.
+var defaultRedirectPolicy = redirectPolicy{max: 10, scope: "any"}
+
+// resolveRedirectPolicy returns the policy set by the flags.
+func resolveRedirectPolicy() redirectPolicy {
+	p := redirectPolicy{
+		max:         flagMaxRedirs,
+		scope:       flagRedirectPolicy,
+		noDowngrade: flagNoHTTPSDowngrade,
+		verbose:     flagVerbose,
+	}
+	if !flagFollowRedirs {
+		p.scope = "none"
+	}
+	return p
+}
+
+// validate checks the scope name and the limit.
+func (p redirectPolicy) validate() error {
+	switch p.scope {
+	case "any", "same-host", "same-domain", "none":
+	default:
+		return fmt.Errorf("invalid redirect policy %q (same-host, same-domain, any, none)", p.scope)
+	}
+	if p.max < 0 {
+		return fmt.Errorf("--max-redirs must be >= 0")
+	}
+	return nil
+}
+
+// checkRedirect is the http.Client CheckRedirect for p. A redirect the
+// policy doesn't allow isn't followed: the redirect response itself is
+// the result. A refused downgrade to http is always reported, other
+// refusals with -v. Going over the limit is an error, as with curl.
+func (p redirectPolicy) checkRedirect(req *http.Request, via []*http.Request) error {
+	from := via[len(via)-1].URL
+	if p.noDowngrade && from.Scheme == "https" && req.URL.Scheme == "http" {
+		fmt.Fprintf(os.Stderr, "[*] Warning: not following redirect from %s to %s: it leaves HTTPS\n", from, req.URL)
+		return http.ErrUseLastResponse
+	}
+	if reason := p.outOfScope(from, req.URL); reason != "" {
+		if p.verbose {
+			fmt.Fprintf(os.Stderr, "[*] Not following redirect to %s: %s\n", req.URL, reason)
+		}
+		return http.ErrUseLastResponse
+	}
+	if len(via) > p.max {
+		return fmt.Errorf("stopped after %d redirects (--max-redirs)", p.max)
+	}
+	return nil
+}
+
+// outOfScope returns why the policy's scope doesn't allow a redirect
+// from one URL to another, or "" if it does.
+func (p redirectPolicy) outOfScope(from, to *url.URL) string {
+	switch p.scope {
+	case "none":
+		return "redirects are off"
+	case "same-host":
+		if !strings.EqualFold(from.Hostname(), to.Hostname()) {
+			return "other host (--redirect-policy same-host)"
+		}
+	case "same-domain":
+		if registrableDomain(from.Hostname()) != registrableDomain(to.Hostname()) {
+			return "other domain (--redirect-policy same-domain)"
+		}
+	}
+	return ""
+}
+
+// registrableDomain returns host's domain under its public suffix
+// ("example.co.uk" for "www.example.co.uk"), or host itself for IPs and
+// names without one.
+func registrableDomain(host string) string {
+	host = strings.ToLower(host)
+	if d, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return d
+	}
+	return host
+}
+
+type redirectPolicyKey struct{}
+
+// withRedirectPolicy returns ctx carrying p, for every request made with it.
+func withRedirectPolicy(ctx context.Context, p redirectPolicy) context.Context {
+	return context.WithValue(ctx, redirectPolicyKey{}, p)
+}
+
+// redirectPolicyFrom returns the policy carried by ctx, or the default.
+func redirectPolicyFrom(ctx context.Context) redirectPolicy {
+	if p, ok := ctx.Value(redirectPolicyKey{}).(redirectPolicy); ok {
+		return p
+	}
+	return defaultRedirectPolicy
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"os"
+	"sync"
+
+	"github.com/charmbracelet/glamour"
+	"golang.org/x/term"
+)
+
+// maxRenderWidth caps the wrap width of rendered markdown; long lines are
+// hard to read on wide terminals.
+const maxRenderWidth = 120
+
+// renderInTerminal reports whether markdown output is rendered with
+// styling: markdown is going to stdout, stdout is a terminal and --plain
+// isn't set.
+func renderInTerminal() bool {
+	if flagPlain || flagJSONOutput || flagGzipOutput || outputMode() != "markdown" {
+		return false
+	}
+	if flagOutput != "" && flagOutput != "-" {
+		return false
+	}
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+var (
+	rendererOnce sync.Once
+	renderer     *glamour.TermRenderer
+)
+
+// renderMarkdown styles md for the terminal: headings, emphasis, links,
+// lists and syntax-highlighted code blocks, wrapped to the terminal
+// width. The style follows the terminal's background, or GLAMOUR_STYLE
+// (e.g. "dark", "light", "notty"). md is returned unchanged if rendering
+// fails.
+func renderMarkdown(md string) string {
+	rendererOnce.Do(func() {
+		width := maxRenderWidth
+		if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 {
+			width = min(w, maxRenderWidth)
+		}
+		renderer, _ = glamour.NewTermRenderer(glamour.WithEnvironmentConfig(), glamour.WithWordWrap(width))
+	})
+	if renderer == nil {
+		return md
+	}
+	out, err := renderer.Render(md)
+	if err != nil {
+		return md
+	}
+	return out
+}
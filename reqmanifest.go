@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// manifestRequest is one entry of a --manifest file: a URL and the
+// options it is fetched with instead of the command line's.
+type manifestRequest struct {
+	URL string `json:"url" yaml:"url"`
+	// Name is the file name the page is written to under --out-dir,
+	// instead of one derived from the URL or --out-name.
+	Name    string `json:"name,omitempty" yaml:"name,omitempty"`
+	Browser string `json:"browser,omitempty" yaml:"browser,omitempty"`
+	Accept  string `json:"accept,omitempty" yaml:"accept,omitempty"`
+	Timeout string `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	// Method, Headers and Body are only read to refuse them: ghostfetch
+	// sends GET requests without custom headers or a body (see Security
+	// in the README).
+	Method  string            `json:"method,omitempty" yaml:"method,omitempty"`
+	Headers map[string]string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	Body    any               `json:"body,omitempty" yaml:"body,omitempty"`
+}
+
+// loadManifest reads a --manifest file, a JSON array of requests or, for
+// .yaml and .yml files, a YAML list, and returns its entries as batch
+// items. Unknown fields are errors, so a misspelt option isn't silently
+// dropped.
+func loadManifest(path string) ([]batchItem, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var reqs []manifestRequest
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &reqs)
+	default:
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&reqs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse manifest %s: %w", path, err)
+	}
+
+	items := make([]batchItem, 0, len(reqs))
+	for i, r := range reqs {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("manifest %s, entry %d: %w", path, i+1, err)
+		}
+		items = append(items, batchItem{
+			URL:     r.URL,
+			Name:    r.Name,
+			Browser: r.Browser,
+			Accept:  r.Accept,
+			Timeout: r.Timeout,
+		})
+	}
+	return items, nil
+}
+
+// validate checks a manifest entry before anything is fetched.
+func (r manifestRequest) validate() error {
+	switch {
+	case r.URL == "":
+		return fmt.Errorf("no url")
+	case r.Method != "" && !strings.EqualFold(r.Method, "GET"):
+		return fmt.Errorf("method %s: only GET requests are sent", r.Method)
+	case len(r.Headers) > 0:
+		return fmt.Errorf("headers: custom request headers aren't supported")
+	case r.Body != nil:
+		return fmt.Errorf("body: request bodies aren't supported")
+	}
+	if r.Timeout != "" {
+		if _, err := time.ParseDuration(r.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout %q: %w", r.Timeout, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strconv"
+	"time"
+)
+
+// retryFailedDelay is the pause before the first --retry-failed round;
+// each further round waits one more of it.
+const retryFailedDelay = 2 * time.Second
+
+// retryable reports whether a batch result is worth fetching again with
+// --retry-failed: the fetch failed for another reason than an unknown
+// host, an anti-bot challenge is still in place, or the server answered
+// 429 or 5xx.
+func retryable(r *fetchResult) bool {
+	if r.StatusCode == 0 {
+		return r.Error != nil && exitCode(r.Error) != exitDNS
+	}
+	return r.Challenge != "" || r.StatusCode == 429 || r.StatusCode >= 500
+}
+
+// attemptsLine returns the "attempts: N" header line of a result that was
+// fetched more than once, or "".
+func attemptsLine(r *fetchResult) string {
+	if r.Attempts < 2 {
+		return ""
+	}
+	return "attempts: " + strconv.Itoa(r.Attempts) + "\n"
+}
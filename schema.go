@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// schemaContext is the @context given to microdata items with a
+// schema.org itemtype.
+const schemaContext = "https://schema.org"
+
+// extractStructuredData returns the structured data items of an HTML page:
+// every JSON-LD object (with @graph containers and top-level arrays
+// flattened, each item keeping its @context) followed by every top-level
+// microdata item converted to the same JSON-LD shape. Blocks that aren't
+// valid JSON are reported on stderr and skipped.
+func extractStructuredData(body []byte, pageURL string) []map[string]any {
+	doc, err := html.Parse(strings.NewReader(string(body)))
+	if err != nil {
+		return nil
+	}
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		base = &url.URL{}
+	}
+
+	items := []map[string]any{}
+	for _, n := range filterNodes(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && n.Data == "script" &&
+			strings.EqualFold(strings.TrimSpace(getAttr(n, "type")), "application/ld+json")
+	}) {
+		text := strings.TrimSpace(textContent(n))
+		text = strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(text, "<!--"), "-->"))
+		text = strings.TrimSuffix(text, ";")
+		var v any
+		if err := json.Unmarshal([]byte(text), &v); err != nil {
+			fmt.Fprintf(os.Stderr, "[*] Warning: skipping invalid JSON-LD block: %v\n", err)
+			continue
+		}
+		items = flattenJSONLD(items, v, nil)
+	}
+
+	for _, n := range filterNodes(doc, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && hasAttr(n, "itemscope") && !hasAttr(n, "itemprop")
+	}) {
+		items = append(items, microdataItem(n, base))
+	}
+	return items
+}
+
+// flattenJSONLD appends the objects in a JSON-LD value to items: arrays
+// and @graph containers are unpacked, and an object without its own
+// @context gets the one of the document it came from.
+func flattenJSONLD(items []map[string]any, v any, context any) []map[string]any {
+	switch v := v.(type) {
+	case []any:
+		for _, e := range v {
+			items = flattenJSONLD(items, e, context)
+		}
+	case map[string]any:
+		if c, ok := v["@context"]; ok {
+			context = c
+		} else if context != nil {
+			v["@context"] = context
+		}
+		if graph, ok := v["@graph"]; ok {
+			return flattenJSONLD(items, graph, context)
+		}
+		items = append(items, v)
+	}
+	return items
+}
+
+// microdataItem converts the microdata item rooted at n (an element with
+// itemscope) into a JSON-LD style object: itemtype becomes @type (short
+// names for schema.org types, with an @context), itemid becomes @id, and
+// each itemprop a property, as an array when it occurs more than once.
+func microdataItem(n *html.Node, base *url.URL) map[string]any {
+	item := map[string]any{}
+	if types := strings.Fields(getAttr(n, "itemtype")); len(types) > 0 {
+		var short []any
+		for _, t := range types {
+			for _, prefix := range []string{"https://schema.org/", "http://schema.org/"} {
+				if s, ok := strings.CutPrefix(t, prefix); ok {
+					item["@context"] = schemaContext
+					t = s
+				}
+			}
+			short = append(short, t)
+		}
+		if len(short) == 1 {
+			item["@type"] = short[0]
+		} else {
+			item["@type"] = short
+		}
+	}
+	if id := getAttr(n, "itemid"); id != "" {
+		item["@id"] = resolveItemURL(base, id)
+	}
+
+	var walk func(*html.Node)
+	walk = func(c *html.Node) {
+		for ; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			if names := strings.Fields(getAttr(c, "itemprop")); len(names) > 0 {
+				var value any
+				if hasAttr(c, "itemscope") {
+					nested := microdataItem(c, base)
+					delete(nested, "@context") // given once, on the top item
+					value = nested
+				} else {
+					value = microdataValue(c, base)
+				}
+				for _, name := range names {
+					addItemProp(item, name, value)
+				}
+			}
+			// A nested item's properties belong to it, not to this one.
+			if !hasAttr(c, "itemscope") {
+				walk(c.FirstChild)
+			}
+		}
+	}
+	walk(n.FirstChild)
+	return item
+}
+
+// microdataValue returns the value of a property element without
+// itemscope, following the HTML microdata rules for which attribute holds
+// it.
+func microdataValue(n *html.Node, base *url.URL) string {
+	switch n.Data {
+	case "meta":
+		return getAttr(n, "content")
+	case "audio", "embed", "iframe", "img", "source", "track", "video":
+		return resolveItemURL(base, getAttr(n, "src"))
+	case "a", "area", "link":
+		return resolveItemURL(base, getAttr(n, "href"))
+	case "object":
+		return resolveItemURL(base, getAttr(n, "data"))
+	case "data", "meter":
+		return getAttr(n, "value")
+	case "time":
+		if dt := getAttr(n, "datetime"); dt != "" {
+			return dt
+		}
+	}
+	return strings.Join(strings.Fields(textContent(n)), " ")
+}
+
+// resolveItemURL resolves a URL-valued property against the page.
+func resolveItemURL(base *url.URL, ref string) string {
+	u, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
+
+// addItemProp adds value under name, turning repeated properties into
+// arrays.
+func addItemProp(item map[string]any, name string, value any) {
+	switch prev := item[name].(type) {
+	case nil:
+		item[name] = value
+	case []any:
+		item[name] = append(prev, value)
+	default:
+		item[name] = []any{prev, value}
+	}
+}
+
+// schemaTypeMatches reports whether an item's @type is typ, comparing
+// schema.org names case-insensitively with or without the schema.org URL.
+func schemaTypeMatches(item map[string]any, typ string) bool {
+	short := func(t string) string {
+		t = strings.TrimPrefix(strings.TrimPrefix(t, "https://schema.org/"), "http://schema.org/")
+		return strings.ToLower(t)
+	}
+	want := short(typ)
+	switch t := item["@type"].(type) {
+	case string:
+		return short(t) == want
+	case []any:
+		for _, e := range t {
+			if s, ok := e.(string); ok && short(s) == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// runSchema fetches a page and prints its JSON-LD and microdata items as
+// a JSON array, optionally only those of the given schema.org types.
+func runSchema(rawURL string, types []string) error {
+	res, err := fetchOne(newFetchOptions(rawURL))
+	if err != nil {
+		return err
+	}
+	in := res.processInput()
+	if in.notHTML() {
+		return fmt.Errorf("%s is not an HTML page (%s)", res.URL, in.contentType.Effective)
+	}
+
+	items := extractStructuredData(res.Body, res.finalURL())
+	if len(types) > 0 {
+		items = slices.DeleteFunc(items, func(item map[string]any) bool {
+			return !slices.ContainsFunc(types, func(t string) bool { return schemaTypeMatches(item, t) })
+		})
+	}
+	if flagVerbose {
+		fmt.Fprintf(os.Stderr, "[*] Structured data: %d item(s)\n", len(items))
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(items)
+}
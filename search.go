@@ -1,58 +1,240 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/net/html"
 )
 
 // searchResult represents a single search result.
 type searchResult struct {
-	Title   string `json:"title"`
-	URL     string `json:"url"`
-	Snippet string `json:"snippet"`
+	// Position is the result's rank in the output, from 1.
+	Position int    `json:"position"`
+	Title    string `json:"title"`
+	URL      string `json:"url"`
+	// DisplayURL is the address as the engine shows it, often shortened
+	// or with a breadcrumb path ("example.com › docs").
+	DisplayURL string `json:"display_url,omitempty"`
+	Snippet    string `json:"snippet"`
+	// Engine is the engine that returned the result (in a search of
+	// several, the one whose title and snippet are shown).
+	Engine string `json:"engine,omitempty"`
+	// Source names a news article's publication. Published is when the
+	// result was published, in RFC 3339 if the engine gives a timestamp
+	// and as the engine writes it otherwise ("Mar 3, 2024").
+	Source    string `json:"source,omitempty"`
+	Published string `json:"published,omitempty"`
+	// Duration and Channel describe a video: its length as the engine
+	// writes it (e.g. "12:34") and who uploaded it.
+	Duration string `json:"duration,omitempty"`
+	Channel  string `json:"channel,omitempty"`
+	// Engines lists the engines that returned the result, in a search
+	// of several engines.
+	Engines []string `json:"engines,omitempty"`
+	// Content is the result page as reader-mode markdown, with
+	// --fetch-results; FetchError says why it couldn't be read.
+	Content    string `json:"content,omitempty"`
+	FetchError string `json:"fetch_error,omitempty"`
+}
+
+// searchParams is what a search asks an engine for.
+type searchParams struct {
+	Query      string
+	MaxResults int
+	// Lang and Region are lowercase ISO 639-1 language and ISO 3166-1
+	// country codes (e.g. "de", "at"), or empty for the engine's default.
+	Lang   string
+	Region string
+	// Since and Before bound the results' dates; zero leaves that end
+	// open.
+	Since  time.Time
+	Before time.Time
+	// Sites limits results to these sites (hosts, optionally with a
+	// path), added to the query as site: operators.
+	Sites []string
+	// FileType, InTitle and ExcludeSites ask for results of a file type,
+	// with words in their title, or not from these sites; see
+	// operatorQuery.
+	FileType     string
+	InTitle      string
+	ExcludeSites []string
+	// LooseDates lets engines without a date filter search without one,
+	// for callers that filter the results by Published themselves.
+	LooseDates bool
+}
+
+// searchPage is one page of an engine's results, with the engine's
+// estimate of how many results there are in all, or 0 if it gives none.
+type searchPage struct {
+	Results []searchResult
+	Total   int64
 }
 
 // searchEngine defines a search engine with its URL builder and parser.
 type searchEngine struct {
 	Name      string
-	SearchURL func(query string, maxResults int) string
+	SearchURL func(p searchParams) string
 	Parse     func(body []byte) []searchResult
+	// Total, when set, reads the estimated result count from a result
+	// page.
+	Total func(body []byte) int64
+	// Session, when set, returns the cookies that sign in to an engine
+	// that needs an account.
+	Session func() ([]*http.Cookie, error)
+	// API, when set, searches through the engine's official API instead
+	// of its result pages, whenever the environment variable APIKeyEnv
+	// holds a key. Engines without a SearchURL only have the API.
+	API       func(key string, p searchParams) (searchPage, error)
+	APIKeyEnv string
+	// Search, when set, runs the whole search, for engines that need
+	// more than one request.
+	Search func(p searchParams) ([]searchResult, error)
+	// Verticals are the engine's searches other than web search, such
+	// as "news", selected with --vertical.
+	Verticals map[string]searchEngine
+	// Dates reports whether the engine applies searchParams' date range.
+	Dates bool
+	// OneSite is set for engines that take a single site: operator, with
+	// no OR between several.
+	OneSite bool
+	// NoLang is set for engines without a language parameter, which
+	// reject --lang rather than only changing Accept-Language.
+	NoLang bool
+	// Operators reports whether the engine understands the filetype:,
+	// intitle: and -site: operators. FileTypes, when set, are the only
+	// file types its filetype: accepts.
+	Operators bool
+	FileTypes []string
+	// Referer is what a browser sends when a result is clicked: the
+	// engine's origin, as its referrer policy trims it. Engines that send
+	// none leave it empty.
+	Referer string
 }
 
 // engines is the registry of available search engines.
 var engines = map[string]searchEngine{
 	"google": {
 		Name: "Google",
-		SearchURL: func(query string, maxResults int) string {
-			return fmt.Sprintf("https://www.google.com/search?q=%s&num=%d&hl=en", url.QueryEscape(query), maxResults)
+		SearchURL: func(p searchParams) string {
+			v := url.Values{"q": {p.Query}, "num": {strconv.Itoa(p.MaxResults)}, "hl": {cmp.Or(p.Lang, "en")}}
+			if p.Region != "" {
+				v.Set("gl", p.Region)
+			}
+			if tbs := googleDateRange(p); tbs != "" {
+				v.Set("tbs", tbs)
+			}
+			return "https://www.google.com/search?" + v.Encode()
+		},
+		Parse:     parseGoogleResults,
+		Total:     googleTotal,
+		Dates:     true,
+		Operators: true,
+		Referer:   "https://www.google.com/",
+		Verticals: map[string]searchEngine{
+			"news": {Name: "Google News", SearchURL: googleNewsURL, Parse: parseNewsFeed, Dates: true, Referer: "https://news.google.com/"},
 		},
-		Parse: parseGoogleResults,
 	},
 	"bing": {
 		Name: "Bing",
-		SearchURL: func(query string, maxResults int) string {
-			return fmt.Sprintf("https://www.bing.com/search?q=%s&count=%d", url.QueryEscape(query), maxResults)
+		SearchURL: func(p searchParams) string {
+			v := bingLocale(p)
+			v.Set("q", p.Query)
+			v.Set("count", strconv.Itoa(p.MaxResults))
+			if filters := bingDateRange(p); filters != "" {
+				v.Set("filters", filters)
+			}
+			return "https://www.bing.com/search?" + v.Encode()
+		},
+		Parse:     parseBingResults,
+		Total:     bingTotal,
+		Dates:     true,
+		Operators: true,
+		Referer:   "https://www.bing.com/",
+		Verticals: map[string]searchEngine{
+			"news":   {Name: "Bing News", SearchURL: bingNewsURL, Parse: parseNewsFeed},
+			"videos": {Name: "Bing Videos", SearchURL: bingVideosURL, Parse: parseBingVideoResults},
 		},
-		Parse: parseBingResults,
 	},
 	"duckduckgo": {
 		Name: "DuckDuckGo",
-		SearchURL: func(query string, maxResults int) string {
-			return fmt.Sprintf("https://html.duckduckgo.com/html/?q=%s", url.QueryEscape(query))
+		SearchURL: func(p searchParams) string {
+			v := url.Values{"q": {p.Query}}
+			if kl := ddgRegion(p); kl != "" {
+				v.Set("kl", kl)
+			}
+			if df := isoDateRange(p, ".."); df != "" {
+				v.Set("df", df)
+			}
+			return "https://html.duckduckgo.com/html/?" + v.Encode()
+		},
+		Parse:     parseDuckDuckGoResults,
+		Dates:     true,
+		OneSite:   true,
+		Operators: true,
+		FileTypes: ddgFileTypes,
+		Referer:   "https://duckduckgo.com/",
+		Verticals: map[string]searchEngine{
+			"videos": {Name: "DuckDuckGo Videos", Search: duckDuckGoVideoSearch, OneSite: true},
 		},
-		Parse: parseDuckDuckGoResults,
 	},
 	"brave": {
 		Name: "Brave",
-		SearchURL: func(query string, maxResults int) string {
-			return fmt.Sprintf("https://search.brave.com/search?q=%s&count=%d", url.QueryEscape(query), maxResults)
+		SearchURL: func(p searchParams) string {
+			v := braveLocale(p)
+			v.Set("q", p.Query)
+			v.Set("count", strconv.Itoa(p.MaxResults))
+			if tf := isoDateRange(p, "to"); tf != "" {
+				v.Set("tf", tf)
+			}
+			return "https://search.brave.com/search?" + v.Encode()
 		},
-		Parse: parseBraveResults,
+		Parse:     parseBraveResults,
+		API:       braveAPISearch,
+		APIKeyEnv: "BRAVE_API_KEY",
+		Dates:     true,
+		Operators: true,
+		Referer:   "https://search.brave.com/",
+	},
+	"kagi": {
+		Name: "Kagi",
+		SearchURL: func(p searchParams) string {
+			v := url.Values{"q": {p.Query}}
+			if p.Region != "" {
+				v.Set("r", p.Region)
+			}
+			return "https://kagi.com/search?" + v.Encode()
+		},
+		Parse:     parseKagiResults,
+		Session:   kagiSession,
+		Operators: true,
+		NoLang:    true,
+	},
+	"serpapi": {
+		Name:      "SerpApi",
+		API:       serpAPISearch,
+		APIKeyEnv: "SERPAPI_API_KEY",
+		Dates:     true,
+		Operators: true,
+		Referer:   "https://www.google.com/",
+	},
+	"serper": {
+		Name:      "Serper",
+		API:       serperSearch,
+		APIKeyEnv: "SERPER_API_KEY",
+		Dates:     true,
+		Operators: true,
+		Referer:   "https://www.google.com/",
 	},
 }
 
@@ -135,6 +317,7 @@ func extractGoogleResult(n *html.Node) (searchResult, bool) {
 		return ""
 	}
 	r.Snippet = findSnippet(n)
+	r.DisplayURL = displayURL(n, func(c *html.Node) bool { return c.Data == "cite" })
 
 	if r.URL == "" && r.Title == "" {
 		return r, false
@@ -142,6 +325,57 @@ func extractGoogleResult(n *html.Node) (searchResult, bool) {
 	return r, true
 }
 
+// displayURL returns the text of the first element under n that keep
+// accepts, such as a result's <cite>, with its whitespace collapsed.
+func displayURL(n *html.Node, keep func(*html.Node) bool) string {
+	for _, c := range filterNodes(n, keep) {
+		return strings.Join(strings.Fields(textContent(c)), " ")
+	}
+	return ""
+}
+
+// resultCountRe finds the number in a result count such as "About
+// 1,230,000 results" or "Ungefähr 1.230.000 Ergebnisse".
+var resultCountRe = regexp.MustCompile(`\d[\d,.\s\x{a0}\x{202f}]*`)
+
+// parseResultCount reads an engine's result count text as a number, or
+// 0 if it has none.
+func parseResultCount(text string) int64 {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, resultCountRe.FindString(text))
+	n, _ := strconv.ParseInt(digits, 10, 64)
+	return n
+}
+
+// pageTotal returns the result count in the first element of a result
+// page that keep accepts.
+func pageTotal(body []byte, keep func(*html.Node) bool) int64 {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return 0
+	}
+	for _, n := range filterNodes(doc, keep) {
+		return parseResultCount(textContent(n))
+	}
+	return 0
+}
+
+// googleTotal reads Google's <div id="result-stats"> ("About 1,230,000
+// results (0.42 seconds)").
+func googleTotal(body []byte) int64 {
+	return pageTotal(body, func(n *html.Node) bool { return getAttr(n, "id") == "result-stats" })
+}
+
+// bingTotal reads Bing's <span class="sb_count"> ("About 1,230,000
+// results").
+func bingTotal(body []byte) int64 {
+	return pageTotal(body, func(n *html.Node) bool { return hasClass(n, "sb_count") })
+}
+
 // getAttr returns the value of an attribute on an HTML node, or empty string if not found.
 func getAttr(n *html.Node, key string) string {
 	for _, attr := range n.Attr {
@@ -239,6 +473,7 @@ func extractBingResult(n *html.Node) (searchResult, bool) {
 		return ""
 	}
 	r.Snippet = findSnippet(n)
+	r.DisplayURL = displayURL(n, func(c *html.Node) bool { return c.Data == "cite" })
 
 	if r.URL == "" && r.Title == "" {
 		return r, false
@@ -303,6 +538,7 @@ func extractDuckDuckGoResult(n *html.Node) (searchResult, bool) {
 		return ""
 	}
 	r.Snippet = findSnippet(n)
+	r.DisplayURL = displayURL(n, func(c *html.Node) bool { return hasClass(c, "result__url") })
 
 	if r.URL == "" && r.Title == "" {
 		return r, false
@@ -359,6 +595,10 @@ func extractBraveResult(n *html.Node) (searchResult, bool) {
 			if hasClass(node, "line-clamp-dynamic") && r.Snippet == "" {
 				r.Snippet = strings.TrimSpace(textContent(node))
 			}
+			// Displayed URL: <cite class="snippet-url ...">
+			if node.Data == "cite" && r.DisplayURL == "" {
+				r.DisplayURL = strings.Join(strings.Fields(textContent(node)), " ")
+			}
 		}
 		for c := node.FirstChild; c != nil; c = c.NextSibling {
 			walk(c)
@@ -419,67 +659,246 @@ func textContent(n *html.Node) string {
 
 // formatSearchResults formats search results as a numbered markdown list.
 func formatSearchResults(query string, results []searchResult) string {
+	return formatResultList("Search", query, results)
+}
+
+// formatResultList formats results as a numbered markdown list under a
+// "## <kind>: <query>" heading.
+func formatResultList(kind string, query string, results []searchResult) string {
 	var sb strings.Builder
-	sb.WriteString(fmt.Sprintf("## Search: %q\n\n", query))
+	sb.WriteString(fmt.Sprintf("## %s: %q\n\n", kind, query))
 
 	for i, r := range results {
 		sb.WriteString(fmt.Sprintf("%d. **[%s](%s)**\n", i+1, r.Title, r.URL))
+		if meta := joinNonEmpty(" · ", r.Channel, r.Duration, r.Source, r.Published, strings.Join(r.Engines, ", ")); meta != "" {
+			sb.WriteString(fmt.Sprintf("   _%s_\n", meta))
+		}
 		if r.Snippet != "" {
 			sb.WriteString(fmt.Sprintf("   %s\n", r.Snippet))
 		}
+		if r.FetchError != "" {
+			sb.WriteString(fmt.Sprintf("   _could not read page: %s_\n", r.FetchError))
+		}
+		if r.Content != "" {
+			// Indented, the page stays part of its list item.
+			sb.WriteString("\n" + indentLines(strings.TrimSpace(r.Content), "   ") + "\n")
+		}
 		sb.WriteString("\n")
 	}
 
 	return sb.String()
 }
 
+// indentLines prefixes each non-empty line of s with indent.
+func indentLines(s, indent string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = indent + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
 // searchJSONOutput is the JSON output format for search results.
 type searchJSONOutput struct {
-	Query   string         `json:"query"`
-	Engine  string         `json:"engine"`
-	Results []searchResult `json:"results"`
+	Query    string `json:"query"`
+	Engine   string `json:"engine"`
+	Vertical string `json:"vertical,omitempty"`
+	// Page is the page of results returned, always the first for now.
+	// TotalEstimate is the engine's estimate of all matching results, when
+	// it gives one.
+	Page          int            `json:"page"`
+	TotalEstimate int64          `json:"total_estimate,omitempty"`
+	Results       []searchResult `json:"results"`
 }
 
-// runSearch executes a web search using the specified engine.
-func runSearch(query string, engineName string, maxResults int) error {
-	eng, ok := engines[engineName]
-	if !ok {
-		return fmt.Errorf("unknown search engine: %s", engineName)
+// runSearch executes a search using the specified engine, in vertical
+// ("web" or "" for web search, or one of the engine's Verticals). The
+// engine may also be "all" or a comma-separated list; see multiSearch.
+func runSearch(engineName string, vertical string, p searchParams) error {
+	if err := p.normalize(); err != nil {
+		return err
+	}
+	if vertical == "web" {
+		vertical = ""
 	}
 
-	searchURL := eng.SearchURL(query, maxResults)
-
-	result, err := fetchOne(fetchOptions{
-		url:            searchURL,
-		browser:        flagBrowser,
-		timeout:        flagTimeout,
-		noCookies:      flagNoCookies,
-		verbose:        flagVerbose,
-		captchaService: flagCaptchaService,
-		captchaKey:     flagCaptchaKey,
-	})
+	var page searchPage
+	var err error
+	if engineName == "all" || strings.Contains(engineName, ",") {
+		page.Results, engineName, err = multiSearch(engineName, vertical, p)
+	} else {
+		page, err = engineSearch(engineName, vertical, p)
+	}
 	if err != nil {
-		return fmt.Errorf("search fetch failed: %w", err)
+		return err
 	}
 
-	results := eng.Parse(result.Body)
-
 	// Truncate to maxResults if needed.
-	if len(results) > maxResults {
-		results = results[:maxResults]
+	results := page.Results
+	if len(results) > p.MaxResults {
+		results = results[:p.MaxResults]
+	}
+	for i := range results {
+		results[i].Position = i + 1
+	}
+	if searchFetchResults > 0 {
+		fetchSearchResults(results, vertical, searchFetchResults)
 	}
 
 	if flagJSONOutput {
 		out := searchJSONOutput{
-			Query:   query,
-			Engine:  engineName,
-			Results: results,
+			Query:         p.Query,
+			Engine:        engineName,
+			Vertical:      vertical,
+			Page:          1,
+			TotalEstimate: page.Total,
+			Results:       results,
 		}
 		enc := json.NewEncoder(os.Stdout)
 		enc.SetIndent("", "  ")
 		return enc.Encode(out)
 	}
 
-	fmt.Print(formatSearchResults(query, results))
+	fmt.Print(formatSearchResults(p.Query, results))
 	return nil
 }
+
+// searchEngineFor returns the engine (or its vertical) that runs p,
+// failing if it can't: an unknown engine or vertical, filters it lacks,
+// or missing credentials.
+func searchEngineFor(engineName string, vertical string, p searchParams) (searchEngine, error) {
+	eng, ok := engines[engineName]
+	if !ok {
+		return eng, fmt.Errorf("unknown search engine: %s", engineName)
+	}
+	if vertical != "" {
+		v, ok := eng.Verticals[vertical]
+		if !ok {
+			return eng, fmt.Errorf("%s has no %s search (engines with one: %s)", eng.Name, vertical, strings.Join(verticalEngines(vertical), ", "))
+		}
+		eng = v
+	}
+	if p.hasDates() && !eng.Dates && !p.LooseDates {
+		return eng, fmt.Errorf("%s search can't filter by date (--since, --before)", eng.Name)
+	}
+	if p.Lang != "" && eng.NoLang {
+		return eng, fmt.Errorf("%s search has no language setting (--lang); use --region", eng.Name)
+	}
+	if len(p.Sites) > 1 && eng.OneSite {
+		return eng, fmt.Errorf("%s search takes a single --site", eng.Name)
+	}
+	if p.hasOperators() && !eng.Operators {
+		return eng, fmt.Errorf("%s search doesn't support --filetype, --intitle or --exclude-site", eng.Name)
+	}
+	if p.FileType != "" && eng.FileTypes != nil && !slices.Contains(eng.FileTypes, p.FileType) {
+		return eng, fmt.Errorf("%s search can't filter by file type %s (only %s)", eng.Name, p.FileType, strings.Join(eng.FileTypes, ", "))
+	}
+	if eng.SearchURL == nil && eng.Search == nil && os.Getenv(eng.APIKeyEnv) == "" {
+		return eng, fmt.Errorf("%s search needs an API key: set %s", eng.Name, eng.APIKeyEnv)
+	}
+	if eng.Session != nil {
+		if _, err := eng.Session(); err != nil {
+			return eng, err
+		}
+	}
+	return eng, nil
+}
+
+// engineSearch runs p on one engine, in vertical.
+func engineSearch(engineName string, vertical string, p searchParams) (searchPage, error) {
+	eng, err := searchEngineFor(engineName, vertical, p)
+	if err != nil {
+		return searchPage{}, err
+	}
+	p.Query = operatorQuery(siteQuery(p.Query, p.Sites), p)
+	if flagVerbose && (len(p.Sites) > 0 || p.hasOperators()) {
+		fmt.Fprintf(os.Stderr, "[*] %s query: %s\n", eng.Name, p.Query)
+	}
+	if !eng.Dates {
+		p.Since, p.Before = time.Time{}, time.Time{}
+	}
+
+	var page searchPage
+	switch key := os.Getenv(eng.APIKeyEnv); {
+	case eng.Search != nil:
+		page.Results, err = eng.Search(p)
+	case eng.API != nil && key != "":
+		if flagVerbose {
+			fmt.Fprintf(os.Stderr, "[*] Searching through the %s API\n", eng.Name)
+		}
+		if page, err = eng.API(key, p); err != nil {
+			err = fmt.Errorf("search failed: %w", err)
+		}
+	default:
+		page, err = scrapeSearch(eng, p)
+	}
+	if err != nil {
+		return searchPage{}, err
+	}
+	for i := range page.Results {
+		page.Results[i].Engine = engineName
+	}
+	return page, nil
+}
+
+// scrapeSearch fetches the engine's result page for query and parses it.
+func scrapeSearch(eng searchEngine, p searchParams) (searchPage, error) {
+	opts := newFetchOptions(eng.SearchURL(p))
+	opts.acceptLanguage = acceptLanguage(p)
+	if eng.Session != nil {
+		var err error
+		if opts.cookies, err = eng.Session(); err != nil {
+			return searchPage{}, err
+		}
+	}
+	result, err := fetchOne(opts)
+	if err != nil {
+		return searchPage{}, fmt.Errorf("search fetch failed: %w", err)
+	}
+	// A rejected session is sent to the sign-in page.
+	if eng.Session != nil {
+		if u, err := url.Parse(result.finalURL()); err == nil && strings.HasPrefix(u.Path, "/signin") {
+			return searchPage{}, fmt.Errorf("%s did not accept the session token (redirected to %s)", eng.Name, u.Path)
+		}
+	}
+	page := searchPage{Results: eng.Parse(result.Body)}
+	if eng.Total != nil {
+		page.Total = eng.Total(result.Body)
+	}
+	return page, nil
+}
+
+// searchReferer returns the Referer of a click on one of engineName's
+// results in vertical, or "" if the engine sends none.
+func searchReferer(engineName string, vertical string) string {
+	eng := engines[engineName]
+	if v, ok := eng.Verticals[vertical]; ok && v.Referer != "" {
+		return v.Referer
+	}
+	return eng.Referer
+}
+
+// verticalEngines returns the engines offering vertical, sorted.
+func verticalEngines(vertical string) []string {
+	var names []string
+	for name, eng := range engines {
+		if _, ok := eng.Verticals[vertical]; ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// joinNonEmpty joins the non-empty parts with sep.
+func joinNonEmpty(sep string, parts ...string) string {
+	var kept []string
+	for _, p := range parts {
+		if p != "" {
+			kept = append(kept, p)
+		}
+	}
+	return strings.Join(kept, sep)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Search API endpoints.
+const (
+	braveAPIURL   = "https://api.search.brave.com/res/v1/web/search"
+	serpAPIURL    = "https://serpapi.com/search.json"
+	serperAPIURL  = "https://google.serper.dev/search"
+	braveMaxCount = 20 // most results the Brave API returns per request
+)
+
+// searchAPI sends req, bounded by --timeout, and decodes the JSON answer
+// into out. It returns the HTTP status for the caller to judge, along
+// with out's API-specific error fields.
+func searchAPI(name string, req *http.Request, out any) (int, error) {
+	timeout, err := time.ParseDuration(flagTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q: %w", flagTimeout, err)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("%s: request: %w", name, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("%s: read response: %w", name, err)
+	}
+	if err := json.Unmarshal(body, out); err != nil {
+		return 0, fmt.Errorf("%s: parse response (HTTP %d): %w", name, resp.StatusCode, err)
+	}
+	return resp.StatusCode, nil
+}
+
+// braveAPISearch runs query through the Brave Search API with the given
+// subscription key, returning its structured web results, so no result
+// page has to be parsed.
+func braveAPISearch(key string, p searchParams) (searchPage, error) {
+	v := braveLocale(p)
+	v.Set("q", p.Query)
+	v.Set("count", strconv.Itoa(min(max(p.MaxResults, 1), braveMaxCount)))
+	if freshness := isoDateRange(p, "to"); freshness != "" {
+		v.Set("freshness", freshness)
+	}
+	req, err := http.NewRequest("GET", braveAPIURL+"?"+v.Encode(), nil)
+	if err != nil {
+		return searchPage{}, fmt.Errorf("brave api: build request: %w", err)
+	}
+	req.Header.Set("X-Subscription-Token", key)
+
+	var result struct {
+		Web struct {
+			Results []struct {
+				Title       string `json:"title"`
+				URL         string `json:"url"`
+				Description string `json:"description"`
+				PageAge     string `json:"page_age"`
+				MetaURL     struct {
+					Netloc string `json:"netloc"`
+					Path   string `json:"path"`
+				} `json:"meta_url"`
+			} `json:"results"`
+		} `json:"web"`
+		Error struct {
+			Code   string `json:"code"`
+			Detail string `json:"detail"`
+		} `json:"error"`
+	}
+	status, err := searchAPI("brave api", req, &result)
+	if err != nil {
+		return searchPage{}, err
+	}
+	if status != http.StatusOK {
+		return searchPage{}, fmt.Errorf("brave api: HTTP %d: %s (%s)", status, result.Error.Detail, result.Error.Code)
+	}
+
+	var results []searchResult
+	for _, r := range result.Web.Results {
+		sr := searchResult{
+			Title:      fragmentText(r.Title),
+			URL:        r.URL,
+			DisplayURL: joinNonEmpty(" ", r.MetaURL.Netloc, r.MetaURL.Path),
+			Snippet:    fragmentText(r.Description),
+		}
+		// Page ages come without a zone ("2023-04-12T08:15:02").
+		if t, err := time.Parse("2006-01-02T15:04:05", r.PageAge); err == nil {
+			sr.Published = t.Format(time.RFC3339)
+		}
+		results = append(results, sr)
+	}
+	return searchPage{Results: results}, nil
+}
+
+// serpAPISearch runs query through SerpApi's Google engine with the given
+// API key, returning Google's organic results.
+func serpAPISearch(key string, p searchParams) (searchPage, error) {
+	params := url.Values{
+		"engine":  {"google"},
+		"q":       {p.Query},
+		"num":     {strconv.Itoa(p.MaxResults)},
+		"hl":      {cmp.Or(p.Lang, "en")},
+		"api_key": {key},
+	}
+	if p.Region != "" {
+		params.Set("gl", p.Region)
+	}
+	if tbs := googleDateRange(p); tbs != "" {
+		params.Set("tbs", tbs)
+	}
+	req, err := http.NewRequest("GET", serpAPIURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return searchPage{}, fmt.Errorf("serpapi: build request: %w", err)
+	}
+
+	var result struct {
+		OrganicResults []struct {
+			Title         string `json:"title"`
+			Link          string `json:"link"`
+			DisplayedLink string `json:"displayed_link"`
+			Snippet       string `json:"snippet"`
+			Date          string `json:"date"`
+		} `json:"organic_results"`
+		SearchInformation struct {
+			TotalResults int64 `json:"total_results"`
+		} `json:"search_information"`
+		Error string `json:"error"`
+	}
+	status, err := searchAPI("serpapi", req, &result)
+	if err != nil {
+		return searchPage{}, err
+	}
+	// SerpApi answers a search without results with 200 and an error.
+	if status != http.StatusOK || (result.Error != "" && !strings.Contains(result.Error, "hasn't returned any results")) {
+		return searchPage{}, fmt.Errorf("serpapi: HTTP %d: %s", status, result.Error)
+	}
+
+	var results []searchResult
+	for _, r := range result.OrganicResults {
+		results = append(results, searchResult{Title: r.Title, URL: r.Link, DisplayURL: r.DisplayedLink, Snippet: r.Snippet, Published: r.Date})
+	}
+	return searchPage{Results: results, Total: result.SearchInformation.TotalResults}, nil
+}
+
+// serperSearch runs query through Serper.dev's Google search API with the
+// given API key, returning Google's organic results.
+func serperSearch(key string, p searchParams) (searchPage, error) {
+	search := map[string]any{"q": p.Query, "num": p.MaxResults}
+	if p.Lang != "" {
+		search["hl"] = p.Lang
+	}
+	if p.Region != "" {
+		search["gl"] = p.Region
+	}
+	if tbs := googleDateRange(p); tbs != "" {
+		search["tbs"] = tbs
+	}
+	payload, _ := json.Marshal(search)
+	req, err := http.NewRequest("POST", serperAPIURL, bytes.NewReader(payload))
+	if err != nil {
+		return searchPage{}, fmt.Errorf("serper: build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-API-KEY", key)
+
+	var result struct {
+		Organic []struct {
+			Title   string `json:"title"`
+			Link    string `json:"link"`
+			Snippet string `json:"snippet"`
+			Date    string `json:"date"`
+		} `json:"organic"`
+		Message string `json:"message"`
+	}
+	status, err := searchAPI("serper", req, &result)
+	if err != nil {
+		return searchPage{}, err
+	}
+	if status != http.StatusOK {
+		return searchPage{}, fmt.Errorf("serper: HTTP %d: %s", status, result.Message)
+	}
+
+	var results []searchResult
+	for _, r := range result.Organic {
+		results = append(results, searchResult{Title: r.Title, URL: r.Link, Snippet: r.Snippet, Published: r.Date})
+	}
+	return searchPage{Results: results}, nil
+}
+
+// fragmentText returns the text of an HTML fragment, such as the Brave
+// API's descriptions with their <strong> highlights.
+func fragmentText(s string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(s), &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div})
+	if err != nil {
+		return s
+	}
+	var sb strings.Builder
+	for _, n := range nodes {
+		sb.WriteString(textContent(n))
+	}
+	return strings.Join(strings.Fields(sb.String()), " ")
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// searchAgeRe matches a relative --since/--before age such as "7d":
+// hours, days, weeks, months or years.
+var searchAgeRe = regexp.MustCompile(`^(\d+)([hdwmy])$`)
+
+// parseSearchTime parses a --since or --before value: a date
+// (2024-01-01) or an age before now ("12h", "7d", "2w", "3m", "1y").
+func parseSearchTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	m := searchAgeRe.FindStringSubmatch(strings.ToLower(s))
+	if m == nil {
+		return time.Time{}, fmt.Errorf("want a date (2024-01-01) or an age (12h, 7d, 2w, 3m, 1y)")
+	}
+	n, _ := strconv.Atoi(m[1])
+	switch m[2] {
+	case "h":
+		return now.Add(-time.Duration(n) * time.Hour), nil
+	case "d":
+		return now.AddDate(0, 0, -n), nil
+	case "w":
+		return now.AddDate(0, 0, -7*n), nil
+	case "m":
+		return now.AddDate(0, -n, 0), nil
+	default:
+		return now.AddDate(-n, 0, 0), nil
+	}
+}
+
+// hasDates reports whether p asks for a date range.
+func (p searchParams) hasDates() bool {
+	return !p.Since.IsZero() || !p.Before.IsZero()
+}
+
+// dateBounds returns p's range with open ends filled in, from the
+// earliest date engines accept up to today, for engines that need both.
+func (p searchParams) dateBounds() (from, to time.Time) {
+	from, to = p.Since, p.Before
+	if from.IsZero() {
+		from = time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if to.IsZero() {
+		to = time.Now().UTC()
+	}
+	return from, to
+}
+
+// googleDateRange returns Google's custom date range (the tbs parameter)
+// for p, or "" without one. Its dates are month/day/year.
+func googleDateRange(p searchParams) string {
+	if !p.hasDates() {
+		return ""
+	}
+	tbs := "cdr:1"
+	if !p.Since.IsZero() {
+		tbs += ",cd_min:" + p.Since.Format("1/2/2006")
+	}
+	if !p.Before.IsZero() {
+		tbs += ",cd_max:" + p.Before.Format("1/2/2006")
+	}
+	return tbs
+}
+
+// bingDateRange returns Bing's custom date range filter for p, or ""
+// without one: ez5 with the first and last day as days since 1970.
+func bingDateRange(p searchParams) string {
+	if !p.hasDates() {
+		return ""
+	}
+	from, to := p.dateBounds()
+	day := func(t time.Time) int64 { return t.Unix() / 86400 }
+	return fmt.Sprintf(`ex1:"ez5_%d_%d"`, day(from), day(to))
+}
+
+// isoDateRange returns p's range as its first and last dates, joined by
+// sep, as DuckDuckGo ("..") and Brave ("to") take them, or "" without one.
+func isoDateRange(p searchParams, sep string) string {
+	if !p.hasDates() {
+		return ""
+	}
+	from, to := p.dateBounds()
+	return from.Format(time.DateOnly) + sep + to.Format(time.DateOnly)
+}
+
+// googleNewsDateQuery returns Google News' after: and before: operators
+// for p, to add to the query.
+func googleNewsDateQuery(p searchParams) string {
+	var ops []string
+	if !p.Since.IsZero() {
+		ops = append(ops, "after:"+p.Since.Format(time.DateOnly))
+	}
+	if !p.Before.IsZero() {
+		ops = append(ops, "before:"+p.Before.Format(time.DateOnly))
+	}
+	return strings.Join(ops, " ")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// fetchSearchResults fetches the pages of the first n results
+// concurrently, as a parallel fetch does, and stores each as reader-mode
+// markdown in its Content. Each is requested as a click from the engine
+// that found it, in vertical. A page that can't be fetched or read gets a
+// FetchError instead; the search itself still succeeds.
+func fetchSearchResults(results []searchResult, vertical string, n int) {
+	n = min(n, len(results))
+	maxPar := flagMaxParallel
+	if maxPar <= 0 {
+		maxPar = 5
+	}
+	sem := make(chan struct{}, maxPar)
+	var wg sync.WaitGroup
+	for i := range results[:n] {
+		wg.Add(1)
+		go func(r *searchResult) {
+			defer wg.Done()
+			sem <- struct{}{}        // acquire semaphore slot
+			defer func() { <-sem }() // release semaphore slot
+
+			content, err := readResultPage(r.URL, searchReferer(r.Engine, vertical))
+			if err != nil {
+				if flagVerbose {
+					fmt.Fprintf(os.Stderr, "[*] Warning: could not read %s: %v\n", r.URL, err)
+				}
+				r.FetchError = err.Error()
+				return
+			}
+			r.Content = content
+		}(&results[i])
+	}
+	wg.Wait()
+}
+
+// readResultPage fetches a result's page, navigating from referer, and
+// returns its main content as markdown. Plain text is returned as is and
+// PDFs as their text; other non-HTML bodies, such as images, are an error.
+func readResultPage(pageURL string, referer string) (string, error) {
+	opts := newFetchOptions(pageURL)
+	opts.referer = referer
+	res, err := fetchOne(opts)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode >= 400 {
+		return "", fmt.Errorf("HTTP %d", res.StatusCode)
+	}
+	in := res.processInput()
+	if in.isPDF() {
+		return pdfToText(res.Body)
+	}
+	if in.notHTML() {
+		return "", fmt.Errorf("%w (%s)", errNotHTML, in.contentType.Effective)
+	}
+	if t := in.contentType.Effective; t != "" && !isHTMLType(t) {
+		return string(res.Body), nil
+	}
+	return htmlToMarkdown(string(res.Body), res.URL, true)
+}
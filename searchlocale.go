@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	langCodeRe   = regexp.MustCompile(`^[a-z]{2,3}$`)
+	regionCodeRe = regexp.MustCompile(`^[a-z]{2}$`)
+)
+
+// newSearchParams returns the parameters for a search for query from the
+// search flags.
+func newSearchParams(query string) (searchParams, error) {
+	p := searchParams{
+		Query:      query,
+		MaxResults: searchMaxResults,
+		Lang:       searchLang,
+		Region:     searchRegion,
+	}
+	for _, site := range searchSites {
+		site = normalizeSite(site)
+		if site == "" || strings.ContainsAny(site, " \t\"") {
+			return p, fmt.Errorf("invalid --site %q: want a host such as example.com", site)
+		}
+		p.Sites = append(p.Sites, site)
+	}
+	if err := p.parseSearchOperators(searchFileType, searchInTitle, searchExcludeSites); err != nil {
+		return p, err
+	}
+	now := time.Now().UTC()
+	var err error
+	if searchSince != "" {
+		if p.Since, err = parseSearchTime(searchSince, now); err != nil {
+			return p, fmt.Errorf("invalid --since %q: %w", searchSince, err)
+		}
+	}
+	if searchBefore != "" {
+		if p.Before, err = parseSearchTime(searchBefore, now); err != nil {
+			return p, fmt.Errorf("invalid --before %q: %w", searchBefore, err)
+		}
+	}
+	if !p.Since.IsZero() && !p.Before.IsZero() && !p.Since.Before(p.Before) {
+		return p, fmt.Errorf("--since %s is not before --before %s", searchSince, searchBefore)
+	}
+	return p, nil
+}
+
+// normalize lowercases the language and region and checks they are
+// codes engines understand.
+func (p *searchParams) normalize() error {
+	p.Lang = strings.ToLower(strings.TrimSpace(p.Lang))
+	p.Region = strings.ToLower(strings.TrimSpace(p.Region))
+	if p.Lang != "" && !langCodeRe.MatchString(p.Lang) {
+		return fmt.Errorf("invalid --lang %q: want an ISO 639-1 code such as de", p.Lang)
+	}
+	if p.Region != "" && !regionCodeRe.MatchString(p.Region) {
+		return fmt.Errorf("invalid --region %q: want an ISO 3166-1 code such as at", p.Region)
+	}
+	return nil
+}
+
+// acceptLanguage returns the Accept-Language header a browser set to
+// p's language (and region) would send, or "" to keep the profile's.
+func acceptLanguage(p searchParams) string {
+	if p.Lang == "" {
+		return ""
+	}
+	tags := []string{p.Lang}
+	if p.Region != "" {
+		tags = []string{p.Lang + "-" + strings.ToUpper(p.Region), p.Lang + ";q=0.9"}
+	}
+	if p.Lang != "en" {
+		tags = append(tags, "en;q=0.8")
+	}
+	return strings.Join(tags, ",")
+}
+
+// bingLocale returns Bing's language and country parameters for p:
+// setlang and cc.
+func bingLocale(p searchParams) url.Values {
+	v := url.Values{}
+	if p.Lang != "" {
+		v.Set("setlang", p.Lang)
+	}
+	if p.Region != "" {
+		v.Set("cc", p.Region)
+	}
+	return v
+}
+
+// braveLocale returns Brave's language and country parameters for p:
+// search_lang and country, which its web search and API share.
+func braveLocale(p searchParams) url.Values {
+	v := url.Values{}
+	if p.Lang != "" {
+		v.Set("search_lang", p.Lang)
+	}
+	if p.Region != "" {
+		v.Set("country", p.Region)
+	}
+	return v
+}
+
+// englishRegions are the countries DuckDuckGo searches in English.
+var englishRegions = map[string]bool{
+	"us": true, "uk": true, "au": true, "ca": true, "ie": true,
+	"nz": true, "za": true, "in": true, "sg": true, "ph": true,
+}
+
+// ddgRegion returns DuckDuckGo's kl region for p, country then language
+// ("de-de", "uk-en"), or "" without a region. The language defaults to
+// the country's own: English for English-speaking countries, otherwise
+// the code shared by most (de, fr, es...).
+func ddgRegion(p searchParams) string {
+	if p.Region == "" {
+		return ""
+	}
+	region := p.Region
+	if region == "gb" {
+		region = "uk" // DuckDuckGo's code for the United Kingdom
+	}
+	lang := p.Lang
+	if lang == "" {
+		lang = region
+		if englishRegions[region] {
+			lang = "en"
+		}
+	}
+	return region + "-" + lang
+}
+
+// normalizeSite turns a --site value into what follows site:, dropping
+// any scheme and trailing slash ("https://Example.com/" is example.com).
+func normalizeSite(site string) string {
+	site = strings.TrimSpace(site)
+	if _, rest, ok := strings.Cut(site, "://"); ok {
+		site = rest
+	}
+	site = strings.TrimSuffix(site, "/")
+	host, path, _ := strings.Cut(site, "/")
+	if path != "" {
+		return strings.ToLower(host) + "/" + path
+	}
+	return strings.ToLower(host)
+}
+
+// siteQuery adds site: operators for sites to query, joined with OR when
+// there are several, which every engine with OR understands.
+func siteQuery(query string, sites []string) string {
+	if len(sites) == 0 {
+		return query
+	}
+	ops := make([]string, len(sites))
+	for i, site := range sites {
+		ops[i] = "site:" + site
+	}
+	if len(ops) == 1 {
+		return query + " " + ops[0]
+	}
+	return query + " (" + strings.Join(ops, " OR ") + ")"
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// fileTypeRe matches a --filetype value: a bare extension such as "pdf".
+var fileTypeRe = regexp.MustCompile(`^[a-z0-9]{1,10}$`)
+
+// ddgFileTypes are the only file types DuckDuckGo's filetype: accepts.
+var ddgFileTypes = []string{"pdf", "doc", "docx", "xls", "xlsx", "ppt", "pptx", "html"}
+
+// parseSearchOperators validates the --filetype, --intitle and
+// --exclude-site flags into p.
+func (p *searchParams) parseSearchOperators(fileType, inTitle string, excludeSites []string) error {
+	p.FileType = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(fileType)), ".")
+	if p.FileType != "" && !fileTypeRe.MatchString(p.FileType) {
+		return fmt.Errorf("invalid --filetype %q: want an extension such as pdf", fileType)
+	}
+	p.InTitle = strings.Join(strings.Fields(inTitle), " ")
+	if strings.Contains(p.InTitle, `"`) {
+		return fmt.Errorf("invalid --intitle %q: quotes are added as needed", inTitle)
+	}
+	for _, site := range excludeSites {
+		site = normalizeSite(site)
+		if site == "" || strings.ContainsAny(site, " \t\"") {
+			return fmt.Errorf("invalid --exclude-site %q: want a host such as example.com", site)
+		}
+		p.ExcludeSites = append(p.ExcludeSites, site)
+	}
+	return nil
+}
+
+// hasOperators reports whether p asks for any of the operators
+// operatorQuery adds.
+func (p searchParams) hasOperators() bool {
+	return p.FileType != "" || p.InTitle != "" || len(p.ExcludeSites) > 0
+}
+
+// operatorQuery adds p's filetype:, intitle: and -site: operators to
+// query. A title of several words is quoted, so it is matched as a phrase
+// rather than only its first word being looked for in the title.
+func operatorQuery(query string, p searchParams) string {
+	ops := []string{query}
+	if p.FileType != "" {
+		ops = append(ops, "filetype:"+p.FileType)
+	}
+	if p.InTitle != "" {
+		title := p.InTitle
+		if strings.Contains(title, " ") {
+			title = `"` + title + `"`
+		}
+		ops = append(ops, "intitle:"+title)
+	}
+	for _, site := range p.ExcludeSites {
+		ops = append(ops, "-site:"+site)
+	}
+	return strings.Join(ops, " ")
+}
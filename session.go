@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// session shares warm transports and cookie jars between the requests of
+// one invocation when --next chains several of them, so later requests
+// reuse open connections and see cookies set by earlier ones.
+type session struct {
+	mu         sync.Mutex
+	transports map[string]http.RoundTripper
+	jars       map[string]*PersistentJar
+}
+
+// currentSession is nil unless the invocation uses --next; each fetch then
+// creates its own transport and loads the jar from disk as before.
+var currentSession *session
+
+func newSession() *session {
+	return &session{
+		transports: make(map[string]http.RoundTripper),
+		jars:       make(map[string]*PersistentJar),
+	}
+}
+
+// sessionTransport returns the session's transport for this profile and
+// options, creating it on first use.
+func sessionTransport(profile BrowserProfile, opts transportOptions) (http.RoundTripper, error) {
+	s := currentSession
+	if s == nil {
+		return newTransport(profile, opts)
+	}
+	key := fmt.Sprintf("%s|%+v", profile.Name, opts)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tr, ok := s.transports[key]; ok {
+		return tr, nil
+	}
+	tr, err := newTransport(profile, opts)
+	if err != nil {
+		return nil, err
+	}
+	s.transports[key] = tr
+	return tr, nil
+}
+
+// sessionJar returns the cookie jar stored at path, loading it from disk
+// only once per session.
+func sessionJar(path string) (*PersistentJar, error) {
+	s := currentSession
+	if s == nil {
+		jar := newPersistentJar(path)
+		return jar, jar.Load()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if jar, ok := s.jars[path]; ok {
+		return jar, nil
+	}
+	jar := newPersistentJar(path)
+	if err := jar.Load(); err != nil {
+		return nil, err
+	}
+	s.jars[path] = jar
+	return jar, nil
+}
+
+// splitNext splits command-line arguments into independent request
+// segments at each "--next".
+func splitNext(args []string) [][]string {
+	segments := [][]string{{}}
+	for _, a := range args {
+		if a == "--next" {
+			segments = append(segments, []string{})
+			continue
+		}
+		segments[len(segments)-1] = append(segments[len(segments)-1], a)
+	}
+	return segments
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitNext(t *testing.T) {
+	got := splitNext([]string{"fetch", "a", "--next", "fetch", "-j", "b"})
+	want := [][]string{{"fetch", "a"}, {"fetch", "-j", "b"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitNext = %q, want %q", got, want)
+	}
+
+	// Empty segments are kept; each runs as a command of its own.
+	got = splitNext([]string{"--next", "a", "--next"})
+	want = [][]string{{}, {"a"}, {}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitNext = %q, want %q", got, want)
+	}
+
+	// Only the exact argument splits.
+	got = splitNext([]string{"a", "--next=1", "--nextx"})
+	want = [][]string{{"a", "--next=1", "--nextx"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitNext = %q, want %q", got, want)
+	}
+}
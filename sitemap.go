@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// sitemapEntry is one <url> of a sitemap's <urlset>.
+type sitemapEntry struct {
+	URL        string  `json:"url"`
+	LastMod    string  `json:"lastmod,omitempty"`
+	ChangeFreq string  `json:"changefreq,omitempty"`
+	Priority   float64 `json:"priority,omitempty"`
+}
+
+// sitemapXML covers both sitemap document types: a <urlset> of pages and
+// a <sitemapindex> pointing at further sitemaps.
+type sitemapXML struct {
+	XMLName xml.Name
+	URLs    []struct {
+		Loc        string `xml:"loc"`
+		LastMod    string `xml:"lastmod"`
+		ChangeFreq string `xml:"changefreq"`
+		Priority   string `xml:"priority"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// maxSitemapNesting bounds how many sitemap index levels are followed.
+const maxSitemapNesting = 3
+
+// runSitemap lists the pages in a site's sitemaps, or with fetch fetches
+// them like "ghostfetch fetch" does several URLs. limit > 0 keeps only the
+// first limit pages.
+func runSitemap(rawURL string, fetch bool, limit int) error {
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "https://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid URL %q", rawURL)
+	}
+
+	sitemaps := []string{rawURL}
+	if !isSitemapURL(u) {
+		sitemaps = discoverSitemaps(u)
+	}
+	var entries []sitemapEntry
+	seen := make(map[string]bool)
+	for _, sm := range sitemaps {
+		entries = append(entries, readSitemap(sm, 0, seen)...)
+	}
+	if len(entries) == 0 {
+		return fmt.Errorf("no sitemap entries found for %s", rawURL)
+	}
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	if flagVerbose {
+		fmt.Fprintf(os.Stderr, "[*] Sitemap: %d page(s)\n", len(entries))
+	}
+
+	if fetch {
+		if flagMirror && flagOutDir == "" {
+			return fmt.Errorf("--mirror needs --out-dir")
+		}
+		items := make([]batchItem, len(entries))
+		for i, e := range entries {
+			items[i] = batchItem{URL: e.URL}
+		}
+		return runParallelFetch(items)
+	}
+
+	out, err := openOutput()
+	if err != nil {
+		return err
+	}
+	if flagJSONOutput {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		enc.Encode(entries)
+	} else {
+		for _, e := range entries {
+			fmt.Fprintln(out, e.URL)
+		}
+	}
+	return out.Close()
+}
+
+// isSitemapURL reports whether u names a sitemap itself rather than a
+// site whose sitemaps have to be discovered.
+func isSitemapURL(u *url.URL) bool {
+	p := strings.ToLower(u.Path)
+	return strings.HasSuffix(p, ".xml") || strings.HasSuffix(p, ".xml.gz") || strings.Contains(p, "sitemap")
+}
+
+// discoverSitemaps returns the sitemaps listed in the site's robots.txt,
+// or /sitemap.xml when it lists none.
+func discoverSitemaps(u *url.URL) []string {
+	root := u.Scheme + "://" + u.Host
+	var sitemaps []string
+	res, err := fetchOne(newFetchOptions(root + "/robots.txt"))
+	if err == nil && res.StatusCode < 400 {
+		sc := bufio.NewScanner(bytes.NewReader(res.Body))
+		for sc.Scan() {
+			name, value, ok := strings.Cut(sc.Text(), ":")
+			if ok && strings.EqualFold(strings.TrimSpace(name), "sitemap") {
+				if v := strings.TrimSpace(value); v != "" {
+					sitemaps = append(sitemaps, v)
+				}
+			}
+		}
+	}
+	if len(sitemaps) == 0 {
+		sitemaps = []string{root + "/sitemap.xml"}
+	}
+	if flagVerbose {
+		fmt.Fprintf(os.Stderr, "[*] Sitemaps: %s\n", strings.Join(sitemaps, ", "))
+	}
+	return sitemaps
+}
+
+// readSitemap fetches and parses one sitemap, following sitemap index
+// files up to maxSitemapNesting levels. Sitemaps that can't be read are
+// reported and skipped, so one broken child doesn't lose the rest.
+func readSitemap(sitemapURL string, level int, seen map[string]bool) []sitemapEntry {
+	if seen[sitemapURL] {
+		return nil
+	}
+	seen[sitemapURL] = true
+
+	doc, err := fetchSitemap(sitemapURL)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "[*] Warning: sitemap %s: %v\n", sitemapURL, err)
+		return nil
+	}
+
+	var entries []sitemapEntry
+	for _, u := range doc.URLs {
+		loc := strings.TrimSpace(u.Loc)
+		if loc == "" {
+			continue
+		}
+		e := sitemapEntry{
+			URL:        loc,
+			LastMod:    strings.TrimSpace(u.LastMod),
+			ChangeFreq: strings.TrimSpace(u.ChangeFreq),
+		}
+		if p, err := strconv.ParseFloat(strings.TrimSpace(u.Priority), 64); err == nil {
+			e.Priority = p
+		}
+		entries = append(entries, e)
+	}
+	for _, sm := range doc.Sitemaps {
+		loc := strings.TrimSpace(sm.Loc)
+		if loc == "" {
+			continue
+		}
+		if level >= maxSitemapNesting {
+			fmt.Fprintf(os.Stderr, "[*] Warning: sitemap %s: index nested too deeply, skipped\n", loc)
+			continue
+		}
+		entries = append(entries, readSitemap(loc, level+1, seen)...)
+	}
+	return entries
+}
+
+// fetchSitemap fetches a sitemap through the fetch pipeline and parses
+// it, gunzipping .xml.gz files served without a Content-Encoding.
+func fetchSitemap(sitemapURL string) (*sitemapXML, error) {
+	if flagVerbose {
+		fmt.Fprintf(os.Stderr, "[*] Reading sitemap %s\n", sitemapURL)
+	}
+	res, err := fetchOne(newFetchOptions(sitemapURL))
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 400 {
+		return nil, fmt.Errorf("HTTP %d", res.StatusCode)
+	}
+	body := res.Body
+	if len(body) > 2 && body[0] == 0x1f && body[1] == 0x8b {
+		gr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("gunzip: %w", err)
+		}
+		if body, err = io.ReadAll(gr); err != nil {
+			return nil, fmt.Errorf("gunzip: %w", err)
+		}
+	}
+
+	var doc sitemapXML
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("parse: %w", err)
+	}
+	if doc.XMLName.Local != "urlset" && doc.XMLName.Local != "sitemapindex" {
+		return nil, fmt.Errorf("not a sitemap (root element <%s>)", doc.XMLName.Local)
+	}
+	return &doc, nil
+}
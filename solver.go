@@ -1,21 +1,50 @@
 package main
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
+	"net/http"
 	"net/url"
+	"runtime/metrics"
 	"strings"
 	"time"
 
 	"github.com/dop251/goja"
+	"github.com/dop251/goja_nodejs/eventloop"
 )
 
 // SolveResult holds the output from evaluating a JS challenge script.
 type SolveResult struct {
 	CookieName  string
 	CookieValue string
-	FormAction  string
-	FormData    map[string]string
+	// Cookies holds every cookie the script set, in order; CookieName and
+	// CookieValue are the last of them.
+	Cookies    []*http.Cookie
+	FormAction string
+	FormData   map[string]string
+	// Submitted reports whether the script submitted a page form; the form's
+	// action and its fields' final values are FormAction and FormData.
+	Submitted bool
+	// Scripts are the src URLs of script elements the script added to the
+	// page, which a browser would load next.
+	Scripts []string
+	// Requests are the fetch and XMLHttpRequest calls the script made, in
+	// order, for the caller to replay through the real transport.
+	Requests []JSRequest
+	// Delay is the longest setTimeout delay the script asked for. Timers
+	// wait at most jsMaxTimerDelay, so callers whose server checks the
+	// timing wait for the rest.
+	Delay time.Duration
+}
+
+// JSRequest is an HTTP request a challenge script issued with fetch or
+// XMLHttpRequest. The sandbox doesn't send it; its response is empty.
+type JSRequest struct {
+	Method  string
+	URL     string // as given by the script, possibly relative
+	Headers [][2]string
+	Body    string
 }
 
 // JSSolver evaluates JavaScript challenge scripts in a sandboxed goja runtime
@@ -23,36 +52,185 @@ type SolveResult struct {
 // solved tokens.
 type JSSolver struct {
 	pageURL string
+	// cookies are visible to the script through document.cookie, as the
+	// page's cookies would be in a browser.
+	cookies []*http.Cookie
+	// page is the HTML the script runs in, exposed as document.
+	page []byte
+}
+
+func newJSSolver(pageURL string, page []byte) *JSSolver {
+	return &JSSolver{pageURL: pageURL, page: page}
+}
+
+// jsMaxTimerDelay caps how long a setTimeout or setInterval callback
+// waits, so scripts that pause before answering finish promptly.
+const jsMaxTimerDelay = time.Second
+
+// jsLimits bounds a Solve call: timeout covers the script and its
+// timers, and maxMemory, when non-zero, is how far the heap may grow
+// while it runs.
+type jsLimits struct {
+	timeout   time.Duration
+	maxMemory int64
+}
+
+// defaultJSLimits applies when the context carries no limits.
+var defaultJSLimits = jsLimits{timeout: 10 * time.Second, maxMemory: 256 << 20}
+
+// jsLimitsKey carries a fetch's solver limits in its context, so every
+// solver (Cloudflare, Incapsula, DDoS-Guard, plain JS) honors them.
+type jsLimitsKey struct{}
+
+// withJSLimits returns ctx carrying limits.
+func withJSLimits(ctx context.Context, limits jsLimits) context.Context {
+	return context.WithValue(ctx, jsLimitsKey{}, limits)
 }
 
-func newJSSolver(pageURL string) *JSSolver {
-	return &JSSolver{pageURL: pageURL}
+// jsLimitsFrom returns the solver limits carried by ctx, or the defaults.
+func jsLimitsFrom(ctx context.Context) jsLimits {
+	if limits, ok := ctx.Value(jsLimitsKey{}).(jsLimits); ok {
+		return limits
+	}
+	return defaultJSLimits
 }
 
-// Solve executes the given JavaScript in a goja VM with DOM stubs.
-// It returns the extracted cookie or form data, or an error if execution
-// fails or times out.
-func (s *JSSolver) Solve(script string) (*SolveResult, error) {
-	vm := goja.New()
+// jsMemoryCheckInterval is how often the heap is sampled against
+// jsLimits.maxMemory.
+const jsMemoryCheckInterval = 50 * time.Millisecond
+
+// jsSolveSlot lets one Solve with a memory budget run at a time, so the
+// heap growth of one script isn't charged to another running alongside.
+var jsSolveSlot = make(chan struct{}, 1)
+
+// jsHeapBytes returns the bytes currently held by heap objects. Unlike
+// runtime.ReadMemStats it doesn't stop the world, so it can be sampled
+// often. The heap is shared by the whole process: solves under a budget
+// take turns (see jsSolveSlot), but the growth still counts the fetches
+// running meanwhile, so the budget is approximate, meant to stop runaway
+// scripts rather than to account precisely.
+func jsHeapBytes() int64 {
+	sample := []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}
+	metrics.Read(sample)
+	if sample[0].Value.Kind() != metrics.KindUint64 {
+		return 0
+	}
+	return int64(sample[0].Value.Uint64())
+}
+
+// Solve executes the given JavaScript in a goja VM with DOM stubs, then
+// runs its event loop (promises, async functions, timers) until no work
+// is left. It returns the extracted cookie or form data, or an error if
+// execution fails, the script exceeds the limits carried by ctx (see
+// withJSLimits) or ctx is done. Timers still pending at that point are
+// dropped.
+func (s *JSSolver) Solve(ctx context.Context, script string) (*SolveResult, error) {
+	limits := jsLimitsFrom(ctx)
+	if limits.maxMemory > 0 {
+		select {
+		case jsSolveSlot <- struct{}{}:
+			defer func() { <-jsSolveSlot }()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	loop := eventloop.NewEventLoop(eventloop.EnableConsole(false))
+	defer loop.Terminate()
 	result := &SolveResult{}
 
-	// Set up a watchdog goroutine that interrupts the VM after 10 seconds.
 	done := make(chan struct{})
-	go func() {
-		select {
-		case <-done:
-		case <-time.After(10 * time.Second):
-			vm.Interrupt("execution timeout")
+	defer close(done)
+
+	// ctx stops WebAssembly code, which the VM interrupt can't reach.
+	parent := ctx
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+	var wasm *jsWasm
+	defer func() {
+		if wasm != nil {
+			wasm.Close()
 		}
 	}()
-	defer close(done)
 
-	s.setupGlobals(vm, result)
+	var err error
+	loop.Run(func(vm *goja.Runtime) {
+		// Watchdog: interrupt the VM and stop the loop after the timeout,
+		// once the heap outgrows the memory budget, or when the fetch ends.
+		go func() {
+			stop := func(reason string) {
+				vm.Interrupt(reason)
+				cancel()
+				loop.StopNoWait()
+			}
+			timeout := time.NewTimer(limits.timeout)
+			defer timeout.Stop()
+			var check <-chan time.Time
+			var base int64
+			if limits.maxMemory > 0 {
+				base = jsHeapBytes()
+				ticker := time.NewTicker(jsMemoryCheckInterval)
+				defer ticker.Stop()
+				check = ticker.C
+			}
+			for {
+				select {
+				case <-done:
+					return
+				case <-parent.Done():
+					stop(parent.Err().Error())
+					return
+				case <-timeout.C:
+					stop(fmt.Sprintf("timed out after %s", limits.timeout))
+					return
+				case <-check:
+					if grown := jsHeapBytes() - base; grown > limits.maxMemory {
+						stop(fmt.Sprintf("memory limit exceeded (heap grew by %s, limit %s)", humanBytes(grown), humanBytes(limits.maxMemory)))
+						return
+					}
+				}
+			}
+		}()
 
-	_, err := vm.RunString(script)
+		s.setupGlobals(vm, result)
+		wasm = newJSWasm(ctx, vm)
+		wasm.setup()
+		if _, err = vm.RunString(script); err != nil {
+			return
+		}
+
+		// The loop ends by itself when no timer is left, but intervals
+		// never finish: once only intervals remain, let them run for
+		// 2*jsMaxTimerDelay and then stop the loop.
+		timers, ok := goja.AssertFunction(vm.Get("__timers"))
+		if !ok {
+			return
+		}
+		if v, err := timers(goja.Undefined()); err == nil && v.String() != "0,0" {
+			const tick = 100 * time.Millisecond
+			idle := time.Duration(0)
+			loop.SetInterval(func(vm *goja.Runtime) {
+				var counts []int
+				v, err := timers(goja.Undefined())
+				if err != nil || vm.ExportTo(v, &counts) != nil || len(counts) != 2 {
+					loop.StopNoWait()
+					return
+				}
+				switch pending, intervals := counts[0], counts[1]; {
+				case pending == 0 && intervals == 0:
+					loop.StopNoWait()
+				case pending == 0:
+					if idle += tick; idle >= 2*jsMaxTimerDelay {
+						loop.StopNoWait()
+					}
+				default:
+					idle = 0
+				}
+			}, tick)
+		}
+	})
 	if err != nil {
 		if intErr, ok := err.(*goja.InterruptedError); ok {
-			return nil, fmt.Errorf("JS execution timed out: %v", intErr.Value())
+			return nil, fmt.Errorf("JS execution stopped: %v", intErr.Value())
 		}
 		return nil, fmt.Errorf("JS execution error: %w", err)
 	}
@@ -61,8 +239,9 @@ func (s *JSSolver) Solve(script string) (*SolveResult, error) {
 }
 
 // setupGlobals registers browser-like globals in the goja VM so that
-// typical JS challenge scripts can execute: atob/btoa, setTimeout, console,
-// document (with cookie interception), window.location, and navigator.
+// typical JS challenge scripts can execute: atob/btoa, timers, crypto,
+// console, a DOM of the page (with cookie interception), fetch/XMLHttpRequest,
+// window.location, and navigator.
 func (s *JSSolver) setupGlobals(vm *goja.Runtime, result *SolveResult) {
 	parsedURL, _ := url.Parse(s.pageURL)
 
@@ -82,13 +261,58 @@ func (s *JSSolver) setupGlobals(vm *goja.Runtime, result *SolveResult) {
 		return vm.ToValue(base64.StdEncoding.EncodeToString([]byte(raw)))
 	})
 
-	// setTimeout: executes the callback immediately (no real async needed)
-	vm.Set("setTimeout", func(call goja.FunctionCall) goja.Value {
-		if fn, ok := goja.AssertFunction(call.Argument(0)); ok {
-			fn(goja.Undefined())
+	// setTimeout/setInterval: run on the event loop with delays capped at
+	// jsMaxTimerDelay; the longest timeout requested is recorded
+	for _, name := range []string{"setTimeout", "setInterval"} {
+		schedule, ok := goja.AssertFunction(vm.Get(name))
+		if !ok {
+			continue
 		}
-		return vm.ToValue(0)
-	})
+		isTimeout := name == "setTimeout"
+		vm.Set(name, func(call goja.FunctionCall) goja.Value {
+			d := time.Duration(call.Argument(1).ToInteger()) * time.Millisecond
+			if isTimeout && d > result.Delay {
+				result.Delay = d
+			}
+			args := []goja.Value{call.Argument(0), vm.ToValue(min(d, jsMaxTimerDelay).Milliseconds())}
+			if len(call.Arguments) > 2 {
+				args = append(args, call.Arguments[2:]...)
+			}
+			v, err := schedule(goja.Undefined(), args...)
+			if err != nil {
+				panic(err)
+			}
+			return v
+		})
+	}
+
+	// Track pending timeouts and live intervals, so Solve can tell when
+	// only intervals, which never finish on their own, are left.
+	vm.RunString(`
+		(function() {
+			var st = setTimeout, si = setInterval;
+			var pending = new Set(), intervals = new Set();
+			setTimeout = function(fn, d) {
+				if (typeof fn !== "function") return st.apply(null, arguments);
+				var args = Array.prototype.slice.call(arguments, 2);
+				var t = st(function() { pending.delete(t); fn.apply(null, args); }, d);
+				pending.add(t);
+				return t;
+			};
+			setInterval = function() {
+				var i = si.apply(null, arguments);
+				intervals.add(i);
+				return i;
+			};
+			var ct = clearTimeout, ci = clearInterval;
+			clearTimeout = function(t) { pending.delete(t); ct(t); };
+			clearInterval = function(i) { intervals.delete(i); ci(i); };
+			__timers = function() { return [pending.size, intervals.size]; };
+		})();
+	`)
+
+	// crypto, crypto.subtle, TextEncoder and TextDecoder
+	setupCrypto(vm)
 
 	// console: no-op stubs
 	console := vm.NewObject()
@@ -105,44 +329,100 @@ func (s *JSSolver) setupGlobals(vm *goja.Runtime, result *SolveResult) {
 			if len(kv) == 2 {
 				result.CookieName = kv[0]
 				result.CookieValue = kv[1]
+				result.Cookies = append(result.Cookies, &http.Cookie{Name: kv[0], Value: kv[1]})
 			}
 		}
 		return goja.Undefined()
 	})
 
-	// document object with DOM stubs
-	document := vm.NewObject()
-	document.Set("createElement", func(call goja.FunctionCall) goja.Value {
-		tagName := call.Argument(0).String()
-		elem := vm.NewObject()
-		elem.Set("tagName", strings.ToUpper(tagName))
-		elem.Set("innerHTML", "")
-		elem.Set("setAttribute", func(c goja.FunctionCall) goja.Value {
-			elem.Set(c.Argument(0).String(), c.Argument(1).String())
-			return goja.Undefined()
-		})
-		elem.Set("getAttribute", func(c goja.FunctionCall) goja.Value {
-			v := elem.Get(c.Argument(0).String())
-			if v == nil {
-				return goja.Null()
-			}
-			return v
-		})
-		return elem
-	})
-	document.Set("getElementById", func(call goja.FunctionCall) goja.Value {
-		return goja.Null()
+	// __getCookie: returns the page's cookies plus any the script set,
+	// formatted like document.cookie.
+	vm.Set("__getCookie", func(call goja.FunctionCall) goja.Value {
+		var parts []string
+		for _, c := range mergeCookies(s.cookies, result.Cookies) {
+			parts = append(parts, c.Name+"="+c.Value)
+		}
+		return vm.ToValue(strings.Join(parts, "; "))
 	})
-	document.Set("getElementsByTagName", func(call goja.FunctionCall) goja.Value {
-		return vm.NewArray()
+
+	// fetch and XMLHttpRequest: record the request and answer with an
+	// empty 200 response.
+	vm.Set("__recordRequest", func(call goja.FunctionCall) goja.Value {
+		req := JSRequest{
+			Method: strings.ToUpper(call.Argument(0).String()),
+			URL:    call.Argument(1).String(),
+		}
+		if h, ok := call.Argument(2).(*goja.Object); ok {
+			for _, k := range h.Keys() {
+				req.Headers = append(req.Headers, [2]string{k, h.Get(k).String()})
+			}
+		}
+		if b := call.Argument(3); !goja.IsUndefined(b) && !goja.IsNull(b) {
+			req.Body = b.String()
+		}
+		result.Requests = append(result.Requests, req)
+		return goja.Undefined()
 	})
-	vm.Set("document", document)
+	vm.RunString(`
+		function __emptyResponse(url) {
+			return {
+				ok: true, status: 200, statusText: "OK", url: url, redirected: false,
+				headers: { get: function() { return null; }, has: function() { return false; } },
+				text: function() { return Promise.resolve(""); },
+				json: function() { return Promise.resolve({}); },
+				arrayBuffer: function() { return Promise.resolve(new ArrayBuffer(0)); }
+			};
+		}
+		function __headerObject(h) {
+			var out = {};
+			if (!h) return out;
+			if (typeof h.forEach === "function") {
+				h.forEach(function(v, k) { if (Array.isArray(v)) { out[v[0]] = v[1]; } else { out[k] = v; } });
+				return out;
+			}
+			for (var k in h) { out[k] = String(h[k]); }
+			return out;
+		}
+		var fetch = function(input, init) {
+			init = init || {};
+			var url = typeof input === "string" ? input : (input && input.url) || String(input);
+			__recordRequest(init.method || "GET", url, __headerObject(init.headers), init.body);
+			return Promise.resolve(__emptyResponse(url));
+		};
+		var XMLHttpRequest = function() {
+			this.readyState = 0;
+			this.status = 0;
+			this.responseText = "";
+			this.response = "";
+			this._headers = {};
+		};
+		XMLHttpRequest.prototype.open = function(method, url) {
+			this._method = method;
+			this._url = String(url);
+			this.readyState = 1;
+		};
+		XMLHttpRequest.prototype.setRequestHeader = function(k, v) { this._headers[k] = String(v); };
+		XMLHttpRequest.prototype.getResponseHeader = function() { return null; };
+		XMLHttpRequest.prototype.getAllResponseHeaders = function() { return ""; };
+		XMLHttpRequest.prototype.abort = function() {};
+		XMLHttpRequest.prototype.send = function(body) {
+			__recordRequest(this._method || "GET", this._url, this._headers, body);
+			this.readyState = 4;
+			this.status = 200;
+			if (typeof this.onreadystatechange === "function") this.onreadystatechange();
+			if (typeof this.onload === "function") this.onload();
+		};
+	`)
+
+	// document: a live DOM of the page
+	dom := newJSDOM(vm, s.page, result)
+	vm.Set("document", dom.document())
 
 	// Define document.cookie as a property with getter/setter so that
 	// assignments like `document.cookie = "name=value"` are intercepted.
 	vm.RunString(`
 		Object.defineProperty(document, "cookie", {
-			get: function() { return ""; },
+			get: function() { return __getCookie(); },
 			set: function(v) { __setCookie(v); },
 			configurable: true
 		});
@@ -159,6 +439,12 @@ func (s *JSSolver) setupGlobals(vm *goja.Runtime, result *SolveResult) {
 		loc.Set("host", parsedURL.Host)
 		window.Set("location", loc)
 	}
+	window.Set("fetch", vm.Get("fetch"))
+	window.Set("crypto", vm.Get("crypto"))
+	window.Set("XMLHttpRequest", vm.Get("XMLHttpRequest"))
+	window.Set("document", vm.Get("document"))
+	window.Set("addEventListener", dom.onLoad())
+	window.Set("removeEventListener", func(goja.FunctionCall) goja.Value { return goja.Undefined() })
 	vm.Set("window", window)
 	vm.Set("location", window.Get("location"))
 
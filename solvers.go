@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// Solver handles an anti-bot challenge. The built-in Cloudflare,
+// Incapsula and DDoS-Guard solvers are compiled-in Solvers, and
+// vendor-specific ones can be added without touching challenge.go, as
+// more compiled-in solvers or as plugins. Solvers are tried in order on
+// the response the previous ones left; each whose Detect matches gets to
+// Solve it.
+type Solver interface {
+	// Name identifies the solver; it is reported as the challenge name
+	// while its challenge is in place.
+	Name() string
+	// Detect reports whether resp (with its decoded body) is this
+	// solver's challenge.
+	Detect(resp *http.Response, body []byte) bool
+	// Solve answers the challenge, making any requests it needs through
+	// f, and returns what the retried request should carry.
+	Solve(ctx context.Context, f *Fetcher, resp *http.Response, body []byte) (*Solution, error)
+}
+
+// scriptSolver is a Solver that runs the page's scripts in the JS
+// sandbox; --no-js leaves it out.
+type scriptSolver interface {
+	Solver
+	runsScripts()
+}
+
+// Solution is what a Solver earned: cookies to store and send, and
+// headers to add to the retried request.
+type Solution struct {
+	Cookies []*http.Cookie
+	Headers [][2]string
+}
+
+// Fetcher makes requests for a Solver through the fetch's transport, so
+// they carry the browser profile's fingerprint and the session's cookies.
+// Cookies set by its responses are sent on its later requests. Requests
+// only go to the host being fetched, so a challenge page can't send the
+// session's cookies, or a POST, anywhere else.
+type Fetcher struct {
+	tr      http.RoundTripper
+	profile BrowserProfile
+	host    string
+	cookies []*http.Cookie
+	verbose bool
+}
+
+// newFetcher returns a Fetcher for solving a challenge of targetURL.
+func newFetcher(tr http.RoundTripper, profile BrowserProfile, targetURL string, cookies []*http.Cookie, verbose bool) *Fetcher {
+	f := &Fetcher{tr: tr, profile: profile, cookies: cookies, verbose: verbose}
+	if u, err := url.Parse(targetURL); err == nil {
+		f.host = u.Host
+	}
+	return f
+}
+
+// Fetch sends a request with the given headers on top of the profile's,
+// following redirects on the target's host, and returns the response with
+// its decoded body. Only http and https URLs on the target's host are
+// allowed; a redirect elsewhere isn't followed but returned as is.
+func (f *Fetcher) Fetch(ctx context.Context, method, targetURL string, headers [][2]string, body string) (*http.Response, []byte, error) {
+	u, err := url.Parse(targetURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || f.host == "" || !strings.EqualFold(u.Host, f.host) {
+		return nil, nil, fmt.Errorf("refusing %s %s: solvers may only request %s", method, targetURL, f.host)
+	}
+	if f.verbose {
+		fmt.Fprintf(os.Stderr, "[*] Solver request %s %s\n", method, targetURL)
+	}
+	policy := redirectPolicyFrom(ctx)
+	if policy.scope != "none" {
+		policy.scope = "same-host"
+	}
+	policy.verbose = false
+	resp, respBody, err := doFetchWithBody(withRedirectPolicy(ctx, policy), f.tr, f.profile, method, targetURL, headers, f.cookies, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	if loc := resp.Header.Get("Location"); f.verbose && loc != "" && resp.StatusCode/100 == 3 {
+		fmt.Fprintf(os.Stderr, "[*] Solver request not following redirect to %s\n", loc)
+	}
+	f.cookies = mergeCookies(f.cookies, redirectCookies(resp))
+	return resp, respBody, nil
+}
+
+// Cookies returns the session's cookies, including those set by the
+// Fetcher's responses so far.
+func (f *Fetcher) Cookies() []*http.Cookie {
+	return f.cookies
+}
+
+// Profile returns the browser profile requests are made as.
+func (f *Fetcher) Profile() BrowserProfile {
+	return f.profile
+}
+
+var (
+	// solverRegistry holds solvers compiled in, added by registerSolver
+	// from init functions.
+	solverRegistry []Solver
+	// pluginSolvers holds the external plugins loaded for the current
+	// invocation; see loadSolverPlugins.
+	pluginSolvers []Solver
+)
+
+// registerSolver adds a compiled-in solver to the registry.
+func registerSolver(s Solver) {
+	solverRegistry = append(solverRegistry, s)
+}
+
+// registeredSolvers returns the compiled-in solvers followed by the
+// loaded plugins, in the order they are tried.
+func registeredSolvers() []Solver {
+	return append(append([]Solver(nil), solverRegistry...), pluginSolvers...)
+}
+
+// withoutScriptSolvers returns solvers without the ones that run page
+// scripts, for --no-js.
+func withoutScriptSolvers(solvers []Solver) []Solver {
+	var out []Solver
+	for _, s := range solvers {
+		if _, ok := s.(scriptSolver); !ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// findSolver returns the first solver that detects its challenge in
+// resp, or nil.
+func findSolver(solvers []Solver, resp *http.Response, body []byte) Solver {
+	for _, s := range solvers {
+		if s.Detect(resp, body) {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// TestFetcherStaysOnHost checks that a solver's requests, redirects
+// included, never reach a host other than the one being fetched.
+func TestFetcherStaysOnHost(t *testing.T) {
+	otherHit := false
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otherHit = true
+	}))
+	defer other.Close()
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/away":
+			http.Redirect(w, r, other.URL+"/collect", http.StatusTemporaryRedirect)
+		case "/here":
+			http.Redirect(w, r, "/done", http.StatusFound)
+		default:
+			w.Write([]byte("done"))
+		}
+	}))
+	defer target.Close()
+
+	// The test servers share 127.0.0.1, so the other one is reached as
+	// localhost.
+	other.URL = strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+	f := newFetcher(http.DefaultTransport, BrowserProfile{}, target.URL+"/", nil, false)
+	ctx := context.Background()
+
+	if _, _, err := f.Fetch(ctx, "GET", other.URL+"/", nil, ""); err == nil {
+		t.Errorf("Fetch of another host succeeded")
+	}
+
+	resp, _, err := f.Fetch(ctx, "POST", target.URL+"/away", nil, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusTemporaryRedirect || otherHit {
+		t.Errorf("redirect to another host was followed (status %d)", resp.StatusCode)
+	}
+
+	resp, body, err := f.Fetch(ctx, "GET", target.URL+"/here", nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "done" {
+		t.Errorf("redirect on the same host wasn't followed: %d %q", resp.StatusCode, body)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// casStore is a content-addressed store: each body is written once under
+// objects/<aa>/<sha256>, and every fetch appends a line to index.jsonl
+// mapping the URL to the hash. Identical pages across runs share one
+// object, and comparing index lines between runs shows what changed.
+type casStore struct {
+	dir string
+}
+
+// casIndexEntry is one line of index.jsonl.
+type casIndexEntry struct {
+	URL       string    `json:"url"`
+	SHA256    string    `json:"sha256"`
+	Status    int       `json:"status"`
+	Size      int       `json:"size"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// parseStore parses a --store spec. Only "cas:<dir>" is supported.
+func parseStore(spec string) (*casStore, error) {
+	kind, dir, ok := strings.Cut(spec, ":")
+	if !ok || kind != "cas" || dir == "" {
+		return nil, fmt.Errorf("invalid store %q (expected cas:<dir>)", spec)
+	}
+	return &casStore{dir: dir}, nil
+}
+
+// objectPath returns the path of the object with the given hex hash.
+func (s *casStore) objectPath(sum string) string {
+	return filepath.Join(s.dir, "objects", sum[:2], sum)
+}
+
+// Put stores content for r, skipping the write if an identical object
+// already exists, and appends an index entry. It returns the hex hash.
+func (s *casStore) Put(r *fetchResult, content []byte) (string, error) {
+	raw := sha256.Sum256(content)
+	sum := hex.EncodeToString(raw[:])
+
+	obj := s.objectPath(sum)
+	if _, err := os.Stat(obj); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(obj), 0755); err != nil {
+			return "", err
+		}
+		// Write to a temp file and rename so a crash never leaves a
+		// truncated object under a valid hash.
+		tmp := obj + ".tmp"
+		if err := os.WriteFile(tmp, content, 0644); err != nil {
+			return "", err
+		}
+		if err := os.Rename(tmp, obj); err != nil {
+			return "", err
+		}
+	}
+
+	line, err := json.Marshal(casIndexEntry{
+		URL:       r.URL,
+		SHA256:    sum,
+		Status:    r.StatusCode,
+		Size:      len(content),
+		FetchedAt: r.FetchedAt,
+	})
+	if err != nil {
+		return "", err
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, "index.jsonl"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return "", err
+	}
+	return sum, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// resultStream writes batch and crawl results to the output as they are
+// produced, for --ndjson and --stream: as JSON Lines, one
+// parallelJSONEntry per line, or as the page blocks of the text output.
+// It is safe for concurrent use.
+type resultStream struct {
+	mu   sync.Mutex
+	out  *outputWriter
+	opts outputOptions
+	// asJSON is set for JSON Lines: with --ndjson, or --stream and --json.
+	asJSON bool
+	// n counts the results written, so text blocks are separated like
+	// formatParallelResults separates them.
+	n int
+}
+
+// streamResults reports whether results are written as they come in.
+func streamResults() bool {
+	return flagNDJSON || flagStream
+}
+
+// newResultStream opens the output for --ndjson or --stream. Results go to
+// stdout or -o, so the file-writing modes are rejected.
+func newResultStream(opts outputOptions) (*resultStream, error) {
+	if flagOutDir != "" || flagStore != "" {
+		return nil, fmt.Errorf("--ndjson and --stream write to stdout or -o and can't be combined with --out-dir or --store")
+	}
+	out, err := openOutput()
+	if err != nil {
+		return nil, err
+	}
+	return &resultStream{out: out, opts: opts, asJSON: flagNDJSON || opts.asJSON}, nil
+}
+
+// write outputs r. The body is processed before the lock is taken, so
+// slow conversions don't hold up other results.
+func (w *resultStream) write(r *fetchResult) {
+	if !w.asJSON {
+		block := formatParallelResult(r, w.opts)
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		if w.n > 0 {
+			block = "\n" + block
+		}
+		w.n++
+		w.out.Write([]byte(block))
+		return
+	}
+	line, err := json.Marshal(newParallelJSONEntry(r, w.opts))
+	if err != nil {
+		line, _ = json.Marshal(parallelJSONEntry{URL: r.URL, Status: r.StatusCode, Error: err.Error()})
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.out.Write(append(line, '\n'))
+}
+
+// Close flushes and closes the output. Calling it again is a no-op.
+func (w *resultStream) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.out.Close()
+}
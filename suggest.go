@@ -0,0 +1,93 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// suggestEngines maps an engine name to its query suggestion (autocomplete)
+// endpoint for a prefix. Each answers in the OpenSearch suggestions
+// format; see parseSuggestions.
+var suggestEngines = map[string]func(p searchParams) string{
+	"google": func(p searchParams) string {
+		v := url.Values{"client": {"firefox"}, "ie": {"utf-8"}, "oe": {"utf-8"}, "q": {p.Query}}
+		v.Set("hl", cmp.Or(p.Lang, "en"))
+		if p.Region != "" {
+			v.Set("gl", p.Region)
+		}
+		return "https://suggestqueries.google.com/complete/search?" + v.Encode()
+	},
+	"duckduckgo": func(p searchParams) string {
+		v := url.Values{"q": {p.Query}, "type": {"list"}}
+		if kl := ddgRegion(p); kl != "" {
+			v.Set("kl", kl)
+		}
+		return "https://duckduckgo.com/ac/?" + v.Encode()
+	},
+	"brave": func(p searchParams) string {
+		v := braveLocale(p)
+		v.Set("q", p.Query)
+		return "https://search.brave.com/api/suggest?" + v.Encode()
+	},
+}
+
+// parseSuggestions parses an OpenSearch suggestions response,
+// ["prefix", ["completion", ...], ...], into its completions.
+func parseSuggestions(body []byte) ([]string, error) {
+	var resp []json.RawMessage
+	if err := json.Unmarshal(body, &resp); err != nil || len(resp) < 2 {
+		return nil, fmt.Errorf("unexpected suggestions response")
+	}
+	var completions []string
+	if err := json.Unmarshal(resp[1], &completions); err != nil {
+		return nil, fmt.Errorf("unexpected suggestions response: %w", err)
+	}
+	return completions, nil
+}
+
+// runSuggest prints engineName's query suggestions for prefix, one per
+// line, or as a JSON array with --json.
+func runSuggest(engineName string, prefix string) error {
+	suggestURL, ok := suggestEngines[engineName]
+	if !ok {
+		return fmt.Errorf("no suggestions from %q (engines: %s)", engineName, strings.Join(slices.Sorted(maps.Keys(suggestEngines)), ", "))
+	}
+	p := searchParams{Query: prefix, Lang: searchLang, Region: searchRegion}
+	if err := p.normalize(); err != nil {
+		return err
+	}
+
+	opts := newFetchOptions(suggestURL(p))
+	opts.acceptLanguage = acceptLanguage(p)
+	opts.accept = "application/json, text/javascript, */*; q=0.01"
+	result, err := fetchOne(opts)
+	if err != nil {
+		return fmt.Errorf("suggest fetch failed: %w", err)
+	}
+	if result.StatusCode != 200 {
+		return fmt.Errorf("%s suggestions returned HTTP %d", engineName, result.StatusCode)
+	}
+	completions, err := parseSuggestions(result.Body)
+	if err != nil {
+		return fmt.Errorf("%s: %w", engineName, err)
+	}
+
+	if flagJSONOutput {
+		if completions == nil {
+			completions = []string{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(completions)
+	}
+	for _, c := range completions {
+		fmt.Fprintln(os.Stdout, c)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// fetchTiming is the timing breakdown of a fetch's final request, redirects
+// included, in milliseconds. DNS, Connect and TLS are zero when the request
+// reused a connection (or, for DNS, when the host was prefetched); TTFB
+// runs from the start of the request to the first byte of the final
+// response, and Total to the end of its body.
+type fetchTiming struct {
+	DNS     float64 `json:"dns_ms"`
+	Connect float64 `json:"connect_ms"`
+	TLS     float64 `json:"tls_ms"`
+	TTFB    float64 `json:"ttfb_ms"`
+	Total   float64 `json:"total_ms"`
+}
+
+// requestTrace collects the phase durations of a request through
+// httptrace. Phases that happen more than once, e.g. a new connection per
+// redirect, add up. The hooks may run concurrently (parallel dials), hence
+// the lock.
+type requestTrace struct {
+	mu        sync.Mutex
+	start     time.Time
+	dnsStart  time.Time
+	connStart map[string]time.Time
+	tlsStart  time.Time
+	firstByte time.Time
+	timing    fetchTiming
+}
+
+// withRequestTrace returns ctx set up to trace the requests made with it.
+func withRequestTrace(ctx context.Context) (context.Context, *requestTrace) {
+	t := &requestTrace{start: time.Now(), connStart: make(map[string]time.Time)}
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.timing.DNS += msSince(t.dnsStart)
+			t.mu.Unlock()
+		},
+		ConnectStart: func(network, addr string) {
+			t.mu.Lock()
+			t.connStart[network+addr] = time.Now()
+			t.mu.Unlock()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			// Only the dial that won counts.
+			t.mu.Lock()
+			if err == nil {
+				t.timing.Connect += msSince(t.connStart[network+addr])
+			}
+			t.mu.Unlock()
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			t.tlsStart = time.Now()
+			t.mu.Unlock()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			t.timing.TLS += msSince(t.tlsStart)
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.firstByte = time.Now()
+			t.mu.Unlock()
+		},
+	}), t
+}
+
+// done returns the timing of the traced request, which has been read to
+// the end.
+func (t *requestTrace) done() *fetchTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	timing := t.timing
+	if !t.firstByte.IsZero() {
+		timing.TTFB = ms(t.firstByte.Sub(t.start))
+	}
+	timing.Total = msSince(t.start)
+	return &timing
+}
+
+// ms converts d to fractional milliseconds, rounded to the microsecond.
+func ms(d time.Duration) float64 {
+	return float64(d.Round(time.Microsecond)) / float64(time.Millisecond)
+}
+
+func msSince(t time.Time) float64 {
+	return ms(time.Since(t))
+}
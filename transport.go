@@ -1,15 +1,21 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"compress/gzip"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/http/httptrace"
+	"os"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/andybalholm/brotli"
 	utls "github.com/refraction-networking/utls"
@@ -21,67 +27,268 @@ import (
 // negotiated protocol.
 type roundTripper struct {
 	profile BrowserProfile
+	opts    transportOptions
 	h2      *http2.Transport
 	h1      *http.Transport
+	// h1Hosts records hosts that failed over HTTP/2 and were downgraded,
+	// so later requests in the same run go straight to HTTP/1.1.
+	h1Hosts sync.Map
 }
 
+// transportOptions tunes connection-level behavior of the transport.
+// Zero values leave the corresponding limit to the request context.
+type transportOptions struct {
+	// connectTimeout bounds the TCP dial plus TLS handshake, so a dead host
+	// or a hung handshake fails fast even when the overall timeout is long.
+	connectTimeout time.Duration
+	// readTimeout bounds each read from the server: waiting for response
+	// headers and any stall while streaming the body.
+	readTimeout time.Duration
+	// http10 sends requests as HTTP/1.0 on a fresh connection each time,
+	// for ancient or embedded servers that mishandle HTTP/1.1 and h2.
+	http10 bool
+	// noKeepAlive disables connection reuse and forces HTTP/1.1.
+	noKeepAlive bool
+	verbose     bool
+}
+
+// errHTTP11Negotiated is returned by the h2 dialer when the server selected
+// http/1.1 via ALPN; RoundTrip retries such requests on the h1 transport.
+var errHTTP11Negotiated = errors.New("server negotiated http/1.1")
+
 // newTransport creates a new http.RoundTripper that uses uTLS with the
 // given browser profile's TLS ClientHello fingerprint.
-func newTransport(profile BrowserProfile) (http.RoundTripper, error) {
-	rt := &roundTripper{profile: profile}
+func newTransport(profile BrowserProfile, opts transportOptions) (http.RoundTripper, error) {
+	rt := &roundTripper{profile: profile, opts: opts}
 
 	// Create an HTTP/2 transport that uses our uTLS dialer.
 	// We ignore the *tls.Config parameter since we use uTLS instead.
 	rt.h2 = &http2.Transport{
 		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
-			return rt.dialTLS(ctx, network, addr)
+			conn, err := rt.dialTLS(ctx, network, addr, []string{"h2", "http/1.1"})
+			if err != nil {
+				return nil, err
+			}
+			if conn.(*utls.UConn).ConnectionState().NegotiatedProtocol != "h2" {
+				conn.Close()
+				return nil, errHTTP11Negotiated
+			}
+			return conn, nil
 		},
 	}
 
 	// Create an HTTP/1.1 transport as fallback.
 	rt.h1 = &http.Transport{
 		DialTLSContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-			return rt.dialTLS(ctx, network, addr)
+			return rt.dialTLS(ctx, network, addr, []string{"http/1.1"})
 		},
+		DialContext:           rt.dial,
+		ResponseHeaderTimeout: opts.readTimeout,
+		DisableKeepAlives:     opts.noKeepAlive,
 	}
 
 	return rt, nil
 }
 
-// dialTLS creates a uTLS connection with the browser profile's fingerprint.
-func (rt *roundTripper) dialTLS(ctx context.Context, network, addr string) (net.Conn, error) {
+// dial opens a plain TCP connection for http:// URLs, honoring the
+// connect and read timeouts.
+func (rt *roundTripper) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	if rt.opts.connectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rt.opts.connectTimeout)
+		defer cancel()
+	}
+	dialer := &net.Dialer{}
+	conn, err := dialCached(ctx, dialer, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if rt.opts.readTimeout > 0 {
+		conn = &readTimeoutConn{Conn: conn, timeout: rt.opts.readTimeout}
+	}
+	return conn, nil
+}
+
+// dialTLS creates a uTLS connection with the browser profile's fingerprint,
+// advertising the given ALPN protocols.
+func (rt *roundTripper) dialTLS(ctx context.Context, network, addr string, protos []string) (net.Conn, error) {
 	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
 		return nil, err
 	}
+	if rt.opts.connectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, rt.opts.connectTimeout)
+		defer cancel()
+	}
 	dialer := &net.Dialer{}
-	tcpConn, err := dialer.DialContext(ctx, network, addr)
+	tcpConn, err := dialCached(ctx, dialer, network, addr)
 	if err != nil {
 		return nil, err
 	}
-	tlsConn := utls.UClient(tcpConn, &utls.Config{
+	var conn net.Conn = tcpConn
+	if rt.opts.readTimeout > 0 {
+		conn = &readTimeoutConn{Conn: tcpConn, timeout: rt.opts.readTimeout}
+	}
+	tlsConn := utls.UClient(conn, &utls.Config{
 		ServerName: host,
-		NextProtos: []string{"h2", "http/1.1"},
+		NextProtos: protos,
 	}, rt.profile.TLSHello)
-	if err := tlsConn.Handshake(); err != nil {
+	// net/http only reports the handshakes it does itself to a trace.
+	trace := httptrace.ContextClientTrace(ctx)
+	if trace != nil && trace.TLSHandshakeStart != nil {
+		trace.TLSHandshakeStart()
+	}
+	err = tlsConn.HandshakeContext(ctx)
+	if trace != nil && trace.TLSHandshakeDone != nil {
+		trace.TLSHandshakeDone(tls.ConnectionState{}, err)
+	}
+	if err != nil {
 		tcpConn.Close()
-		return nil, fmt.Errorf("TLS handshake failed: %w", err)
+		return nil, fmt.Errorf("%w: %w", errTLSHandshake, err)
 	}
 	return tlsConn, nil
 }
 
-// RoundTrip executes an HTTP request. It first probes the server's ALPN
-// support by dialing and checking the negotiated protocol, then delegates
-// to either the HTTP/2 or HTTP/1.1 transport.
+// readTimeoutConn refreshes the read deadline before every Read, turning
+// a fixed deadline into an idle timeout: a server that keeps sending data
+// is never cut off, but one that stalls for longer than timeout is.
+type readTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *readTimeoutConn) Read(p []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(p)
+}
+
+// RoundTrip executes an HTTP request. HTTPS requests go to the HTTP/2
+// transport first; if the server negotiates http/1.1 or the h2 connection
+// fails with a protocol error, the request is retried over HTTP/1.1 and the
+// host is remembered as h1-only for the rest of the run.
 func (rt *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	// For non-HTTPS, fall back to HTTP/1.1.
-	if req.URL.Scheme != "https" {
+	if rt.opts.http10 {
+		return rt.roundTripHTTP10(req)
+	}
+	// For non-HTTPS or when keep-alive is disabled, use HTTP/1.1.
+	if req.URL.Scheme != "https" || rt.opts.noKeepAlive {
+		return rt.h1.RoundTrip(req)
+	}
+	if _, ok := rt.h1Hosts.Load(req.URL.Host); ok {
 		return rt.h1.RoundTrip(req)
 	}
-	// Try HTTP/2 first. If the server negotiated h2 via ALPN, the h2
-	// transport will handle it. We use h2 by default since our ALPN
-	// lists h2 first and most modern servers support it.
-	return rt.h2.RoundTrip(req)
+
+	resp, err := rt.h2.RoundTrip(req)
+	if err == nil || !isH2DowngradeError(err) || (req.Body != nil && req.GetBody == nil) {
+		return resp, err
+	}
+
+	if rt.opts.verbose && !errors.Is(err, errHTTP11Negotiated) {
+		fmt.Fprintf(os.Stderr, "[*] HTTP/2 error (%v), retrying over HTTP/1.1\n", err)
+	}
+	rt.h1Hosts.Store(req.URL.Host, true)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = body
+	}
+	return rt.h1.RoundTrip(req)
+}
+
+// isH2DowngradeError reports whether err means the server cannot (or will
+// not) speak HTTP/2 with us, so retrying over HTTP/1.1 may succeed.
+func isH2DowngradeError(err error) bool {
+	if errors.Is(err, errHTTP11Negotiated) {
+		return true
+	}
+	var se http2.StreamError
+	if errors.As(err, &se) {
+		return se.Code == http2.ErrCodeProtocol || se.Code == http2.ErrCodeHTTP11Required
+	}
+	var ce http2.ConnectionError
+	if errors.As(err, &ce) {
+		return http2.ErrCode(ce) == http2.ErrCodeProtocol || http2.ErrCode(ce) == http2.ErrCodeHTTP11Required
+	}
+	var ge http2.GoAwayError
+	if errors.As(err, &ge) {
+		return ge.ErrCode == http2.ErrCodeProtocol || ge.ErrCode == http2.ErrCodeHTTP11Required
+	}
+	return false
+}
+
+// roundTripHTTP10 writes the request as HTTP/1.0 on a dedicated connection
+// and reads the response until the server closes it. net/http cannot send
+// HTTP/1.0 requests, so the request line and headers are written by hand.
+func (rt *roundTripper) roundTripHTTP10(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	addr := req.URL.Host
+	if req.URL.Port() == "" {
+		if req.URL.Scheme == "https" {
+			addr = net.JoinHostPort(req.URL.Hostname(), "443")
+		} else {
+			addr = net.JoinHostPort(req.URL.Hostname(), "80")
+		}
+	}
+
+	var conn net.Conn
+	var err error
+	if req.URL.Scheme == "https" {
+		conn, err = rt.dialTLS(ctx, "tcp", addr, []string{"http/1.1"})
+	} else {
+		conn, err = rt.dial(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	bw := bufio.NewWriter(conn)
+	fmt.Fprintf(bw, "%s %s HTTP/1.0\r\nHost: %s\r\n", req.Method, req.URL.RequestURI(), host)
+	req.Header.Write(bw)
+	bw.WriteString("\r\n")
+	if req.Body != nil {
+		if _, err := io.Copy(bw, req.Body); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		req.Body.Close()
+	}
+	if err := bw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body = &connClosingBody{ReadCloser: resp.Body, conn: conn}
+	return resp, nil
+}
+
+// connClosingBody closes the underlying connection along with the body.
+type connClosingBody struct {
+	io.ReadCloser
+	conn net.Conn
+}
+
+func (b *connClosingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.conn.Close()
+	return err
 }
 
 // doFetch performs an HTTP request using the given transport and profile.
@@ -90,9 +297,23 @@ func doFetch(ctx context.Context, tr http.RoundTripper, profile BrowserProfile,
 	return doFetchWithBody(ctx, tr, profile, method, url, extraHeaders, cookies, "")
 }
 
-// doFetchWithBody performs an HTTP request using the given transport and profile.
-// If body is non-empty, it is sent as the request body (useful for POST/PUT requests).
-func doFetchWithBody(ctx context.Context, tr http.RoundTripper, profile BrowserProfile, method, targetURL string, extraHeaders [][2]string, cookies []*http.Cookie, body string) (*http.Response, []byte, error) {
+// truncatedBodyError is returned by doFetchWithBody together with the
+// response and the body bytes received before the read failed.
+type truncatedBodyError struct {
+	n   int // raw bytes received
+	err error
+}
+
+func (e *truncatedBodyError) Error() string {
+	return fmt.Sprintf("body truncated after %d bytes: %v", e.n, e.err)
+}
+
+func (e *truncatedBodyError) Unwrap() error { return e.err }
+
+// sendRequest sends a request with the profile's headers, extraHeaders
+// and cookies, following up to 10 redirects. The caller must close the
+// response body, which is still encoded as indicated by Content-Encoding.
+func sendRequest(ctx context.Context, tr http.RoundTripper, profile BrowserProfile, method, targetURL string, extraHeaders [][2]string, cookies []*http.Cookie, body string) (*http.Response, error) {
 	var reqBody io.Reader
 	if body != "" {
 		reqBody = strings.NewReader(body)
@@ -100,7 +321,7 @@ func doFetchWithBody(ctx context.Context, tr http.RoundTripper, profile BrowserP
 
 	req, err := http.NewRequestWithContext(ctx, method, targetURL, reqBody)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
 	// Apply profile headers in order
@@ -117,41 +338,58 @@ func doFetchWithBody(ctx context.Context, tr http.RoundTripper, profile BrowserP
 	}
 
 	client := &http.Client{
-		Transport: tr,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			if len(via) >= 10 {
-				return fmt.Errorf("too many redirects")
-			}
-			return nil
-		},
+		Transport:     tr,
+		CheckRedirect: redirectPolicyFrom(ctx).checkRedirect,
 	}
+	return client.Do(req)
+}
 
-	resp, err := client.Do(req)
+// doFetchWithBody performs an HTTP request using the given transport and profile.
+// If body is non-empty, it is sent as the request body (useful for POST/PUT requests).
+func doFetchWithBody(ctx context.Context, tr http.RoundTripper, profile BrowserProfile, method, targetURL string, extraHeaders [][2]string, cookies []*http.Cookie, body string) (*http.Response, []byte, error) {
+	resp, err := sendRequest(ctx, tr, profile, method, targetURL, extraHeaders, cookies, body)
 	if err != nil {
 		return nil, nil, err
 	}
 	defer resp.Body.Close()
+	respBody, err := readBody(resp)
+	return resp, respBody, err
+}
 
+// readBody reads and decodes the whole response body. A body cut off
+// mid-stream is returned with a *truncatedBodyError.
+func readBody(resp *http.Response) ([]byte, error) {
 	// Read the raw body bytes first, then decompress.
 	// Buffering first allows fallback to raw bytes if decompression fails.
-	rawBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return resp, nil, fmt.Errorf("read body failed: %w", err)
+	// If the read fails mid-stream (reset, timeout), keep what arrived and
+	// report it as truncated: partial HTML is often still useful.
+	// With --max-body-size, reading stops at the limit, both on the wire
+	// and after decompression, and the body counts as truncated.
+	limit := bodyLimit(resp)
+	rawBody, readErr := io.ReadAll(capReader(resp.Body, limit))
+	if readErr != nil && len(rawBody) == 0 {
+		return nil, fmt.Errorf("read body failed: %w", readErr)
 	}
+	partial := readErr != nil
 
 	respBody := rawBody
 	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
 	case "gzip":
 		gr, err := gzip.NewReader(bytes.NewReader(rawBody))
 		if err == nil {
-			if decoded, err := io.ReadAll(gr); err == nil {
+			decoded, err := io.ReadAll(capReader(gr, limit))
+			if err == nil || (partial && len(decoded) > 0) {
 				respBody = decoded
+			} else if errors.Is(err, errBodyTooLarge) {
+				respBody, partial, readErr = decoded, true, err
 			}
 		}
 	case "br":
 		br := brotli.NewReader(bytes.NewReader(rawBody))
-		decoded, err := io.ReadAll(br)
-		if err == nil {
+		decoded, err := io.ReadAll(capReader(br, limit))
+		if errors.Is(err, errBodyTooLarge) {
+			respBody, partial, readErr = decoded, true, err
+		} else if err == nil {
 			respBody = decoded
 		} else if len(decoded) > 0 {
 			// Brotli "excessive input" can occur with trailing data after
@@ -160,5 +398,8 @@ func doFetchWithBody(ctx context.Context, tr http.RoundTripper, profile BrowserP
 		}
 	}
 
-	return resp, respBody, nil
+	if partial {
+		return respBody, &truncatedBodyError{n: len(rawBody), err: readErr}
+	}
+	return respBody, nil
 }
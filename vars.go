@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// loadVarRows reads variable rows from a CSV file (first line is the
+// header) or a JSONL file (one object per line). The format is chosen by
+// extension (.jsonl, .ndjson) or by the first character being '{'.
+func loadVarRows(path string) ([]map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	trimmed := bytes.TrimSpace(data)
+	if strings.HasSuffix(path, ".jsonl") || strings.HasSuffix(path, ".ndjson") || bytes.HasPrefix(trimmed, []byte("{")) {
+		return parseJSONLRows(data)
+	}
+	return parseCSVRows(data)
+}
+
+// parseCSVRows parses CSV with a header row into one map per record.
+func parseCSVRows(data []byte) ([]map[string]string, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("parse CSV: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := records[0]
+	rows := make([]map[string]string, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		row := make(map[string]string, len(header))
+		for i, name := range header {
+			if i < len(rec) {
+				row[strings.TrimSpace(name)] = rec[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// parseJSONLRows parses one JSON object per line. Non-string values are
+// rendered with their JSON text (numbers stay numbers, e.g. 42).
+func parseJSONLRows(data []byte) ([]map[string]string, error) {
+	var rows []map[string]string
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+	for n := 1; sc.Scan(); n++ {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(line, &obj); err != nil {
+			return nil, fmt.Errorf("parse JSONL line %d: %w", n, err)
+		}
+		row := make(map[string]string, len(obj))
+		for k, raw := range obj {
+			var s string
+			if err := json.Unmarshal(raw, &s); err == nil {
+				row[k] = s
+			} else {
+				row[k] = string(raw)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, sc.Err()
+}
+
+// expandURLTemplate executes a URL template such as
+// "https://site/items/{{.sku}}" once per row. Values are URL-escaped, so a
+// field with spaces, "&" or "/" stays one path segment or query value;
+// {{raw "field"}} inserts a field as is. Missing fields are an error so
+// that a typo doesn't silently produce broken URLs.
+func expandURLTemplate(tmpl string, rows []map[string]string) ([]batchItem, error) {
+	var row map[string]string
+	t, err := template.New("url").Option("missingkey=error").Funcs(template.FuncMap{
+		"raw": func(key string) (string, error) {
+			v, ok := row[key]
+			if !ok {
+				return "", fmt.Errorf("no field %q", key)
+			}
+			return v, nil
+		},
+	}).Parse(tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL template %q: %w", tmpl, err)
+	}
+	items := make([]batchItem, 0, len(rows))
+	for i, r := range rows {
+		row = r
+		var sb strings.Builder
+		if err := t.Execute(&sb, escapeVars(r)); err != nil {
+			return nil, fmt.Errorf("URL template row %d: %w", i+1, err)
+		}
+		items = append(items, batchItem{URL: sb.String(), Vars: r})
+	}
+	return items, nil
+}
+
+// escapeVars returns row with its values escaped for any part of a URL:
+// query escaping, with spaces as %20 rather than "+", which is only a
+// space in a query.
+func escapeVars(row map[string]string) map[string]string {
+	escaped := make(map[string]string, len(row))
+	for k, v := range row {
+		escaped[k] = strings.ReplaceAll(url.QueryEscape(v), "+", "%20")
+	}
+	return escaped
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+// bingVideosURL returns Bing's video search page for p.
+func bingVideosURL(p searchParams) string {
+	v := bingLocale(p)
+	v.Set("q", p.Query)
+	v.Set("count", strconv.Itoa(p.MaxResults))
+	return "https://www.bing.com/videos/search?" + v.Encode()
+}
+
+// parseBingVideoResults parses Bing video search HTML. Each tile is a
+// <div class="mc_vtvc"> whose <div class="vrhdata"> carries the video's
+// metadata as JSON in its vrhm attribute: "vt" title, "pgurl" page URL,
+// "du" duration. The channel is in <div class="mc_vtvc_meta_row_channel">.
+func parseBingVideoResults(body []byte) []searchResult {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	var results []searchResult
+	for _, tile := range filterNodes(doc, func(n *html.Node) bool { return n.Data == "div" && hasClass(n, "mc_vtvc") }) {
+		var r searchResult
+		for _, n := range filterNodes(tile, func(n *html.Node) bool { return hasAttr(n, "vrhm") }) {
+			var meta struct {
+				Title    string `json:"vt"`
+				URL      string `json:"pgurl"`
+				Duration string `json:"du"`
+			}
+			if json.Unmarshal([]byte(getAttr(n, "vrhm")), &meta) == nil {
+				r.Title, r.URL, r.Duration = meta.Title, meta.URL, meta.Duration
+				break
+			}
+		}
+		for _, n := range filterNodes(tile, func(n *html.Node) bool { return hasClass(n, "mc_vtvc_meta_row_channel") }) {
+			r.Channel = strings.Join(strings.Fields(textContent(n)), " ")
+			break
+		}
+		if r.URL != "" {
+			results = append(results, r)
+		}
+	}
+	return results
+}
+
+// vqdRe finds the search token DuckDuckGo's result pages embed for their
+// JSON endpoints.
+var vqdRe = regexp.MustCompile(`vqd=["']?([\d-]+)`)
+
+// duckDuckGoVideoSearch searches DuckDuckGo's videos. Its video results
+// only come from a JSON endpoint that needs the token (vqd) of a search
+// page, so the search page for query is fetched first, as the site does.
+func duckDuckGoVideoSearch(p searchParams) ([]searchResult, error) {
+	pageURL := "https://duckduckgo.com/?q=" + url.QueryEscape(p.Query) + "&iax=videos&ia=videos"
+	pageOpts := newFetchOptions(pageURL)
+	pageOpts.acceptLanguage = acceptLanguage(p)
+	page, err := fetchOne(pageOpts)
+	if err != nil {
+		return nil, fmt.Errorf("search fetch failed: %w", err)
+	}
+	m := vqdRe.FindSubmatch(page.Body)
+	if m == nil {
+		return nil, fmt.Errorf("duckduckgo: no search token in the video search page")
+	}
+
+	opts := newFetchOptions(fmt.Sprintf("https://duckduckgo.com/v.js?l=%s&o=json&q=%s&vqd=%s&f=,,,&p=1",
+		cmp.Or(ddgRegion(p), "us-en"), url.QueryEscape(p.Query), m[1]))
+	opts.referer = pageURL
+	opts.acceptLanguage = pageOpts.acceptLanguage
+	opts.accept = "application/json, text/javascript, */*; q=0.01"
+	result, err := fetchOne(opts)
+	if err != nil {
+		return nil, fmt.Errorf("search fetch failed: %w", err)
+	}
+	return parseDuckDuckGoVideoResults(result.Body)
+}
+
+// parseDuckDuckGoVideoResults parses DuckDuckGo's video JSON, in which
+// "content" is the video's page URL and "uploader" (or "publisher", the
+// hosting site) its channel.
+func parseDuckDuckGoVideoResults(body []byte) ([]searchResult, error) {
+	var data struct {
+		Results []struct {
+			Title       string `json:"title"`
+			Content     string `json:"content"`
+			Description string `json:"description"`
+			Duration    string `json:"duration"`
+			Uploader    string `json:"uploader"`
+			Publisher   string `json:"publisher"`
+			Published   string `json:"published"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("duckduckgo: parse video results: %w", err)
+	}
+	var results []searchResult
+	for _, v := range data.Results {
+		r := searchResult{
+			Title:    v.Title,
+			URL:      v.Content,
+			Snippet:  strings.Join(strings.Fields(v.Description), " "),
+			Duration: v.Duration,
+			Channel:  v.Uploader,
+		}
+		if r.Channel == "" {
+			r.Channel = v.Publisher
+		}
+		// Publish times come without a zone ("2019-04-18T16:23:43.0000000").
+		if t, err := time.Parse("2006-01-02T15:04:05.9999999", v.Published); err == nil {
+			r.Published = t.Format(time.RFC3339)
+		}
+		if r.URL != "" {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// warmStep records one page visited while warming a session.
+type warmStep struct {
+	URL    string `json:"url"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// runWarm visits a domain's homepage and then a few internal pages linked
+// from it, the way a person arriving at the site would. Challenges are
+// solved along the way and all cookies land in the persistent jar, so a
+// following batch against deep URLs starts with a warm session.
+func runWarm(domain string, pages int, delay time.Duration) error {
+	if flagNoCookies {
+		return fmt.Errorf("warm stores cookies for later runs; it cannot be used with --no-cookies")
+	}
+	if delay < 0 {
+		return fmt.Errorf("invalid --delay %s", delay)
+	}
+	home := domain
+	if !strings.Contains(home, "://") {
+		home = "https://" + home
+	}
+	u, err := url.Parse(home)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid domain %q", domain)
+	}
+	home = u.Scheme + "://" + u.Host + "/"
+
+	var steps []warmStep
+	result, err := fetchOne(newFetchOptions(home))
+	if err != nil {
+		steps = append(steps, warmStep{URL: home, Error: err.Error()})
+		return writeWarmSteps(steps, err)
+	}
+	steps = append(steps, warmStep{URL: home, Status: result.StatusCode})
+
+	// Pick a few same-host links from the homepage, in page order.
+	var targets []string
+	for _, l := range extractLinks(result.Body, result.URL) {
+		if len(targets) >= pages {
+			break
+		}
+		lu, err := url.Parse(l.URL)
+		if err != nil || lu.Host != u.Host || lu.Path == "" || lu.Path == "/" {
+			continue
+		}
+		targets = append(targets, l.URL)
+	}
+
+	referer := result.URL
+	for _, target := range targets {
+		// Pause like a reader would before clicking through.
+		time.Sleep(delay + time.Duration(rand.Int63n(int64(delay)/2+1)))
+
+		opts := newFetchOptions(target)
+		opts.referer = referer
+		res, err := fetchOne(opts)
+		if err != nil {
+			steps = append(steps, warmStep{URL: target, Error: err.Error()})
+			continue
+		}
+		steps = append(steps, warmStep{URL: target, Status: res.StatusCode})
+		referer = res.URL
+	}
+
+	return writeWarmSteps(steps, nil)
+}
+
+// writeWarmSteps prints the visited pages as text or JSON and passes err
+// through so the command still fails when the homepage could not be fetched.
+func writeWarmSteps(steps []warmStep, err error) error {
+	if flagJSONOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(steps)
+		return err
+	}
+	for _, s := range steps {
+		if s.Error != "" {
+			fmt.Printf("ERR %s (%s)\n", s.URL, s.Error)
+		} else {
+			fmt.Printf("%d %s\n", s.Status, s.URL)
+		}
+	}
+	return err
+}
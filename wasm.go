@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/dop251/goja"
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+)
+
+// wasmMemoryLimitPages caps each module's memory at 64MB.
+const wasmMemoryLimitPages = 1024
+
+// jsWasm backs the sandbox's WebAssembly object with wazero. Each instance
+// gets its own runtime, so modules can import the same names; all of them
+// stop when ctx is done and are closed by Close.
+type jsWasm struct {
+	ctx   context.Context
+	vm    *goja.Runtime
+	cache wazero.CompilationCache
+	// modules are compiled in the first runtime for their metadata, and
+	// recompiled (from the cache) in each instance's runtime.
+	modules   []jsWasmModule
+	runtimes  []wazero.Runtime
+	instances []api.Module
+}
+
+type jsWasmModule struct {
+	code     []byte
+	compiled wazero.CompiledModule
+}
+
+func newJSWasm(ctx context.Context, vm *goja.Runtime) *jsWasm {
+	return &jsWasm{ctx: ctx, vm: vm, cache: wazero.NewCompilationCache()}
+}
+
+// Close releases every runtime the script created.
+func (w *jsWasm) Close() {
+	for _, r := range w.runtimes {
+		r.Close(context.Background())
+	}
+	w.cache.Close(context.Background())
+}
+
+func (w *jsWasm) newRuntime() wazero.Runtime {
+	r := wazero.NewRuntimeWithConfig(w.ctx, wazero.NewRuntimeConfig().
+		WithCompilationCache(w.cache).
+		WithMemoryLimitPages(wasmMemoryLimitPages).
+		WithCloseOnContextDone(true))
+	w.runtimes = append(w.runtimes, r)
+	return r
+}
+
+func (w *jsWasm) throw(format string, args ...any) {
+	panic(w.vm.NewTypeError("WebAssembly: " + fmt.Sprintf(format, args...)))
+}
+
+// setup registers WebAssembly.Module, Instance, validate, compile and
+// instantiate.
+func (w *jsWasm) setup() {
+	vm := w.vm
+	compiler := w.newRuntime()
+
+	vm.Set("__wasmCompile", func(call goja.FunctionCall) goja.Value {
+		buf, ok := call.Argument(0).Export().(goja.ArrayBuffer)
+		if !ok {
+			w.throw("expected a BufferSource")
+		}
+		m, err := compiler.CompileModule(w.ctx, buf.Bytes())
+		if err != nil {
+			w.throw("compile: %v", err)
+		}
+		w.modules = append(w.modules, jsWasmModule{code: buf.Bytes(), compiled: m})
+		return vm.ToValue(len(w.modules) - 1)
+	})
+	vm.Set("__wasmExports", func(call goja.FunctionCall) goja.Value {
+		m := w.module(call.Argument(0)).compiled
+		var list []any
+		for name := range m.ExportedFunctions() {
+			list = append(list, map[string]any{"name": name, "kind": "function"})
+		}
+		for name := range m.ExportedMemories() {
+			list = append(list, map[string]any{"name": name, "kind": "memory"})
+		}
+		return vm.ToValue(list)
+	})
+	vm.Set("__wasmInstantiate", func(call goja.FunctionCall) goja.Value {
+		return vm.ToValue(w.instantiate(w.module(call.Argument(0)), call.Argument(1)))
+	})
+	vm.Set("__wasmCall", func(call goja.FunctionCall) goja.Value {
+		inst := w.instance(call.Argument(0))
+		name := call.Argument(1).String()
+		fn := inst.ExportedFunction(name)
+		if fn == nil {
+			w.throw("no exported function %q", name)
+		}
+		var jsArgs []goja.Value
+		if a, ok := call.Argument(2).(*goja.Object); ok {
+			vm.ExportTo(a, &jsArgs)
+		}
+		def := fn.Definition()
+		params := make([]uint64, len(def.ParamTypes()))
+		for i, t := range def.ParamTypes() {
+			arg := goja.Undefined()
+			if i < len(jsArgs) {
+				arg = jsArgs[i]
+			}
+			params[i] = wasmEncode(t, arg)
+		}
+		results, err := fn.Call(w.ctx, params...)
+		if err != nil {
+			panic(vm.NewGoError(fmt.Errorf("WebAssembly: %s: %w", name, err)))
+		}
+		switch len(results) {
+		case 0:
+			return goja.Undefined()
+		case 1:
+			return vm.ToValue(wasmDecode(def.ResultTypes()[0], results[0]))
+		}
+		out := make([]any, len(results))
+		for i, r := range results {
+			out[i] = wasmDecode(def.ResultTypes()[i], r)
+		}
+		return vm.ToValue(out)
+	})
+	vm.Set("__wasmMemory", func(call goja.FunctionCall) goja.Value {
+		mem := w.memory(call.Argument(0), call.Argument(1).String())
+		// A view of the memory itself, valid until it grows.
+		buf, _ := mem.Read(0, mem.Size())
+		return vm.ToValue(vm.NewArrayBuffer(buf))
+	})
+	vm.Set("__wasmGrow", func(call goja.FunctionCall) goja.Value {
+		mem := w.memory(call.Argument(0), call.Argument(1).String())
+		prev, ok := mem.Grow(uint32(call.Argument(2).ToInteger()))
+		if !ok {
+			panic(vm.NewGoError(fmt.Errorf("WebAssembly: memory.grow failed")))
+		}
+		return vm.ToValue(prev)
+	})
+
+	vm.RunString(`
+		(function() {
+			function bytesOf(src) {
+				if (src instanceof ArrayBuffer) return src.slice(0);
+				if (src && src.buffer instanceof ArrayBuffer)
+					return src.buffer.slice(src.byteOffset, src.byteOffset + src.byteLength);
+				throw new TypeError("WebAssembly: expected a BufferSource");
+			}
+			function Module(src) {
+				if (!(this instanceof Module)) throw new TypeError("WebAssembly.Module must be called with new");
+				this._id = __wasmCompile(bytesOf(src));
+			}
+			Module.exports = function(m) { return __wasmExports(m._id); };
+			function Instance(module, imports) {
+				if (!(this instanceof Instance)) throw new TypeError("WebAssembly.Instance must be called with new");
+				var id = __wasmInstantiate(module._id, imports || {});
+				var exports = {};
+				__wasmExports(module._id).forEach(function(e) {
+					if (e.kind === "function") {
+						exports[e.name] = function() { return __wasmCall(id, e.name, Array.prototype.slice.call(arguments)); };
+					} else {
+						exports[e.name] = {
+							get buffer() { return __wasmMemory(id, e.name); },
+							grow: function(n) { return __wasmGrow(id, e.name, n); }
+						};
+					}
+				});
+				this.exports = Object.freeze(exports);
+			}
+			WebAssembly = {
+				Module: Module,
+				Instance: Instance,
+				validate: function(src) {
+					try { new Module(src); return true; } catch (e) { return false; }
+				},
+				compile: function(src) {
+					return new Promise(function(resolve) { resolve(new Module(src)); });
+				},
+				instantiate: function(src, imports) {
+					return new Promise(function(resolve) {
+						if (src instanceof Module) {
+							resolve(new Instance(src, imports));
+							return;
+						}
+						var module = new Module(src);
+						resolve({ module: module, instance: new Instance(module, imports) });
+					});
+				}
+			};
+		})();
+	`)
+}
+
+func (w *jsWasm) module(v goja.Value) jsWasmModule {
+	i := int(v.ToInteger())
+	if i < 0 || i >= len(w.modules) {
+		w.throw("invalid module")
+	}
+	return w.modules[i]
+}
+
+func (w *jsWasm) instance(v goja.Value) api.Module {
+	i := int(v.ToInteger())
+	if i < 0 || i >= len(w.instances) {
+		w.throw("invalid instance")
+	}
+	return w.instances[i]
+}
+
+func (w *jsWasm) memory(inst goja.Value, name string) api.Memory {
+	mem := w.instance(inst).ExportedMemory(name)
+	if mem == nil {
+		w.throw("no exported memory %q", name)
+	}
+	return mem
+}
+
+// instantiate links mod's imported functions to the JS functions in
+// imports and instantiates it in a runtime of its own, returning the
+// instance's index.
+func (w *jsWasm) instantiate(mod jsWasmModule, imports goja.Value) int {
+	vm := w.vm
+	if len(mod.compiled.ImportedMemories()) > 0 {
+		w.throw("imported memories are not supported")
+	}
+	r := w.newRuntime()
+	m, err := r.CompileModule(w.ctx, mod.code)
+	if err != nil {
+		w.throw("compile: %v", err)
+	}
+	importObj, _ := imports.(*goja.Object)
+
+	hosts := map[string]wazero.HostModuleBuilder{}
+	for _, def := range m.ImportedFunctions() {
+		modName, name, _ := def.Import()
+		var jsFn goja.Callable
+		if importObj != nil {
+			if ns, ok := importObj.Get(modName).(*goja.Object); ok {
+				jsFn, _ = goja.AssertFunction(ns.Get(name))
+			}
+		}
+		if jsFn == nil {
+			w.throw("import %s.%s is not a function", modName, name)
+		}
+		b, ok := hosts[modName]
+		if !ok {
+			b = r.NewHostModuleBuilder(modName)
+			hosts[modName] = b
+		}
+		params, results := def.ParamTypes(), def.ResultTypes()
+		b.NewFunctionBuilder().WithGoModuleFunction(api.GoModuleFunc(func(ctx context.Context, mod api.Module, stack []uint64) {
+			args := make([]goja.Value, len(params))
+			for i, t := range params {
+				args[i] = vm.ToValue(wasmDecode(t, stack[i]))
+			}
+			v, err := jsFn(goja.Undefined(), args...)
+			if err != nil {
+				panic(err)
+			}
+			if len(results) > 0 {
+				stack[0] = wasmEncode(results[0], v)
+			}
+		}), params, results).Export(name)
+	}
+	for name, b := range hosts {
+		if _, err := b.Instantiate(w.ctx); err != nil {
+			w.throw("link %s: %v", name, err)
+		}
+	}
+
+	inst, err := r.InstantiateModule(w.ctx, m, wazero.NewModuleConfig().WithName(""))
+	if err != nil {
+		w.throw("instantiate: %v", err)
+	}
+	w.instances = append(w.instances, inst)
+	return len(w.instances) - 1
+}
+
+// wasmEncode converts a JS value to a wasm value of type t.
+func wasmEncode(t api.ValueType, v goja.Value) uint64 {
+	switch t {
+	case api.ValueTypeI32:
+		return api.EncodeI32(int32(v.ToInteger()))
+	case api.ValueTypeI64:
+		return api.EncodeI64(v.ToInteger())
+	case api.ValueTypeF32:
+		return api.EncodeF32(float32(v.ToFloat()))
+	case api.ValueTypeF64:
+		return api.EncodeF64(v.ToFloat())
+	}
+	return 0
+}
+
+// wasmDecode converts a wasm value of type t to a Go value for JS.
+func wasmDecode(t api.ValueType, v uint64) any {
+	switch t {
+	case api.ValueTypeI32:
+		return api.DecodeI32(v)
+	case api.ValueTypeI64:
+		return int64(v)
+	case api.ValueTypeF32:
+		return float64(api.DecodeF32(v))
+	case api.ValueTypeF64:
+		return math.Float64frombits(v)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// wikiLangRe matches a Wikipedia language edition, such as "de",
+// "simple" or "zh-yue".
+var wikiLangRe = regexp.MustCompile(`^[a-z]{2,8}(-[a-z]{2,8})*$`)
+
+// wikiArticleURL asks the lang Wikipedia's REST search API for the
+// article best matching term and returns its URL.
+func wikiArticleURL(lang string, term string) (string, error) {
+	base := "https://" + lang + ".wikipedia.org"
+	opts := newFetchOptions(base + "/w/rest.php/v1/search/page?limit=1&q=" + url.QueryEscape(term))
+	opts.accept = "application/json"
+	result, err := fetchOne(opts)
+	if err != nil {
+		return "", fmt.Errorf("wikipedia search failed: %w", err)
+	}
+	if result.StatusCode != 200 {
+		return "", fmt.Errorf("wikipedia search returned HTTP %d", result.StatusCode)
+	}
+	var found struct {
+		Pages []struct {
+			Key   string `json:"key"`
+			Title string `json:"title"`
+		} `json:"pages"`
+	}
+	if err := json.Unmarshal(result.Body, &found); err != nil {
+		return "", fmt.Errorf("wikipedia search: parse response: %w", err)
+	}
+	if len(found.Pages) == 0 {
+		return "", fmt.Errorf("no %s.wikipedia.org article matches %q", lang, term)
+	}
+	page := found.Pages[0]
+	if flagVerbose {
+		fmt.Fprintf(os.Stderr, "[*] Best match: %s\n", page.Title)
+	}
+	// Keys are titles with underscores; their slashes stay in the path.
+	key := cmp.Or(page.Key, strings.ReplaceAll(page.Title, " ", "_"))
+	return base + "/wiki/" + strings.ReplaceAll(url.PathEscape(key), "%2F", "/"), nil
+}
+
+// runWiki looks up the Wikipedia article best matching term and fetches
+// it like any page, as reader-mode markdown unless another output format
+// was asked for.
+func runWiki(lang string, term string) error {
+	lang = strings.ToLower(strings.TrimSpace(lang))
+	if !wikiLangRe.MatchString(lang) {
+		return fmt.Errorf("invalid --lang %q: want a Wikipedia language such as de", lang)
+	}
+	articleURL, err := wikiArticleURL(lang, term)
+	if err != nil {
+		return err
+	}
+	if len(flagProcess) == 0 && !flagMarkdownFull && !flagRaw {
+		flagMarkdown = true
+	}
+	return runSingleFetch(articleURL)
+}